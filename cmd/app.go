@@ -62,6 +62,15 @@ func (a *app) Main() error {
 		return fmt.Errorf("error when init logger %v: ", err)
 	}
 
+	t := utils.NewTracer(*v)
+	if t == nil {
+		return errors.New("empty tracer repo")
+	}
+	err = t.Initialize()
+	if err != nil {
+		return fmt.Errorf("error when init tracer %v: ", err)
+	}
+
 	u := utils.NewUUIDGenerator()
 	if u == nil {
 		return errors.New("empty uid generator repo")
@@ -77,7 +86,26 @@ func (a *app) Main() error {
 		return errors.New("empty validator repo")
 	}
 
-	s := server.NewServer(*v, l, jwt, u, vals, *val)
+	s := server.NewServer(*v, l, t, jwt, u, vals, *val)
+
+	switch flagValues.Command {
+	case "":
+	case "import":
+		iv, err := flags.GetImportFlags(flagValues.Args, v.ImportBatchSize)
+		if err != nil {
+			return err
+		}
+		return a.importUsers(s, iv)
+	case "export":
+		ev, err := flags.GetExportFlags(flagValues.Args)
+		if err != nil {
+			return err
+		}
+		return a.exportUsers(s, ev)
+	default:
+		return fmt.Errorf("unknown command %s", flagValues.Command)
+	}
+
 	if err := s.Start(); err != nil {
 		return fmt.Errorf("error when starting the server %v: ", err)
 	}
@@ -0,0 +1,36 @@
+//go:build !coverage
+// +build !coverage
+
+package cmd
+
+import (
+	"context"
+	"dall06/go-cleanapi/cmd/tools"
+	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/server"
+	"fmt"
+	"os"
+)
+
+// exportUsers runs the export command, it streams the users from the database into the file
+func (a *app) exportUsers(s server.Server, ev *tools.ExportValues) error {
+	file, err := os.Create(ev.File)
+	if err != nil {
+		return fmt.Errorf("error when creating the export file %v: ", err)
+	}
+	defer file.Close()
+
+	req := &controller.ExportRequest{
+		Format:      ev.Format,
+		Columns:     ev.Columns,
+		Email:       ev.Email,
+		Phone:       ev.Phone,
+		CreatedFrom: ev.CreatedFrom,
+		CreatedTo:   ev.CreatedTo,
+	}
+	if err := s.Export(context.Background(), file, req); err != nil {
+		return fmt.Errorf("error when exporting users %v: ", err)
+	}
+
+	return file.Close()
+}
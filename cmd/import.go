@@ -0,0 +1,40 @@
+//go:build !coverage
+// +build !coverage
+
+package cmd
+
+import (
+	"context"
+	"dall06/go-cleanapi/cmd/tools"
+	"dall06/go-cleanapi/pkg/server"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// importUsers runs the import command, it streams the file into the database
+// and prints the report of every row as json
+func (a *app) importUsers(s server.Server, iv *tools.ImportValues) error {
+	file, err := os.Open(iv.File)
+	if err != nil {
+		return fmt.Errorf("error when opening the import file %v: ", err)
+	}
+	defer file.Close()
+
+	report, importErr := s.Import(context.Background(), file, iv.Format, iv.DryRun, iv.BatchSize)
+	if report == nil {
+		return fmt.Errorf("error when importing users %v: ", importErr)
+	}
+
+	// an import that stopped midway still prints the rows of the batches it created
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return fmt.Errorf("error when printing the import report %v: ", err)
+	}
+	if importErr != nil {
+		return fmt.Errorf("error when importing users %v: ", importErr)
+	}
+
+	return nil
+}
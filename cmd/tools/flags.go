@@ -2,19 +2,49 @@
 package tools
 
 import (
+	"errors"
 	"flag"
 	"os"
+	"path/filepath"
+	"strings"
 )
 
 // FlagValues means the values obtained as flag parameters of cli
 type FlagValues struct {
 	Port    string
 	Version string
+	// Command is the first argument after the flags, empty runs the server
+	Command string
+	// Args are the arguments of the command
+	Args []string
+}
+
+// ImportValues means the values obtained as flag parameters of the import command
+type ImportValues struct {
+	File      string
+	Format    string
+	DryRun    bool
+	BatchSize int
+}
+
+// ExportValues means the values obtained as flag parameters of the export command
+type ExportValues struct {
+	File        string
+	Format      string
+	Columns     []string
+	Email       string
+	Phone       string
+	CreatedFrom string
+	CreatedTo   string
 }
 
 // Flags is an interface that extend tools
 type Flags interface {
 	GetFlags() (*FlagValues, error)
+	// GetImportFlags parses the arguments of the import command, batchSize is the default batch size
+	GetImportFlags(args []string, batchSize int) (*ImportValues, error)
+	// GetExportFlags parses the arguments of the export command
+	GetExportFlags(args []string) (*ExportValues, error)
 }
 
 type flags struct {
@@ -46,7 +76,72 @@ func (f *flags) GetFlags() (*FlagValues, error) {
 	fv := &FlagValues{
 		Port:    port,
 		Version: version,
+		Args:    []string{},
+	}
+	if args := f.flagSet.Args(); len(args) > 0 {
+		fv.Command = args[0]
+		fv.Args = args[1:]
 	}
 
 	return fv, nil
 }
+
+func (f *flags) GetImportFlags(args []string, batchSize int) (*ImportValues, error) {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+
+	iv := &ImportValues{}
+	fs.StringVar(&iv.Format, "format", "", "csv or ndjson, by default the extension of the file")
+	fs.BoolVar(&iv.DryRun, "dry-run", false, "report the rows without creating the users")
+	fs.IntVar(&iv.BatchSize, "batch", batchSize, "number of rows per transaction")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() != 1 {
+		return nil, errors.New("usage: import [-format csv|ndjson] [-dry-run] [-batch n] <file>")
+	}
+	if iv.BatchSize <= 0 {
+		return nil, errors.New("the batch size must be positive")
+	}
+
+	iv.File = fs.Arg(0)
+	if iv.Format == "" {
+		iv.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(iv.File)), ".")
+	}
+
+	return iv, nil
+}
+
+func (f *flags) GetExportFlags(args []string) (*ExportValues, error) {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+
+	ev := &ExportValues{}
+	var columns string
+	fs.StringVar(&ev.Format, "format", "", "csv or ndjson, by default the extension of the file")
+	fs.StringVar(&columns, "columns", "", "comma separated columns, by default every column")
+	fs.StringVar(&ev.Email, "email", "", "email prefix of the exported users")
+	fs.StringVar(&ev.Phone, "phone", "", "phone prefix of the exported users")
+	fs.StringVar(&ev.CreatedFrom, "created-from", "", "RFC 3339 lower bound of the creation date")
+	fs.StringVar(&ev.CreatedTo, "created-to", "", "RFC 3339 upper bound of the creation date")
+
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	// the logs go to the standard output, so the export is always written to a file
+	if fs.NArg() != 1 {
+		return nil, errors.New("usage: export [-format csv|ndjson] [-columns a,b] " +
+			"[-email prefix] [-phone prefix] [-created-from date] [-created-to date] <file>")
+	}
+
+	if columns != "" {
+		for _, column := range strings.Split(columns, ",") {
+			ev.Columns = append(ev.Columns, strings.TrimSpace(column))
+		}
+	}
+	ev.File = fs.Arg(0)
+	if ev.Format == "" {
+		ev.Format = strings.TrimPrefix(strings.ToLower(filepath.Ext(ev.File)), ".")
+	}
+
+	return ev, nil
+}
@@ -65,3 +65,135 @@ func TestFlags(t *testing.T) {
 		fmt.Println(i)
 	}
 }
+
+func TestImportFlags(t *testing.T) {
+	successfulCases := []struct {
+		name     string
+		args     []string
+		expected tools.ImportValues
+	}{
+		{
+			name: "it should get default import flags",
+			args: []string{"users.CSV"},
+			expected: tools.ImportValues{
+				File:      "users.CSV",
+				Format:    "csv",
+				BatchSize: 500,
+			},
+		},
+		{
+			name: "it should get custom import flags",
+			args: []string{"-format", "ndjson", "-dry-run", "-batch", "50", "users.txt"},
+			expected: tools.ImportValues{
+				File:      "users.txt",
+				Format:    "ndjson",
+				DryRun:    true,
+				BatchSize: 50,
+			},
+		},
+	}
+
+	failedCases := []struct {
+		name string
+		args []string
+	}{
+		{
+			name: "it should not get import flags, missing file",
+			args: []string{"-dry-run"},
+		},
+		{
+			name: "it should not get import flags, invalid batch size",
+			args: []string{"-batch", "0", "users.csv"},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			iv, err := tools.NewFlags().GetImportFlags(tc.args, 500)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, *iv)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			iv, err := tools.NewFlags().GetImportFlags(tc.args, 500)
+			assert.Error(t, err)
+			assert.Nil(t, iv)
+		})
+	}
+}
+
+func TestExportFlags(t *testing.T) {
+	successfulCases := []struct {
+		name     string
+		args     []string
+		expected tools.ExportValues
+	}{
+		{
+			name: "it should get default export flags",
+			args: []string{"users.csv"},
+			expected: tools.ExportValues{
+				File:   "users.csv",
+				Format: "csv",
+			},
+		},
+		{
+			name: "it should get custom export flags",
+			args: []string{"-columns", "uid, email", "-email", "test", "-created-from", "2023-01-01T00:00:00Z", "users.NDJSON"},
+			expected: tools.ExportValues{
+				File:        "users.NDJSON",
+				Format:      "ndjson",
+				Columns:     []string{"uid", "email"},
+				Email:       "test",
+				CreatedFrom: "2023-01-01T00:00:00Z",
+			},
+		},
+	}
+
+	failedCases := []struct {
+		name string
+		args []string
+	}{
+		{
+			name: "it should not get export flags, missing file",
+			args: []string{"-email", "test"},
+		},
+		{
+			name: "it should not get export flags, unknown flag",
+			args: []string{"-password", "users.csv"},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ev, err := tools.NewFlags().GetExportFlags(tc.args)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, *ev)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ev, err := tools.NewFlags().GetExportFlags(tc.args)
+			assert.Error(t, err)
+			assert.Nil(t, ev)
+		})
+	}
+}
@@ -8,15 +8,38 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// APIVersions are the major versions served side by side, from the oldest to the latest
+var APIVersions = []string{APIv1, APIv2}
+
+// IsAPIVersion tells whether the version is served
+func IsAPIVersion(version string) bool {
+	for _, v := range APIVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
 // Vars are config variables
 type Vars struct {
-	// APIBasePath makes reference to api basepath
+	// APIRootPath is the path every api version is served under
+	APIRootPath string
+	// APIBasePath makes reference to api basepath, the one of the default version
 	APIBasePath string
+	// APIDefaultVersion is the version of the requests that name none, in their path or Accept header
+	APIDefaultVersion string
+	// APIDeprecations are the dates since the versions are deprecated
+	APIDeprecations map[string]time.Time
+	// APISunsets are the dates since the versions are no longer served
+	APISunsets map[string]time.Time
 	// APIPort makes reference to api port
 	APIPort string
 	// APIKey makes reference to api key string
@@ -39,6 +62,108 @@ type Vars struct {
 	APIVersion string
 	// AppName contains the name of the server including version
 	AppName string
+	// TraceExporter is the exporter used for the traces: none, stdout or otlp
+	TraceExporter string
+	// TraceEndpoint is the host:port of the otlp collector
+	TraceEndpoint string
+	// BodyLimit is the max size in bytes of a request body for the whole server
+	BodyLimit int
+	// ReadTimeout is the max duration for reading a full request
+	ReadTimeout time.Duration
+	// WriteTimeout is the max duration before timing out writes of a response
+	WriteTimeout time.Duration
+	// IdleTimeout is the max duration to wait for the next request on keep-alive
+	IdleTimeout time.Duration
+	// HandlerTimeout is the default deadline of a handler, including its db work
+	HandlerTimeout time.Duration
+	// RouteBodyLimits overrides the body limit per route name, it can not exceed BodyLimit
+	RouteBodyLimits map[string]int
+	// RouteTimeouts overrides the handler timeout per route name
+	RouteTimeouts map[string]time.Duration
+	// RevocationStore is where revoked sessions are kept: memory or sql
+	RevocationStore string
+	// AccessTokenTTL is the lifetime of the user jwt
+	AccessTokenTTL time.Duration
+	// RefreshTokenTTL is the lifetime of a refresh token, every rotation starts a new one
+	RefreshTokenTTL time.Duration
+	// MailDriver is how emails are delivered: file or smtp
+	MailDriver string
+	// MailFrom is the sender address of the emails
+	MailFrom string
+	// MailDir is the directory where the file driver writes the emails
+	MailDir string
+	// SMTPHost is the host of the smtp server
+	SMTPHost string
+	// SMTPPort is the port of the smtp server
+	SMTPPort string
+	// SMTPUser is the user of the smtp server, empty means no auth
+	SMTPUser string
+	// SMTPPassword is the password of the smtp user
+	SMTPPassword string
+	// PasswordResetTTL is the lifetime of a password reset token
+	PasswordResetTTL time.Duration
+	// PasswordResetURL is the page that receives the reset token as the token query parameter
+	PasswordResetURL string
+	// EmailVerificationTTL is the lifetime of a signed email verification link
+	EmailVerificationTTL time.Duration
+	// EmailVerificationURL is the page that receives the verification token as the token query parameter
+	EmailVerificationURL string
+	// EmailVerificationResend is the min time between two verification emails to the same address
+	EmailVerificationResend time.Duration
+	// RequireVerifiedEmail blocks the login of users whose email is not verified yet
+	RequireVerifiedEmail bool
+	// SMSDir is the directory where the fake sms sender writes the messages
+	SMSDir string
+	// PhoneOTPTTL is the lifetime of a phone verification code
+	PhoneOTPTTL time.Duration
+	// PhoneOTPResend is the min time between two codes to the same user
+	PhoneOTPResend time.Duration
+	// PhoneOTPAttempts is the number of wrong codes accepted before the code is discarded
+	PhoneOTPAttempts int
+	// TOTPIssuer is the name shown next to the account in authenticator apps
+	TOTPIssuer string
+	// MFATokenTTL is the lifetime of the token that waits for the second factor of a login
+	MFATokenTTL time.Duration
+	// MFAAttempts is the number of wrong second factor codes accepted per user within MFATokenTTL
+	MFAAttempts int
+	// LockoutStore is where failed logins are kept: memory or sql
+	LockoutStore string
+	// LockoutThreshold is the number of failed logins of an account before it is locked
+	LockoutThreshold int
+	// LockoutIPThreshold is the number of failed logins from an ip before it is locked
+	LockoutIPThreshold int
+	// LockoutBaseDelay is the first lock, every new failure doubles it
+	LockoutBaseDelay time.Duration
+	// LockoutMaxDelay is the longest lock
+	LockoutMaxDelay time.Duration
+	// LockoutWindow is the time without failures after which they are forgotten
+	LockoutWindow time.Duration
+	// ImportBatchSize is the number of rows created per transaction by a bulk import
+	ImportBatchSize int
+	// ImportTimeout replaces HandlerTimeout, ReadTimeout and WriteTimeout for the streamed user imports,
+	// unless the import route has its own timeout
+	ImportTimeout time.Duration
+	// ExportWriteTimeout replaces WriteTimeout for the streamed user exports, which may take long
+	ExportWriteTimeout time.Duration
+	// GraphQLMaxDepth is the deepest selection a graphql operation may have
+	GraphQLMaxDepth int
+	// GraphQLMaxComplexity is the highest cost of a graphql operation, every field costs 1
+	// and the fields under a paged one cost once per user of the page
+	GraphQLMaxComplexity int
+	// GRPCPort is the port of the grpc server of the user service
+	GRPCPort string
+	// EventsStore is where the user events are buffered: memory or sql
+	EventsStore string
+	// EventsBufferSize is the number of user events kept for the streams that resume
+	EventsBufferSize int
+	// EventsHeartbeat is the time between the heartbeats of an idle event stream,
+	// streams also look for the events of other instances at every heartbeat
+	EventsHeartbeat time.Duration
+	// EventsStreamTimeout is the time an event stream stays open, clients reconnect
+	// with the id of the last event they got
+	EventsStreamTimeout time.Duration
+	// AdminKey is the secret of the x-admin-key header of admin routes, empty disables them
+	AdminKey string
 }
 
 const (
@@ -53,6 +178,120 @@ const (
 	envStage            = "STAGE"
 	envCookieEncryption = "COOKIE_ENCRYPTION"
 	envAPIKey           = "API_KEY"
+	envTraceExporter    = "TRACE_EXPORTER"
+	envTraceEndpoint    = "TRACE_ENDPOINT"
+	envBodyLimit        = "BODY_LIMIT"
+	envReadTimeout      = "READ_TIMEOUT"
+	envWriteTimeout     = "WRITE_TIMEOUT"
+	envIdleTimeout      = "IDLE_TIMEOUT"
+	envHandlerTimeout   = "HANDLER_TIMEOUT"
+	envRouteBodyLimits  = "ROUTE_BODY_LIMITS"
+	envRouteTimeouts    = "ROUTE_TIMEOUTS"
+	envRevocationStore  = "REVOCATION_STORE"
+	envAccessTokenTTL   = "ACCESS_TOKEN_TTL"
+	envRefreshTokenTTL  = "REFRESH_TOKEN_TTL"
+	envMailDriver       = "MAIL_DRIVER"
+	envMailFrom         = "MAIL_FROM"
+	envMailDir          = "MAIL_DIR"
+	envSMTPHost         = "SMTP_HOST"
+	envSMTPPort         = "SMTP_PORT"
+	envSMTPUser         = "SMTP_USER"
+	envSMTPPassword     = "SMTP_PASSWORD"
+	envPasswordResetTTL = "PASSWORD_RESET_TTL"
+	envPasswordResetURL = "PASSWORD_RESET_URL"
+	envVerificationTTL  = "EMAIL_VERIFICATION_TTL"
+	envVerificationURL  = "EMAIL_VERIFICATION_URL"
+	envVerificationWait = "EMAIL_VERIFICATION_RESEND"
+	envRequireVerified  = "REQUIRE_VERIFIED_EMAIL"
+	envSMSDir           = "SMS_DIR"
+	envPhoneOTPTTL      = "PHONE_OTP_TTL"
+	envPhoneOTPResend   = "PHONE_OTP_RESEND"
+	envPhoneOTPAttempts = "PHONE_OTP_ATTEMPTS"
+	envTOTPIssuer       = "TOTP_ISSUER"
+	envMFATokenTTL      = "MFA_TOKEN_TTL"
+	envMFAAttempts      = "MFA_ATTEMPTS"
+	envLockoutStore     = "LOCKOUT_STORE"
+	envLockoutThreshold = "LOCKOUT_THRESHOLD"
+	envLockoutIPLimit   = "LOCKOUT_IP_THRESHOLD"
+	envLockoutBase      = "LOCKOUT_BASE_DELAY"
+	envLockoutMax       = "LOCKOUT_MAX_DELAY"
+	envLockoutWindow    = "LOCKOUT_WINDOW"
+	envAdminKey         = "ADMIN_KEY"
+	envImportBatchSize  = "IMPORT_BATCH_SIZE"
+	envImportTimeout    = "IMPORT_TIMEOUT"
+	envExportTimeout    = "EXPORT_WRITE_TIMEOUT"
+	envGraphQLDepth     = "GRAPHQL_MAX_DEPTH"
+	envGraphQLCost      = "GRAPHQL_MAX_COMPLEXITY"
+	envGRPCPort         = "GRPC_PORT"
+	envEventsStore      = "EVENTS_STORE"
+	envEventsBuffer     = "EVENTS_BUFFER_SIZE"
+	envEventsHeartbeat  = "EVENTS_HEARTBEAT"
+	envEventsTimeout    = "EVENTS_STREAM_TIMEOUT"
+	envDefaultVersion   = "API_DEFAULT_VERSION"
+	envDeprecations     = "API_DEPRECATIONS"
+	envSunsets          = "API_SUNSETS"
+
+	defaultTraceExporter  = "none"
+	defaultTraceEndpoint  = "localhost:4318"
+	defaultBodyLimit      = "4194304"
+	defaultReadTimeout    = "10s"
+	defaultWriteTimeout   = "10s"
+	defaultIdleTimeout    = "60s"
+	defaultHandlerTimeout = "5s"
+	defaultAccessTokenTTL = "15m"
+	defaultRefreshTTL     = "720h"
+	defaultMailFrom       = "no-reply@localhost"
+	defaultMailDir        = "logs/mail"
+	defaultSMTPPort       = "587"
+	defaultResetTTL       = "30m"
+	defaultVerifyTTL      = "24h"
+	defaultVerifyWait     = "1m"
+	defaultRequireVerify  = "false"
+	defaultSMSDir         = "logs/sms"
+	defaultOTPTTL         = "5m"
+	defaultOTPResend      = "1m"
+	defaultOTPAttempts    = "5"
+	defaultMFATokenTTL    = "5m"
+	defaultMFAAttempts    = "5"
+	defaultLockoutLimit   = "5"
+	defaultLockoutIPLimit = "20"
+	defaultLockoutBase    = "1m"
+	defaultLockoutMax     = "1h"
+	defaultLockoutWindow  = "1h"
+	defaultImportBatch    = "500"
+	defaultImportTimeout  = "1h"
+	defaultExportTimeout  = "1h"
+	defaultGraphQLDepth   = "6"
+	defaultGraphQLCost    = "1000"
+	defaultGRPCPort       = "50051"
+	defaultEventsBuffer   = "1000"
+	defaultHeartbeat      = "15s"
+	defaultEventsTimeout  = "1h"
+
+	// RevocationStoreMemory keeps revoked sessions in the process, they are lost on restart
+	RevocationStoreMemory = "memory"
+	// RevocationStoreSQL keeps revoked sessions in the database, shared by every instance
+	RevocationStoreSQL = "sql"
+
+	// LockoutStoreMemory keeps failed logins in the process, every instance counts its own
+	LockoutStoreMemory = "memory"
+	// LockoutStoreSQL keeps failed logins in the database, shared by every instance
+	LockoutStoreSQL = "sql"
+
+	// EventsStoreMemory buffers the user events in the process, every instance streams its own
+	EventsStoreMemory = "memory"
+	// EventsStoreSQL buffers the user events in the database, shared by every instance
+	EventsStoreSQL = "sql"
+
+	// MailDriverFile writes every email to a file in MailDir, nothing leaves the host
+	MailDriverFile = "file"
+	// MailDriverSMTP sends the emails through the smtp server
+	MailDriverSMTP = "smtp"
+
+	// APIv1 is the first major version of the api, its users routes keep their original paths
+	APIv1 = "v1"
+	// APIv2 is the second major version of the api, its users routes are resource oriented
+	APIv2 = "v2"
 )
 
 // Config is an interface that extends config
@@ -88,7 +327,7 @@ func (c *config) SetConfig() (*Vars, error) {
 		return nil, err
 	}
 
-	c.Vars.DBConnString = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s", //"<user>:<password>@tcp(127.0.0.1:3306)/<dbname>"
+	c.Vars.DBConnString = fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", //"<user>:<password>@tcp(127.0.0.1:3306)/<dbname>?parseTime=true"
 		os.Getenv(envUserDB), os.Getenv(envPasswordDB), os.Getenv(envHostDB), os.Getenv(envPortDB), os.Getenv(envNameDB))
 	c.Vars.JWTSecret = []byte(fmt.Sprint(os.Getenv(envSecretJWT)))
 	c.Vars.Stage = strings.ToLower(os.Getenv(envStage))
@@ -102,7 +341,7 @@ func (c *config) SetConfig() (*Vars, error) {
 	c.Vars.APIVersion = c.version
 
 	c.Vars.CookieSecret = os.Getenv(envCookieEncryption)
-	c.Vars.APIBasePath = fmt.Sprintf("/%s/api/v%s", c.Vars.ProyectName, c.version)
+	c.Vars.APIRootPath = fmt.Sprintf("/%s/api", c.Vars.ProyectName)
 	c.Vars.AppName = fmt.Sprintf("%s v%s", proyectName, c.Vars.APIVersion)
 
 	ak := os.Getenv(envAPIKey)
@@ -110,6 +349,53 @@ func (c *config) SetConfig() (*Vars, error) {
 	sha := sha512.Sum512_256([]byte(ak))
 	c.Vars.APIKeyHash = hex.EncodeToString(sha[:])
 
+	c.Vars.TraceExporter = strings.ToLower(c.getEnv(envTraceExporter, defaultTraceExporter))
+	c.Vars.TraceEndpoint = c.getEnv(envTraceEndpoint, defaultTraceEndpoint)
+
+	if err := c.loadLimits(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadSessions(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadMail(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadSMS(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadMFA(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadLockout(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadBulk(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadGraphQL(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadGRPC(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadEvents(); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadVersions(); err != nil {
+		return nil, err
+	}
+
 	return &c.Vars, nil
 }
 
@@ -150,6 +436,426 @@ func (c *config) getProjectPath() (string, error) {
 	return "", fmt.Errorf("failed to find project root directory")
 }
 
+func (c *config) getEnv(key string, fallback string) string {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return fallback
+	}
+	return value
+}
+
+func (c *config) loadLimits() error {
+	bodyLimit, err := strconv.Atoi(c.getEnv(envBodyLimit, defaultBodyLimit))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envBodyLimit, err)
+	}
+	c.Vars.BodyLimit = bodyLimit
+
+	timeouts := []struct {
+		key      string
+		fallback string
+		value    *time.Duration
+	}{
+		{key: envReadTimeout, fallback: defaultReadTimeout, value: &c.Vars.ReadTimeout},
+		{key: envWriteTimeout, fallback: defaultWriteTimeout, value: &c.Vars.WriteTimeout},
+		{key: envIdleTimeout, fallback: defaultIdleTimeout, value: &c.Vars.IdleTimeout},
+		{key: envHandlerTimeout, fallback: defaultHandlerTimeout, value: &c.Vars.HandlerTimeout},
+	}
+	for _, t := range timeouts {
+		d, err := time.ParseDuration(c.getEnv(t.key, t.fallback))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", t.key, err)
+		}
+		*t.value = d
+	}
+
+	c.Vars.RouteBodyLimits = make(map[string]int)
+	for name, value := range c.getEnvMap(envRouteBodyLimits) {
+		limit, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s for route %s: %w", envRouteBodyLimits, name, err)
+		}
+		if limit > c.Vars.BodyLimit {
+			return fmt.Errorf("invalid %s for route %s: exceeds %s", envRouteBodyLimits, name, envBodyLimit)
+		}
+		c.Vars.RouteBodyLimits[name] = limit
+	}
+
+	c.Vars.RouteTimeouts = make(map[string]time.Duration)
+	for name, value := range c.getEnvMap(envRouteTimeouts) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("invalid %s for route %s: %w", envRouteTimeouts, name, err)
+		}
+		c.Vars.RouteTimeouts[name] = d
+	}
+
+	return nil
+}
+
+func (c *config) loadSessions() error {
+	c.Vars.RevocationStore = strings.ToLower(c.getEnv(envRevocationStore, RevocationStoreMemory))
+	if c.Vars.RevocationStore != RevocationStoreMemory && c.Vars.RevocationStore != RevocationStoreSQL {
+		return fmt.Errorf("invalid %s: %s", envRevocationStore, c.Vars.RevocationStore)
+	}
+
+	ttls := []struct {
+		key      string
+		fallback string
+		value    *time.Duration
+	}{
+		{key: envAccessTokenTTL, fallback: defaultAccessTokenTTL, value: &c.Vars.AccessTokenTTL},
+		{key: envRefreshTokenTTL, fallback: defaultRefreshTTL, value: &c.Vars.RefreshTokenTTL},
+	}
+	for _, t := range ttls {
+		ttl, err := time.ParseDuration(c.getEnv(t.key, t.fallback))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", t.key, err)
+		}
+		*t.value = ttl
+	}
+	if c.Vars.AccessTokenTTL <= 0 || c.Vars.RefreshTokenTTL <= c.Vars.AccessTokenTTL {
+		return fmt.Errorf("invalid %s: it must outlive %s", envRefreshTokenTTL, envAccessTokenTTL)
+	}
+
+	return nil
+}
+
+func (c *config) loadMail() error {
+	c.Vars.MailDriver = strings.ToLower(c.getEnv(envMailDriver, MailDriverFile))
+	c.Vars.MailFrom = c.getEnv(envMailFrom, defaultMailFrom)
+	c.Vars.MailDir = c.getEnv(envMailDir, defaultMailDir)
+	if !filepath.IsAbs(c.Vars.MailDir) {
+		c.Vars.MailDir = filepath.Join(c.Vars.ProyectPath, c.Vars.MailDir)
+	}
+	c.Vars.SMTPHost = os.Getenv(envSMTPHost)
+	c.Vars.SMTPPort = c.getEnv(envSMTPPort, defaultSMTPPort)
+	c.Vars.SMTPUser = os.Getenv(envSMTPUser)
+	c.Vars.SMTPPassword = os.Getenv(envSMTPPassword)
+	c.Vars.PasswordResetURL = os.Getenv(envPasswordResetURL)
+	c.Vars.EmailVerificationURL = os.Getenv(envVerificationURL)
+
+	requireVerified, err := strconv.ParseBool(c.getEnv(envRequireVerified, defaultRequireVerify))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envRequireVerified, err)
+	}
+	c.Vars.RequireVerifiedEmail = requireVerified
+
+	durations := []struct {
+		key      string
+		fallback string
+		value    *time.Duration
+	}{
+		{key: envPasswordResetTTL, fallback: defaultResetTTL, value: &c.Vars.PasswordResetTTL},
+		{key: envVerificationTTL, fallback: defaultVerifyTTL, value: &c.Vars.EmailVerificationTTL},
+		{key: envVerificationWait, fallback: defaultVerifyWait, value: &c.Vars.EmailVerificationResend},
+	}
+	for _, d := range durations {
+		duration, err := time.ParseDuration(c.getEnv(d.key, d.fallback))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+		*d.value = duration
+	}
+
+	switch c.Vars.MailDriver {
+	case MailDriverFile:
+	case MailDriverSMTP:
+		if c.Vars.SMTPHost == "" {
+			return fmt.Errorf("invalid %s: %s requires %s", envMailDriver, MailDriverSMTP, envSMTPHost)
+		}
+	default:
+		return fmt.Errorf("invalid %s: %s", envMailDriver, c.Vars.MailDriver)
+	}
+	if c.Vars.PasswordResetTTL <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envPasswordResetTTL)
+	}
+	if c.Vars.EmailVerificationTTL <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envVerificationTTL)
+	}
+	if c.Vars.EmailVerificationResend < 0 {
+		return fmt.Errorf("invalid %s: it can not be negative", envVerificationWait)
+	}
+
+	return nil
+}
+
+func (c *config) loadSMS() error {
+	c.Vars.SMSDir = c.getEnv(envSMSDir, defaultSMSDir)
+	if !filepath.IsAbs(c.Vars.SMSDir) {
+		c.Vars.SMSDir = filepath.Join(c.Vars.ProyectPath, c.Vars.SMSDir)
+	}
+
+	attempts, err := strconv.Atoi(c.getEnv(envPhoneOTPAttempts, defaultOTPAttempts))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envPhoneOTPAttempts, err)
+	}
+	if attempts <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envPhoneOTPAttempts)
+	}
+	c.Vars.PhoneOTPAttempts = attempts
+
+	durations := []struct {
+		key      string
+		fallback string
+		value    *time.Duration
+	}{
+		{key: envPhoneOTPTTL, fallback: defaultOTPTTL, value: &c.Vars.PhoneOTPTTL},
+		{key: envPhoneOTPResend, fallback: defaultOTPResend, value: &c.Vars.PhoneOTPResend},
+	}
+	for _, d := range durations {
+		duration, err := time.ParseDuration(c.getEnv(d.key, d.fallback))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+		*d.value = duration
+	}
+	if c.Vars.PhoneOTPTTL <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envPhoneOTPTTL)
+	}
+	if c.Vars.PhoneOTPResend < 0 {
+		return fmt.Errorf("invalid %s: it can not be negative", envPhoneOTPResend)
+	}
+
+	return nil
+}
+
+func (c *config) loadMFA() error {
+	c.Vars.TOTPIssuer = c.getEnv(envTOTPIssuer, c.Vars.ProyectName)
+
+	attempts, err := strconv.Atoi(c.getEnv(envMFAAttempts, defaultMFAAttempts))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envMFAAttempts, err)
+	}
+	if attempts <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envMFAAttempts)
+	}
+	c.Vars.MFAAttempts = attempts
+
+	ttl, err := time.ParseDuration(c.getEnv(envMFATokenTTL, defaultMFATokenTTL))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envMFATokenTTL, err)
+	}
+	c.Vars.MFATokenTTL = ttl
+	if c.Vars.MFATokenTTL <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envMFATokenTTL)
+	}
+
+	return nil
+}
+
+func (c *config) loadLockout() error {
+	c.Vars.LockoutStore = strings.ToLower(c.getEnv(envLockoutStore, LockoutStoreMemory))
+	if c.Vars.LockoutStore != LockoutStoreMemory && c.Vars.LockoutStore != LockoutStoreSQL {
+		return fmt.Errorf("invalid %s: %s", envLockoutStore, c.Vars.LockoutStore)
+	}
+	c.Vars.AdminKey = os.Getenv(envAdminKey)
+
+	thresholds := []struct {
+		key      string
+		fallback string
+		value    *int
+	}{
+		{key: envLockoutThreshold, fallback: defaultLockoutLimit, value: &c.Vars.LockoutThreshold},
+		{key: envLockoutIPLimit, fallback: defaultLockoutIPLimit, value: &c.Vars.LockoutIPThreshold},
+	}
+	for _, t := range thresholds {
+		threshold, err := strconv.Atoi(c.getEnv(t.key, t.fallback))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", t.key, err)
+		}
+		if threshold <= 0 {
+			return fmt.Errorf("invalid %s: it must be positive", t.key)
+		}
+		*t.value = threshold
+	}
+
+	delays := []struct {
+		key      string
+		fallback string
+		value    *time.Duration
+	}{
+		{key: envLockoutBase, fallback: defaultLockoutBase, value: &c.Vars.LockoutBaseDelay},
+		{key: envLockoutMax, fallback: defaultLockoutMax, value: &c.Vars.LockoutMaxDelay},
+		{key: envLockoutWindow, fallback: defaultLockoutWindow, value: &c.Vars.LockoutWindow},
+	}
+	for _, d := range delays {
+		delay, err := time.ParseDuration(c.getEnv(d.key, d.fallback))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+		*d.value = delay
+	}
+	if c.Vars.LockoutBaseDelay <= 0 || c.Vars.LockoutMaxDelay < c.Vars.LockoutBaseDelay {
+		return fmt.Errorf("invalid %s: it must be positive and not exceed %s", envLockoutBase, envLockoutMax)
+	}
+	if c.Vars.LockoutWindow <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envLockoutWindow)
+	}
+
+	return nil
+}
+
+func (c *config) loadBulk() error {
+	batchSize, err := strconv.Atoi(c.getEnv(envImportBatchSize, defaultImportBatch))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envImportBatchSize, err)
+	}
+	if batchSize <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envImportBatchSize)
+	}
+	c.Vars.ImportBatchSize = batchSize
+
+	importTimeout, err := time.ParseDuration(c.getEnv(envImportTimeout, defaultImportTimeout))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envImportTimeout, err)
+	}
+	if importTimeout <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envImportTimeout)
+	}
+	c.Vars.ImportTimeout = importTimeout
+
+	exportTimeout, err := time.ParseDuration(c.getEnv(envExportTimeout, defaultExportTimeout))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envExportTimeout, err)
+	}
+	c.Vars.ExportWriteTimeout = exportTimeout
+
+	return nil
+}
+
+func (c *config) loadGraphQL() error {
+	limits := []struct {
+		key      string
+		fallback string
+		value    *int
+	}{
+		{key: envGraphQLDepth, fallback: defaultGraphQLDepth, value: &c.Vars.GraphQLMaxDepth},
+		{key: envGraphQLCost, fallback: defaultGraphQLCost, value: &c.Vars.GraphQLMaxComplexity},
+	}
+	for _, l := range limits {
+		limit, err := strconv.Atoi(c.getEnv(l.key, l.fallback))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", l.key, err)
+		}
+		if limit <= 0 {
+			return fmt.Errorf("invalid %s: it must be positive", l.key)
+		}
+		*l.value = limit
+	}
+
+	return nil
+}
+
+func (c *config) loadGRPC() error {
+	port := c.getEnv(envGRPCPort, defaultGRPCPort)
+	number, err := strconv.Atoi(port)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envGRPCPort, err)
+	}
+	if number <= 0 || number > 65535 {
+		return fmt.Errorf("invalid %s: it must be between 1 and 65535", envGRPCPort)
+	}
+	if port == c.port {
+		return fmt.Errorf("invalid %s: it is the port of the http server", envGRPCPort)
+	}
+	c.Vars.GRPCPort = port
+
+	return nil
+}
+
+func (c *config) loadEvents() error {
+	c.Vars.EventsStore = strings.ToLower(c.getEnv(envEventsStore, EventsStoreMemory))
+	if c.Vars.EventsStore != EventsStoreMemory && c.Vars.EventsStore != EventsStoreSQL {
+		return fmt.Errorf("invalid %s: %s", envEventsStore, c.Vars.EventsStore)
+	}
+
+	size, err := strconv.Atoi(c.getEnv(envEventsBuffer, defaultEventsBuffer))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", envEventsBuffer, err)
+	}
+	if size <= 0 {
+		return fmt.Errorf("invalid %s: it must be positive", envEventsBuffer)
+	}
+	c.Vars.EventsBufferSize = size
+
+	durations := []struct {
+		key      string
+		fallback string
+		value    *time.Duration
+	}{
+		{key: envEventsHeartbeat, fallback: defaultHeartbeat, value: &c.Vars.EventsHeartbeat},
+		{key: envEventsTimeout, fallback: defaultEventsTimeout, value: &c.Vars.EventsStreamTimeout},
+	}
+	for _, d := range durations {
+		duration, err := time.ParseDuration(c.getEnv(d.key, d.fallback))
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", d.key, err)
+		}
+		if duration <= 0 {
+			return fmt.Errorf("invalid %s: it must be positive", d.key)
+		}
+		*d.value = duration
+	}
+	if c.Vars.EventsHeartbeat >= c.Vars.EventsStreamTimeout {
+		return fmt.Errorf("invalid %s: it must be shorter than %s", envEventsHeartbeat, envEventsTimeout)
+	}
+
+	return nil
+}
+
+func (c *config) loadVersions() error {
+	c.Vars.APIDefaultVersion = strings.ToLower(c.getEnv(envDefaultVersion, APIVersions[len(APIVersions)-1]))
+	if !IsAPIVersion(c.Vars.APIDefaultVersion) {
+		return fmt.Errorf("invalid %s: %s", envDefaultVersion, c.Vars.APIDefaultVersion)
+	}
+	c.Vars.APIBasePath = fmt.Sprintf("%s/%s", c.Vars.APIRootPath, c.Vars.APIDefaultVersion)
+
+	deprecations, err := c.getEnvVersionDates(envDeprecations)
+	if err != nil {
+		return err
+	}
+	c.Vars.APIDeprecations = deprecations
+
+	sunsets, err := c.getEnvVersionDates(envSunsets)
+	if err != nil {
+		return err
+	}
+	c.Vars.APISunsets = sunsets
+
+	return nil
+}
+
+// getEnvVersionDates reads a variable with the form "version=RFC 3339 date,version=RFC 3339 date"
+func (c *config) getEnvVersionDates(key string) (map[string]time.Time, error) {
+	dates := make(map[string]time.Time)
+	for version, value := range c.getEnvMap(key) {
+		version = strings.ToLower(version)
+		if !IsAPIVersion(version) {
+			return nil, fmt.Errorf("invalid %s: unknown version %s", key, version)
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s for version %s: %w", key, version, err)
+		}
+		dates[version] = t
+	}
+	return dates, nil
+}
+
+// getEnvMap reads a variable with the form "name=value,name=value"
+func (c *config) getEnvMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range strings.Split(os.Getenv(key), ",") {
+		name, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || name == "" {
+			continue
+		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
+	}
+	return values
+}
+
 func (c *config) loadName() (string, error) {
 	cmd := exec.Command("go", "list", "-m")
 	out, err := cmd.Output()
@@ -4,6 +4,7 @@ package config_test
 import (
 	"dall06/go-cleanapi/config"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -61,6 +62,43 @@ func TestConfigVars(test *testing.T) {
 			assert.NotEmpty(t, vars.CookieSecret, "expected cookie secret, but got empty")
 			assert.NotEmpty(t, vars.APIVersion, "expected api version, but got empty")
 			assert.NotEmpty(t, vars.AppName, "expected app name, but got empty")
+			assert.NotEmpty(t, vars.TraceExporter, "expected trace exporter, but got empty")
+			assert.NotEmpty(t, vars.TraceEndpoint, "expected trace endpoint, but got empty")
+			assert.NotEmpty(t, vars.BodyLimit, "expected body limit, but got empty")
+			assert.NotEmpty(t, vars.ReadTimeout, "expected read timeout, but got empty")
+			assert.NotEmpty(t, vars.WriteTimeout, "expected write timeout, but got empty")
+			assert.NotEmpty(t, vars.IdleTimeout, "expected idle timeout, but got empty")
+			assert.NotEmpty(t, vars.HandlerTimeout, "expected handler timeout, but got empty")
+			assert.NotNil(t, vars.RouteBodyLimits, "expected route body limits, but got nil")
+			assert.NotNil(t, vars.RouteTimeouts, "expected route timeouts, but got nil")
+			assert.NotEmpty(t, vars.RevocationStore, "expected revocation store, but got empty")
+			assert.NotEmpty(t, vars.AccessTokenTTL, "expected access token ttl, but got empty")
+			assert.NotEmpty(t, vars.RefreshTokenTTL, "expected refresh token ttl, but got empty")
+			assert.NotEmpty(t, vars.MailDriver, "expected mail driver, but got empty")
+			assert.NotEmpty(t, vars.MailFrom, "expected mail from, but got empty")
+			assert.NotEmpty(t, vars.MailDir, "expected mail dir, but got empty")
+			assert.NotEmpty(t, vars.PasswordResetTTL, "expected password reset ttl, but got empty")
+			assert.NotEmpty(t, vars.EmailVerificationTTL, "expected email verification ttl, but got empty")
+			assert.NotEmpty(t, vars.SMSDir, "expected sms dir, but got empty")
+			assert.NotEmpty(t, vars.PhoneOTPTTL, "expected phone otp ttl, but got empty")
+			assert.NotEmpty(t, vars.PhoneOTPAttempts, "expected phone otp attempts, but got empty")
+			assert.NotEmpty(t, vars.TOTPIssuer, "expected totp issuer, but got empty")
+			assert.NotEmpty(t, vars.MFATokenTTL, "expected mfa token ttl, but got empty")
+			assert.NotEmpty(t, vars.MFAAttempts, "expected mfa attempts, but got empty")
+			assert.NotEmpty(t, vars.LockoutStore, "expected lockout store, but got empty")
+			assert.NotEmpty(t, vars.LockoutThreshold, "expected lockout threshold, but got empty")
+			assert.NotEmpty(t, vars.LockoutIPThreshold, "expected lockout ip threshold, but got empty")
+			assert.NotEmpty(t, vars.LockoutBaseDelay, "expected lockout base delay, but got empty")
+			assert.NotEmpty(t, vars.LockoutWindow, "expected lockout window, but got empty")
+			assert.NotEmpty(t, vars.ImportBatchSize, "expected import batch size, but got empty")
+			assert.NotEmpty(t, vars.ExportWriteTimeout, "expected export write timeout, but got empty")
+			assert.NotEmpty(t, vars.GraphQLMaxDepth, "expected graphql max depth, but got empty")
+			assert.NotEmpty(t, vars.GraphQLMaxComplexity, "expected graphql max complexity, but got empty")
+			assert.NotEmpty(t, vars.GRPCPort, "expected grpc port, but got empty")
+			assert.NotEmpty(t, vars.EventsStore, "expected events store, but got empty")
+			assert.NotEmpty(t, vars.EventsBufferSize, "expected events buffer size, but got empty")
+			assert.NotEmpty(t, vars.EventsHeartbeat, "expected events heartbeat, but got empty")
+			assert.NotEmpty(t, vars.EventsStreamTimeout, "expected events stream timeout, but got empty")
 		})
 	}
 
@@ -76,3 +114,246 @@ func TestConfigVars(test *testing.T) {
 		})
 	}
 }
+
+func TestConfigLimits(test *testing.T) {
+	successfulCases := []struct {
+		name              string
+		env               map[string]string
+		expectedBodyLimit int
+		expectedTimeout   time.Duration
+		expectedRoutes    map[string]time.Duration
+	}{
+		{
+			name: "it should load limits and per route overrides",
+			env: map[string]string{
+				"BODY_LIMIT":        "2048",
+				"HANDLER_TIMEOUT":   "2s",
+				"ROUTE_BODY_LIMITS": "auth=512, signup=1024",
+				"ROUTE_TIMEOUTS":    "all=10s",
+			},
+			expectedBodyLimit: 2048,
+			expectedTimeout:   2 * time.Second,
+			expectedRoutes:    map[string]time.Duration{"all": 10 * time.Second},
+		},
+	}
+
+	failedCases := []struct {
+		name string
+		env  map[string]string
+	}{
+		{
+			name: "it should not load limits, invalid body limit",
+			env:  map[string]string{"BODY_LIMIT": "big"},
+		},
+		{
+			name: "it should not load limits, invalid handler timeout",
+			env:  map[string]string{"HANDLER_TIMEOUT": "5"},
+		},
+		{
+			name: "it should not load limits, route body limit greater than global",
+			env:  map[string]string{"BODY_LIMIT": "1024", "ROUTE_BODY_LIMITS": "signup=2048"},
+		},
+		{
+			name: "it should not load limits, invalid route timeout",
+			env:  map[string]string{"ROUTE_TIMEOUTS": "all=ten"},
+		},
+		{
+			name: "it should not load limits, refresh token shorter than access token",
+			env:  map[string]string{"ACCESS_TOKEN_TTL": "1h", "REFRESH_TOKEN_TTL": "30m"},
+		},
+		{
+			name: "it should not load config, unknown revocation store",
+			env:  map[string]string{"REVOCATION_STORE": "redis"},
+		},
+		{
+			name: "it should not load config, unknown mail driver",
+			env:  map[string]string{"MAIL_DRIVER": "pigeon"},
+		},
+		{
+			name: "it should not load config, smtp driver without host",
+			env:  map[string]string{"MAIL_DRIVER": "smtp", "SMTP_HOST": ""},
+		},
+		{
+			name: "it should not load config, invalid password reset ttl",
+			env:  map[string]string{"PASSWORD_RESET_TTL": "-1m"},
+		},
+		{
+			name: "it should not load config, invalid email verification ttl",
+			env:  map[string]string{"EMAIL_VERIFICATION_TTL": "0s"},
+		},
+		{
+			name: "it should not load config, invalid require verified email switch",
+			env:  map[string]string{"REQUIRE_VERIFIED_EMAIL": "maybe"},
+		},
+		{
+			name: "it should not load config, invalid phone otp attempts",
+			env:  map[string]string{"PHONE_OTP_ATTEMPTS": "0"},
+		},
+		{
+			name: "it should not load config, invalid mfa token ttl",
+			env:  map[string]string{"MFA_TOKEN_TTL": "0s"},
+		},
+		{
+			name: "it should not load config, invalid lockout store",
+			env:  map[string]string{"LOCKOUT_STORE": "redis"},
+		},
+		{
+			name: "it should not load config, lockout base delay over the max delay",
+			env:  map[string]string{"LOCKOUT_BASE_DELAY": "2h", "LOCKOUT_MAX_DELAY": "1h"},
+		},
+		{
+			name: "it should not load config, invalid import batch size",
+			env:  map[string]string{"IMPORT_BATCH_SIZE": "0"},
+		},
+		{
+			name: "it should not load config, invalid import timeout",
+			env:  map[string]string{"IMPORT_TIMEOUT": "0s"},
+		},
+		{
+			name: "it should not load config, invalid export write timeout",
+			env:  map[string]string{"EXPORT_WRITE_TIMEOUT": "forever"},
+		},
+		{
+			name: "it should not load config, invalid graphql max depth",
+			env:  map[string]string{"GRAPHQL_MAX_DEPTH": "0"},
+		},
+		{
+			name: "it should not load config, invalid graphql max complexity",
+			env:  map[string]string{"GRAPHQL_MAX_COMPLEXITY": "a lot"},
+		},
+		{
+			name: "it should not load config, invalid grpc port",
+			env:  map[string]string{"GRPC_PORT": "70000"},
+		},
+		{
+			name: "it should not load config, grpc port of the http server",
+			env:  map[string]string{"GRPC_PORT": "8080"},
+		},
+		{
+			name: "it should not load config, invalid events store",
+			env:  map[string]string{"EVENTS_STORE": "kafka"},
+		},
+		{
+			name: "it should not load config, invalid events buffer size",
+			env:  map[string]string{"EVENTS_BUFFER_SIZE": "-1"},
+		},
+		{
+			name: "it should not load config, events heartbeat over the stream timeout",
+			env:  map[string]string{"EVENTS_HEARTBEAT": "2h", "EVENTS_STREAM_TIMEOUT": "1h"},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			cfg := config.NewConfig("8080", "0")
+			vars, err := cfg.SetConfig()
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedBodyLimit, vars.BodyLimit)
+			assert.Equal(t, tc.expectedTimeout, vars.HandlerTimeout)
+			assert.Equal(t, tc.expectedRoutes, vars.RouteTimeouts)
+			assert.Equal(t, 512, vars.RouteBodyLimits["auth"])
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			cfg := config.NewConfig("8080", "0")
+			vars, err := cfg.SetConfig()
+			assert.Error(t, err)
+			assert.Empty(t, vars, "expected nil, but got vars")
+		})
+	}
+}
+
+func TestConfigVersions(test *testing.T) {
+	deprecated := time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	successfulCases := []struct {
+		name                 string
+		env                  map[string]string
+		expectedVersion      string
+		expectedDeprecations map[string]time.Time
+		expectedSunsets      map[string]time.Time
+	}{
+		{
+			name:                 "it should serve the latest version by default",
+			env:                  map[string]string{},
+			expectedVersion:      config.APIv2,
+			expectedDeprecations: map[string]time.Time{},
+			expectedSunsets:      map[string]time.Time{},
+		},
+		{
+			name: "it should load the default version, deprecations and sunsets",
+			env: map[string]string{
+				"API_DEFAULT_VERSION": "V1",
+				"API_DEPRECATIONS":    "v1=2023-06-01T00:00:00Z",
+				"API_SUNSETS":         "v1=2024-06-01T00:00:00Z",
+			},
+			expectedVersion:      config.APIv1,
+			expectedDeprecations: map[string]time.Time{config.APIv1: deprecated},
+			expectedSunsets:      map[string]time.Time{config.APIv1: sunset},
+		},
+	}
+
+	failedCases := []struct {
+		name string
+		env  map[string]string
+	}{
+		{
+			name: "it should not load versions, unknown default version",
+			env:  map[string]string{"API_DEFAULT_VERSION": "v9"},
+		},
+		{
+			name: "it should not load versions, deprecation of an unknown version",
+			env:  map[string]string{"API_DEPRECATIONS": "v0=2023-06-01T00:00:00Z"},
+		},
+		{
+			name: "it should not load versions, invalid sunset date",
+			env:  map[string]string{"API_SUNSETS": "v1=tomorrow"},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			cfg := config.NewConfig("8080", "0")
+			vars, err := cfg.SetConfig()
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedVersion, vars.APIDefaultVersion)
+			assert.Equal(t, vars.APIRootPath+"/"+tc.expectedVersion, vars.APIBasePath)
+			assert.Equal(t, tc.expectedDeprecations, vars.APIDeprecations)
+			assert.Equal(t, tc.expectedSunsets, vars.APISunsets)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+
+			cfg := config.NewConfig("8080", "0")
+			vars, err := cfg.SetConfig()
+			assert.Error(t, err)
+			assert.Empty(t, vars, "expected nil, but got vars")
+		})
+	}
+}
@@ -9,7 +9,7 @@ import (
 // @description Golang REST Api based on Uncle's Bob Clean Arch
 // @version 1.0.0
 // @host localhost:8080
-// @BasePath /go-cleanapi/api/v1
+// @BasePath /go-cleanapi/api/v2
 func main() {
 	app := cmd.NewApp()
 
@@ -5,16 +5,30 @@
 package controller
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/adapter/render"
+	"dall06/go-cleanapi/pkg/internal"
 	"dall06/go-cleanapi/pkg/internal/usecases"
 	"dall06/go-cleanapi/utils"
 	"database/sql"
+	"errors"
 	"fmt"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
+	fiberutils "github.com/gofiber/fiber/v2/utils"
 	"github.com/mitchellh/mapstructure"
 	"github.com/patrickmn/go-cache"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -26,34 +40,93 @@ const (
 
 	requestError  = "request error"
 	internalError = "internal error"
-	notFound      = "not Found error"
-	missingID     = "missing id parameter"
+	// the messages of the responses are keys of the message catalog
+	notFound      = "request.not_found"
+	missingID     = "request.missing_id"
+	invalidUser   = "request.invalid_user"
 	userIsNil     = "user is null"
 	usersAreNil   = "users are null"
-	registered    = "account registered successfully"
-	modified      = "account modified successfully"
-	deleted       = "account deleted successfully"
+	userNotFound  = "user.not_found"
+	usersNotFound = "users.not_found"
+	registered    = "account.registered"
+	modified      = "account.modified"
+	deleted       = "account.deleted"
+	loggedOut     = "session.logged_out"
+	pwdChanged    = "password.changed"
+	pwdReset      = "password.reset"
+	resetSent     = "password.reset_sent"
+	emailVerified = "email.verified"
+	verifySent    = "email.verification_sent"
+	phoneCodeSent = "phone.code_sent"
+	phoneVerified = "phone.verified"
+	mfaRequired   = "mfa.required"
+	loggedIn      = "session.logged_in"
+	totpEnabled   = "totp.enabled"
+	totpDisabled  = "totp.disabled"
+	unlocked      = "account.unlocked"
+	refreshed     = "session.refreshed"
+	noSession     = "session.missing"
 
-	processed = "request processed"
+	// SessionCookie is the cookie carrying the user jwt
+	SessionCookie = "session_id"
+	// RefreshCookie is the cookie carrying the opaque refresh token
+	RefreshCookie = "refresh_token"
+
+	processed         = "request processed"
+	unsupportedImport = "request.unsupported_import"
+	invalidEventID    = "request.invalid_last_event_id"
+	invalidDryRun     = "request.invalid_dry_run"
+	missingToken      = "request.missing_token"
+	invalidQuery      = "request.invalid_query"
+	invalidImport     = "request.invalid_import"
 )
 
 // Controller is an interface for controller
 type Controller interface {
 	Auth(context *fiber.Ctx) error
+	AuthMFA(context *fiber.Ctx) error
+	Refresh(context *fiber.Ctx) error
 	Post(context *fiber.Ctx) error
 	Get(context *fiber.Ctx) error
 	GetAll(context *fiber.Ctx) error
+	Search(context *fiber.Ctx) error
 	Put(context *fiber.Ctx) error
+	Patch(context *fiber.Ctx) error
+	ChangePassword(context *fiber.Ctx) error
+	ForgotPassword(context *fiber.Ctx) error
+	ResetPassword(context *fiber.Ctx) error
+	VerifyEmail(context *fiber.Ctx) error
+	ResendVerification(context *fiber.Ctx) error
+	RequestPhoneCode(context *fiber.Ctx) error
+	ConfirmPhoneCode(context *fiber.Ctx) error
+	EnrollTOTP(context *fiber.Ctx) error
+	ConfirmTOTP(context *fiber.Ctx) error
+	DisableTOTP(context *fiber.Ctx) error
+	Unlock(context *fiber.Ctx) error
+	Import(context *fiber.Ctx) error
+	Export(context *fiber.Ctx) error
+	Events(context *fiber.Ctx) error
 	Delete(context *fiber.Ctx) error
+	Logout(context *fiber.Ctx) error
+	LogoutAll(context *fiber.Ctx) error
 }
 
 type controller struct {
-	usecases    usecases.UseCases
-	validate    validator.Validate
-	logger      utils.Logger
-	jwt         utils.JWT
-	validations utils.Validations
-	cache       *cache.Cache
+	usecases      usecases.UseCases
+	sessions      usecases.Sessions
+	resets        usecases.PasswordResets
+	verifications usecases.EmailVerifications
+	phones        usecases.PhoneVerifications
+	twoFactor     usecases.TwoFactor
+	lockouts      usecases.Lockouts
+	importer      Importer
+	exporter      Exporter
+	streamer      EventStreamer
+	validate      validator.Validate
+	logger        utils.Logger
+	jwt           utils.JWT
+	validations   utils.Validations
+	cache         *cache.Cache
 }
 
 var _ Controller = (*controller)(nil)
@@ -61,6 +134,15 @@ var _ Controller = (*controller)(nil)
 // NewController is a Constructor for controller
 func NewController(
 	uc usecases.UseCases,
+	s usecases.Sessions,
+	pr usecases.PasswordResets,
+	ev usecases.EmailVerifications,
+	pv usecases.PhoneVerifications,
+	tf usecases.TwoFactor,
+	lo usecases.Lockouts,
+	im Importer,
+	ex Exporter,
+	es EventStreamer,
 	v validator.Validate,
 	l utils.Logger,
 	j utils.JWT,
@@ -68,22 +150,41 @@ func NewController(
 	c cache.Cache,
 ) Controller {
 	return &controller{
-		usecases:    uc,
-		validate:    v,
-		logger:      l,
-		jwt:         j,
-		validations: val,
-		cache:       &c,
+		usecases:      uc,
+		sessions:      s,
+		resets:        pr,
+		verifications: ev,
+		phones:        pv,
+		twoFactor:     tf,
+		lockouts:      lo,
+		importer:      im,
+		exporter:      ex,
+		streamer:      es,
+		validate:      v,
+		logger:        l,
+		jwt:           j,
+		validations:   val,
+		cache:         &c,
 	}
 }
 
 // @Summary Auth as user
-// @Description auth a as user with phone or mail
-// @Accept json
-// @Produce json
+// @Description auth a as user with phone or mail, users with two-factor auth get an mfa token
+// @Description instead of the session cookies, it is exchanged at /users/auth/mfa.
+// @Description Repeated failures lock the account and the ip for a growing time, a locked account
+// @Description is answered like wrong credentials. When verified emails are required, the right
+// @Description password of an unverified email gets a 403, which is not a failure
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
 // @Param user body PostRequest true "PostRequest object"
-// @Success 200 {string} Accepted
+// @Success 200 {string} MFARequired
+// @Success 202 {string} Accepted
 // @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /users/auth [post]
 func (c *controller) Auth(ctx *fiber.Ctx) error {
 	req := &AuthRequest{
@@ -94,7 +195,7 @@ func (c *controller) Auth(ctx *fiber.Ctx) error {
 
 	if err := c.validate.Struct(req); err != nil {
 		c.logger.Error("%s: %s", requestError, err)
-		return fiber.NewError(statusBadRequest, fmt.Sprintf("%s: %s", requestError, err))
+		return problem.Validation(err)
 	}
 
 	userName := req.UserName
@@ -104,59 +205,394 @@ func (c *controller) Auth(ctx *fiber.Ctx) error {
 	case c.validations.IsPhone(userName):
 		userInput.Phone = userName
 	default:
-		return fiber.NewError(statusBadRequest, fmt.Sprintf("%s: %s", requestError, "invalid user format"))
+		return problem.BadRequest(invalidUser)
 	}
 	userInput.Password = req.Password
 
-	res, err := c.usecases.AuthUser(userInput)
+	if err := c.lockouts.Check(ctx.UserContext(), userName, ctx.IP()); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	res, err := c.usecases.AuthUser(ctx.UserContext(), userInput)
+	if errors.Is(err, internal.ErrUnauthorized) {
+		if err := c.lockouts.Fail(ctx.UserContext(), userName, ctx.IP()); err != nil {
+			c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		}
+	}
 	if err != nil {
 		// Return an error response if the use case returns an error
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+		return problem.From(err)
+	}
+	if err := c.lockouts.Succeed(ctx.UserContext(), userName); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
 	}
 	if res.ID == "" {
 		// Return an error response if the use case returns an error
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, missingID)
-		return fiber.NewError(statusBadRequest, fmt.Sprintf("%s: %s", requestError, missingID))
+		return problem.BadRequest(missingID)
+	}
+	if res.MFAToken != "" {
+		c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+		return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, mfaRequired), "mfa_token": res.MFAToken})
 	}
 
-	accessToken, err := c.jwt.CreateUserJWT(res.ID)
+	refreshToken, err := c.sessions.IssueRefreshToken(ctx.UserContext(), res.ID)
 	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	if err := c.setSessionCookies(ctx, refreshToken); err != nil {
 		// Return an error response if the use case returns an error
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, userIsNil)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, userIsNil))
+		return problem.Internal(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusAccepted, fiber.Map{"msg": message(ctx, registered)})
+}
+
+// @Summary Unlock the logins of an account or an ip
+// @Description Forget the failed logins and the lock of a user name, an ip or both,
+// @Description it needs the admin key in the x-admin-key header
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
+// @Param unlock body UnlockRequest true "UnlockRequest object"
+// @Success 200 {string} Unlocked
+// @Security ApiKeyAuth
+// @Security AdminKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/lockouts/unlock [post]
+func (c *controller) Unlock(ctx *fiber.Ctx) error {
+	req := &UnlockRequest{}
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
 	}
 
-	// Create cookie
-	cookie := new(fiber.Cookie)
-	cookie.Name = "session_id"
-	cookie.Value = accessToken
-	cookie.Expires = time.Now().Add(15 * time.Hour)
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	if err := c.lockouts.Unlock(ctx.UserContext(), req.User, req.IP); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
 
 	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
-	ctx.Cookie(cookie)
-	return ctx.Status(fiber.StatusAccepted).JSON(fiber.Map{"msg": registered})
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, unlocked)})
+}
+
+// @Summary Import users
+// @Description Create users from a csv or a newline delimited json body, every row is checked like a signup
+// @Description and rows are created in batches, each one in a transaction. The answer reports every row as
+// @Description created, skipped (the user exists) or failed, with dry_run nothing is created.
+// @Description The format is the format query or the content type: text/csv or application/x-ndjson.
+// @Description The body is streamed, an import that times out answers 503 with the report of the
+// @Description batches it created, marked as interrupted
+// @Accept plain
+// @Produce json,xml,application/msgpack
+// @Param format query string false "csv or ndjson"
+// @Param dry_run query bool false "report without creating"
+// @Success 200 {object} ImportReport
+// @Security ApiKeyAuth
+// @Security AdminKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 415 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Failure 503 {object} ImportReport
+// @Router /users/import [post]
+func (c *controller) Import(ctx *fiber.Ctx) error {
+	format := ctx.Query("format")
+	if format == "" {
+		format = importFormat(string(ctx.Request().Header.ContentType()))
+	}
+	if format != ImportCSV && format != ImportNDJSON {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, unsupportedImport)
+		return problem.New(fiber.StatusUnsupportedMediaType, "", unsupportedImport)
+	}
+
+	dryRun, err := strconv.ParseBool(ctx.Query("dry_run", "false"))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.BadRequest(invalidDryRun)
+	}
+
+	// the server streams request bodies, so the rows are read as they arrive
+	body := ctx.Context().RequestBodyStream()
+	if body == nil {
+		body = bytes.NewReader(ctx.Body())
+	}
+
+	report, err := c.importer.Import(ctx.UserContext(), body, format, dryRun)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		if ctx.UserContext().Err() != nil {
+			// the batches created before the timeout stay, the report tells which rows they were
+			if report != nil {
+				return render.Respond(ctx, fiber.StatusServiceUnavailable, report.Localize(i18n.TranslatorOf(ctx)))
+			}
+			return problem.From(err)
+		}
+		return problem.BadRequest(invalidImport).Wrap(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusOK, report.Localize(i18n.TranslatorOf(ctx)))
+}
+
+// importFormat is the import format of a content type, empty when it is not one
+func importFormat(contentType string) string {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case "text/csv":
+		return ImportCSV
+	case "application/x-ndjson", "application/ndjson":
+		return ImportNDJSON
+	default:
+		return ""
+	}
+}
+
+// @Summary Export users
+// @Description Stream the users matching the filters as csv or newline delimited json, in creation order.
+// @Description The users are written as they are read, so the export of a large table starts right away.
+// @Description columns is a comma separated list of uid, email, phone, created_at, email_verified and
+// @Description phone_verified, every column is exported when it is empty
+// @Produce plain
+// @Param format query string false "csv (default) or ndjson"
+// @Param columns query string false "comma separated columns"
+// @Param email query string false "email prefix"
+// @Param phone query string false "phone prefix"
+// @Param created_from query string false "RFC 3339 lower bound of created_at"
+// @Param created_to query string false "RFC 3339 upper bound of created_at"
+// @Success 200 {string} string
+// @Security ApiKeyAuth
+// @Security AdminKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Router /users/export [get]
+func (c *controller) Export(ctx *fiber.Ctx) error {
+	req := &ExportRequest{}
+	if err := ctx.QueryParser(req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.BadRequest(invalidQuery).Wrap(err)
+	}
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", requestError, err)
+		return problem.Validation(err)
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if exportFormat(req.Format) == ExportNDJSON {
+		contentType = "application/x-ndjson"
+	}
+	ctx.Attachment("users." + exportFormat(req.Format))
+	ctx.Set(fiber.HeaderContentType, contentType)
+
+	exportCtx, cancel := streamContext(ctx)
+	method, path := ctx.Method(), fiberutils.CopyString(ctx.Path())
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		if err := c.exporter.Export(exportCtx, &streamWriter{w: w, cancel: cancel}, req); err != nil {
+			c.logger.Error("%s path[%s] -> %s: %s", method, path, internalError, err)
+		}
+	})
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return nil
+}
+
+// @Summary Stream user events
+// @Description Stream the user created, updated and deleted events as server-sent events. A client that
+// @Description reconnects sends the id of its last event in the Last-Event-ID header and gets the events
+// @Description it missed while they are still buffered, without it the stream starts with the next event.
+// @Description types is a comma separated list of user.created, user.updated and user.deleted, every type
+// @Description is streamed when it is empty
+// @Produce text/event-stream
+// @Param Last-Event-ID header string false "id of the last event received"
+// @Param types query string false "comma separated event types"
+// @Param uid query string false "uid of the user"
+// @Success 200 {string} string
+// @Security ApiKeyAuth
+// @Security AdminKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/events [get]
+func (c *controller) Events(ctx *fiber.Ctx) error {
+	req := &EventsRequest{}
+	if err := ctx.QueryParser(req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.BadRequest(invalidQuery).Wrap(err)
+	}
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", requestError, err)
+		return problem.Validation(err)
+	}
+
+	// browsers send the header on reconnections, other clients may only be able to set the query
+	lastEventID := ctx.Get("Last-Event-ID", ctx.Query("last_event_id"))
+	var lastID int64
+	if lastEventID != "" {
+		id, err := strconv.ParseInt(lastEventID, 10, 64)
+		if err != nil || id < 0 {
+			c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, invalidEventID)
+			return problem.BadRequest(invalidEventID)
+		}
+		lastID = id
+	} else {
+		id, err := c.streamer.LastID(ctx.UserContext())
+		if err != nil {
+			c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+			return problem.From(err)
+		}
+		lastID = id
+	}
+
+	ctx.Set(fiber.HeaderContentType, "text/event-stream")
+	ctx.Set(fiber.HeaderCacheControl, "no-cache")
+	// proxies buffering the responses would hold the events back
+	ctx.Set("X-Accel-Buffering", "no")
+
+	streamCtx, cancel := streamContext(ctx)
+	method, path := ctx.Method(), fiberutils.CopyString(ctx.Path())
+	ctx.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer cancel()
+		if err := c.streamer.Stream(streamCtx, &streamWriter{w: w, cancel: cancel}, req, lastID); err != nil {
+			c.logger.Error("%s path[%s] -> %s: %s", method, path, internalError, err)
+		}
+	})
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return nil
+}
+
+// @Summary Complete a two-factor login
+// @Description Exchange the mfa token of a login and a totp code or a recovery code for the session cookies,
+// @Description after too many wrong codes every code is refused for a while
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
+// @Param mfa body MFARequest true "MFARequest object"
+// @Success 202 {string} Accepted
+// @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/auth/mfa [post]
+func (c *controller) AuthMFA(ctx *fiber.Ctx) error {
+	req := &MFARequest{}
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	uid, err := c.twoFactor.CompleteLogin(ctx.UserContext(), req.MFAToken, req.Code)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	refreshToken, err := c.sessions.IssueRefreshToken(ctx.UserContext(), uid)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	if err := c.setSessionCookies(ctx, refreshToken); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.Internal(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusAccepted, fiber.Map{"msg": message(ctx, loggedIn)})
+}
+
+// @Summary Refresh the session
+// @Description Exchange the refresh token cookie for a new access token and a new refresh token,
+// @Description a refresh token can be used only once, reusing it ends every session of its family
+// @Produce json,xml,application/msgpack
+// @Success 200 {string} Refreshed
+// @Security ApiKeyAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/refresh [post]
+func (c *controller) Refresh(ctx *fiber.Ctx) error {
+	refreshToken, err := c.sessions.RotateRefreshToken(ctx.UserContext(), ctx.Cookies(RefreshCookie))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		ctx.ClearCookie(SessionCookie, RefreshCookie)
+		return problem.From(err)
+	}
+
+	if err := c.setSessionCookies(ctx, refreshToken); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.Internal(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, refreshed)})
+}
+
+// setSessionCookies signs a new access token for the owner of the refresh token and sets both cookies
+func (c *controller) setSessionCookies(ctx *fiber.Ctx, refreshToken *internal.IssuedRefreshToken) error {
+	accessToken, err := c.jwt.CreateUserJWT(refreshToken.UID)
+	if err != nil {
+		return err
+	}
+	token, err := c.jwt.ParseUserJWT(accessToken)
+	if err != nil {
+		return err
+	}
+
+	ctx.Cookie(&fiber.Cookie{
+		Name:     SessionCookie,
+		Value:    accessToken,
+		Expires:  token.ExpiresAt,
+		HTTPOnly: true,
+	})
+	ctx.Cookie(&fiber.Cookie{
+		Name:     RefreshCookie,
+		Value:    refreshToken.Token,
+		Expires:  refreshToken.ExpiresAt,
+		HTTPOnly: true,
+	})
+	return nil
 }
 
 // @Summary Create a user
 // @Description Create a new user
-// @Accept json
-// @Produce json
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
 // @Param user body PostRequest true "PostRequest object"
 // @Success 201 {string} Created
 // @Security ApiKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /users [post]
 func (c *controller) Post(ctx *fiber.Ctx) error {
 	req := &PostRequest{}
 
-	if err := ctx.BodyParser(&req); err != nil {
-		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+	if err := render.Bind(ctx, &req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
 	}
 
 	if err := c.validate.Struct(req); err != nil {
 		c.logger.Error("%s: %s", requestError, err)
-		return fiber.NewError(statusBadRequest, fmt.Sprintf("%s: %s", requestError, err))
+		return problem.Validation(err)
 	}
 
 	userInput := &User{
@@ -165,24 +601,36 @@ func (c *controller) Post(ctx *fiber.Ctx) error {
 		Password: req.Password,
 	}
 
-	err := c.usecases.RegisterUser(userInput)
+	err := c.usecases.RegisterUser(ctx.UserContext(), userInput)
 	if err != nil {
 		// Return an error response if the use case returns an error
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+		return problem.From(err)
+	}
+
+	// the account exists already, a failed email can be sent again with the resend endpoint
+	err = c.verifications.SendVerification(ctx.UserContext(), req.Email)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
 	}
 
 	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
-	return ctx.Status(fiber.StatusCreated).JSON(fiber.Map{"msg": registered})
+	return render.Respond(ctx, fiber.StatusCreated, fiber.Map{"msg": message(ctx, registered)})
 }
 
 // @Summary Get a user by ID
-// @Description Retrieve a single user by ID
-// @Produce json
+// @Description Retrieve a single user by ID, its ETag is the If-Match of its updates and deletes
+// @Produce json,xml,application/msgpack
 // @Param id path int true "User ID"
+// @Param If-None-Match header string false "ETag of a copy of the user the client has"
 // @Success 200 {object} User
+// @Success 304
+// @Header 200 {string} ETag "version of the user"
 // @Security ApiKeyAuth
 // @Security JwtTokenAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /users/{id} [get]
 func (c *controller) Get(ctx *fiber.Ctx) error {
 	// Get the id parameter from the request context
@@ -190,21 +638,21 @@ func (c *controller) Get(ctx *fiber.Ctx) error {
 	if id == "" {
 		// Return an error response if the id parameter is missing
 		c.logger.Error("%s: %s", requestError, missingID)
-		return fiber.NewError(statusBadRequest, fmt.Sprintf("%s: %s", requestError, missingID))
+		return problem.BadRequest(missingID)
 	}
 
 	// Call the use case to retrieve the user by id
 	userInput := &User{ID: id}
 	empty := &User{}
-	userData, err := c.usecases.IndexUserByID(userInput)
+	userData, err := c.usecases.IndexUserByID(ctx.UserContext(), userInput)
 	if err != nil {
 		// Return an error response if the use case returns an error
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+		return problem.From(err)
 	}
 	if userData == nil {
 		c.logger.Error("%s: %s", statusNotFound, userIsNil)
-		return fiber.NewError(statusNotFound, fmt.Sprintf("%s: %s", internalError, userIsNil))
+		return problem.NotFound(userNotFound)
 	}
 
 	// Convert the user data to the output format
@@ -213,160 +661,881 @@ func (c *controller) Get(ctx *fiber.Ctx) error {
 	if err != nil {
 		// Return an error response if the user data cannot be converted
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+		return problem.Internal(err)
 	}
 	if err == sql.ErrNoRows {
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), notFound, userIsNil)
-		return fiber.NewError(statusNotFound, fmt.Sprintf("%s: %s", notFound, err))
+		return problem.NotFound(userNotFound)
 	}
 	if userOutput == nil {
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), notFound, userIsNil)
-		return fiber.NewError(statusNotFound, fmt.Sprintf("%s: %s", notFound, err))
+		return problem.NotFound(userNotFound)
 	}
 	if userOutput == empty {
 		c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), notFound, userIsNil)
-		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{"data": empty, "msg": notFound})
+		return render.Respond(ctx, fiber.StatusOK, fiber.Map{"data": empty, "msg": message(ctx, notFound)})
+	}
+
+	ctx.Set(fiber.HeaderETag, userETag(userOutput.Version))
+	if ctx.Fresh() {
+		return ctx.SendStatus(fiber.StatusNotModified)
 	}
 
 	// Return a success response with the user data
 	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
-	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{"data": userOutput})
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"data": userOutput})
 }
 
 // @Summary Get all users
-// @Description Retrieve all users
-// @Produce json
-// @Success 200 {array} User
+// @Description Retrieve a page of users, filtered and sorted
+// @Produce json,xml,application/msgpack
+// @Param limit query int false "page size, 1 to 100"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param offset query int false "users to skip, not allowed with cursor"
+// @Param sort query string false "created_at, email or phone, prefixed with - for descending order"
+// @Param email query string false "email prefix"
+// @Param phone query string false "phone prefix"
+// @Param created_from query string false "RFC 3339 inclusive lower bound of the creation date"
+// @Param created_to query string false "RFC 3339 exclusive upper bound of the creation date"
+// @Success 200 {object} UsersPage
+// @Header 200 {string} Link "first and next pages"
 // @Security ApiKeyAuth
 // @Security JwtTokenAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /users [get]
 func (c *controller) GetAll(ctx *fiber.Ctx) error {
+	req := &ListRequest{}
+	if err := ctx.QueryParser(req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.BadRequest(invalidQuery).Wrap(err)
+	}
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", requestError, err)
+		return problem.Validation(err)
+	}
+
 	// check if exists in cache, if yes returns value, if not, continues
-	cachedUsers, found := c.cache.Get("users")
+	cacheKey := fmt.Sprintf("users?%s", ctx.Request().URI().QueryArgs().String())
+	cachedUsers, found := c.cache.Get(cacheKey)
 	if found {
-		usersOutput := cachedUsers.(*Users)
-		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{"data": usersOutput})
+		pageOutput := cachedUsers.(*UsersPage)
+		ctx.Set(fiber.HeaderLink, c.pageLinks(ctx, req, pageOutput))
+		return render.Respond(ctx, fiber.StatusOK, pageOutput)
 	}
 
-	users, err := c.usecases.IndexUsers()
+	page, err := c.usecases.IndexUsers(ctx.UserContext(), req)
 	if err != nil {
 		// Return an error response if the use case returns an error
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+		return problem.From(err)
 	}
-	if users == nil {
+	if page == nil {
 		c.logger.Error("%s: %s", notFound, usersAreNil)
-		return fiber.NewError(statusNotFound, fmt.Sprintf("%s: %s", notFound, usersAreNil))
+		return problem.NotFound(usersNotFound)
 	}
 
 	// Convert the user data to the output format
-	usersOutput := &Users{}
-	err = mapstructure.Decode(users, &usersOutput)
+	pageOutput, err := usersPage(page, req)
 	if err != nil {
 		// Return an error response if the user data cannot be converted
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
-	}
-	if usersOutput == nil {
-		c.logger.Error("%s: %s", notFound, usersAreNil)
-		return fiber.NewError(statusNotFound, fmt.Sprintf("%s: %s", notFound, usersAreNil))
-	}
-	if len(*usersOutput) == 0 {
-		c.logger.Info("%s: %s", notFound, usersAreNil)
-		return ctx.Status(fiber.StatusOK).JSON(fiber.Map{"data": usersOutput})
+		return problem.Internal(err)
 	}
 
 	// Set new cache
-	c.cache.Set("users", usersOutput, cache.DefaultExpiration)
+	c.cache.Set(cacheKey, pageOutput, cache.DefaultExpiration)
 
 	// Return a success response with the user data
 	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
-	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{"data": usersOutput})
+	ctx.Set(fiber.HeaderLink, c.pageLinks(ctx, req, pageOutput))
+	return render.Respond(ctx, fiber.StatusOK, pageOutput)
+}
+
+// @Summary Search users
+// @Description Search users by fragments of their email or phone, their verification status or their
+// @Description creation date, at least one of them is required. Fragments are matched at the start of
+// @Description the field, or anywhere in it with match=contains and 3 characters at least.
+// @Description It needs the admin key in the x-admin-key header
+// @Produce json,xml,application/msgpack
+// @Param limit query int false "page size, 1 to 100"
+// @Param cursor query string false "next_cursor of the previous page"
+// @Param offset query int false "users to skip, not allowed with cursor"
+// @Param sort query string false "created_at, email or phone, prefixed with - for descending order"
+// @Param email query string false "email fragment"
+// @Param phone query string false "phone fragment"
+// @Param match query string false "prefix or contains, prefix by default"
+// @Param email_verified query bool false "only users whose email is or is not verified"
+// @Param phone_verified query bool false "only users whose phone is or is not verified"
+// @Param created_from query string false "RFC 3339 inclusive lower bound of the creation date"
+// @Param created_to query string false "RFC 3339 exclusive upper bound of the creation date"
+// @Success 200 {object} UsersPage
+// @Header 200 {string} Link "first and next pages"
+// @Security ApiKeyAuth
+// @Security AdminKeyAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 403 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/search [get]
+func (c *controller) Search(ctx *fiber.Ctx) error {
+	req := &SearchRequest{}
+	if err := ctx.QueryParser(req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.BadRequest(invalidQuery).Wrap(err)
+	}
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", requestError, err)
+		return problem.Validation(err)
+	}
+
+	// searches are not cached, support staff expect to see the users as they are now
+	page, err := c.usecases.SearchUsers(ctx.UserContext(), req)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+	if page == nil {
+		c.logger.Error("%s: %s", notFound, usersAreNil)
+		return problem.NotFound(usersNotFound)
+	}
+
+	pageOutput, err := usersPage(page, &req.ListRequest)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.Internal(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	ctx.Set(fiber.HeaderLink, c.pageLinks(ctx, &req.ListRequest, pageOutput))
+	return render.Respond(ctx, fiber.StatusOK, pageOutput)
+}
+
+// usersPage converts a page of users to the output format, with the metadata of the request
+func usersPage(page *internal.UsersPage, req *ListRequest) (*UsersPage, error) {
+	usersOutput := Users{}
+	if err := mapstructure.Decode(page.Users, &usersOutput); err != nil {
+		return nil, err
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = usecases.DefaultPageSize
+	}
+	return &UsersPage{
+		Data: usersOutput,
+		Meta: Meta{
+			Total:      page.Total,
+			Limit:      limit,
+			Offset:     req.Offset,
+			NextCursor: page.NextCursor,
+		},
+	}, nil
+}
+
+// pageLinks builds the RFC 8288 Link header, it pages with offset when the request did, otherwise with cursor
+func (c *controller) pageLinks(ctx *fiber.Ctx, req *ListRequest, page *UsersPage) string {
+	link := func(rel string, set func(q url.Values)) string {
+		q := url.Values{}
+		ctx.Request().URI().QueryArgs().VisitAll(func(key, value []byte) {
+			q.Set(string(key), string(value))
+		})
+		q.Del("cursor")
+		q.Del("offset")
+		set(q)
+		return fmt.Sprintf("<%s%s?%s>; rel=\"%s\"", ctx.BaseURL(), ctx.Path(), q.Encode(), rel)
+	}
+
+	links := []string{link("first", func(q url.Values) {})}
+	meta := page.Meta
+	switch {
+	case req.Offset > 0:
+		if meta.Offset+meta.Limit < meta.Total {
+			links = append(links, link("next", func(q url.Values) {
+				q.Set("offset", strconv.Itoa(meta.Offset+meta.Limit))
+			}))
+		}
+		prev := meta.Offset - meta.Limit
+		if prev < 0 {
+			prev = 0
+		}
+		links = append(links, link("prev", func(q url.Values) {
+			if prev > 0 {
+				q.Set("offset", strconv.Itoa(prev))
+			}
+		}))
+	case meta.NextCursor != "":
+		links = append(links, link("next", func(q url.Values) {
+			q.Set("cursor", meta.NextCursor)
+		}))
+	}
+
+	return strings.Join(links, ", ")
 }
 
 // @Summary Update a user
-// @Description Update a user with a given ID
-// @Accept json
-// @Produce json
+// @Description Update the email and phone of a user with a given ID, the password is changed at /users/password
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
 // @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user as it was read, or *"
 // @Param user body PutRequest true "PutRequest object"
 // @Success 200 {string} Updated
+// @Header 200 {string} ETag "version of the updated user"
 // @Security ApiKeyAuth
 // @Security JwtTokenAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /users/{id} [put]
 func (c *controller) Put(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
 	if id == "" {
 		// Return an error response if the id parameter is missing
 		c.logger.Error("%s: %s", statusBadRequest, missingID)
-		return fiber.NewError(statusBadRequest, fmt.Sprintf("%s: %s", requestError, missingID))
+		return problem.BadRequest(missingID)
+	}
+
+	version, err := ifMatch(ctx)
+	if err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return err
 	}
 
 	req := &PutRequest{}
-	if err := ctx.BodyParser(req); err != nil {
-		c.logger.Error("%s: %s", statusInternalServerError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
 	}
 
 	if err := c.validate.Struct(req); err != nil {
 		c.logger.Error("%s: %s", statusBadRequest, err)
-		return fiber.NewError(statusBadRequest, fmt.Sprintf("%s: %s", requestError, err))
+		return problem.Validation(err)
 	}
 
 	userInput := &User{
-		ID:       id,
-		Email:    req.Email,
-		Phone:    req.Phone,
-		Password: req.Password,
+		ID:      id,
+		Email:   req.Email,
+		Phone:   req.Phone,
+		Version: version,
+	}
+	err = c.usecases.ModifyUser(ctx.UserContext(), userInput)
+	if err != nil {
+		// Return an error response if the use case returns an error
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	// the update moved the user exactly one version past the one it matched
+	if version > 0 {
+		ctx.Set(fiber.HeaderETag, userETag(version+1))
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, modified)})
+}
+
+// @Summary Partially update a user
+// @Description Update only the supplied fields of a user, with a RFC 7396 merge patch or a RFC 6902 json patch
+// @Accept json
+// @Accept application/merge-patch+json
+// @Accept application/json-patch+json
+// @Produce json,xml,application/msgpack
+// @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user as it was read, or *"
+// @Param user body PatchRequest true "PatchRequest object, null removes the phone"
+// @Success 200 {string} Updated
+// @Header 200 {string} ETag "version of the updated user"
+// @Security ApiKeyAuth
+// @Security JwtTokenAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 415 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/{id} [patch]
+func (c *controller) Patch(ctx *fiber.Ctx) error {
+	id := ctx.Params("id")
+	if id == "" {
+		// Return an error response if the id parameter is missing
+		c.logger.Error("%s: %s", statusBadRequest, missingID)
+		return problem.BadRequest(missingID)
 	}
-	err := c.usecases.ModifyUser(userInput)
+
+	version, err := ifMatch(ctx)
+	if err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return err
+	}
+
+	req, err := parsePatch(ctx.Get(fiber.HeaderContentType), ctx.Body())
+	if err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return err
+	}
+
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	patchInput := &UserPatch{
+		ID:      id,
+		Email:   req.Email,
+		Phone:   req.Phone,
+		Version: version,
+	}
+	err = c.usecases.ModifyUser(ctx.UserContext(), patchInput)
 	if err != nil {
 		// Return an error response if the use case returns an error
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+		return problem.From(err)
+	}
+
+	if version > 0 {
+		ctx.Set(fiber.HeaderETag, userETag(version+1))
 	}
 
 	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
-	return ctx.Status(fiber.StatusOK).JSON(fiber.Map{"msg": modified})
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, modified)})
 }
 
 // @Summary Delete a user
 // @Description Delete a user with a given ID
 // @Param id path int true "User ID"
+// @Param If-Match header string true "ETag of the user as it was read, or *"
 // @Param user body DeleteRequest true "DeleteRequest object"
 // @Success 204
 // @Security ApiKeyAuth
 // @Security JwtTokenAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 412 {object} problem.Problem
+// @Failure 428 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
 // @Router /users/{id} [delete]
 func (c *controller) Delete(ctx *fiber.Ctx) error {
 	id := ctx.Params("id")
 	if id == "" {
 		// Return an error response if the id parameter is missing
 		c.logger.Error("%s: %s", requestError, missingID)
-		return fiber.NewError(statusBadRequest, fmt.Sprintf("%s: %s", requestError, missingID))
+		return problem.BadRequest(missingID)
+	}
+
+	version, err := ifMatch(ctx)
+	if err != nil {
+		c.logger.Error("%s: %s", requestError, err)
+		return err
 	}
 
 	req := &DeleteRequest{}
-	if err := ctx.BodyParser(req); err != nil {
-		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
 	}
 	if err := c.validate.Struct(req); err != nil {
 		c.logger.Error("%s: %s", requestError, missingID)
-		return fiber.NewError(statusBadRequest, fmt.Sprintf("%s: %s", requestError, err))
+		return problem.Validation(err)
 	}
 
 	userInput := &User{
 		ID:       id,
 		Password: req.Password,
+		Version:  version,
 	}
 
-	err := c.usecases.DestroyUser(userInput)
+	err = c.usecases.DestroyUser(ctx.UserContext(), userInput)
 	if err != nil {
 		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
-		return fiber.NewError(statusInternalServerError, fmt.Sprintf("%s: %s", internalError, err))
+		return problem.From(err)
 	}
 
 	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
-	return ctx.Status(fiber.StatusNoContent).JSON(fiber.Map{"msg": deleted})
+	return render.Respond(ctx, fiber.StatusNoContent, fiber.Map{"msg": message(ctx, deleted)})
+}
+
+// @Summary Change the password
+// @Description Change the password of the current user, the current password must match and the new one
+// @Description needs 8 to 64 characters with a lower case letter, an upper case letter and a digit.
+// @Description Every other session of the user ends and new session cookies are set
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
+// @Param password body ChangePasswordRequest true "ChangePasswordRequest object"
+// @Success 200 {string} Changed
+// @Security ApiKeyAuth
+// @Security JwtTokenAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/password [put]
+func (c *controller) ChangePassword(ctx *fiber.Ctx) error {
+	token, err := c.jwt.ParseUserJWT(ctx.Cookies(SessionCookie))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.Unauthorized(noSession)
+	}
+
+	req := &ChangePasswordRequest{}
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	change := &PasswordChange{
+		ID:              token.UID,
+		CurrentPassword: req.CurrentPassword,
+		NewPassword:     req.NewPassword,
+	}
+	err = c.usecases.ChangePassword(ctx.UserContext(), change)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	// sessions started with the old password end, the current one starts over
+	err = c.sessions.EndAllSessions(ctx.UserContext(), token.UID, time.Now())
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+	refreshToken, err := c.sessions.IssueRefreshToken(ctx.UserContext(), token.UID)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+	if err := c.setSessionCookies(ctx, refreshToken); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.Internal(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, pwdChanged)})
+}
+
+// @Summary Forgot the password
+// @Description Mail a one-time link to reset the password, the answer is the same whether the email is registered or not
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
+// @Param email body ForgotPasswordRequest true "ForgotPasswordRequest object"
+// @Success 202 {string} Accepted
+// @Failure 400 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/password/forgot [post]
+func (c *controller) ForgotPassword(ctx *fiber.Ctx) error {
+	req := &ForgotPasswordRequest{}
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	err := c.resets.RequestPasswordReset(ctx.UserContext(), req.Email)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusAccepted, fiber.Map{"msg": message(ctx, resetSent)})
+}
+
+// @Summary Reset the password
+// @Description Set a new password with the token of a reset link, the token works once
+// @Description and every session of the user ends
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
+// @Param password body ResetPasswordRequest true "ResetPasswordRequest object"
+// @Success 200 {string} Reset
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/password/reset [post]
+func (c *controller) ResetPassword(ctx *fiber.Ctx) error {
+	req := &ResetPasswordRequest{}
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	uid, err := c.resets.ResetPassword(ctx.UserContext(), req.Token, req.NewPassword)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	err = c.sessions.EndAllSessions(ctx.UserContext(), uid, time.Now())
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	ctx.ClearCookie(SessionCookie, RefreshCookie)
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, pwdReset)})
+}
+
+// @Summary Verify the email
+// @Description Mark the email of a signed verification link as verified
+// @Produce json,xml,application/msgpack
+// @Param token query string true "verification token of the link"
+// @Success 200 {string} Verified
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/email/verify [get]
+func (c *controller) VerifyEmail(ctx *fiber.Ctx) error {
+	token := ctx.Query("token")
+	if token == "" {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, "missing token")
+		return problem.BadRequest(missingToken)
+	}
+
+	err := c.verifications.VerifyEmail(ctx.UserContext(), token)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, emailVerified)})
+}
+
+// @Summary Resend the email verification
+// @Description Mail a new verification link, the answer is the same whether the email is registered,
+// @Description verified or not, and the same email can be mailed again only after a while
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
+// @Param email body ResendVerificationRequest true "ResendVerificationRequest object"
+// @Success 202 {string} Accepted
+// @Failure 400 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/email/verify/resend [post]
+func (c *controller) ResendVerification(ctx *fiber.Ctx) error {
+	req := &ResendVerificationRequest{}
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	err := c.verifications.SendVerification(ctx.UserContext(), req.Email)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusAccepted, fiber.Map{"msg": message(ctx, verifySent)})
+}
+
+// @Summary Request a phone verification code
+// @Description Text a one-time code to the phone of the current user, a new code can be requested only after a while
+// @Produce json,xml,application/msgpack
+// @Success 202 {string} Accepted
+// @Security ApiKeyAuth
+// @Security JwtTokenAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 422 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/phone/verify [post]
+func (c *controller) RequestPhoneCode(ctx *fiber.Ctx) error {
+	token, err := c.jwt.ParseUserJWT(ctx.Cookies(SessionCookie))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.Unauthorized(noSession)
+	}
+
+	err = c.phones.RequestPhoneCode(ctx.UserContext(), token.UID)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusAccepted, fiber.Map{"msg": message(ctx, phoneCodeSent)})
+}
+
+// @Summary Confirm the phone verification code
+// @Description Verify the phone of the current user with the texted code, after too many wrong codes a new one is needed
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
+// @Param code body ConfirmPhoneRequest true "ConfirmPhoneRequest object"
+// @Success 200 {string} Verified
+// @Security ApiKeyAuth
+// @Security JwtTokenAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/phone/verify/confirm [post]
+func (c *controller) ConfirmPhoneCode(ctx *fiber.Ctx) error {
+	token, err := c.jwt.ParseUserJWT(ctx.Cookies(SessionCookie))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.Unauthorized(noSession)
+	}
+
+	req := &ConfirmPhoneRequest{}
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	err = c.phones.ConfirmPhoneCode(ctx.UserContext(), token.UID, req.Code)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, phoneVerified)})
+}
+
+// @Summary Enroll a totp authenticator
+// @Description Create a new totp secret for the current user, the uri is meant to be shown as a qr code.
+// @Description The login does not ask for it until it is confirmed
+// @Produce json,xml,application/msgpack
+// @Success 200 {object} TOTPEnrollment
+// @Security ApiKeyAuth
+// @Security JwtTokenAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/mfa/totp [post]
+func (c *controller) EnrollTOTP(ctx *fiber.Ctx) error {
+	token, err := c.jwt.ParseUserJWT(ctx.Cookies(SessionCookie))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.Unauthorized(noSession)
+	}
+
+	res, err := c.twoFactor.EnrollTOTP(ctx.UserContext(), token.UID)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	enrollment := &TOTPEnrollment{}
+	if err := mapstructure.Decode(res, enrollment); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.Internal(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusOK, enrollment)
+}
+
+// @Summary Confirm the totp authenticator
+// @Description Enable two-factor auth with a code of the enrolled secret, the answer carries
+// @Description the one-time recovery codes and they are not shown again
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
+// @Param code body TOTPCodeRequest true "TOTPCodeRequest object"
+// @Success 200 {string} Enabled
+// @Security ApiKeyAuth
+// @Security JwtTokenAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 404 {object} problem.Problem
+// @Failure 409 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/mfa/totp/confirm [post]
+func (c *controller) ConfirmTOTP(ctx *fiber.Ctx) error {
+	token, err := c.jwt.ParseUserJWT(ctx.Cookies(SessionCookie))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.Unauthorized(noSession)
+	}
+
+	req := &TOTPCodeRequest{}
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	codes, err := c.twoFactor.ConfirmTOTP(ctx.UserContext(), token.UID, req.Code)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, totpEnabled), "recovery_codes": codes})
+}
+
+// @Summary Disable the totp authenticator
+// @Description Turn two-factor auth off with a totp code or a recovery code, the recovery codes are discarded
+// @Accept json,xml,application/msgpack
+// @Produce json,xml,application/msgpack
+// @Param code body TOTPCodeRequest true "TOTPCodeRequest object"
+// @Success 200 {string} Disabled
+// @Security ApiKeyAuth
+// @Security JwtTokenAuth
+// @Failure 400 {object} problem.Problem
+// @Failure 401 {object} problem.Problem
+// @Failure 429 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/mfa/totp [delete]
+func (c *controller) DisableTOTP(ctx *fiber.Ctx) error {
+	token, err := c.jwt.ParseUserJWT(ctx.Cookies(SessionCookie))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.Unauthorized(noSession)
+	}
+
+	req := &TOTPCodeRequest{}
+	if err := render.Bind(ctx, req); err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.From(err)
+	}
+
+	if err := c.validate.Struct(req); err != nil {
+		c.logger.Error("%s: %s", statusBadRequest, err)
+		return problem.Validation(err)
+	}
+
+	err = c.twoFactor.DisableTOTP(ctx.UserContext(), token.UID, req.Code)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, totpDisabled)})
+}
+
+// @Summary Log out
+// @Description End the current session, its token is revoked until it expires and its refresh token family ends
+// @Produce json,xml,application/msgpack
+// @Success 200 {string} LoggedOut
+// @Security ApiKeyAuth
+// @Security JwtTokenAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/logout [post]
+func (c *controller) Logout(ctx *fiber.Ctx) error {
+	token, err := c.jwt.ParseUserJWT(ctx.Cookies(SessionCookie))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.Unauthorized(noSession)
+	}
+
+	err = c.sessions.EndSession(ctx.UserContext(), token)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+	if refreshToken := ctx.Cookies(RefreshCookie); refreshToken != "" {
+		err = c.sessions.EndRefreshToken(ctx.UserContext(), refreshToken)
+		if err != nil {
+			c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+			return problem.From(err)
+		}
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	ctx.ClearCookie(SessionCookie, RefreshCookie)
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, loggedOut)})
+}
+
+// @Summary Log out everywhere
+// @Description End every session of the user issued until now, including the current one
+// @Produce json,xml,application/msgpack
+// @Success 200 {string} LoggedOut
+// @Security ApiKeyAuth
+// @Security JwtTokenAuth
+// @Failure 401 {object} problem.Problem
+// @Failure 500 {object} problem.Problem
+// @Router /users/logout/all [post]
+func (c *controller) LogoutAll(ctx *fiber.Ctx) error {
+	token, err := c.jwt.ParseUserJWT(ctx.Cookies(SessionCookie))
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return problem.Unauthorized(noSession)
+	}
+
+	err = c.sessions.EndAllSessions(ctx.UserContext(), token.UID, time.Now())
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+	err = c.sessions.EndSession(ctx.UserContext(), token)
+	if err != nil {
+		c.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), internalError, err)
+		return problem.From(err)
+	}
+
+	c.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	ctx.ClearCookie(SessionCookie, RefreshCookie)
+	return render.Respond(ctx, fiber.StatusOK, fiber.Map{"msg": message(ctx, loggedOut)})
+}
+
+// message is the message of a key of the catalog in the language of the request
+func message(ctx *fiber.Ctx, key string, params ...string) string {
+	return i18n.Message(i18n.LocaleOf(ctx), key, params...)
+}
+
+// streamContext is the context of a streamed response. The stream is written once the handler
+// returned and the route timeout cancelled its context, so it keeps the span of the request but
+// not its deadline, and its writer cancels it once the client is gone
+func streamContext(ctx *fiber.Ctx) (context.Context, context.CancelFunc) {
+	return context.WithCancel(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx.UserContext())))
+}
+
+// streamWriter is the writer of a streamed response. A write or a flush fails when the client
+// closed the connection, then the stream is cancelled so its queries stop reading rows for nobody
+type streamWriter struct {
+	w      *bufio.Writer
+	cancel context.CancelFunc
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	n, err := s.w.Write(p)
+	if err != nil {
+		s.cancel()
+	}
+	return n, err
+}
+
+func (s *streamWriter) Flush() error {
+	if err := s.w.Flush(); err != nil {
+		s.cancel()
+		return err
+	}
+	return nil
 }
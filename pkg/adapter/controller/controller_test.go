@@ -3,34 +3,72 @@ package controller_test
 
 import (
 	"bytes"
+	"context"
 	"dall06/go-cleanapi/config"
 	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/adapter/render"
 	"dall06/go-cleanapi/pkg/internal"
 	"dall06/go-cleanapi/pkg/internal/repository"
 	"dall06/go-cleanapi/pkg/internal/usecases"
 	"dall06/go-cleanapi/utils"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/go-playground/validator/v10"
+	"github.com/go-sql-driver/mysql"
 	"github.com/gofiber/fiber/v2"
 	"github.com/patrickmn/go-cache"
 	"github.com/stretchr/testify/assert"
 )
 
 const (
-	spCreate  = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
-	spRead    = "CALL `go_cleanapi`.`sp_read_user`(?);"
-	spReadAll = "CALL `go_cleanapi`.`sp_read_users`();"
-	spUpdate  = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
-	spDelete  = "CALL `go_cleanapi`.`sp_delete_user`(?, ?);"
-	spLogin   = "CALL `go_cleanapi`.`sp_login_user`(?, ?, ?);"
+	spCreate = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
+	spRead   = "CALL `go_cleanapi`.`sp_read_user`(?);"
+	qCount   = "SELECT COUNT(*) FROM `go_cleanapi`.`users`"
+	qList    = "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL FROM `go_cleanapi`.`users`"
+	spUpdate = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
+	spDelete = "CALL `go_cleanapi`.`sp_delete_user`(?, ?, ?);"
+	spLogin  = "CALL `go_cleanapi`.`sp_login_user`(?, ?, ?);"
+
+	spUpdatePassword = "CALL `go_cleanapi`.`sp_update_user_password`(?, ?, ?);"
+
+	spCreateRefreshToken      = "CALL `go_cleanapi`.`sp_create_refresh_token`(?, ?, ?, ?);"
+	spReadRefreshToken        = "CALL `go_cleanapi`.`sp_read_refresh_token`(?);"
+	spRotateRefreshToken      = "CALL `go_cleanapi`.`sp_rotate_refresh_token`(?, ?, ?);"
+	spRevokeRefreshFamily     = "CALL `go_cleanapi`.`sp_revoke_refresh_family`(?);"
+	spRevokeUserRefreshTokens = "CALL `go_cleanapi`.`sp_revoke_user_refresh_tokens`(?);"
+
+	spCreatePasswordReset = "CALL `go_cleanapi`.`sp_create_password_reset`(?, ?, ?);"
+	spResetPassword       = "CALL `go_cleanapi`.`sp_reset_password`(?, ?);"
+
+	spReadEmailVerification = "CALL `go_cleanapi`.`sp_read_email_verification`(?);"
+	spVerifyUserEmail       = "CALL `go_cleanapi`.`sp_verify_user_email`(?);"
+
+	spCreatePhoneOTP  = "CALL `go_cleanapi`.`sp_create_phone_otp`(?, ?, ?);"
+	spConfirmPhoneOTP = "CALL `go_cleanapi`.`sp_confirm_phone_otp`(?, ?, ?);"
+
+	spCreateTOTP         = "CALL `go_cleanapi`.`sp_create_totp`(?, ?);"
+	spReadTOTP           = "CALL `go_cleanapi`.`sp_read_totp`(?);"
+	spEnableTOTP         = "CALL `go_cleanapi`.`sp_enable_totp`(?, ?);"
+	spCreateRecoveryCode = "CALL `go_cleanapi`.`sp_create_totp_recovery_code`(?, ?);"
+	spUseTOTPStep        = "CALL `go_cleanapi`.`sp_use_totp_step`(?, ?);"
+	spUseRecoveryCode    = "CALL `go_cleanapi`.`sp_use_totp_recovery_code`(?, ?);"
+	spDisableTOTP        = "CALL `go_cleanapi`.`sp_disable_totp`(?);"
 )
 
 func TestAuth(test *testing.T) {
@@ -54,48 +92,84 @@ func TestAuth(test *testing.T) {
 
 	rowsSetOne := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		"im an ID",
+		true,
+		false,
 	)
 	rowsSetTwo := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		"im an ID",
+		true,
+		false,
 	)
 	rowsSetThree := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		"im an ID",
+		true,
+		false,
 	)
 	rowsSetFour := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		"im an ID",
+		true,
+		false,
 	)
 	rowsSetFive := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		"im an ID",
+		true,
+		false,
 	)
 	rowsSetSix := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		"im an ID",
+		true,
+		false,
 	)
 	rowsSetSeven := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		"im an ID",
+		true,
+		false,
 	)
 	rowsSetEight := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		"im an ID",
+		true,
+		false,
 	)
 	rowsSetNine := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		"im an ID",
+		true,
+		false,
 	)
 
 	formValuesEmail := url.Values{}
@@ -252,6 +326,7 @@ func TestAuth(test *testing.T) {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
 	}
 	app := fiber.New(sCfg)
 
@@ -286,13 +361,26 @@ func TestAuth(test *testing.T) {
 				&tc.dbUser.Phone,
 				&tc.dbUser.Password,
 			).WillReturnRows(tc.rows)
+			m.ExpectExec(regexp.QuoteMeta(spCreateRefreshToken)).
+				WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "im an ID", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
 			assert.Empty(t, err, "expected no error, but got:", err)
 
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Post("/auth/"+tc.testID, ctrl.Auth)
 
@@ -303,6 +391,10 @@ func TestAuth(test *testing.T) {
 			assert.NoError(t, err)
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			cookies := resp.Header.Values(fiber.HeaderSetCookie)
+			assert.Len(t, cookies, 2)
+			assert.Contains(t, strings.Join(cookies, "\n"), controller.RefreshCookie+"=")
+			assert.NoError(t, m.ExpectationsWereMet())
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
 		})
@@ -329,8 +421,18 @@ func TestAuth(test *testing.T) {
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Post("/auth/"+tc.testID, ctrl.Auth)
 
@@ -386,8 +488,10 @@ func TestPost(test *testing.T) {
 		testID         string
 		name           string
 		dbUser         *internal.User
+		contentType    string
 		body           string
 		expectedStatus int
+		expectedDetail string
 	}{
 		{
 			testID:         "test3",
@@ -417,12 +521,39 @@ func TestPost(test *testing.T) {
 			expectedStatus: fiber.StatusInternalServerError,
 			body:           `{"email":"test2@test.com","phone":"","password":"12345pAsSWORd*"}`,
 		},
+		{
+			testID:         "test7",
+			name:           "it should not post user, invalid messagepack",
+			dbUser:         dbUser1,
+			contentType:    render.MIMEMessagePack,
+			expectedStatus: fiber.StatusBadRequest,
+			body:           "\xc1",
+			expectedDetail: "invalid messagepack body",
+		},
+		{
+			testID:         "test8",
+			name:           "it should not post user, invalid xml",
+			dbUser:         dbUser1,
+			contentType:    fiber.MIMEApplicationXML,
+			expectedStatus: fiber.StatusBadRequest,
+			body:           "<user><email>test@test.com</email>",
+			expectedDetail: "invalid xml body",
+		},
+		{
+			testID:         "test9",
+			name:           "it should not post user, invalid phone",
+			dbUser:         dbUser1,
+			expectedStatus: fiber.StatusBadRequest,
+			body:           `{"email":"test@test.com","phone":"1234","password":"12345pAsSWORd*"}`,
+			expectedDetail: "one or more fields are invalid",
+		},
 	}
 
 	sCfg := fiber.Config{
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
 	}
 	app := fiber.New(sCfg)
 
@@ -458,13 +589,27 @@ func TestPost(test *testing.T) {
 				&tc.dbUser.Phone,
 				&tc.dbUser.Password,
 			).WillReturnResult(sqlmock.NewResult(0, 0))
+			m.ExpectQuery(regexp.QuoteMeta(spReadEmailVerification)).
+				WithArgs(tc.dbUser.Email).
+				WillReturnRows(sqlmock.NewRows([]string{"pending"}).AddRow(true))
 			assert.Empty(t, err, "expected no error, but got:", err)
 
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
+			dir := t.TempDir()
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, dir)
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Post("/post/"+tc.testID, ctrl.Post)
 
@@ -479,6 +624,11 @@ func TestPost(test *testing.T) {
 			}()
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			// the new account is asked to verify its email
+			files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+			assert.NoError(t, err)
+			assert.Len(t, files, 1)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
 		})
@@ -506,13 +656,27 @@ func TestPost(test *testing.T) {
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Post("/post/"+tc.testID, ctrl.Post)
 
+			contentType := tc.contentType
+			if contentType == "" {
+				contentType = fiber.MIMEApplicationJSON
+			}
 			req := httptest.NewRequest("POST", "/post/"+tc.testID, bytes.NewBufferString(tc.body))
-			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set("Content-Type", contentType)
 			resp, err := app.Test(req)
 			assert.NoError(t, err)
 			defer func() {
@@ -522,6 +686,11 @@ func TestPost(test *testing.T) {
 			}()
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedDetail != "" {
+				p := &problem.Problem{}
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(p))
+				assert.Equal(t, tc.expectedDetail, p.Detail)
+			}
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
 		})
@@ -540,16 +709,25 @@ func TestGet(test *testing.T) {
 		"id_user",
 		"user_email",
 		"user_phone",
+		"email_verified",
+		"phone_verified",
+		"version",
 	}).AddRow(
 		dbUser1.ID,
 		dbUser1.Email,
 		dbUser1.Phone,
+		false,
+		false,
+		3,
 	)
 
 	rowsSet2 := sqlmock.NewRows([]string{
 		"id_user",
 		"user_email",
 		"user_phone",
+		"email_verified",
+		"phone_verified",
+		"version",
 	})
 
 	successfulCases := []struct {
@@ -557,6 +735,7 @@ func TestGet(test *testing.T) {
 		name           string
 		dbUser         *internal.User
 		id             string
+		ifNoneMatch    string
 		expectedStatus int
 		rows           *sqlmock.Rows
 	}{
@@ -568,6 +747,16 @@ func TestGet(test *testing.T) {
 			rows:           rowsSet1,
 			id:             "im_an_id",
 		},
+		{
+			testID:         "test5",
+			name:           "it should not send an unchanged user (mocked)",
+			dbUser:         dbUser1,
+			ifNoneMatch:    `"3"`,
+			expectedStatus: fiber.StatusNotModified,
+			rows: sqlmock.NewRows([]string{"id_user", "user_email", "user_phone", "email_verified", "phone_verified", "version"}).
+				AddRow(dbUser1.ID, dbUser1.Email, dbUser1.Phone, false, false, 3),
+			id: "im_an_id",
+		},
 	}
 
 	failedCases := []struct {
@@ -580,9 +769,9 @@ func TestGet(test *testing.T) {
 	}{
 		{
 			testID:         "test2",
-			name:           "it should not read user (mocked), empty id",
+			name:           "it should not read user (mocked), user not found",
 			dbUser:         dbUser1,
-			expectedStatus: fiber.StatusOK,
+			expectedStatus: fiber.StatusNotFound,
 			rows:           rowsSet1,
 			id:             "im_an_id",
 		},
@@ -608,6 +797,7 @@ func TestGet(test *testing.T) {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
 	}
 	app := fiber.New(sCfg)
 
@@ -643,17 +833,31 @@ func TestGet(test *testing.T) {
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Get("/users/"+tc.testID+"/:id", ctrl.Get)
 
 			// Make a request to the route with the test user ID
 			req := httptest.NewRequest(fiber.MethodGet, "/users/"+tc.testID+"/"+tc.id, nil)
+			if tc.ifNoneMatch != "" {
+				req.Header.Set(fiber.HeaderIfNoneMatch, tc.ifNoneMatch)
+			}
 			resp, err := app.Test(req)
 			assert.NoError(t, err)
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Equal(t, `"3"`, resp.Header.Get(fiber.HeaderETag))
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
 		})
@@ -676,8 +880,18 @@ func TestGet(test *testing.T) {
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Get("/users/"+tc.testID+"/:id", ctrl.Get)
 
@@ -693,11 +907,11 @@ func TestGet(test *testing.T) {
 }
 
 func TestGetAll(test *testing.T) {
+	createdAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
 	dbUser1 := &internal.User{
-		ID:       "im_an_id",
-		Email:    "test@test.com",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
+		ID:    "im_an_id",
+		Email: "test@test.com",
+		Phone: "+991234567890",
 	}
 	dbUser2 := &internal.User{
 		ID:    "im an id 2",
@@ -705,43 +919,93 @@ func TestGetAll(test *testing.T) {
 		Phone: "+891234567891",
 	}
 
-	rowsSet1 := sqlmock.NewRows([]string{
-		"id_user",
-		"user_email",
-		"user_phone",
-	}).AddRow(
-		dbUser1.ID,
-		dbUser1.Email,
-		dbUser1.Phone,
-	).AddRow(
-		dbUser2.ID,
-		dbUser2.Email,
-		dbUser2.Phone,
-	)
-
-	rowsSet2 := sqlmock.NewRows([]string{
-		"id_user",
-		"user_email",
-		"user_phone",
-	})
+	columns := []string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}
 
 	successfulCases := []struct {
 		testID         string
 		name           string
+		query          string
 		expectedStatus int
+		expectedNext   string
+		total          int
 		rows           *sqlmock.Rows
 	}{
 		{
 			testID:         "test1",
 			name:           "it should read users (mocked)",
 			expectedStatus: fiber.StatusOK,
-			rows:           rowsSet1,
+			total:          2,
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUser1.ID, dbUser1.Email, dbUser1.Phone, createdAt, dbUser1.EmailVerified, dbUser1.PhoneVerified).
+				AddRow(dbUser2.ID, dbUser2.Email, dbUser2.Phone, createdAt, dbUser2.EmailVerified, dbUser2.PhoneVerified),
 		},
 		{
 			testID:         "test2",
 			name:           "it should read users (mocked), but empty db",
 			expectedStatus: fiber.StatusOK,
-			rows:           rowsSet2,
+			rows:           sqlmock.NewRows(columns),
+		},
+		{
+			testID:         "test3",
+			name:           "it should read users (mocked) with a next cursor link",
+			query:          "?limit=1&email=test",
+			expectedStatus: fiber.StatusOK,
+			expectedNext:   "cursor=",
+			total:          2,
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUser1.ID, dbUser1.Email, dbUser1.Phone, createdAt, dbUser1.EmailVerified, dbUser1.PhoneVerified).
+				AddRow(dbUser2.ID, dbUser2.Email, dbUser2.Phone, createdAt, dbUser2.EmailVerified, dbUser2.PhoneVerified),
+		},
+		{
+			testID:         "test4",
+			name:           "it should read users (mocked) with a next offset link",
+			query:          "?limit=1&offset=1&sort=-phone",
+			expectedStatus: fiber.StatusOK,
+			expectedNext:   "offset=2",
+			total:          3,
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUser2.ID, dbUser2.Email, dbUser2.Phone, createdAt, dbUser2.EmailVerified, dbUser2.PhoneVerified).
+				AddRow(dbUser1.ID, dbUser1.Email, dbUser1.Phone, createdAt, dbUser1.EmailVerified, dbUser1.PhoneVerified),
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		query          string
+		expectedStatus int
+		expectedDetail string
+	}{
+		{
+			testID:         "test5",
+			name:           "it should not read users, unknown sort",
+			query:          "?sort=password",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test6",
+			name:           "it should not read users, limit too big",
+			query:          "?limit=1000",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test7",
+			name:           "it should not read users, cursor and offset",
+			query:          "?offset=1&cursor=eyJ2IjoiIiwiaWQiOiJpZCJ9",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test8",
+			name:           "it should not read users, invalid date",
+			query:          "?created_from=yesterday",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test9",
+			name:           "it should not read users, limit not a number",
+			query:          "?limit=ten",
+			expectedStatus: fiber.StatusBadRequest,
+			expectedDetail: "invalid query parameters",
 		},
 	}
 
@@ -749,6 +1013,7 @@ func TestGetAll(test *testing.T) {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
 	}
 	app := fiber.New(sCfg)
 
@@ -776,49 +1041,251 @@ func TestGetAll(test *testing.T) {
 				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 			}
 
+			m.ExpectQuery(regexp.QuoteMeta(qCount)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tc.total))
+			m.ExpectQuery(regexp.QuoteMeta(qList)).WillReturnRows(tc.rows)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Get("/users/"+tc.testID, ctrl.GetAll)
+
+			// Make a request to the route with the test query
+			req := httptest.NewRequest(fiber.MethodGet, "/users/"+tc.testID+tc.query, nil)
+			resp, err := app.Test(req)
 			assert.NoError(t, err)
 
-			m.ExpectQuery(regexp.QuoteMeta(spReadAll)).WillReturnRows(tc.rows)
-			assert.Empty(t, err, "expected no error, but got:", err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Contains(t, resp.Header.Get(fiber.HeaderLink), `rel="first"`)
+			if tc.expectedNext != "" {
+				assert.Contains(t, resp.Header.Get(fiber.HeaderLink), tc.expectedNext)
+				assert.Contains(t, resp.Header.Get(fiber.HeaderLink), `rel="next"`)
+			}
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, _, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
 
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Get("/users/"+tc.testID, ctrl.GetAll)
 
-			// Make a request to the route with the test user ID
-			req := httptest.NewRequest(fiber.MethodGet, "/users/"+tc.testID, nil)
+			req := httptest.NewRequest(fiber.MethodGet, "/users/"+tc.testID+tc.query, nil)
 			resp, err := app.Test(req)
 			assert.NoError(t, err)
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
-			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
+			if tc.expectedDetail != "" {
+				p := &problem.Problem{}
+				assert.NoError(t, json.NewDecoder(resp.Body).Decode(p))
+				assert.Equal(t, tc.expectedDetail, p.Detail)
+			}
+		})
+	}
+}
+
+func TestSearch(test *testing.T) {
+	createdAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	columns := []string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}
+
+	successfulCases := []struct {
+		testID       string
+		name         string
+		query        string
+		expectedNext string
+		total        int
+		rows         *sqlmock.Rows
+	}{
+		{
+			testID: "test1",
+			name:   "it should search users (mocked) by prefix",
+			query:  "?email=test",
+			total:  1,
+			rows: sqlmock.NewRows(columns).
+				AddRow("im an id", "test@test.com", "+991234567890", createdAt, true, false),
+		},
+		{
+			testID:       "test2",
+			name:         "it should search users (mocked) containing a fragment with a next cursor link",
+			query:        "?phone=1234&match=contains&email_verified=true&limit=1",
+			expectedNext: "cursor=",
+			total:        2,
+			rows: sqlmock.NewRows(columns).
+				AddRow("im an id", "test@test.com", "+991234567890", createdAt, true, false).
+				AddRow("im an id 2", "test2@test.com", "+891234567891", createdAt, true, true),
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{
+			testID:         "test3",
+			name:           "it should not search users, no criteria",
+			query:          "?limit=10",
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			testID:         "test4",
+			name:           "it should not search users, unknown match",
+			query:          "?email=test&match=suffix",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test5",
+			name:           "it should not search users, fragment too short to contain",
+			query:          "?email=te&match=contains",
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			testID:         "test6",
+			name:           "it should not search users, invalid verification status",
+			query:          "?phone_verified=maybe",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	app := fiber.New(fiber.Config{ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock())})
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	newController := func(t *testing.T, db *sql.DB) controller.Controller {
+		myCache := cache.New(5*time.Minute, 10*time.Minute)
+		r := repository.NewRepository(db)
+		sessions := newSessions(db)
+		events := newUserEvents()
+		uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+		resets := newPasswordResets(db, t.TempDir())
+		verifications := newEmailVerifications(db, t.TempDir())
+		phones := newPhoneVerifications(db, t.TempDir())
+		twoFactor := newTwoFactor(db)
+		lockouts := newLockouts()
+		importer := newImporter(db)
+		exporter := newExporter(db)
+		streamer := newEventStreamer(events)
+		return controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			m.ExpectQuery(regexp.QuoteMeta(qCount)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(tc.total))
+			m.ExpectQuery(regexp.QuoteMeta(qList)).WillReturnRows(tc.rows)
+
+			app.Get("/users/search/"+tc.testID, newController(t, db).Search)
+
+			req := httptest.NewRequest(fiber.MethodGet, "/users/search/"+tc.testID+tc.query, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Contains(t, resp.Header.Get(fiber.HeaderLink), `rel="first"`)
+			if tc.expectedNext != "" {
+				assert.Contains(t, resp.Header.Get(fiber.HeaderLink), tc.expectedNext)
+				assert.Contains(t, resp.Header.Get(fiber.HeaderLink), `rel="next"`)
+			}
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
 
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, _, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			app.Get("/users/search/"+tc.testID, newController(t, db).Search)
+
+			req := httptest.NewRequest(fiber.MethodGet, "/users/search/"+tc.testID+tc.query, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
 		})
 	}
 }
 
 func TestPut(test *testing.T) {
 	dbUser1 := &internal.User{
-		ID:       "im_an_id",
-		Email:    "test@test.com",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
+		ID:    "im_an_id",
+		Email: "test@test.com",
+		Phone: "+991234567890",
 	}
 	dbUser2 := &internal.User{
-		ID:       "im_an_id",
-		Email:    "test@test.com",
-		Phone:    "",
-		Password: "12345pAsSWORd*",
+		ID:    "im_an_id",
+		Email: "test@test.com",
+		Phone: "",
 	}
 	dbUser3 := &internal.User{
-		ID:       "im_an_id",
-		Email:    "",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
+		ID:    "im_an_id",
+		Email: "",
+		Phone: "+991234567890",
+	}
+
+	dbUser4 := &internal.User{
+		ID:      "im_an_id",
+		Email:   "test@test.com",
+		Phone:   "+991234567890",
+		Version: 3,
 	}
 
 	successfulCases := []struct {
@@ -827,15 +1294,27 @@ func TestPut(test *testing.T) {
 		dbUser         *internal.User
 		body           string
 		id             string
+		ifMatch        string
+		expectedETag   string
 		expectedStatus int
 	}{
+		{
+			testID:         "test8",
+			name:           "it should put user (mocked), matching its etag",
+			dbUser:         dbUser4,
+			expectedStatus: fiber.StatusOK,
+			id:             "im_an_id",
+			ifMatch:        `"3"`,
+			expectedETag:   `"4"`,
+			body:           `{"email":"test@test.com","phone":"+991234567890"}`,
+		},
 		{
 			testID:         "test1",
 			name:           "it should put user (mocked)",
 			dbUser:         dbUser1,
 			expectedStatus: fiber.StatusOK,
 			id:             "im_an_id",
-			body:           `{"email":"test@test.com","phone":"+991234567890","password":"12345pAsSWORd*"}`,
+			body:           `{"email":"test@test.com","phone":"+991234567890"}`,
 		},
 		{
 			testID:         "test2",
@@ -843,7 +1322,7 @@ func TestPut(test *testing.T) {
 			dbUser:         dbUser2,
 			expectedStatus: fiber.StatusOK,
 			id:             "im_an_id",
-			body:           `{"email":"test@test.com","phone":"","password":"12345pAsSWORd*"}`,
+			body:           `{"email":"test@test.com","phone":""}`,
 		},
 		{
 			testID:         "test3",
@@ -851,7 +1330,7 @@ func TestPut(test *testing.T) {
 			dbUser:         dbUser3,
 			expectedStatus: fiber.StatusOK,
 			id:             "im_an_id",
-			body:           `{"email":"","phone":"+991234567890","password":"12345pAsSWORd*"}`,
+			body:           `{"email":"","phone":"+991234567890"}`,
 		},
 	}
 
@@ -861,23 +1340,42 @@ func TestPut(test *testing.T) {
 		dbUser         *internal.User
 		body           string
 		id             string
+		ifMatch        string
 		expectedStatus int
 	}{
+		{
+			testID:         "test9",
+			name:           "it should not put user, weak etag",
+			dbUser:         dbUser1,
+			expectedStatus: fiber.StatusPreconditionFailed,
+			id:             "im_an_id",
+			ifMatch:        `W/"3"`,
+			body:           `{"email":"test@test.com","phone":"+991234567890"}`,
+		},
+		{
+			testID:         "test10",
+			name:           "it should not put user, several etags",
+			dbUser:         dbUser1,
+			expectedStatus: fiber.StatusBadRequest,
+			id:             "im_an_id",
+			ifMatch:        `"3", "4"`,
+			body:           `{"email":"test@test.com","phone":"+991234567890"}`,
+		},
 		{
 			testID:         "test4",
 			name:           "it should post user (mocked), empty id",
 			dbUser:         dbUser1,
 			expectedStatus: fiber.StatusNotFound,
 			id:             "",
-			body:           `{"email":"","phone":"+991234567890","password":"12345pAsSWORd*"}`,
+			body:           `{"email":"","phone":"+991234567890"}`,
 		},
 		{
 			testID:         "test5",
-			name:           "it should post user (mocked), empty password",
+			name:           "it should not put user (mocked), nothing to update",
 			dbUser:         dbUser2,
 			id:             "im_an_id",
-			expectedStatus: fiber.StatusBadRequest,
-			body:           `{"email":"test@test.com","phone":"","password":""}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			body:           `{"email":"","phone":"","password":"12345pAsSWORd*"}`,
 		},
 		{
 			testID:         "test6",
@@ -893,7 +1391,7 @@ func TestPut(test *testing.T) {
 			dbUser:         dbUser2,
 			id:             "im_an_id_2",
 			expectedStatus: fiber.StatusInternalServerError,
-			body:           `{"email":"test@test.com","phone":"","password":"12345pAsSWORd*"}`,
+			body:           `{"email":"test@test.com","phone":""}`,
 		},
 	}
 
@@ -901,6 +1399,7 @@ func TestPut(test *testing.T) {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
 	}
 	app := fiber.New(sCfg)
 
@@ -930,24 +1429,38 @@ func TestPut(test *testing.T) {
 
 			assert.NoError(t, err)
 
+			// empty fields are not updated, they travel as NULL
 			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WithArgs(
-				&tc.dbUser.ID,
-				&tc.dbUser.Email,
-				&tc.dbUser.Phone,
-				&tc.dbUser.Password,
+				tc.dbUser.ID,
+				tc.dbUser.Version,
+				nullable(tc.dbUser.Email),
+				nullable(tc.dbUser.Phone),
 			).WillReturnResult(sqlmock.NewResult(0, 1))
 			assert.Empty(t, err, "expected no error, but got:", err)
 
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Put("/put/"+tc.testID+"/:id", ctrl.Put)
 
 			req := httptest.NewRequest("PUT", "/put/"+tc.testID+"/"+tc.id, bytes.NewBufferString(tc.body))
 			req.Header.Set("Content-Type", "application/json")
+			if tc.ifMatch != "" {
+				req.Header.Set(fiber.HeaderIfMatch, tc.ifMatch)
+			}
 			resp, err := app.Test(req)
 			assert.NoError(t, err)
 			defer func() {
@@ -957,6 +1470,7 @@ func TestPut(test *testing.T) {
 			}()
 
 			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Equal(t, tc.expectedETag, resp.Header.Get(fiber.HeaderETag))
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
 		})
@@ -975,22 +1489,35 @@ func TestPut(test *testing.T) {
 
 			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WithArgs(
 				&tc.dbUser.ID,
+				&tc.dbUser.Version,
 				&tc.dbUser.Email,
 				&tc.dbUser.Phone,
-				&tc.dbUser.Password,
 			).WillReturnResult(sqlmock.NewResult(0, 1))
 			assert.Empty(t, err, "expected no error, but got:", err)
 
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Put("/put/"+tc.testID+"/:id", ctrl.Put)
 
 			req := httptest.NewRequest("PUT", "/put/"+tc.testID+"/"+tc.id, bytes.NewBufferString(tc.body))
 			req.Header.Set("Content-Type", "application/json")
+			if tc.ifMatch != "" {
+				req.Header.Set(fiber.HeaderIfMatch, tc.ifMatch)
+			}
 			resp, err := app.Test(req)
 			assert.NoError(t, err)
 			defer func() {
@@ -1006,33 +1533,300 @@ func TestPut(test *testing.T) {
 	}
 }
 
-func TestDelet(test *testing.T) {
-	dbUser1 := &internal.User{
-		ID:       "im_an_id",
-		Email:    "test@test.com",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
-	}
+func TestPatch(test *testing.T) {
 	successfulCases := []struct {
-		testID         string
-		name           string
-		dbUser         *internal.User
-		body           string
-		id             string
-		expectedStatus int
+		testID       string
+		name         string
+		contentType  string
+		ifMatch      string
+		body         string
+		args         []driver.Value
+		expectedETag string
 	}{
 		{
-			testID:         "test1",
-			name:           "it should delete user (mocked)",
-			dbUser:         dbUser1,
-			expectedStatus: fiber.StatusNoContent,
-			id:             "im_an_id",
-			body:           `{"password":"12345pAsSWORd*"}`,
+			testID:      "test1",
+			name:        "it should patch user (mocked), merge patch",
+			contentType: controller.MergePatchType,
+			body:        `{"email":"test@test.com"}`,
+			args:        []driver.Value{"im_an_id", 0, "test@test.com", nil},
 		},
-	}
-
-	failedCases := []struct {
-		testID         string
+		{
+			testID:      "test2",
+			name:        "it should patch user (mocked), merge patch removing the phone",
+			contentType: controller.MergePatchType,
+			body:        `{"phone":null}`,
+			args:        []driver.Value{"im_an_id", 0, nil, ""},
+		},
+		{
+			testID:      "test3",
+			name:        "it should patch user (mocked), json patch",
+			contentType: controller.JSONPatchType,
+			body:        `[{"op":"replace","path":"/email","value":"test@test.com"},{"op":"remove","path":"/phone"}]`,
+			args:        []driver.Value{"im_an_id", 0, "test@test.com", ""},
+		},
+		{
+			testID:      "test4",
+			name:        "it should patch user (mocked), plain json as merge patch",
+			contentType: fiber.MIMEApplicationJSONCharsetUTF8,
+			body:        `{"phone":"+991234567890"}`,
+			args:        []driver.Value{"im_an_id", 0, nil, "+991234567890"},
+		},
+		{
+			testID:      "test12",
+			name:        "it should patch user (mocked), any version",
+			contentType: controller.MergePatchType,
+			ifMatch:     "*",
+			body:        `{"email":"test@test.com"}`,
+			args:        []driver.Value{"im_an_id", 0, "test@test.com", nil},
+		},
+		{
+			testID:       "test13",
+			name:         "it should patch user (mocked), matching its etag",
+			contentType:  controller.MergePatchType,
+			ifMatch:      `"7"`,
+			body:         `{"email":"test@test.com"}`,
+			args:         []driver.Value{"im_an_id", 7, "test@test.com", nil},
+			expectedETag: `"8"`,
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		contentType    string
+		ifMatch        string
+		body           string
+		expectedStatus int
+		expectedRule   string
+	}{
+		{
+			testID:         "test14",
+			name:           "it should not patch user, malformed etag",
+			contentType:    controller.MergePatchType,
+			ifMatch:        `"seven"`,
+			body:           `{"email":"test@test.com"}`,
+			expectedStatus: fiber.StatusPreconditionFailed,
+		},
+		{
+			testID:         "test5",
+			name:           "it should not patch user, unsupported content type",
+			contentType:    fiber.MIMETextPlain,
+			body:           `{"email":"test@test.com"}`,
+			expectedStatus: fiber.StatusUnsupportedMediaType,
+		},
+		{
+			testID:         "test6",
+			name:           "it should not patch user, invalid email",
+			contentType:    controller.MergePatchType,
+			body:           `{"email":"not an email"}`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test7",
+			name:           "it should not patch user, removing the email",
+			contentType:    controller.MergePatchType,
+			body:           `{"email":null}`,
+			expectedStatus: fiber.StatusBadRequest,
+			expectedRule:   "min",
+		},
+		{
+			testID:         "test15",
+			name:           "it should not patch user, emptying the email",
+			contentType:    controller.MergePatchType,
+			body:           `{"email":""}`,
+			expectedStatus: fiber.StatusBadRequest,
+			expectedRule:   "min",
+		},
+		{
+			testID:         "test16",
+			name:           "it should not patch user, json patch removing the email",
+			contentType:    controller.JSONPatchType,
+			body:           `[{"op":"remove","path":"/email"}]`,
+			expectedStatus: fiber.StatusBadRequest,
+			expectedRule:   "min",
+		},
+		{
+			testID:         "test17",
+			name:           "it should not patch user, invalid phone",
+			contentType:    controller.MergePatchType,
+			body:           `{"phone":"1234"}`,
+			expectedStatus: fiber.StatusBadRequest,
+			expectedRule:   "e164|len=0",
+		},
+		{
+			testID:         "test8",
+			name:           "it should not patch user, read only member",
+			contentType:    controller.MergePatchType,
+			body:           `{"uid":"other"}`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			testID:         "test9",
+			name:           "it should not patch user, unsupported operation",
+			contentType:    controller.JSONPatchType,
+			body:           `[{"op":"move","from":"/email","path":"/phone"}]`,
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			testID:         "test10",
+			name:           "it should not patch user, malformed patch",
+			contentType:    controller.MergePatchType,
+			body:           `["email"]`,
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test11",
+			name:           "it should not patch user, not found",
+			contentType:    controller.MergePatchType,
+			body:           `{"email":"test@test.com"}`,
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WithArgs(tc.args...).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Patch("/patch/"+tc.testID+"/:id", ctrl.Patch)
+
+			req := httptest.NewRequest(fiber.MethodPatch, "/patch/"+tc.testID+"/im_an_id", bytes.NewBufferString(tc.body))
+			req.Header.Set(fiber.HeaderContentType, tc.contentType)
+			if tc.ifMatch != "" {
+				req.Header.Set(fiber.HeaderIfMatch, tc.ifMatch)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, tc.expectedETag, resp.Header.Get(fiber.HeaderETag))
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Patch("/patch/"+tc.testID+"/:id", ctrl.Patch)
+
+			req := httptest.NewRequest(fiber.MethodPatch, "/patch/"+tc.testID+"/im_an_id", bytes.NewBufferString(tc.body))
+			req.Header.Set(fiber.HeaderContentType, tc.contentType)
+			if tc.ifMatch != "" {
+				req.Header.Set(fiber.HeaderIfMatch, tc.ifMatch)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			if tc.expectedRule != "" {
+				p := problem.Problem{}
+				err = json.NewDecoder(resp.Body).Decode(&p)
+				assert.NoError(t, err)
+				assert.Equal(t, problem.CodeValidation, p.Code)
+				if assert.Len(t, p.Errors, 1) {
+					assert.Equal(t, tc.expectedRule, p.Errors[0].Rule)
+				}
+			}
+		})
+	}
+}
+
+func TestDelet(test *testing.T) {
+	dbUser1 := &internal.User{
+		ID:       "im_an_id",
+		Email:    "test@test.com",
+		Phone:    "+991234567890",
+		Password: "12345pAsSWORd*",
+	}
+	successfulCases := []struct {
+		testID         string
+		name           string
+		dbUser         *internal.User
+		body           string
+		id             string
+		expectedStatus int
+	}{
+		{
+			testID:         "test1",
+			name:           "it should delete user (mocked)",
+			dbUser:         dbUser1,
+			expectedStatus: fiber.StatusNoContent,
+			id:             "im_an_id",
+			body:           `{"password":"12345pAsSWORd*"}`,
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
 		name           string
 		dbUser         *internal.User
 		body           string
@@ -1077,6 +1871,7 @@ func TestDelet(test *testing.T) {
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
 	}
 	app := fiber.New(sCfg)
 
@@ -1109,14 +1904,25 @@ func TestDelet(test *testing.T) {
 			m.ExpectExec(regexp.QuoteMeta(spDelete)).WithArgs(
 				&tc.dbUser.ID,
 				&tc.dbUser.Password,
+				&tc.dbUser.Version,
 			).WillReturnResult(sqlmock.NewResult(0, 1))
 			assert.Empty(t, err, "expected no error, but got:", err)
 
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Delete("/delete/"+tc.testID+"/:id", ctrl.Delete)
 
@@ -1150,14 +1956,25 @@ func TestDelet(test *testing.T) {
 			m.ExpectExec(regexp.QuoteMeta(spDelete)).WithArgs(
 				&tc.dbUser.ID,
 				&tc.dbUser.Password,
+				&tc.dbUser.Version,
 			).WillReturnResult(sqlmock.NewResult(0, 1))
 			assert.Empty(t, err, "expected no error, but got:", err)
 
 			myCache := cache.New(5*time.Minute, 10*time.Minute)
 
 			r := repository.NewRepository(db)
-			uc := usecases.NewUseCases(r, uuid)
-			ctrl := controller.NewController(uc, *v, l, jwt, val, *myCache)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
 
 			app.Delete("/delete/"+tc.testID+"/:id", ctrl.Delete)
 
@@ -1177,3 +1994,2793 @@ func TestDelet(test *testing.T) {
 		})
 	}
 }
+
+// nullable is the sql argument of an optional field, empty strings are sent as NULL
+func nullable(s string) driver.Value {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func TestChangePassword(test *testing.T) {
+	wrongCredentials := &mysql.MySQLError{Number: 1644, Message: "not authorized (wrong credentials)"}
+	copy(wrongCredentials.SQLState[:], "40100")
+
+	successfulCases := []struct {
+		testID string
+		name   string
+		body   string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			testID: "test1",
+			name:   "it should change the password (mocked)",
+			body:   `{"current_password":"12345pAsSWORd*","new_password":"54321pAsSWORd*"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdatePassword)).
+					WithArgs("im_an_id", "12345pAsSWORd*", "54321pAsSWORd*").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spRevokeUserRefreshTokens)).
+					WithArgs("im_an_id").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreateRefreshToken)).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "im_an_id", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		session        string
+		body           string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test2",
+			name:           "it should not change the password, missing session",
+			body:           `{"current_password":"12345pAsSWORd*","new_password":"54321pAsSWORd*"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			testID:         "test3",
+			name:           "it should not change the password, same password",
+			session:        "im_an_id",
+			body:           `{"current_password":"12345pAsSWORd*","new_password":"12345pAsSWORd*"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test4",
+			name:           "it should not change the password, weak password",
+			session:        "im_an_id",
+			body:           `{"current_password":"12345pAsSWORd*","new_password":"password"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			testID:  "test5",
+			name:    "it should not change the password (mocked), wrong current password",
+			session: "im_an_id",
+			body:    `{"current_password":"wrong pAsSWORd*","new_password":"54321pAsSWORd*"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdatePassword)).WillReturnError(wrongCredentials)
+			},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Put("/password/"+tc.testID, ctrl.ChangePassword)
+
+			req := httptest.NewRequest(fiber.MethodPut, "/password/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: "im_an_id"})
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			cookies := strings.Join(resp.Header.Values(fiber.HeaderSetCookie), "\n")
+			assert.Contains(t, cookies, controller.SessionCookie+"=im_an_id")
+			assert.Contains(t, cookies, controller.RefreshCookie+"=")
+			assert.NoError(t, m.ExpectationsWereMet())
+
+			// the sessions started before the change are rejected from now on
+			old := &utils.UserToken{ID: "im_an_old_id", UID: "im_an_id", IssuedAt: time.Now().Add(-time.Minute)}
+			revoked, err := sessions.IsSessionRevoked(context.Background(), old)
+			assert.NoError(t, err)
+			assert.True(t, revoked)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Put("/password/"+tc.testID, ctrl.ChangePassword)
+
+			req := httptest.NewRequest(fiber.MethodPut, "/password/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			if tc.session != "" {
+				req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: tc.session})
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+// newSessions is a sessions usecase keeping revocations in memory and refresh tokens in the mocked db
+func newSessions(db *sql.DB) usecases.Sessions {
+	return usecases.NewSessions(repository.NewMemoryRevocations(time.Hour), repository.NewRefreshTokens(db), utils.NewUUIDMock(), time.Hour)
+}
+
+// newPasswordResets is a password resets usecase writing its emails into dir
+func newPasswordResets(db *sql.DB, dir string) usecases.PasswordResets {
+	return usecases.NewPasswordResets(repository.NewPasswordResets(db), utils.NewFileMailer(dir, "no-reply@test.com"), time.Hour, "http://localhost/reset")
+}
+
+// newEmailVerifications is an email verifications usecase writing its emails into dir
+func newEmailVerifications(db *sql.DB, dir string) usecases.EmailVerifications {
+	return usecases.NewEmailVerifications(repository.NewEmailVerifications(db), utils.NewFileMailer(dir, "no-reply@test.com"), utils.NewJWTMock(), time.Hour, time.Minute, "http://localhost/verify")
+}
+
+func newPhoneVerifications(db *sql.DB, dir string) usecases.PhoneVerifications {
+	return usecases.NewPhoneVerifications(repository.NewPhoneOTPs(db), utils.NewFakeSMSSender(dir), 5*time.Minute, time.Minute, 5)
+}
+
+func newTwoFactor(db *sql.DB) usecases.TwoFactor {
+	return usecases.NewTwoFactor(repository.NewTwoFactor(db), utils.NewTOTP("go-cleanapi"), utils.NewJWTMock(), 5, 5*time.Minute)
+}
+
+func newImporter(db *sql.DB) controller.Importer {
+	return controller.NewImporter(usecases.NewImports(repository.NewImports(db), utils.NewUUIDMock()), *validator.New(), 2)
+}
+
+func newExporter(db *sql.DB) controller.Exporter {
+	return controller.NewExporter(usecases.NewExports(repository.NewExports(db)), *validator.New())
+}
+
+func newUserEvents() usecases.UserEvents {
+	return usecases.NewUserEvents(repository.NewMemoryUserEvents(10))
+}
+
+func newEventStreamer(events usecases.UserEvents) controller.EventStreamer {
+	return controller.NewEventStreamer(events, time.Second, 2*time.Second)
+}
+
+func newLockouts() usecases.Lockouts {
+	return usecases.NewLockouts(repository.NewMemoryLoginFailures(), 3, 20, time.Minute, time.Hour, time.Hour)
+}
+
+func TestLogout(test *testing.T) {
+	refreshColumns := []string{"id_family", "id_user", "expires_at", "used_at", "revoked_at"}
+
+	successfulCases := []struct {
+		testID       string
+		name         string
+		path         string
+		handler      func(ctrl controller.Controller) fiber.Handler
+		refreshToken string
+		expect       func(m sqlmock.Sqlmock)
+	}{
+		{
+			testID:  "test1",
+			name:    "it should log out",
+			path:    "/logout/",
+			handler: func(ctrl controller.Controller) fiber.Handler { return ctrl.Logout },
+			expect:  func(m sqlmock.Sqlmock) {},
+		},
+		{
+			testID:  "test2",
+			name:    "it should log out everywhere",
+			path:    "/logout/all/",
+			handler: func(ctrl controller.Controller) fiber.Handler { return ctrl.LogoutAll },
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spRevokeUserRefreshTokens)).
+					WithArgs("im_an_id").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			testID:       "test5",
+			name:         "it should log out and end the refresh token family",
+			path:         "/logout/",
+			handler:      func(ctrl controller.Controller) fiber.Handler { return ctrl.Logout },
+			refreshToken: "im_a_refresh_token",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).
+					WillReturnRows(sqlmock.NewRows(refreshColumns).
+						AddRow("im a family id", "im_an_id", time.Now().Add(time.Hour), nil, nil))
+				m.ExpectExec(regexp.QuoteMeta(spRevokeRefreshFamily)).
+					WithArgs("im a family id").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		path           string
+		handler        func(ctrl controller.Controller) fiber.Handler
+		expectedStatus int
+	}{
+		{
+			testID:         "test3",
+			name:           "it should not log out, missing session",
+			path:           "/logout/",
+			handler:        func(ctrl controller.Controller) fiber.Handler { return ctrl.Logout },
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			testID:         "test4",
+			name:           "it should not log out everywhere, missing session",
+			path:           "/logout/all/",
+			handler:        func(ctrl controller.Controller) fiber.Handler { return ctrl.LogoutAll },
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post(tc.path+tc.testID, tc.handler(ctrl))
+
+			req := httptest.NewRequest(fiber.MethodPost, tc.path+tc.testID, nil)
+			req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: "im_an_id"})
+			if tc.refreshToken != "" {
+				req.AddCookie(&http.Cookie{Name: controller.RefreshCookie, Value: tc.refreshToken})
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			cookies := strings.Join(resp.Header.Values(fiber.HeaderSetCookie), "\n")
+			assert.Contains(t, cookies, controller.SessionCookie+"=;")
+			assert.Contains(t, cookies, controller.RefreshCookie+"=;")
+			assert.NoError(t, m.ExpectationsWereMet())
+
+			// the token of the session is rejected from now on
+			token, err := jwt.ParseUserJWT("im_an_id")
+			assert.NoError(t, err)
+			revoked, err := sessions.IsSessionRevoked(context.Background(), token)
+			assert.NoError(t, err)
+			assert.True(t, revoked)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, _, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post(tc.path+tc.testID, tc.handler(ctrl))
+
+			req := httptest.NewRequest(fiber.MethodPost, tc.path+tc.testID, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestRefresh(test *testing.T) {
+	refreshColumns := []string{"id_family", "id_user", "expires_at", "used_at", "revoked_at"}
+
+	successfulCases := []struct {
+		testID string
+		name   string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			testID: "test1",
+			name:   "it should refresh the session (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).
+					WillReturnRows(sqlmock.NewRows(refreshColumns).
+						AddRow("im a family id", "im an ID", time.Now().Add(time.Hour), nil, nil))
+				m.ExpectExec(regexp.QuoteMeta(spRotateRefreshToken)).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		refreshToken   string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test2",
+			name:           "it should not refresh the session, missing refresh token",
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			testID:       "test3",
+			name:         "it should not refresh the session, unknown refresh token (mocked)",
+			refreshToken: "im_a_refresh_token",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).WillReturnError(sql.ErrNoRows)
+			},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			testID:       "test4",
+			name:         "it should not refresh the session, reused refresh token (mocked)",
+			refreshToken: "im_a_refresh_token",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).
+					WillReturnRows(sqlmock.NewRows(refreshColumns).
+						AddRow("im a family id", "im an ID", time.Now().Add(time.Hour), time.Now(), nil))
+				m.ExpectExec(regexp.QuoteMeta(spRevokeRefreshFamily)).
+					WithArgs("im a family id").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/refresh/"+tc.testID, ctrl.Refresh)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/refresh/"+tc.testID, nil)
+			req.AddCookie(&http.Cookie{Name: controller.RefreshCookie, Value: "im_a_refresh_token"})
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			cookies := strings.Join(resp.Header.Values(fiber.HeaderSetCookie), "\n")
+			assert.Contains(t, cookies, controller.SessionCookie+"=")
+			assert.Contains(t, cookies, controller.RefreshCookie+"=")
+			assert.NotContains(t, cookies, "im_a_refresh_token")
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/refresh/"+tc.testID, ctrl.Refresh)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/refresh/"+tc.testID, nil)
+			if tc.refreshToken != "" {
+				req.AddCookie(&http.Cookie{Name: controller.RefreshCookie, Value: tc.refreshToken})
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestForgotPassword(test *testing.T) {
+	successfulCases := []struct {
+		testID string
+		name   string
+		body   string
+		expect func(m sqlmock.Sqlmock)
+		mails  int
+	}{
+		{
+			testID: "test1",
+			name:   "it should mail a reset link (mocked)",
+			body:   `{"email":"test@test.com"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreatePasswordReset)).
+					WithArgs(sqlmock.AnyArg(), "test@test.com", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			mails: 1,
+		},
+		{
+			testID: "test2",
+			name:   "it should answer the same for an unknown email (mocked)",
+			body:   `{"email":"unknown@test.com"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreatePasswordReset)).
+					WithArgs(sqlmock.AnyArg(), "unknown@test.com", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			mails: 0,
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		body           string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test3",
+			name:           "it should not mail a reset link, invalid email",
+			body:           `{"email":"im not an email"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test4",
+			name:           "it should not mail a reset link, empty body",
+			body:           `{}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			dir := t.TempDir()
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, dir)
+			verifications := newEmailVerifications(db, dir)
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/password/forgot/"+tc.testID, ctrl.ForgotPassword)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/password/forgot/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+			assert.NoError(t, err)
+			assert.Len(t, files, tc.mails)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/password/forgot/"+tc.testID, ctrl.ForgotPassword)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/password/forgot/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestResetPassword(test *testing.T) {
+	successfulCases := []struct {
+		testID string
+		name   string
+		body   string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			testID: "test1",
+			name:   "it should reset the password (mocked)",
+			body:   `{"token":"im a reset token","new_password":"54321pAsSWORd*"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spResetPassword)).
+					WithArgs(sqlmock.AnyArg(), "54321pAsSWORd*").
+					WillReturnRows(sqlmock.NewRows([]string{"id_user"}).AddRow("im_an_id"))
+				m.ExpectExec(regexp.QuoteMeta(spRevokeUserRefreshTokens)).
+					WithArgs("im_an_id").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		body           string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test2",
+			name:           "it should not reset the password, missing token",
+			body:           `{"new_password":"54321pAsSWORd*"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test3",
+			name:           "it should not reset the password, weak password",
+			body:           `{"token":"im a reset token","new_password":"password"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnprocessableEntity,
+		},
+		{
+			testID: "test4",
+			name:   "it should not reset the password (mocked), used or expired token",
+			body:   `{"token":"im a reset token","new_password":"54321pAsSWORd*"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spResetPassword)).
+					WillReturnRows(sqlmock.NewRows([]string{"id_user"}))
+			},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/password/reset/"+tc.testID, ctrl.ResetPassword)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/password/reset/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+
+			// the sessions started before the reset are rejected from now on
+			old := &utils.UserToken{ID: "im_an_old_id", UID: "im_an_id", IssuedAt: time.Now().Add(-time.Minute)}
+			revoked, err := sessions.IsSessionRevoked(context.Background(), old)
+			assert.NoError(t, err)
+			assert.True(t, revoked)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/password/reset/"+tc.testID, ctrl.ResetPassword)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/password/reset/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestVerifyEmail(test *testing.T) {
+	userNotFound := &mysql.MySQLError{Number: 1644, Message: "user not found"}
+	copy(userNotFound.SQLState[:], "40400")
+
+	successfulCases := []struct {
+		testID string
+		name   string
+		token  string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			testID: "test1",
+			name:   "it should verify the email (mocked)",
+			token:  "test@test.com",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spVerifyUserEmail)).
+					WithArgs("test@test.com").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		token          string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test2",
+			name:           "it should not verify the email, missing token",
+			token:          "",
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID: "test3",
+			name:   "it should not verify the email (mocked), unknown email",
+			token:  "unknown@test.com",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spVerifyUserEmail)).WillReturnError(userNotFound)
+			},
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Get("/email/verify/"+tc.testID, ctrl.VerifyEmail)
+
+			req := httptest.NewRequest(fiber.MethodGet, "/email/verify/"+tc.testID+"?token="+url.QueryEscape(tc.token), nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Get("/email/verify/"+tc.testID, ctrl.VerifyEmail)
+
+			req := httptest.NewRequest(fiber.MethodGet, "/email/verify/"+tc.testID+"?token="+url.QueryEscape(tc.token), nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestResendVerification(test *testing.T) {
+	successfulCases := []struct {
+		testID string
+		name   string
+		body   string
+		expect func(m sqlmock.Sqlmock)
+		mails  int
+	}{
+		{
+			testID: "test1",
+			name:   "it should resend a verification link (mocked)",
+			body:   `{"email":"test@test.com"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadEmailVerification)).
+					WithArgs("test@test.com").
+					WillReturnRows(sqlmock.NewRows([]string{"pending"}).AddRow(true))
+			},
+			mails: 1,
+		},
+		{
+			testID: "test2",
+			name:   "it should answer the same for an unknown email (mocked)",
+			body:   `{"email":"unknown@test.com"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadEmailVerification)).
+					WithArgs("unknown@test.com").
+					WillReturnRows(sqlmock.NewRows([]string{"pending"}))
+			},
+			mails: 0,
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		body           string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test3",
+			name:           "it should not resend a verification link, invalid email",
+			body:           `{"email":"im not an email"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			dir := t.TempDir()
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, dir)
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/email/resend/"+tc.testID, ctrl.ResendVerification)
+
+			send := func() *http.Response {
+				req := httptest.NewRequest(fiber.MethodPost, "/email/resend/"+tc.testID, strings.NewReader(tc.body))
+				req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+				resp, err := app.Test(req)
+				assert.NoError(t, err)
+				return resp
+			}
+
+			assert.Equal(t, fiber.StatusAccepted, send().StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+			assert.NoError(t, err)
+			assert.Len(t, files, tc.mails)
+
+			// a second request for the same email is throttled, registered or not
+			assert.Equal(t, fiber.StatusTooManyRequests, send().StatusCode)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/email/resend/"+tc.testID, ctrl.ResendVerification)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/email/resend/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestRequestPhoneCode(test *testing.T) {
+	alreadyVerified := &mysql.MySQLError{Number: 1644, Message: "phone already verified"}
+	copy(alreadyVerified.SQLState[:], "40900")
+
+	successfulCases := []struct {
+		testID string
+		name   string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			testID: "test1",
+			name:   "it should text a phone code (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreatePhoneOTP)).
+					WithArgs("im_an_id", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"user_phone"}).AddRow("+991234567890"))
+			},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		session        string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test2",
+			name:           "it should not text a phone code, missing session",
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			testID:  "test3",
+			name:    "it should not text a phone code, phone already verified (mocked)",
+			session: "im_an_id",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreatePhoneOTP)).WillReturnError(alreadyVerified)
+			},
+			expectedStatus: fiber.StatusConflict,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			dir := t.TempDir()
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, dir)
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/phone/verify/"+tc.testID, ctrl.RequestPhoneCode)
+
+			send := func() *http.Response {
+				req := httptest.NewRequest(fiber.MethodPost, "/phone/verify/"+tc.testID, nil)
+				req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: "im_an_id"})
+				resp, err := app.Test(req)
+				assert.NoError(t, err)
+				return resp
+			}
+
+			assert.Equal(t, fiber.StatusAccepted, send().StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.sms"))
+			assert.NoError(t, err)
+			assert.Len(t, files, 1)
+
+			// a second code for the same user is throttled
+			assert.Equal(t, fiber.StatusTooManyRequests, send().StatusCode)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/phone/verify/"+tc.testID, ctrl.RequestPhoneCode)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/phone/verify/"+tc.testID, nil)
+			if tc.session != "" {
+				req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: tc.session})
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestConfirmPhoneCode(test *testing.T) {
+	wrongCode := &mysql.MySQLError{Number: 1644, Message: "invalid or expired code"}
+	copy(wrongCode.SQLState[:], "40100")
+	tooManyAttempts := &mysql.MySQLError{Number: 1644, Message: "too many wrong codes, request a new one"}
+	copy(tooManyAttempts.SQLState[:], "42900")
+
+	successfulCases := []struct {
+		testID string
+		name   string
+		body   string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			testID: "test1",
+			name:   "it should verify the phone (mocked)",
+			body:   `{"code":"123456"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spConfirmPhoneOTP)).
+					WithArgs("im_an_id", sqlmock.AnyArg(), 5).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		session        string
+		body           string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test2",
+			name:           "it should not verify the phone, missing session",
+			body:           `{"code":"123456"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			testID:         "test3",
+			name:           "it should not verify the phone, invalid code",
+			session:        "im_an_id",
+			body:           `{"code":"12a4"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:  "test4",
+			name:    "it should not verify the phone, wrong code (mocked)",
+			session: "im_an_id",
+			body:    `{"code":"654321"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spConfirmPhoneOTP)).WillReturnError(wrongCode)
+			},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			testID:  "test5",
+			name:    "it should not verify the phone, too many wrong codes (mocked)",
+			session: "im_an_id",
+			body:    `{"code":"654321"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spConfirmPhoneOTP)).WillReturnError(tooManyAttempts)
+			},
+			expectedStatus: fiber.StatusTooManyRequests,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/phone/confirm/"+tc.testID, ctrl.ConfirmPhoneCode)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/phone/confirm/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: "im_an_id"})
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/phone/confirm/"+tc.testID, ctrl.ConfirmPhoneCode)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/phone/confirm/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			if tc.session != "" {
+				req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: tc.session})
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuthMFA(test *testing.T) {
+	usedCode := &mysql.MySQLError{Number: 1644, Message: "invalid or used code"}
+	copy(usedCode.SQLState[:], "40100")
+
+	successfulCases := []struct {
+		testID string
+		name   string
+		body   string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			testID: "test1",
+			name:   "it should complete the login with a recovery code (mocked)",
+			body:   `{"mfa_token":"im_an_id","code":"abcd-2345"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).
+					WithArgs("im_an_id", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreateRefreshToken)).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "im_an_id", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		body           string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test2",
+			name:           "it should not complete the login, missing code",
+			body:           `{"mfa_token":"im_an_id"}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID: "test3",
+			name:   "it should not complete the login, used recovery code (mocked)",
+			body:   `{"mfa_token":"im_an_id","code":"abcd-2345"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).WillReturnError(usedCode)
+			},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	test.Run("it should answer an mfa token instead of a session (mocked)", func(t *testing.T) {
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(spLogin)).
+			WithArgs("test@test.com", "", "12345pAsSWORd*").
+			WillReturnRows(sqlmock.NewRows([]string{"id_user", "email_verified", "totp_enabled"}).AddRow("im_an_id", true, true))
+
+		myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+		r := repository.NewRepository(db)
+		sessions := newSessions(db)
+		events := newUserEvents()
+		uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+		resets := newPasswordResets(db, t.TempDir())
+		verifications := newEmailVerifications(db, t.TempDir())
+		phones := newPhoneVerifications(db, t.TempDir())
+		twoFactor := newTwoFactor(db)
+		lockouts := newLockouts()
+		importer := newImporter(db)
+		exporter := newExporter(db)
+		streamer := newEventStreamer(events)
+		ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+		app.Post("/auth/pending", ctrl.Auth)
+
+		form := url.Values{}
+		form.Set("user", "test@test.com")
+		form.Set("password", "12345pAsSWORd*")
+		req := httptest.NewRequest(fiber.MethodPost, "/auth/pending", strings.NewReader(form.Encode()))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		assert.Empty(t, resp.Header.Values(fiber.HeaderSetCookie))
+		body, err := io.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		assert.Contains(t, string(body), `"mfa_token":"im_an_id"`)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/auth/mfa/"+tc.testID, ctrl.AuthMFA)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/auth/mfa/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusAccepted, resp.StatusCode)
+			cookies := strings.Join(resp.Header.Values(fiber.HeaderSetCookie), "\n")
+			assert.Contains(t, cookies, controller.SessionCookie+"=im_an_id")
+			assert.Contains(t, cookies, controller.RefreshCookie+"=")
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/auth/mfa/"+tc.testID, ctrl.AuthMFA)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/auth/mfa/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.Empty(t, resp.Header.Values(fiber.HeaderSetCookie))
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestTOTP(test *testing.T) {
+	totpColumns := []string{"totp_secret", "totp_enabled", "totp_last_step"}
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	successfulCases := []struct {
+		testID  string
+		name    string
+		method  string
+		handler func(ctrl controller.Controller) fiber.Handler
+		body    func(t *testing.T) string
+		expect  func(m sqlmock.Sqlmock)
+		contain string
+	}{
+		{
+			testID:  "test1",
+			name:    "it should enroll totp (mocked)",
+			method:  fiber.MethodPost,
+			handler: func(ctrl controller.Controller) fiber.Handler { return ctrl.EnrollTOTP },
+			body:    func(t *testing.T) string { return "" },
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreateTOTP)).
+					WithArgs("im_an_id", sqlmock.AnyArg()).
+					WillReturnRows(sqlmock.NewRows([]string{"user_email"}).AddRow("test@test.com"))
+			},
+			contain: `"uri":"otpauth://totp/go-cleanapi:test@test.com?`,
+		},
+		{
+			testID:  "test2",
+			name:    "it should confirm totp (mocked)",
+			method:  fiber.MethodPost,
+			handler: func(ctrl controller.Controller) fiber.Handler { return ctrl.ConfirmTOTP },
+			body: func(t *testing.T) string {
+				code, err := utils.NewTOTP("go-cleanapi").Code(secret, time.Now())
+				assert.NoError(t, err)
+				return `{"code":"` + code + `"}`
+			},
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+					WithArgs("im_an_id").
+					WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(secret, false, -1))
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spEnableTOTP)).WillReturnResult(sqlmock.NewResult(0, 1))
+				for i := 0; i < 10; i++ {
+					m.ExpectExec(regexp.QuoteMeta(spCreateRecoveryCode)).WillReturnResult(sqlmock.NewResult(0, 1))
+				}
+				m.ExpectCommit()
+			},
+			contain: `"recovery_codes":[`,
+		},
+		{
+			testID:  "test3",
+			name:    "it should disable totp (mocked)",
+			method:  fiber.MethodDelete,
+			handler: func(ctrl controller.Controller) fiber.Handler { return ctrl.DisableTOTP },
+			body:    func(t *testing.T) string { return `{"code":"abcd-2345"}` },
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).
+					WithArgs("im_an_id", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spDisableTOTP)).
+					WithArgs("im_an_id").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			contain: `"msg"`,
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		method         string
+		handler        func(ctrl controller.Controller) fiber.Handler
+		session        string
+		body           string
+		expect         func(m sqlmock.Sqlmock)
+		expectedStatus int
+	}{
+		{
+			testID:         "test4",
+			name:           "it should not enroll totp, missing session",
+			method:         fiber.MethodPost,
+			handler:        func(ctrl controller.Controller) fiber.Handler { return ctrl.EnrollTOTP },
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			testID:         "test5",
+			name:           "it should not confirm totp, missing code",
+			method:         fiber.MethodPost,
+			handler:        func(ctrl controller.Controller) fiber.Handler { return ctrl.ConfirmTOTP },
+			session:        "im_an_id",
+			body:           `{}`,
+			expect:         func(m sqlmock.Sqlmock) {},
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:  "test6",
+			name:    "it should not confirm totp, wrong code (mocked)",
+			method:  fiber.MethodPost,
+			handler: func(ctrl controller.Controller) fiber.Handler { return ctrl.ConfirmTOTP },
+			session: "im_an_id",
+			body:    `{"code":"000000"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+					WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(secret, false, -1))
+			},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+		{
+			testID:  "test7",
+			name:    "it should not disable totp, not enabled (mocked)",
+			method:  fiber.MethodDelete,
+			handler: func(ctrl controller.Controller) fiber.Handler { return ctrl.DisableTOTP },
+			session: "im_an_id",
+			body:    `{"code":"123456"}`,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).WillReturnRows(sqlmock.NewRows(totpColumns))
+			},
+			expectedStatus: fiber.StatusUnauthorized,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Add(tc.method, "/mfa/totp/"+tc.testID, tc.handler(ctrl))
+
+			req := httptest.NewRequest(tc.method, "/mfa/totp/"+tc.testID, strings.NewReader(tc.body(t)))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: "im_an_id"})
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Contains(t, string(body), tc.contain)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Add(tc.method, "/mfa/totp/"+tc.testID, tc.handler(ctrl))
+
+			req := httptest.NewRequest(tc.method, "/mfa/totp/"+tc.testID, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			if tc.session != "" {
+				req.AddCookie(&http.Cookie{Name: controller.SessionCookie, Value: tc.session})
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestAuthLockout(test *testing.T) {
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	db, m, err := sqlmock.New()
+	if err != nil {
+		test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+
+	myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+	r := repository.NewRepository(db)
+	sessions := newSessions(db)
+	events := newUserEvents()
+	uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+	resets := newPasswordResets(db, test.TempDir())
+	verifications := newEmailVerifications(db, test.TempDir())
+	phones := newPhoneVerifications(db, test.TempDir())
+	twoFactor := newTwoFactor(db)
+	lockouts := newLockouts()
+	importer := newImporter(db)
+	exporter := newExporter(db)
+	streamer := newEventStreamer(events)
+	ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+	app.Post("/auth/lockout", ctrl.Auth)
+	app.Post("/lockouts/unlock", ctrl.Unlock)
+
+	auth := func(t *testing.T, password string) int {
+		form := url.Values{}
+		form.Set("user", "Test@test.com")
+		form.Set("password", password)
+		req := httptest.NewRequest(fiber.MethodPost, "/auth/lockout", strings.NewReader(form.Encode()))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+	unlock := func(t *testing.T, body string) int {
+		req := httptest.NewRequest(fiber.MethodPost, "/lockouts/unlock", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+		return resp.StatusCode
+	}
+
+	test.Run("it should lock the account after the failed logins (mocked)", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			m.ExpectQuery(regexp.QuoteMeta(spLogin)).
+				WithArgs("Test@test.com", "", "wrong").
+				WillReturnRows(sqlmock.NewRows([]string{"id_user", "email_verified", "totp_enabled"}))
+			assert.Equal(t, fiber.StatusUnauthorized, auth(t, "wrong"))
+		}
+
+		// the right password is refused like a wrong one and the database is not asked
+		assert.Equal(t, fiber.StatusUnauthorized, auth(t, "12345pAsSWORd*"))
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+
+	test.Run("it should not unlock, missing account and ip", func(t *testing.T) {
+		assert.Equal(t, fiber.StatusBadRequest, unlock(t, `{}`))
+	})
+
+	test.Run("it should login once an admin unlocks the account (mocked)", func(t *testing.T) {
+		assert.Equal(t, fiber.StatusOK, unlock(t, `{"user":"test@test.com"}`))
+
+		m.ExpectQuery(regexp.QuoteMeta(spLogin)).
+			WithArgs("Test@test.com", "", "12345pAsSWORd*").
+			WillReturnRows(sqlmock.NewRows([]string{"id_user", "email_verified", "totp_enabled"}).AddRow("im an ID", true, false))
+		m.ExpectExec(regexp.QuoteMeta(spCreateRefreshToken)).
+			WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "im an ID", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		assert.Equal(t, fiber.StatusAccepted, auth(t, "12345pAsSWORd*"))
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+
+	test.Run("it should not lock an unverified account that has the right password (mocked)", func(t *testing.T) {
+		verifiedOnly := usecases.NewUseCases(r, uuid, true, jwt, 5*time.Minute, events)
+		ctrl := controller.NewController(verifiedOnly, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+		app.Post("/auth/unverified", ctrl.Auth)
+
+		for i := 0; i < 4; i++ {
+			m.ExpectQuery(regexp.QuoteMeta(spLogin)).
+				WithArgs("unverified@test.com", "", "12345pAsSWORd*").
+				WillReturnRows(sqlmock.NewRows([]string{"id_user", "email_verified", "totp_enabled"}).AddRow("im an ID", false, false))
+
+			form := url.Values{}
+			form.Set("user", "unverified@test.com")
+			form.Set("password", "12345pAsSWORd*")
+			req := httptest.NewRequest(fiber.MethodPost, "/auth/unverified", strings.NewReader(form.Encode()))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationForm)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusForbidden, resp.StatusCode)
+		}
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+}
+
+func TestImport(test *testing.T) {
+	duplicated := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'taken@test.com' for key 'users.user_email'"}
+
+	successfulCases := []struct {
+		testID      string
+		name        string
+		query       string
+		contentType string
+		body        string
+		expect      func(m sqlmock.Sqlmock)
+		expected    controller.ImportReport
+	}{
+		{
+			testID:      "test1",
+			name:        "it should import a csv in batches (mocked)",
+			contentType: "text/csv",
+			body:        "email,phone,password\ntest@test.com,+521234567890,12345pAsSWORd*\nnot an email,,12345pAsSWORd*\ntaken@test.com,,12345pAsSWORd*\n",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).
+					WithArgs(sqlmock.AnyArg(), "test@test.com", "+521234567890", "12345pAsSWORd*").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectCommit()
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnError(duplicated)
+				m.ExpectCommit()
+			},
+			expected: controller.ImportReport{
+				Created: 1,
+				Skipped: 1,
+				Failed:  1,
+				Rows: []controller.ImportResult{
+					{Line: 2, Email: "test@test.com", Status: internal.ImportCreated},
+					{Line: 3, Email: "not an email", Status: internal.ImportFailed, Error: "Email failed on the email rule"},
+					{Line: 4, Email: "taken@test.com", Status: internal.ImportSkipped, Error: "user already exists"},
+				},
+			},
+		},
+		{
+			testID: "test2",
+			name:   "it should report a ndjson dry run (mocked)",
+			query:  "?format=ndjson&dry_run=true",
+			body:   "{\"email\":\"test@test.com\",\"password\":\"12345pAsSWORd*\"}\n\n{\"email\":\n",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectRollback()
+			},
+			expected: controller.ImportReport{
+				DryRun:  true,
+				Created: 1,
+				Failed:  1,
+				Rows: []controller.ImportResult{
+					{Line: 1, Email: "test@test.com", Status: internal.ImportCreated},
+					{Line: 3, Status: internal.ImportFailed, Error: "invalid json object"},
+				},
+			},
+		},
+		{
+			testID:      "test6",
+			name:        "it should report a user repeated in a later batch of a dry run as skipped (mocked)",
+			query:       "?dry_run=true",
+			contentType: "text/csv",
+			body:        "email,password\ntest@test.com,12345pAsSWORd*\nother@test.com,12345pAsSWORd*\nTEST@test.com,12345pAsSWORd*\n",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectRollback()
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectRollback()
+			},
+			expected: controller.ImportReport{
+				DryRun:  true,
+				Created: 2,
+				Skipped: 1,
+				Rows: []controller.ImportResult{
+					{Line: 2, Email: "test@test.com", Status: internal.ImportCreated},
+					{Line: 3, Email: "other@test.com", Status: internal.ImportCreated},
+					{Line: 4, Email: "TEST@test.com", Status: internal.ImportSkipped, Error: "user already exists"},
+				},
+			},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		query          string
+		contentType    string
+		body           string
+		expectedStatus int
+	}{
+		{
+			testID:         "test3",
+			name:           "it should not import, unsupported format",
+			contentType:    fiber.MIMEApplicationJSON,
+			body:           `[]`,
+			expectedStatus: fiber.StatusUnsupportedMediaType,
+		},
+		{
+			testID:         "test4",
+			name:           "it should not import, csv without password column",
+			contentType:    "text/csv",
+			body:           "email,phone\ntest@test.com,\n",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test5",
+			name:           "it should not import, invalid dry run",
+			query:          "?format=csv&dry_run=maybe",
+			body:           "email,password\n",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	test.Run("it should report the batches created before a streamed import timed out (mocked)", func(t *testing.T) {
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectBegin()
+		m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+		m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+		m.ExpectCommit()
+		m.ExpectBegin()
+		m.ExpectExec(regexp.QuoteMeta(spCreate)).WillDelayFor(time.Second).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+		r := repository.NewRepository(db)
+		sessions := newSessions(db)
+		events := newUserEvents()
+		uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+		resets := newPasswordResets(db, t.TempDir())
+		verifications := newEmailVerifications(db, t.TempDir())
+		phones := newPhoneVerifications(db, t.TempDir())
+		twoFactor := newTwoFactor(db)
+		lockouts := newLockouts()
+		importer := newImporter(db)
+		exporter := newExporter(db)
+		streamer := newEventStreamer(events)
+		ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+		streamed := fiber.New(fiber.Config{StreamRequestBody: true, ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock())})
+		streamed.Post("/import/timeout", func(c *fiber.Ctx) error {
+			ctx, cancel := context.WithTimeout(c.UserContext(), 100*time.Millisecond)
+			defer cancel()
+			c.SetUserContext(ctx)
+			return c.Next()
+		}, ctrl.Import)
+
+		body := "email,password\ntest@test.com,12345pAsSWORd*\nother@test.com,12345pAsSWORd*\nlast@test.com,12345pAsSWORd*\n"
+		req := httptest.NewRequest(fiber.MethodPost, "/import/timeout", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, "text/csv")
+		resp, err := streamed.Test(req, -1)
+		assert.NoError(t, err)
+
+		assert.Equal(t, fiber.StatusServiceUnavailable, resp.StatusCode)
+		report := controller.ImportReport{}
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		assert.NoError(t, err)
+		assert.Equal(t, controller.ImportReport{
+			Interrupted: true,
+			Created:     2,
+			Rows: []controller.ImportResult{
+				{Line: 2, Email: "test@test.com", Status: internal.ImportCreated},
+				{Line: 3, Email: "other@test.com", Status: internal.ImportCreated},
+			},
+		}, report)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+
+	test.Run("it should report the errors of the rows in the language of the request (mocked)", func(t *testing.T) {
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectBegin()
+		m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+		m.ExpectRollback()
+		m.ExpectBegin()
+		m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+		m.ExpectRollback()
+
+		// the translations are registered on the validator before the importer copies it
+		translator, err := i18n.NewTranslator(v)
+		if err != nil {
+			test.Fatalf("expected no error but got %v", err)
+		}
+		myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+		r := repository.NewRepository(db)
+		sessions := newSessions(db)
+		events := newUserEvents()
+		uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+		resets := newPasswordResets(db, t.TempDir())
+		verifications := newEmailVerifications(db, t.TempDir())
+		phones := newPhoneVerifications(db, t.TempDir())
+		twoFactor := newTwoFactor(db)
+		lockouts := newLockouts()
+		importer := controller.NewImporter(usecases.NewImports(repository.NewImports(db), uuid), *v, 2)
+		exporter := newExporter(db)
+		streamer := newEventStreamer(events)
+		ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+		app.Post("/import/language", func(c *fiber.Ctx) error {
+			i18n.SetTranslator(c, translator.Negotiate("es"))
+			return c.Next()
+		}, ctrl.Import)
+
+		body := "email,password\ntest@test.com,12345pAsSWORd*\nnot an email,12345pAsSWORd*\nTEST@test.com,12345pAsSWORd*\n"
+		req := httptest.NewRequest(fiber.MethodPost, "/import/language?dry_run=true", strings.NewReader(body))
+		req.Header.Set(fiber.HeaderContentType, "text/csv")
+		resp, err := app.Test(req)
+		assert.NoError(t, err)
+
+		assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+		report := controller.ImportReport{}
+		err = json.NewDecoder(resp.Body).Decode(&report)
+		assert.NoError(t, err)
+		assert.Equal(t, controller.ImportReport{
+			DryRun:  true,
+			Created: 1,
+			Skipped: 1,
+			Failed:  1,
+			Rows: []controller.ImportResult{
+				{Line: 2, Email: "test@test.com", Status: internal.ImportCreated},
+				{Line: 3, Email: "not an email", Status: internal.ImportFailed, Error: "email debe ser una dirección de correo electrónico válida"},
+				{Line: 4, Email: "TEST@test.com", Status: internal.ImportSkipped, Error: "el usuario ya existe"},
+			},
+		}, report)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/import/"+tc.testID, ctrl.Import)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/import/"+tc.testID+tc.query, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, tc.contentType)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			report := controller.ImportReport{}
+			err = json.NewDecoder(resp.Body).Decode(&report)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, report)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Post("/import/"+tc.testID, ctrl.Import)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/import/"+tc.testID+tc.query, strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, tc.contentType)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestExport(test *testing.T) {
+	createdAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	columns := []string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}
+	qExport := "FROM `go_cleanapi`.`users`"
+
+	successfulCases := []struct {
+		testID              string
+		name                string
+		query               string
+		expect              func(m sqlmock.Sqlmock)
+		expectedContentType string
+		expected            string
+	}{
+		{
+			testID: "test1",
+			name:   "it should stream every column as csv (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(qExport)).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow("im an id", "test@test.com", "+521234567890", createdAt, true, false).
+						AddRow("im another id", "other@test.com", "", createdAt, false, false))
+			},
+			expectedContentType: "text/csv; charset=utf-8",
+			expected: "uid,email,phone,created_at,email_verified,phone_verified\n" +
+				"im an id,test@test.com,+521234567890,2023-01-02T03:04:05Z,true,false\n" +
+				"im another id,other@test.com,,2023-01-02T03:04:05Z,false,false\n",
+		},
+		{
+			testID: "test2",
+			name:   "it should stream the selected columns of the filtered users as ndjson (mocked)",
+			query:  "?format=ndjson&columns=email,uid&email=test",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(qExport)).
+					WithArgs("test%").
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow("im an id", "test@test.com", "+521234567890", createdAt, true, false))
+			},
+			expectedContentType: "application/x-ndjson",
+			expected:            "{\"email\":\"test@test.com\",\"uid\":\"im an id\"}\n",
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		query          string
+		expectedStatus int
+	}{
+		{
+			testID:         "test3",
+			name:           "it should not export, unknown column",
+			query:          "?columns=email,password",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test4",
+			name:           "it should not export, unsupported format",
+			query:          "?format=xml",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test5",
+			name:           "it should not export, invalid date",
+			query:          "?created_from=yesterday",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Get("/export/"+tc.testID, ctrl.Export)
+
+			req := httptest.NewRequest(fiber.MethodGet, "/export/"+tc.testID+tc.query, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, tc.expectedContentType, resp.Header.Get(fiber.HeaderContentType))
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(body))
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Get("/export/"+tc.testID, ctrl.Export)
+
+			req := httptest.NewRequest(fiber.MethodGet, "/export/"+tc.testID+tc.query, nil)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestEvents(test *testing.T) {
+	// the published events, the cases expect them by their index
+	published := []struct {
+		eventType string
+		uid       string
+	}{
+		{eventType: internal.UserCreated, uid: "im an id"},
+		{eventType: internal.UserUpdated, uid: "im an id"},
+		{eventType: internal.UserDeleted, uid: "im another id"},
+	}
+
+	successfulCases := []struct {
+		testID   string
+		name     string
+		query    string
+		resume   bool
+		after    int
+		expected []int
+	}{
+		{
+			testID:   "test1",
+			name:     "it should resume after the last event id",
+			resume:   true,
+			after:    0,
+			expected: []int{1, 2},
+		},
+		{
+			testID:   "test2",
+			name:     "it should stream only the selected types",
+			query:    "?types=user.created,user.deleted",
+			resume:   true,
+			after:    -1,
+			expected: []int{0, 2},
+		},
+		{
+			testID:   "test3",
+			name:     "it should stream only the events of the user",
+			query:    "?uid=im%20another%20id",
+			resume:   true,
+			after:    -1,
+			expected: []int{2},
+		},
+		{
+			testID:   "test4",
+			name:     "it should start with the next event without a last event id",
+			expected: []int{},
+		},
+	}
+
+	failedCases := []struct {
+		testID         string
+		name           string
+		query          string
+		lastEventID    string
+		expectedStatus int
+	}{
+		{
+			testID:         "test5",
+			name:           "it should not stream, invalid last event id",
+			lastEventID:    "yesterday",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+		{
+			testID:         "test6",
+			name:           "it should not stream, unknown type",
+			query:          "?types=user.created,user.read",
+			expectedStatus: fiber.StatusBadRequest,
+		},
+	}
+
+	sCfg := fiber.Config{
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	}
+	app := fiber.New(sCfg)
+
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	v := validator.New()
+	l := utils.NewLogger(*vars)
+	err = l.Initialize()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+	uuid := utils.NewUUIDMock()
+	jwt := utils.NewJWTMock()
+	val := utils.NewValidations()
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			start, err := events.LastID(context.Background())
+			assert.NoError(t, err)
+			for _, p := range published {
+				assert.NoError(t, events.Publish(context.Background(), p.eventType, p.uid))
+			}
+			stored, err := events.ReadAfter(context.Background(), start)
+			assert.NoError(t, err)
+			// a closed stream writes what it has and ends, instead of waiting for new events
+			events.Close()
+
+			app.Get("/events/"+tc.testID, ctrl.Events)
+
+			req := httptest.NewRequest(fiber.MethodGet, "/events/"+tc.testID+tc.query, nil)
+			if tc.resume {
+				lastID := start
+				if tc.after >= 0 {
+					lastID = stored[tc.after].ID
+				}
+				req.Header.Set("Last-Event-ID", strconv.FormatInt(lastID, 10))
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, "text/event-stream", resp.Header.Get(fiber.HeaderContentType))
+			assert.Equal(t, "no-cache", resp.Header.Get(fiber.HeaderCacheControl))
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			expected := "retry: 3000\n\n"
+			for _, n := range tc.expected {
+				data, err := json.Marshal(&controller.Event{
+					ID:         stored[n].ID,
+					Type:       stored[n].Type,
+					UID:        stored[n].UID,
+					OccurredAt: stored[n].OccurredAt,
+				})
+				assert.NoError(t, err)
+				expected += fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", stored[n].ID, stored[n].Type, data)
+			}
+			assert.Equal(t, expected, string(body))
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			myCache := cache.New(5*time.Minute, 10*time.Minute)
+
+			r := repository.NewRepository(db)
+			sessions := newSessions(db)
+			events := newUserEvents()
+			uc := usecases.NewUseCases(r, uuid, false, jwt, 5*time.Minute, events)
+			resets := newPasswordResets(db, t.TempDir())
+			verifications := newEmailVerifications(db, t.TempDir())
+			phones := newPhoneVerifications(db, t.TempDir())
+			twoFactor := newTwoFactor(db)
+			lockouts := newLockouts()
+			importer := newImporter(db)
+			exporter := newExporter(db)
+			streamer := newEventStreamer(events)
+			ctrl := controller.NewController(uc, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, *v, l, jwt, val, *myCache)
+
+			app.Get("/events/"+tc.testID, ctrl.Events)
+
+			req := httptest.NewRequest(fiber.MethodGet, "/events/"+tc.testID+tc.query, nil)
+			if tc.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tc.lastEventID)
+			}
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
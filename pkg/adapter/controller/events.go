@@ -0,0 +1,146 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// eventsRetry is the reconnection delay suggested to the clients, in milliseconds
+const eventsRetry = 3000
+
+// EventStreamer is an interface that extends the streams of user events. A stream writes the
+// events after the last one the client got as server-sent events, waits for new ones and sends
+// a comment every heartbeat so proxies keep the connection open
+type EventStreamer interface {
+	Stream(ctx context.Context, w io.Writer, req *EventsRequest, lastID int64) error
+	// LastID is the id a stream without a last event starts after
+	LastID(ctx context.Context) (int64, error)
+}
+
+var _ EventStreamer = (*eventStreamer)(nil)
+
+type eventStreamer struct {
+	events    usecases.UserEvents
+	heartbeat time.Duration
+	timeout   time.Duration
+}
+
+// NewEventStreamer is a constructor for the user event streams, a stream ends after the timeout
+// and the client reconnects with the id of its last event
+func NewEventStreamer(ue usecases.UserEvents, heartbeat time.Duration, timeout time.Duration) EventStreamer {
+	return &eventStreamer{
+		events:    ue,
+		heartbeat: heartbeat,
+		timeout:   timeout,
+	}
+}
+
+func (s *eventStreamer) Stream(ctx context.Context, w io.Writer, req *EventsRequest, lastID int64) error {
+	buffered := bufio.NewWriter(w)
+	flush := func() error {
+		if err := buffered.Flush(); err != nil {
+			return err
+		}
+		// the writer of a streamed response buffers too, and a gone client fails its flush
+		if f, ok := w.(interface{ Flush() error }); ok {
+			return f.Flush()
+		}
+		return nil
+	}
+
+	if _, err := fmt.Fprintf(buffered, "retry: %d\n\n", eventsRetry); err != nil {
+		return err
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	heartbeat := time.NewTicker(s.heartbeat)
+	defer heartbeat.Stop()
+	timeout := time.NewTimer(s.timeout)
+	defer timeout.Stop()
+
+	for {
+		// waiting on the channel taken before the read, so an event published meanwhile is not missed
+		published := s.events.Published()
+
+		for {
+			events, err := s.events.ReadAfter(ctx, lastID)
+			if err != nil {
+				return err
+			}
+			for _, event := range events {
+				lastID = event.ID
+				if !matchEvent(req, event) {
+					continue
+				}
+				if err := writeEvent(buffered, event); err != nil {
+					return err
+				}
+			}
+			if len(events) < usecases.UserEventsBatch {
+				break
+			}
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+
+		select {
+		case <-published:
+		case <-heartbeat.C:
+			// the events of other instances sharing the buffer are read on every heartbeat
+			if _, err := buffered.WriteString(": heartbeat\n\n"); err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return nil
+		case <-s.events.Closed():
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *eventStreamer) LastID(ctx context.Context) (int64, error) {
+	return s.events.LastID(ctx)
+}
+
+// matchEvent tells if the event passes the filters of the stream
+func matchEvent(req *EventsRequest, event *internal.UserEvent) bool {
+	if req.UID != "" && req.UID != event.UID {
+		return false
+	}
+	if len(req.Types) == 0 {
+		return true
+	}
+	for _, t := range req.Types {
+		if t == event.Type {
+			return true
+		}
+	}
+	return false
+}
+
+// writeEvent writes the event as a server-sent event, its id is the one a client resumes from
+func writeEvent(w *bufio.Writer, event *internal.UserEvent) error {
+	data, err := json.Marshal(&Event{
+		ID:         event.ID,
+		Type:       event.Type,
+		UID:        event.UID,
+		OccurredAt: event.OccurredAt.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
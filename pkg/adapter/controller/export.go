@@ -0,0 +1,202 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// ExportCSV is a csv export, its header line names the selected columns
+	ExportCSV = "csv"
+	// ExportNDJSON is a newline delimited json export, one object per user
+	ExportNDJSON = "ndjson"
+
+	// exportFlushRows is the number of users written between flushes, so the export
+	// reaches the client while it is read and a gone client stops it early
+	exportFlushRows = 500
+)
+
+// ExportColumns are the columns an export can select, in the order of a default export
+var ExportColumns = []string{"uid", "email", "phone", "created_at", "email_verified", "phone_verified"}
+
+// Exporter is an interface that extends the user exports, it is shared by the export endpoint
+// and the export command. Users are written as they are read from the database, so an export
+// of millions of users does not sit in memory
+type Exporter interface {
+	Export(ctx context.Context, w io.Writer, req *ExportRequest) error
+}
+
+var _ Exporter = (*exporter)(nil)
+
+type exporter struct {
+	exports  usecases.Exports
+	validate validator.Validate
+}
+
+// NewExporter is a constructor for the user exports
+func NewExporter(ex usecases.Exports, v validator.Validate) Exporter {
+	return &exporter{
+		exports:  ex,
+		validate: v,
+	}
+}
+
+func (e *exporter) Export(ctx context.Context, w io.Writer, req *ExportRequest) error {
+	if err := e.validate.Struct(req); err != nil {
+		return err
+	}
+
+	columns := req.Columns
+	if len(columns) == 0 {
+		columns = ExportColumns
+	}
+
+	buffered := bufio.NewWriter(w)
+	rows, err := newRowWriter(buffered, req.Format, columns)
+	if err != nil {
+		return err
+	}
+	flush := func() error {
+		if err := rows.flush(); err != nil {
+			return err
+		}
+		if err := buffered.Flush(); err != nil {
+			return err
+		}
+		// the writer of a streamed response buffers too
+		if f, ok := w.(interface{ Flush() error }); ok {
+			return f.Flush()
+		}
+		return nil
+	}
+
+	written := 0
+	err = e.exports.ExportUsers(ctx, req, func(u *internal.User) error {
+		if err := rows.write(u); err != nil {
+			return err
+		}
+		written++
+		if written%exportFlushRows == 0 {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return flush()
+}
+
+// exportFormat is the format of the export, csv unless said otherwise
+func exportFormat(format string) string {
+	if format == "" {
+		return ExportCSV
+	}
+	return strings.ToLower(format)
+}
+
+// exportValue is the value of the column of a user, as it is shown by the api
+func exportValue(u *internal.User, column string) interface{} {
+	switch column {
+	case "uid":
+		return u.ID
+	case "email":
+		return u.Email
+	case "phone":
+		return u.Phone
+	case "created_at":
+		if u.CreatedAt == nil {
+			return ""
+		}
+		return u.CreatedAt.UTC().Format(time.RFC3339Nano)
+	case "email_verified":
+		return u.EmailVerified
+	case "phone_verified":
+		return u.PhoneVerified
+	default:
+		return nil
+	}
+}
+
+// rowWriter writes the users of an export in its format
+type rowWriter interface {
+	write(u *internal.User) error
+	flush() error
+}
+
+// newRowWriter is a constructor for the row writer of the format, csv writes its header right away
+func newRowWriter(w io.Writer, format string, columns []string) (rowWriter, error) {
+	switch exportFormat(format) {
+	case ExportCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return nil, err
+		}
+		return &csvWriter{writer: writer, columns: columns}, nil
+	case ExportNDJSON:
+		return &ndjsonWriter{w: w, columns: columns}, nil
+	default:
+		return nil, fmt.Errorf("unsupported export format: %s", format)
+	}
+}
+
+type csvWriter struct {
+	writer  *csv.Writer
+	columns []string
+}
+
+func (c *csvWriter) write(u *internal.User) error {
+	record := make([]string, len(c.columns))
+	for n, column := range c.columns {
+		record[n] = fmt.Sprint(exportValue(u, column))
+	}
+	return c.writer.Write(record)
+}
+
+func (c *csvWriter) flush() error {
+	c.writer.Flush()
+	return c.writer.Error()
+}
+
+type ndjsonWriter struct {
+	w       io.Writer
+	columns []string
+}
+
+// write keeps the keys of every object in the order of the columns
+func (n *ndjsonWriter) write(u *internal.User) error {
+	line := make([]byte, 0, 256)
+	line = append(line, '{')
+	for i, column := range n.columns {
+		if i > 0 {
+			line = append(line, ',')
+		}
+		key, _ := json.Marshal(column)
+		value, err := json.Marshal(exportValue(u, column))
+		if err != nil {
+			return err
+		}
+		line = append(line, key...)
+		line = append(line, ':')
+		line = append(line, value...)
+	}
+	line = append(line, '}', '\n')
+
+	_, err := n.w.Write(line)
+	return err
+}
+
+func (n *ndjsonWriter) flush() error {
+	return nil
+}
@@ -0,0 +1,314 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+)
+
+const (
+	// ImportCSV is a csv import, its header line names the email, phone and password columns
+	ImportCSV = "csv"
+	// ImportNDJSON is a newline delimited json import, one PostRequest object per line
+	ImportNDJSON = "ndjson"
+
+	// maxImportLine is the longest line of an ndjson import
+	maxImportLine = 64 * 1024
+
+	// keys of the messages of the rows in the catalog
+	invalidCSVRow  = "import.invalid_csv_row"
+	invalidJSONRow = "import.invalid_json"
+	rowFailed      = "import.row_failed"
+)
+
+// Importer is an interface that extends the bulk user imports, it is shared by the import endpoint
+// and the import command. Rows are read as a stream, checked with the rules of PostRequest
+// and created in batches, each batch in its own transaction. An import that stops after its
+// header was read returns the report of the batches it created along with the error
+type Importer interface {
+	Import(ctx context.Context, r io.Reader, format string, dryRun bool) (*ImportReport, error)
+}
+
+var _ Importer = (*importer)(nil)
+
+type importer struct {
+	imports   usecases.Imports
+	validate  validator.Validate
+	batchSize int
+}
+
+// NewImporter is a constructor for the bulk user imports, batchSize is the number of rows per transaction
+func NewImporter(im usecases.Imports, v validator.Validate, batchSize int) Importer {
+	return &importer{
+		imports:   im,
+		validate:  v,
+		batchSize: batchSize,
+	}
+}
+
+func (i *importer) Import(ctx context.Context, r io.Reader, format string, dryRun bool) (*ImportReport, error) {
+	rows, err := newRowReader(r, format)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ImportReport{DryRun: dryRun, Rows: make([]ImportResult, 0)}
+	batch := make([]*internal.ImportRow, 0, i.batchSize)
+	// a dry run rolls every batch back, so the users of the batches before are not
+	// there to conflict with, their emails are remembered instead
+	seen := make(map[string]bool)
+	flush := func() error {
+		// a failed batch is already reported row by row, the next one may still work,
+		// unless the import was cancelled and nothing of the batch was created
+		if err := i.imports.ImportUsers(ctx, batch, dryRun); err != nil && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		for _, row := range batch {
+			if dryRun && row.Status == internal.ImportCreated {
+				email := strings.ToLower(row.User.Email)
+				if seen[email] {
+					row.Status = internal.ImportSkipped
+					row.Err = internal.NewError(internal.ErrConflict, "user already exists", nil)
+				}
+				seen[email] = true
+			}
+			report.add(row)
+		}
+		batch = batch[:0]
+		return nil
+	}
+
+	for {
+		line, req, err := rows.next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && line == 0 {
+			report.Interrupted = true
+			return report, fmt.Errorf("failed to read import: %w", err)
+		}
+
+		row := &internal.ImportRow{Line: line, Err: err}
+		if err == nil {
+			if err := i.validate.Struct(req); err != nil {
+				row.Err = err
+			}
+			row.User = &internal.User{Email: req.Email, Phone: req.Phone, Password: req.Password}
+		}
+		batch = append(batch, row)
+
+		stop := ctx.Err()
+		if len(batch) >= i.batchSize {
+			stop = flush()
+		}
+		if stop != nil {
+			report.Interrupted = true
+			return report, stop
+		}
+	}
+	if len(batch) > 0 {
+		if err := flush(); err != nil {
+			report.Interrupted = true
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// add counts the row and keeps its result
+func (r *ImportReport) add(row *internal.ImportRow) {
+	result := ImportResult{Line: row.Line, Status: row.Status, err: row.Err}
+	if row.User != nil {
+		result.Email = row.User.Email
+	}
+
+	switch row.Status {
+	case internal.ImportCreated:
+		r.Created++
+	case internal.ImportSkipped:
+		r.Skipped++
+		result.Error = rowError(row.Err, nil)
+	default:
+		result.Status = internal.ImportFailed
+		r.Failed++
+		result.Error = rowError(row.Err, nil)
+	}
+	r.Rows = append(r.Rows, result)
+}
+
+// Localize renders the errors of the rows in the language of the translator, the report of the
+// importer is in english. Without a translator it stays as it is
+func (r *ImportReport) Localize(trans ut.Translator) *ImportReport {
+	if trans == nil {
+		return r
+	}
+
+	localized := *r
+	localized.Rows = make([]ImportResult, 0, len(r.Rows))
+	for _, result := range r.Rows {
+		result.Error = rowError(result.err, trans)
+		localized.Rows = append(localized.Rows, result)
+	}
+	return &localized
+}
+
+// rowError is the client safe message of the error of a row in the language of the translator,
+// in english without one
+func rowError(err error, trans ut.Translator) string {
+	if err == nil {
+		return ""
+	}
+
+	locale := i18n.English
+	if trans != nil {
+		locale = trans.Locale()
+	}
+
+	var ves validator.ValidationErrors
+	if errors.As(err, &ves) {
+		p := problem.Validation(err).Localize(trans)
+		messages := make([]string, 0, len(p.Errors))
+		for _, fe := range p.Errors {
+			messages = append(messages, fe.Message)
+		}
+		return strings.Join(messages, ", ")
+	}
+
+	var re *rowParseError
+	if errors.As(err, &re) {
+		return i18n.Message(locale, re.key, re.params...)
+	}
+
+	var de *internal.Error
+	if errors.As(err, &de) {
+		return problem.From(de).Localize(trans).Detail
+	}
+
+	return i18n.Message(locale, rowFailed)
+}
+
+// rowParseError is a row that can not be decoded, the import goes on with the next one.
+// Its key and params are the message in the catalog
+type rowParseError struct {
+	key    string
+	params []string
+}
+
+func (e *rowParseError) Error() string {
+	return i18n.Message(i18n.English, e.key, e.params...)
+}
+
+// rowReader streams the rows of an import, the line is 0 when the whole body can not be read
+type rowReader interface {
+	next() (int, *PostRequest, error)
+}
+
+// newRowReader is a constructor for the row reader of the format
+func newRowReader(r io.Reader, format string) (rowReader, error) {
+	switch strings.ToLower(format) {
+	case ImportCSV:
+		return newCSVRows(r)
+	case ImportNDJSON:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 4096), maxImportLine)
+		return &ndjsonRows{scanner: scanner}, nil
+	default:
+		return nil, fmt.Errorf("unsupported import format: %s", format)
+	}
+}
+
+type csvRows struct {
+	reader  *csv.Reader
+	columns map[string]int
+}
+
+func newCSVRows(r io.Reader) (rowReader, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read csv header: %w", err)
+	}
+	columns := make(map[string]int, len(header))
+	for n, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(name))] = n
+	}
+	if _, ok := columns["email"]; !ok {
+		return nil, fmt.Errorf("csv header has no email column")
+	}
+	if _, ok := columns["password"]; !ok {
+		return nil, fmt.Errorf("csv header has no password column")
+	}
+
+	return &csvRows{reader: reader, columns: columns}, nil
+}
+
+func (c *csvRows) next() (int, *PostRequest, error) {
+	record, err := c.reader.Read()
+	if errors.Is(err, io.EOF) {
+		return 0, nil, io.EOF
+	}
+
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return pe.StartLine, nil, &rowParseError{key: invalidCSVRow, params: []string{pe.Err.Error()}}
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	line, _ := c.reader.FieldPos(0)
+	return line, &PostRequest{
+		Email:    c.field(record, "email"),
+		Phone:    c.field(record, "phone"),
+		Password: c.field(record, "password"),
+	}, nil
+}
+
+// field is the value of the column, missing columns are empty
+func (c *csvRows) field(record []string, name string) string {
+	n, ok := c.columns[name]
+	if !ok || n >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[n])
+}
+
+type ndjsonRows struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func (n *ndjsonRows) next() (int, *PostRequest, error) {
+	for n.scanner.Scan() {
+		n.line++
+		text := strings.TrimSpace(n.scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		req := &PostRequest{}
+		if err := json.Unmarshal([]byte(text), req); err != nil {
+			return n.line, nil, &rowParseError{key: invalidJSONRow}
+		}
+		return n.line, req, nil
+	}
+	if err := n.scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+	return 0, nil, io.EOF
+}
@@ -1,11 +1,34 @@
 package controller
 
+import "time"
+
 // User is a struct model for users interaction in controller layer
 type User struct {
-	ID       string `json:"uid"`
-	Email    string `json:"email"`
-	Phone    string `json:"phone"`
-	Password string `json:"password"`
+	ID        string     `json:"uid"`
+	Email     string     `json:"email"`
+	Phone     string     `json:"phone"`
+	Password  string     `json:"password"`
+	CreatedAt *time.Time `json:"created_at,omitempty"`
+	// EmailVerified and PhoneVerified are filled when users are read, listed or searched
+	EmailVerified bool `json:"email_verified"`
+	PhoneVerified bool `json:"phone_verified"`
+	// Version is sent in the ETag header, not in the body
+	Version int `json:"-"`
+}
+
+// UserPatch is a struct model for partial users updates in controller layer
+type UserPatch struct {
+	ID      string
+	Email   *string
+	Phone   *string
+	Version int
+}
+
+// PasswordChange is a struct model for password changes in controller layer
+type PasswordChange struct {
+	ID              string
+	CurrentPassword string
+	NewPassword     string
 }
 
 // Users is a struct model for a slice of users interaction in controller layer
@@ -17,21 +40,166 @@ type AuthRequest struct {
 	Password string `json:"password" validate:"required"`
 }
 
+// MFARequest is a struct model for the second step of a login with two-factor auth in controller layer,
+// the code is a totp code or a recovery code
+type MFARequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	Code     string `json:"code" validate:"required,max=16"`
+}
+
+// UnlockRequest is a struct model for admin unlock requests in controller layer,
+// the user is an email or a phone as it is typed at login
+type UnlockRequest struct {
+	User string `json:"user" validate:"required_without=IP,max=128"`
+	IP   string `json:"ip" validate:"omitempty,ip"`
+}
+
+// ImportReport is a struct model for the result of a bulk user import in controller layer,
+// a dry run reports what a real run would do without creating anyone. An interrupted import
+// reports the rows of the batches it created before it stopped
+type ImportReport struct {
+	DryRun      bool           `json:"dry_run"`
+	Interrupted bool           `json:"interrupted,omitempty"`
+	Created     int            `json:"created"`
+	Skipped     int            `json:"skipped"`
+	Failed      int            `json:"failed"`
+	Rows        []ImportResult `json:"rows"`
+}
+
+// ImportResult is a struct model for the result of a row of a bulk user import in controller layer
+type ImportResult struct {
+	Line   int    `json:"line"`
+	Email  string `json:"email,omitempty"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+
+	// err is the error of the row, kept to render it in the language of the request
+	err error
+}
+
+// ExportRequest is a struct model for user exports in controller layer, it filters like a
+// listing but has no pages, an empty Columns exports every column
+type ExportRequest struct {
+	Format      string   `query:"format" validate:"omitempty,oneof=csv ndjson"`
+	Columns     []string `query:"columns" validate:"omitempty,unique,dive,oneof=uid email phone created_at email_verified phone_verified"`
+	Email       string   `query:"email" validate:"omitempty,max=128"`
+	Phone       string   `query:"phone" validate:"omitempty,max=16"`
+	CreatedFrom string   `query:"created_from" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	CreatedTo   string   `query:"created_to" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+// EventsRequest is a struct model for user event streams in controller layer, an empty Types
+// streams every type and an empty UID the events of every user
+type EventsRequest struct {
+	Types []string `query:"types" validate:"omitempty,unique,dive,oneof=user.created user.updated user.deleted"`
+	UID   string   `query:"uid" validate:"omitempty,max=64"`
+}
+
+// Event is a struct model for a user event sent to the streams in controller layer,
+// it carries the uid only so the clients read the user when they need it
+type Event struct {
+	ID         int64     `json:"id"`
+	Type       string    `json:"type"`
+	UID        string    `json:"uid"`
+	OccurredAt time.Time `json:"occurred_at"`
+}
+
 // PostRequest is a struct model for post requests in controller layer
 type PostRequest struct {
 	Email    string `json:"email" validate:"required,email"`
-	Phone    string `json:"phone" validate:"omitempty"`
+	Phone    string `json:"phone" validate:"omitempty,e164"`
 	Password string `json:"password" validate:"required"`
 }
 
 // PutRequest is a struct model for put requests in controller layer
 type PutRequest struct {
-	Email    string `json:"email" validate:"omitempty"`
-	Phone    string `json:"phone" validate:"omitempty"`
-	Password string `json:"password" validate:"required"`
+	Email string `json:"email" validate:"omitempty"`
+	Phone string `json:"phone" validate:"omitempty,e164"`
+}
+
+// ChangePasswordRequest is a struct model for change password requests in controller layer
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=8,max=64,nefield=CurrentPassword"`
+}
+
+// PatchRequest is a struct model for patch requests in controller layer, nil fields are left unchanged.
+// A null phone removes it, while the email can be changed but never removed
+type PatchRequest struct {
+	Email *string `json:"email,omitempty" validate:"omitempty,min=1,email,max=128"`
+	Phone *string `json:"phone,omitempty" validate:"omitempty,e164|len=0"`
+}
+
+// ForgotPasswordRequest is a struct model for forgot password requests in controller layer
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email,max=128"`
+}
+
+// ResetPasswordRequest is a struct model for reset password requests in controller layer
+type ResetPasswordRequest struct {
+	Token       string `json:"token" validate:"required"`
+	NewPassword string `json:"new_password" validate:"required,min=8,max=64"`
+}
+
+// ResendVerificationRequest is a struct model for resend email verification requests in controller layer
+type ResendVerificationRequest struct {
+	Email string `json:"email" validate:"required,email,max=128"`
+}
+
+// ConfirmPhoneRequest is a struct model for phone verification code requests in controller layer
+type ConfirmPhoneRequest struct {
+	Code string `json:"code" validate:"required,len=6,numeric"`
+}
+
+// TOTPCodeRequest is a struct model for totp enrolment and removal requests in controller layer,
+// the code is a totp code, removals take a recovery code too
+type TOTPCodeRequest struct {
+	Code string `json:"code" validate:"required,max=16"`
+}
+
+// TOTPEnrollment is a struct model for a new totp secret in controller layer,
+// uri is the otpauth uri to show as a qr code
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
 }
 
 // DeleteRequest is a struct model for delete requests in controller layer
 type DeleteRequest struct {
 	Password string `json:"password" validate:"required"`
 }
+
+// ListRequest is a struct model for list query parameters in controller layer
+type ListRequest struct {
+	Limit       int    `query:"limit" validate:"omitempty,min=1,max=100"`
+	Offset      int    `query:"offset" validate:"omitempty,min=0,excluded_with=Cursor"`
+	Cursor      string `query:"cursor" validate:"omitempty,base64rawurl"`
+	Sort        string `query:"sort" validate:"omitempty,oneof=created_at -created_at email -email phone -phone"`
+	Email       string `query:"email" validate:"omitempty,max=128"`
+	Phone       string `query:"phone" validate:"omitempty,max=16"`
+	CreatedFrom string `query:"created_from" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+	CreatedTo   string `query:"created_to" validate:"omitempty,datetime=2006-01-02T15:04:05Z07:00"`
+}
+
+// SearchRequest is a struct model for search query parameters in controller layer,
+// the email and phone fragments are matched by prefix unless match is contains
+type SearchRequest struct {
+	ListRequest   `mapstructure:",squash"`
+	Match         string `query:"match" validate:"omitempty,oneof=prefix contains"`
+	EmailVerified *bool  `query:"email_verified"`
+	PhoneVerified *bool  `query:"phone_verified"`
+}
+
+// Meta is a struct model for pagination metadata in controller layer
+type Meta struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset,omitempty"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// UsersPage is a struct model for a page of users in controller layer
+type UsersPage struct {
+	Data Users `json:"data"`
+	Meta Meta  `json:"meta"`
+}
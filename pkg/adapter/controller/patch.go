@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"encoding/json"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// MergePatchType is the media type of a RFC 7396 merge patch
+	MergePatchType = "application/merge-patch+json"
+	// JSONPatchType is the media type of a RFC 6902 json patch
+	JSONPatchType = "application/json-patch+json"
+
+	opAdd     = "add"
+	opReplace = "replace"
+	opRemove  = "remove"
+)
+
+// patchOperation is a single RFC 6902 operation
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// parsePatch reads the body as a merge patch or a json patch, according to its content type,
+// plain json is taken as a merge patch
+func parsePatch(contentType string, body []byte) (*PatchRequest, error) {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, problem.New(fiber.StatusUnsupportedMediaType, "", "patch.invalid_content_type")
+	}
+
+	switch mediaType {
+	case MergePatchType, fiber.MIMEApplicationJSON:
+		return parseMergePatch(body)
+	case JSONPatchType:
+		return parseJSONPatch(body)
+	default:
+		return nil, problem.New(fiber.StatusUnsupportedMediaType, "", "patch.unsupported_type").
+			With(MergePatchType, JSONPatchType)
+	}
+}
+
+// parseMergePatch follows RFC 7396, members set to null are removed, absent members are left unchanged
+func parseMergePatch(body []byte) (*PatchRequest, error) {
+	members := map[string]json.RawMessage{}
+	if err := json.Unmarshal(body, &members); err != nil {
+		return nil, problem.BadRequest("patch.merge_not_object")
+	}
+
+	req := &PatchRequest{}
+	for member, value := range members {
+		if err := req.set(member, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+// parseJSONPatch follows RFC 6902 for the add, replace and remove operations on the user members
+func parseJSONPatch(body []byte) (*PatchRequest, error) {
+	ops := make([]patchOperation, 0)
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, problem.BadRequest("patch.json_not_array")
+	}
+
+	req := &PatchRequest{}
+	for _, op := range ops {
+		if !strings.HasPrefix(op.Path, "/") {
+			return nil, problem.BadRequest("patch.invalid_path").With(strconv.Quote(op.Path))
+		}
+		member := strings.TrimPrefix(op.Path, "/")
+
+		var err error
+		switch op.Op {
+		case opAdd, opReplace:
+			if len(op.Value) == 0 {
+				return nil, problem.BadRequest("patch.missing_value").With(op.Op)
+			}
+			err = req.set(member, op.Value)
+		case opRemove:
+			err = req.set(member, nil)
+		default:
+			err = problem.New(fiber.StatusUnprocessableEntity, "", "patch.unsupported_operation").With(strconv.Quote(op.Op))
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+// set changes a member of the request, a nil or null value removes it
+func (req *PatchRequest) set(member string, value json.RawMessage) error {
+	s := ""
+	if len(value) > 0 && string(value) != "null" {
+		if err := json.Unmarshal(value, &s); err != nil {
+			return problem.BadRequest("patch.not_string").With(member)
+		}
+	}
+
+	switch member {
+	case "email":
+		req.Email = &s
+	case "phone":
+		req.Phone = &s
+	default:
+		return problem.New(fiber.StatusUnprocessableEntity, "", "patch.not_patchable").With(member)
+	}
+
+	return nil
+}
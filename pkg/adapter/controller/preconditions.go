@@ -0,0 +1,45 @@
+package controller
+
+import (
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// anyETag is the If-Match value that matches every version of a user
+const anyETag = "*"
+
+// userETag is the strong ETag of a version of a user, it is the same for every format of the user
+func userETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// ifMatch is the version of the user named by the If-Match header of the request, 0 matches any
+// version and is returned for * or when there is no header, the routes that require it check it
+// before the handler. Weak ETags never match a write, so they fail like a stale one
+func ifMatch(ctx *fiber.Ctx) (int, error) {
+	header := strings.TrimSpace(ctx.Get(fiber.HeaderIfMatch))
+	if header == "" || header == anyETag {
+		return 0, nil
+	}
+	if strings.Contains(header, ",") {
+		return 0, problem.BadRequest("request.if_match_single")
+	}
+
+	stale := problem.New(fiber.StatusPreconditionFailed, "", "request.stale_etag")
+	if strings.HasPrefix(header, "W/") {
+		return 0, stale
+	}
+	value, err := strconv.Unquote(header)
+	if err != nil {
+		return 0, stale
+	}
+	version, err := strconv.Atoi(value)
+	if err != nil || version <= 0 {
+		return 0, stale
+	}
+
+	return version, nil
+}
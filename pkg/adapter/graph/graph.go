@@ -0,0 +1,220 @@
+// Package graph serves the users over graphql, resolving every field through the same
+// usecases as the rest api. Operations are measured before they run and the ones deeper
+// or costlier than the configured limits are refused
+package graph
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql"
+	"github.com/graphql-go/graphql/gqlerrors"
+	"github.com/graphql-go/graphql/language/ast"
+	"github.com/graphql-go/graphql/language/parser"
+	"github.com/graphql-go/graphql/language/source"
+)
+
+const (
+	processed    = "request processed"
+	requestError = "request error"
+
+	// keys of the messages of the requests that can not run
+	invalidRequest   = "graphql.invalid_request"
+	invalidVariables = "graphql.invalid_variables"
+	missingQuery     = "graphql.missing_query"
+	unknownOperation = "graphql.unknown_operation"
+	getMutation      = "graphql.get_mutation"
+)
+
+// Request is the body of a graphql request, get requests send it as query parameters
+// with the variables as a json object
+type Request struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// GraphQL is an interface that extends the graphql endpoint
+type GraphQL interface {
+	Handle(ctx *fiber.Ctx) error
+}
+
+var _ GraphQL = (*graphQL)(nil)
+
+type graphQL struct {
+	schema graphql.Schema
+	limits limits
+	jwt    utils.JWT
+	logger utils.Logger
+}
+
+// NewGraphQL is a constructor for the graphql endpoint, maxDepth and maxComplexity bound
+// every operation before it runs
+func NewGraphQL(
+	uc usecases.UseCases,
+	ev usecases.EmailVerifications,
+	v validator.Validate,
+	j utils.JWT,
+	l utils.Logger,
+	maxDepth int,
+	maxComplexity int,
+) (GraphQL, error) {
+	r := &resolver{
+		usecases:      uc,
+		verifications: ev,
+		validate:      v,
+		logger:        l,
+	}
+	schema, err := newSchema(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build graphql schema: %w", err)
+	}
+
+	return &graphQL{
+		schema: schema,
+		limits: limits{maxDepth: maxDepth, maxComplexity: maxComplexity},
+		jwt:    j,
+		logger: l,
+	}, nil
+}
+
+// Handle runs a graphql operation. Requests that can not run at all, because they do not parse,
+// do not validate or go over the limits, are answered with a 400 and only errors, while the
+// errors of the resolvers come along with the data of the fields that did resolve
+func (g *graphQL) Handle(ctx *fiber.Ctx) error {
+	req, err := g.request(ctx)
+	if err != nil {
+		g.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return refuse(ctx, err)
+	}
+
+	doc, err := parser.Parse(parser.ParseParams{Source: source.NewSource(&source.Source{
+		Body: []byte(req.Query),
+		Name: "GraphQL request",
+	})})
+	if err != nil {
+		g.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return respond(ctx, fiber.StatusBadRequest, gqlerrors.FormatErrors(err))
+	}
+	validation := graphql.ValidateDocument(&g.schema, doc, nil)
+	if !validation.IsValid {
+		g.logger.Error("%s path[%s] -> %s: %v", ctx.Method(), ctx.Path(), requestError, validation.Errors)
+		return respond(ctx, fiber.StatusBadRequest, validation.Errors)
+	}
+
+	operation := findOperation(doc, req.OperationName)
+	if operation == nil {
+		p := problem.BadRequest(unknownOperation).With(strconv.Quote(req.OperationName))
+		g.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, p)
+		return refuse(ctx, p)
+	}
+	if ctx.Method() == fiber.MethodGet && operation.Operation == ast.OperationTypeMutation {
+		g.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, getMutation)
+		ctx.Set(fiber.HeaderAllow, fiber.MethodPost)
+		return refuse(ctx, problem.New(fiber.StatusMethodNotAllowed, "", getMutation))
+	}
+	if err := g.limits.check(doc, operation, req.Variables); err != nil {
+		g.logger.Error("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), requestError, err)
+		return refuse(ctx, err)
+	}
+
+	// the session was checked by the jwt middleware, me is the user of the cookie
+	userCtx := ctx.UserContext()
+	if token, err := g.jwt.ParseUserJWT(ctx.Cookies(controller.SessionCookie)); err == nil {
+		userCtx = context.WithValue(userCtx, sessionKey{}, token.UID)
+	}
+
+	result := graphql.Execute(graphql.ExecuteParams{
+		Schema:        g.schema,
+		AST:           doc,
+		OperationName: req.OperationName,
+		Args:          req.Variables,
+		Context:       userCtx,
+	})
+	localize(ctx, result.Errors)
+
+	g.logger.Info("%s path[%s] -> %s: %s", ctx.Method(), ctx.Path(), processed, ctx.BaseURL())
+	return ctx.Status(fiber.StatusOK).JSON(result)
+}
+
+// request reads the graphql request from the query of a get or the json body of a post
+func (g *graphQL) request(ctx *fiber.Ctx) (*Request, error) {
+	req := &Request{}
+	if ctx.Method() == fiber.MethodGet {
+		req.Query = ctx.Query("query")
+		req.OperationName = ctx.Query("operationName")
+		if variables := ctx.Query("variables"); variables != "" {
+			if err := json.Unmarshal([]byte(variables), &req.Variables); err != nil {
+				return nil, problem.BadRequest(invalidVariables).Wrap(err)
+			}
+		}
+	} else if err := json.Unmarshal(ctx.Body(), req); err != nil {
+		return nil, problem.BadRequest(invalidRequest).Wrap(err)
+	}
+
+	if req.Query == "" {
+		return nil, problem.BadRequest(missingQuery)
+	}
+	return req, nil
+}
+
+// findOperation is the operation of the document to run, the one named or else the only one
+func findOperation(doc *ast.Document, name string) *ast.OperationDefinition {
+	var found *ast.OperationDefinition
+	for _, definition := range doc.Definitions {
+		operation, ok := definition.(*ast.OperationDefinition)
+		if !ok {
+			continue
+		}
+		if name == "" {
+			if found != nil {
+				return nil
+			}
+			found = operation
+			continue
+		}
+		if operation.Name != nil && operation.Name.Value == name {
+			return operation
+		}
+	}
+	return found
+}
+
+func respond(ctx *fiber.Ctx, status int, errs []gqlerrors.FormattedError) error {
+	return ctx.Status(status).JSON(&graphql.Result{Errors: errs})
+}
+
+// refuse answers a request that can not run with its problem, in the language of the request
+func refuse(ctx *fiber.Ctx, err error) error {
+	p := problem.From(err).Localize(i18n.TranslatorOf(ctx))
+	return respond(ctx, p.Status, gqlerrors.FormatErrors(errors.New(p.Detail)))
+}
+
+// localize renders the errors of the fields in the language of the request, like the rest api
+// renders its problems
+func localize(ctx *fiber.Ctx, errs []gqlerrors.FormattedError) {
+	trans := i18n.TranslatorOf(ctx)
+	for n, formatted := range errs {
+		located, ok := formatted.OriginalError().(*gqlerrors.Error)
+		if !ok {
+			continue
+		}
+		var fe *fieldError
+		if !errors.As(located.OriginalError, &fe) {
+			continue
+		}
+		localized := &fieldError{problem: fe.problem.Localize(trans)}
+		errs[n].Message = localized.Error()
+		errs[n].Extensions = localized.Extensions()
+	}
+}
@@ -0,0 +1,444 @@
+// Package graph_test is a test for the graphql endpoint
+package graph_test
+
+import (
+	"bytes"
+	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/adapter/graph"
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreate = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
+	spRead   = "CALL `go_cleanapi`.`sp_read_user`(?);"
+	spUpdate = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
+	spDelete = "CALL `go_cleanapi`.`sp_delete_user`(?, ?, ?);"
+	qCount   = "SELECT COUNT(*) FROM `go_cleanapi`.`users`"
+	qList    = "SELECT `id_user`, `user_email`, `user_phone`, `created_at`"
+)
+
+// response is the body of a graphql response
+type response struct {
+	Data   map[string]interface{} `json:"data"`
+	Errors []struct {
+		Message    string                 `json:"message"`
+		Extensions map[string]interface{} `json:"extensions"`
+	} `json:"errors"`
+}
+
+func newApp(t *testing.T, db *sql.DB, maxDepth int, maxComplexity int) *fiber.App {
+	t.Helper()
+
+	uc := usecases.NewUseCases(repository.NewRepository(db), utils.NewUUIDMock(), false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+	verifications := usecases.NewEmailVerifications(repository.NewEmailVerifications(db), utils.NewFileMailer(t.TempDir(), "no-reply@test.com"),
+		utils.NewJWTMock(), time.Hour, time.Minute, "http://localhost/verify")
+	gql, err := graph.NewGraphQL(uc, verifications, *validator.New(), utils.NewJWTMock(), utils.NewLoggerMock(), maxDepth, maxComplexity)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when building the schema", err)
+	}
+
+	app := fiber.New()
+	app.Get("/graphql", gql.Handle)
+	app.Post("/graphql", gql.Handle)
+	return app
+}
+
+func send(t *testing.T, app *fiber.App, req *http.Request) (int, *response) {
+	t.Helper()
+
+	res, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when sending the request", err)
+	}
+	body := &response{}
+	if err := json.NewDecoder(res.Body).Decode(body); err != nil {
+		t.Fatalf("an error '%s' was not expected when decoding the response", err)
+	}
+	return res.StatusCode, body
+}
+
+func post(t *testing.T, app *fiber.App, query string, variables map[string]interface{}, session string) (int, *response) {
+	t.Helper()
+
+	body, err := json.Marshal(map[string]interface{}{"query": query, "variables": variables})
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when encoding the request", err)
+	}
+	req := httptest.NewRequest(fiber.MethodPost, "/graphql", bytes.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	if session != "" {
+		req.Header.Set(fiber.HeaderCookie, controller.SessionCookie+"="+session)
+	}
+	return send(t, app, req)
+}
+
+func userRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id_user", "user_email", "user_phone", "email_verified", "phone_verified", "version"}).
+		AddRow("im_an_id", "test@test.com", "+991234567890", true, false, 3)
+}
+
+func TestHandle(test *testing.T) {
+	createdAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	successfulCases := []struct {
+		name      string
+		query     string
+		variables map[string]interface{}
+		session   string
+		mock      func(m sqlmock.Sqlmock)
+		expected  string
+	}{
+		{
+			name:  "it should read a user (mocked)",
+			query: `query($uid: ID!) { user(uid: $uid) { uid email emailVerified version } }`,
+			variables: map[string]interface{}{
+				"uid": "im_an_id",
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spRead)).WithArgs("im_an_id").WillReturnRows(userRows())
+			},
+			expected: `{"user":{"uid":"im_an_id","email":"test@test.com","emailVerified":true,"version":3}}`,
+		},
+		{
+			name:  "it should read no user (mocked), unknown id",
+			query: `{ user(uid: "im_not_an_id") { uid } }`,
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spRead)).WithArgs("im_not_an_id").WillReturnError(sql.ErrNoRows)
+			},
+			expected: `{"user":null}`,
+		},
+		{
+			name:    "it should read the user of the session (mocked)",
+			query:   `{ me { uid phone } }`,
+			session: "im_an_id",
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spRead)).WithArgs("im_an_id").WillReturnRows(userRows())
+			},
+			expected: `{"me":{"uid":"im_an_id","phone":"+991234567890"}}`,
+		},
+		{
+			name:  "it should read a page of users (mocked)",
+			query: `{ users(limit: 1, email: "test") { data { uid createdAt } meta { total limit nextCursor } } }`,
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(qCount)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+				m.ExpectQuery(regexp.QuoteMeta(qList)).WithArgs("test%", 2).WillReturnRows(
+					sqlmock.NewRows([]string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}).
+						AddRow("im_an_id", "test@test.com", "+991234567890", createdAt, true, false))
+			},
+			expected: `{"users":{"data":[{"uid":"im_an_id","createdAt":"2023-05-01T10:00:00Z"}],"meta":{"total":2,"limit":1,"nextCursor":null}}}`,
+		},
+		{
+			name:  "it should sign up a user (mocked)",
+			query: `mutation { signup(email: "test@test.com", password: "12345pAsSWORd*") }`,
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).
+					WithArgs(sqlmock.AnyArg(), "test@test.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expected: `{"signup":true}`,
+		},
+		{
+			name:  "it should update a user (mocked), leaving out the fields not sent",
+			query: `mutation { updateUser(uid: "im_an_id", email: "test@test.com", version: 2) { uid version } }`,
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdate)).
+					WithArgs("im_an_id", 2, "test@test.com", nil).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectQuery(regexp.QuoteMeta(spRead)).WithArgs("im_an_id").WillReturnRows(userRows())
+			},
+			expected: `{"updateUser":{"uid":"im_an_id","version":3}}`,
+		},
+		{
+			name:  "it should delete a user (mocked)",
+			query: `mutation { deleteUser(uid: "im_an_id", password: "12345pAsSWORd*", version: 3) }`,
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spDelete)).
+					WithArgs("im_an_id", "12345pAsSWORd*", 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expected: `{"deleteUser":true}`,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.mock(m)
+
+			status, res := post(t, newApp(t, db, 6, 1000), tc.query, tc.variables, tc.session)
+			assert.Equal(t, fiber.StatusOK, status)
+			assert.Empty(t, res.Errors)
+			data, err := json.Marshal(res.Data)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(data))
+		})
+	}
+
+	failedCases := []struct {
+		name  string
+		query string
+		mock  func(m sqlmock.Sqlmock)
+		code  string
+	}{
+		{
+			name:  "it should not read the user of the session, no session",
+			query: `{ me { uid } }`,
+			mock:  func(m sqlmock.Sqlmock) {},
+			code:  "unauthorized",
+		},
+		{
+			name:  "it should not sign up a user, invalid email",
+			query: `mutation { signup(email: "not an email", password: "12345pAsSWORd*") }`,
+			mock:  func(m sqlmock.Sqlmock) {},
+			code:  "validation_failed",
+		},
+		{
+			name:  "it should not update a user, stale version (mocked)",
+			query: `mutation { updateUser(uid: "im_an_id", phone: "+991234567890", version: 1) { uid } }`,
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdate)).WillReturnError(errors.New("connection refused"))
+			},
+			code: "internal_error",
+		},
+		{
+			name:  "it should not update a user, no version",
+			query: `mutation { updateUser(uid: "im_an_id", phone: "+991234567890") { uid } }`,
+			mock:  func(m sqlmock.Sqlmock) {},
+			code:  "precondition_required",
+		},
+		{
+			name:  "it should not delete a user, no version",
+			query: `mutation { deleteUser(uid: "im_an_id", password: "12345pAsSWORd*") }`,
+			mock:  func(m sqlmock.Sqlmock) {},
+			code:  "precondition_required",
+		},
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.mock(m)
+
+			status, res := post(t, newApp(t, db, 6, 1000), tc.query, nil, "")
+			assert.Equal(t, fiber.StatusOK, status)
+			if assert.Len(t, res.Errors, 1) {
+				assert.Equal(t, tc.code, res.Errors[0].Extensions["code"])
+			}
+		})
+	}
+}
+
+func TestHandleRequests(test *testing.T) {
+	failedCases := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected int
+	}{
+		{
+			name:     "it should not run, no query",
+			method:   fiber.MethodPost,
+			body:     `{"variables":{}}`,
+			expected: fiber.StatusBadRequest,
+		},
+		{
+			name:     "it should not run, not a graphql request",
+			method:   fiber.MethodPost,
+			body:     `query { me { uid } }`,
+			expected: fiber.StatusBadRequest,
+		},
+		{
+			name:     "it should not run, syntax error",
+			method:   fiber.MethodPost,
+			body:     `{"query":"{ me { uid }"}`,
+			expected: fiber.StatusBadRequest,
+		},
+		{
+			name:     "it should not run, unknown field",
+			method:   fiber.MethodPost,
+			body:     `{"query":"{ me { password } }"}`,
+			expected: fiber.StatusBadRequest,
+		},
+		{
+			name:     "it should not run, unknown operation",
+			method:   fiber.MethodPost,
+			body:     `{"query":"query A { me { uid } } query B { me { email } }","operationName":"C"}`,
+			expected: fiber.StatusBadRequest,
+		},
+		{
+			name:     "it should not run, mutation in a get",
+			method:   fiber.MethodGet,
+			target:   "?query=" + url.QueryEscape(`mutation { deleteUser(uid: "im_an_id", password: "x") }`),
+			expected: fiber.StatusMethodNotAllowed,
+		},
+		{
+			name:     "it should not run, too deep",
+			method:   fiber.MethodGet,
+			target:   "?query=" + url.QueryEscape(`{ users { meta { total } } }`),
+			expected: fiber.StatusBadRequest,
+		},
+		{
+			name:     "it should not run, introspection too deep",
+			method:   fiber.MethodPost,
+			body:     `{"query":"{ __schema { types { fields { type ` + strings.Repeat("{ ofType ", 12) + "{ name }" + strings.Repeat(" }", 12) + ` } } } }"}`,
+			expected: fiber.StatusBadRequest,
+		},
+		{
+			name:     "it should not run, too complex for the default page size",
+			method:   fiber.MethodPost,
+			body:     `{"query":"{ users { data { uid email phone } } }"}`,
+			expected: fiber.StatusBadRequest,
+		},
+		{
+			name:     "it should not run, too complex for the page size of a variable",
+			method:   fiber.MethodPost,
+			body:     `{"query":"query($n: Int) { users(limit: $n) { data { ...user } } } fragment user on User { uid email }","variables":{"n":30}}`,
+			expected: fiber.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			app := newApp(t, db, 2, 50)
+			req := httptest.NewRequest(tc.method, "/graphql"+tc.target, bytes.NewReader([]byte(tc.body)))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			status, res := send(t, app, req)
+			assert.Equal(t, tc.expected, status)
+			assert.NotEmpty(t, res.Errors)
+			assert.Nil(t, res.Data)
+		})
+	}
+
+	test.Run("it should run the introspection query of the graphql tools with the default limits", func(t *testing.T) {
+		t.Parallel()
+
+		db, _, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		status, res := post(t, newApp(t, db, 6, 1000), testutil.IntrospectionQuery, nil, "")
+		assert.Equal(t, fiber.StatusOK, status)
+		assert.Empty(t, res.Errors)
+		assert.NotNil(t, res.Data)
+	})
+
+	test.Run("it should run a query in a get within the limits (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(spRead)).WithArgs("im_an_id").WillReturnRows(userRows())
+
+		req := httptest.NewRequest(fiber.MethodGet, "/graphql?query="+url.QueryEscape(`{ __typename me { uid } }`), nil)
+		req.Header.Set(fiber.HeaderCookie, controller.SessionCookie+"=im_an_id")
+		status, res := send(t, newApp(t, db, 2, 50), req)
+		assert.Equal(t, fiber.StatusOK, status)
+		assert.Empty(t, res.Errors)
+		assert.Equal(t, map[string]interface{}{"__typename": "Query", "me": map[string]interface{}{"uid": "im_an_id"}}, res.Data)
+	})
+}
+
+func TestHandleLanguage(test *testing.T) {
+	successfulCases := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{
+			name:     "it should refuse a request in the language of the request",
+			body:     `{"query":""}`,
+			expected: "la solicitud no tiene consulta",
+		},
+		{
+			name:     "it should refuse an operation over the limits in the language of the request",
+			body:     `{"query":"{ users { meta { total } } }"}`,
+			expected: "la operación tiene 3 campos de profundidad, el límite es 2",
+		},
+		{
+			name:     "it should answer the errors of the fields in the language of the request",
+			body:     `{"query":"{ me { uid } }"}`,
+			expected: "no hay sesión",
+		},
+	}
+
+	v := validator.New()
+	translator, err := i18n.NewTranslator(v)
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			uc := usecases.NewUseCases(repository.NewRepository(db), utils.NewUUIDMock(), false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			verifications := usecases.NewEmailVerifications(repository.NewEmailVerifications(db), utils.NewFileMailer(t.TempDir(), "no-reply@test.com"),
+				utils.NewJWTMock(), time.Hour, time.Minute, "http://localhost/verify")
+			gql, err := graph.NewGraphQL(uc, verifications, *v, utils.NewJWTMock(), utils.NewLoggerMock(), 2, 50)
+			if err != nil {
+				t.Fatalf("an error '%s' was not expected when building the schema", err)
+			}
+
+			app := fiber.New()
+			app.Post("/graphql", func(c *fiber.Ctx) error {
+				i18n.SetTranslator(c, translator.Negotiate("es"))
+				return c.Next()
+			}, gql.Handle)
+
+			req := httptest.NewRequest(fiber.MethodPost, "/graphql", strings.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+			_, res := send(t, app, req)
+			if assert.Len(t, res.Errors, 1) {
+				assert.Equal(t, tc.expected, res.Errors[0].Message)
+			}
+		})
+	}
+}
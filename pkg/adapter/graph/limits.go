@@ -0,0 +1,139 @@
+package graph
+
+import (
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"strconv"
+	"strings"
+
+	"github.com/graphql-go/graphql/language/ast"
+)
+
+// pagedFields are the fields that return a page of users, the fields under them
+// resolve once per user of the page
+var pagedFields = map[string]bool{"users": true}
+
+// pageArgument is the argument with the size of a page
+const pageArgument = "limit"
+
+// keys of the messages of the operations over the limits
+const (
+	tooDeep              = "graphql.too_deep"
+	tooComplex           = "graphql.too_complex"
+	introspectionTooDeep = "graphql.introspection_too_deep"
+)
+
+// maxIntrospectionDepth is the max depth of the introspection fields of an operation. Reading the
+// schema nests the type references deeper than the max depth of the operations, the query of
+// the graphql tools is 13 fields deep
+const maxIntrospectionDepth = 15
+
+// limits are the max depth and complexity of an operation
+type limits struct {
+	maxDepth      int
+	maxComplexity int
+}
+
+// check measures the operation before it runs. Its depth is the number of nested fields
+// and its complexity is 1 per field, with the fields under a paged field counted once per
+// user of the page. Introspection fields count in the complexity, but their depth has its own limit
+func (l limits) check(doc *ast.Document, operation *ast.OperationDefinition, variables map[string]interface{}) error {
+	m := &measure{
+		fragments: make(map[string]*ast.FragmentDefinition),
+		variables: variables,
+	}
+	for _, definition := range doc.Definitions {
+		if fragment, ok := definition.(*ast.FragmentDefinition); ok {
+			m.fragments[fragment.Name.Value] = fragment
+		}
+	}
+
+	depth, complexity := m.selections(operation.SelectionSet)
+	if m.introspectionDepth > maxIntrospectionDepth {
+		return problem.BadRequest(introspectionTooDeep).With(strconv.Itoa(m.introspectionDepth), strconv.Itoa(maxIntrospectionDepth))
+	}
+	if depth > l.maxDepth {
+		return problem.BadRequest(tooDeep).With(strconv.Itoa(depth), strconv.Itoa(l.maxDepth))
+	}
+	if complexity > l.maxComplexity {
+		return problem.BadRequest(tooComplex).With(strconv.Itoa(complexity), strconv.Itoa(l.maxComplexity))
+	}
+	return nil
+}
+
+// measure walks the selections of an operation, the fragments were checked for cycles
+// by the validation of the document
+type measure struct {
+	fragments map[string]*ast.FragmentDefinition
+	variables map[string]interface{}
+	// introspectionDepth is the depth of the deepest introspection field, from that field down
+	introspectionDepth int
+}
+
+// selections are the depth and the complexity of a selection set
+func (m *measure) selections(set *ast.SelectionSet) (int, int) {
+	if set == nil {
+		return 0, 0
+	}
+
+	depth, complexity := 0, 0
+	add := func(d int, c int) {
+		if d > depth {
+			depth = d
+		}
+		complexity += c
+	}
+	for _, selection := range set.Selections {
+		switch s := selection.(type) {
+		case *ast.Field:
+			d, c := m.selections(s.SelectionSet)
+			if strings.HasPrefix(s.Name.Value, "__") {
+				if d+1 > m.introspectionDepth {
+					m.introspectionDepth = d + 1
+				}
+				add(0, 1+c)
+				continue
+			}
+			add(d+1, 1+m.pageSize(s)*c)
+		case *ast.InlineFragment:
+			add(m.selections(s.SelectionSet))
+		case *ast.FragmentSpread:
+			if fragment, ok := m.fragments[s.Name.Value]; ok {
+				add(m.selections(fragment.SelectionSet))
+			}
+		}
+	}
+	return depth, complexity
+}
+
+// pageSize is the number of users a field returns, 1 for the fields that are not paged.
+// A page without a limit has the default size, and a limit over the max is clamped like the usecases do
+func (m *measure) pageSize(field *ast.Field) int {
+	if !pagedFields[field.Name.Value] {
+		return 1
+	}
+
+	size := 0
+	for _, argument := range field.Arguments {
+		if argument.Name.Value != pageArgument {
+			continue
+		}
+		switch value := argument.Value.(type) {
+		case *ast.IntValue:
+			size, _ = strconv.Atoi(value.Value)
+		case *ast.Variable:
+			// json numbers are decoded as floats
+			if v, ok := m.variables[value.Name.Value].(float64); ok {
+				size = int(v)
+			}
+		}
+	}
+
+	if size <= 0 {
+		return usecases.DefaultPageSize
+	}
+	if size > usecases.MaxPageSize {
+		return usecases.MaxPageSize
+	}
+	return size
+}
@@ -0,0 +1,344 @@
+package graph
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/graphql-go/graphql"
+)
+
+const (
+	internalError = "internal error"
+	// noSession is the key of the message of me without a session
+	noSession = "graphql.no_session"
+	// noVersion is the key of the message of the writes without the version of the user
+	noVersion = "request.version_required"
+)
+
+// sessionKey is the context key of the id of the user of the session
+type sessionKey struct{}
+
+// usersPage is a page of users as the graphql schema reads it
+type usersPage struct {
+	Data internal.Users
+	Meta controller.Meta
+}
+
+// fieldError is the error of a field, it carries the code and status of the problem
+// the rest api would answer, so clients handle both apis alike
+type fieldError struct {
+	problem *problem.Problem
+}
+
+var _ error = (*fieldError)(nil)
+
+// Error is the detail of the problem, its internal cause is only logged
+func (e *fieldError) Error() string {
+	return e.problem.Detail
+}
+
+func (e *fieldError) Extensions() map[string]interface{} {
+	extensions := map[string]interface{}{
+		"code":   e.problem.Code,
+		"status": e.problem.Status,
+	}
+	if len(e.problem.Errors) > 0 {
+		extensions["errors"] = e.problem.Errors
+	}
+	return extensions
+}
+
+// resolver resolves the fields of the schema through the usecases
+type resolver struct {
+	usecases      usecases.UseCases
+	verifications usecases.EmailVerifications
+	validate      validator.Validate
+	logger        utils.Logger
+}
+
+func newSchema(r *resolver) (graphql.Schema, error) {
+	userType := graphql.NewObject(graphql.ObjectConfig{
+		Name:        "User",
+		Description: "A user, its password is never read",
+		Fields: graphql.Fields{
+			"uid": &graphql.Field{
+				Type: graphql.NewNonNull(graphql.ID),
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					return p.Source.(*internal.User).ID, nil
+				},
+			},
+			"email":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"phone":         &graphql.Field{Type: graphql.NewNonNull(graphql.String)},
+			"createdAt":     &graphql.Field{Type: graphql.DateTime},
+			"emailVerified": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"phoneVerified": &graphql.Field{Type: graphql.NewNonNull(graphql.Boolean)},
+			"version": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Int),
+				Description: "The version of the user, updates and deletes must send it to fail on a stale copy",
+			},
+		},
+	})
+
+	metaType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Meta",
+		Fields: graphql.Fields{
+			"total":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"limit":  &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"offset": &graphql.Field{Type: graphql.NewNonNull(graphql.Int)},
+			"nextCursor": &graphql.Field{
+				Type:        graphql.String,
+				Description: "The cursor of the next page, null on the last one",
+				Resolve: func(p graphql.ResolveParams) (interface{}, error) {
+					if cursor := p.Source.(controller.Meta).NextCursor; cursor != "" {
+						return cursor, nil
+					}
+					return nil, nil
+				},
+			},
+		},
+	})
+
+	pageType := graphql.NewObject(graphql.ObjectConfig{
+		Name: "UsersPage",
+		Fields: graphql.Fields{
+			"data": &graphql.Field{Type: graphql.NewNonNull(graphql.NewList(graphql.NewNonNull(userType)))},
+			"meta": &graphql.Field{Type: graphql.NewNonNull(metaType)},
+		},
+	})
+
+	query := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Query",
+		Fields: graphql.Fields{
+			"user": &graphql.Field{
+				Type:        userType,
+				Description: "The user of the id, null when there is none",
+				Args: graphql.FieldConfigArgument{
+					"uid": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+				},
+				Resolve: r.user,
+			},
+			"me": &graphql.Field{
+				Type:        graphql.NewNonNull(userType),
+				Description: "The user of the session",
+				Resolve:     r.me,
+			},
+			"users": &graphql.Field{
+				Type:        graphql.NewNonNull(pageType),
+				Description: "A page of users, filtered and sorted like GET /users",
+				Args: graphql.FieldConfigArgument{
+					"limit":       &graphql.ArgumentConfig{Type: graphql.Int},
+					"offset":      &graphql.ArgumentConfig{Type: graphql.Int},
+					"cursor":      &graphql.ArgumentConfig{Type: graphql.String},
+					"sort":        &graphql.ArgumentConfig{Type: graphql.String},
+					"email":       &graphql.ArgumentConfig{Type: graphql.String},
+					"phone":       &graphql.ArgumentConfig{Type: graphql.String},
+					"createdFrom": &graphql.ArgumentConfig{Type: graphql.String},
+					"createdTo":   &graphql.ArgumentConfig{Type: graphql.String},
+				},
+				Resolve: r.users,
+			},
+		},
+	})
+
+	mutation := graphql.NewObject(graphql.ObjectConfig{
+		Name: "Mutation",
+		Fields: graphql.Fields{
+			"signup": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Creates a user and mails the verification of its email. Like every operation it needs a session, so it creates accounts for others, new users sign up with the rest api",
+				Args: graphql.FieldConfigArgument{
+					"email":    &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"phone":    &graphql.ArgumentConfig{Type: graphql.String},
+					"password": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+				},
+				Resolve: r.signup,
+			},
+			"updateUser": &graphql.Field{
+				Type:        graphql.NewNonNull(userType),
+				Description: "Updates the email or the phone of a user, the ones not sent are left unchanged",
+				Args: graphql.FieldConfigArgument{
+					"uid":     &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"email":   &graphql.ArgumentConfig{Type: graphql.String},
+					"phone":   &graphql.ArgumentConfig{Type: graphql.String},
+					"version": &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.updateUser,
+			},
+			"deleteUser": &graphql.Field{
+				Type:        graphql.NewNonNull(graphql.Boolean),
+				Description: "Deletes a user, its password must match",
+				Args: graphql.FieldConfigArgument{
+					"uid":      &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.ID)},
+					"password": &graphql.ArgumentConfig{Type: graphql.NewNonNull(graphql.String)},
+					"version":  &graphql.ArgumentConfig{Type: graphql.Int},
+				},
+				Resolve: r.deleteUser,
+			},
+		},
+	})
+
+	return graphql.NewSchema(graphql.SchemaConfig{
+		Query:    query,
+		Mutation: mutation,
+	})
+}
+
+func (r *resolver) user(p graphql.ResolveParams) (interface{}, error) {
+	uid, _ := p.Args["uid"].(string)
+	user, err := r.usecases.IndexUserByID(p.Context, &controller.User{ID: uid})
+	if errors.Is(err, internal.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, r.fail("user", err)
+	}
+	return user, nil
+}
+
+func (r *resolver) me(p graphql.ResolveParams) (interface{}, error) {
+	uid := sessionUID(p.Context)
+	if uid == "" {
+		return nil, r.fail("me", problem.Unauthorized(noSession))
+	}
+	user, err := r.usecases.IndexUserByID(p.Context, &controller.User{ID: uid})
+	if err != nil {
+		return nil, r.fail("me", err)
+	}
+	return user, nil
+}
+
+func (r *resolver) users(p graphql.ResolveParams) (interface{}, error) {
+	req := &controller.ListRequest{}
+	req.Limit, _ = p.Args["limit"].(int)
+	req.Offset, _ = p.Args["offset"].(int)
+	req.Cursor, _ = p.Args["cursor"].(string)
+	req.Sort, _ = p.Args["sort"].(string)
+	req.Email, _ = p.Args["email"].(string)
+	req.Phone, _ = p.Args["phone"].(string)
+	req.CreatedFrom, _ = p.Args["createdFrom"].(string)
+	req.CreatedTo, _ = p.Args["createdTo"].(string)
+	if err := r.validate.Struct(req); err != nil {
+		return nil, r.fail("users", problem.Validation(err))
+	}
+
+	page, err := r.usecases.IndexUsers(p.Context, req)
+	if err != nil {
+		return nil, r.fail("users", err)
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = usecases.DefaultPageSize
+	}
+	return &usersPage{
+		Data: page.Users,
+		Meta: controller.Meta{
+			Total:      page.Total,
+			Limit:      limit,
+			Offset:     req.Offset,
+			NextCursor: page.NextCursor,
+		},
+	}, nil
+}
+
+func (r *resolver) signup(p graphql.ResolveParams) (interface{}, error) {
+	req := &controller.PostRequest{}
+	req.Email, _ = p.Args["email"].(string)
+	req.Phone, _ = p.Args["phone"].(string)
+	req.Password, _ = p.Args["password"].(string)
+	if err := r.validate.Struct(req); err != nil {
+		return nil, r.fail("signup", problem.Validation(err))
+	}
+
+	err := r.usecases.RegisterUser(p.Context, &controller.User{
+		Email:    req.Email,
+		Phone:    req.Phone,
+		Password: req.Password,
+	})
+	if err != nil {
+		return nil, r.fail("signup", err)
+	}
+
+	// the account exists already, a failed email can be sent again with the resend endpoint
+	if err := r.verifications.SendVerification(p.Context, req.Email); err != nil {
+		r.logger.Error("graphql field[signup] -> %s: %s", internalError, err)
+	}
+	return true, nil
+}
+
+func (r *resolver) updateUser(p graphql.ResolveParams) (interface{}, error) {
+	uid, _ := p.Args["uid"].(string)
+	version, _ := p.Args["version"].(int)
+	if version <= 0 {
+		return nil, r.fail("updateUser", problem.New(fiber.StatusPreconditionRequired, "", noVersion))
+	}
+	req := &controller.PatchRequest{}
+	if email, ok := p.Args["email"].(string); ok {
+		req.Email = &email
+	}
+	if phone, ok := p.Args["phone"].(string); ok {
+		req.Phone = &phone
+	}
+	if err := r.validate.Struct(req); err != nil {
+		return nil, r.fail("updateUser", problem.Validation(err))
+	}
+
+	err := r.usecases.ModifyUser(p.Context, &controller.UserPatch{
+		ID:      uid,
+		Email:   req.Email,
+		Phone:   req.Phone,
+		Version: version,
+	})
+	if err != nil {
+		return nil, r.fail("updateUser", err)
+	}
+
+	user, err := r.usecases.IndexUserByID(p.Context, &controller.User{ID: uid})
+	if err != nil {
+		return nil, r.fail("updateUser", err)
+	}
+	return user, nil
+}
+
+func (r *resolver) deleteUser(p graphql.ResolveParams) (interface{}, error) {
+	uid, _ := p.Args["uid"].(string)
+	version, _ := p.Args["version"].(int)
+	if version <= 0 {
+		return nil, r.fail("deleteUser", problem.New(fiber.StatusPreconditionRequired, "", noVersion))
+	}
+	req := &controller.DeleteRequest{}
+	req.Password, _ = p.Args["password"].(string)
+	if err := r.validate.Struct(req); err != nil {
+		return nil, r.fail("deleteUser", problem.Validation(err))
+	}
+
+	err := r.usecases.DestroyUser(p.Context, &controller.User{
+		ID:       uid,
+		Password: req.Password,
+		Version:  version,
+	})
+	if err != nil {
+		return nil, r.fail("deleteUser", err)
+	}
+	return true, nil
+}
+
+// fail logs the error of a field and converts it to the problem the rest api would answer
+func (r *resolver) fail(field string, err error) error {
+	p := problem.From(err)
+	r.logger.Error("graphql field[%s] -> %s: %s", field, internalError, p.Error())
+	return &fieldError{problem: p}
+}
+
+// sessionUID is the id of the user of the session, empty without a session
+func sessionUID(ctx context.Context) string {
+	uid, _ := ctx.Value(sessionKey{}).(string)
+	return uid
+}
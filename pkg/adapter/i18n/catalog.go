@@ -0,0 +1,98 @@
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// bundleFiles are the bundles of messages, one json object of keys and messages per locale
+//
+//go:embed locales/*.json
+var bundleFiles embed.FS
+
+// catalog is the message catalog of every locale, loaded once from the embedded bundles
+var catalog = mustLoadCatalog()
+
+type messageCatalog struct {
+	bundles map[string]map[string]string
+	// keys are the keys of the default messages, so a message of the business layer
+	// that only knows its english text is found too
+	keys map[string]string
+}
+
+// Message is the message of a key in a locale, with its {0}, {1}... placeholders replaced by the params.
+// A key missing in the locale falls back to the default locale, a message of the business layer is
+// found by its english text, and a text that is in no bundle is kept as it is
+func Message(locale string, key string, params ...string) string {
+	if k, ok := catalog.keys[key]; ok {
+		key = k
+	}
+
+	msg, ok := catalog.bundles[locale][key]
+	if !ok {
+		msg, ok = catalog.bundles[Locales[0]][key]
+	}
+	if !ok {
+		msg = key
+	}
+
+	for i, param := range params {
+		msg = strings.ReplaceAll(msg, "{"+strconv.Itoa(i)+"}", param)
+	}
+	return msg
+}
+
+// LocaleOf is the locale negotiated for the request, the default one when it was not negotiated
+func LocaleOf(ctx *fiber.Ctx) string {
+	trans := TranslatorOf(ctx)
+	if trans == nil {
+		return Locales[0]
+	}
+	return trans.Locale()
+}
+
+// loadCatalog reads the bundle of every locale, the default one must hold every key
+func loadCatalog() (*messageCatalog, error) {
+	c := &messageCatalog{
+		bundles: make(map[string]map[string]string, len(Locales)),
+		keys:    make(map[string]string),
+	}
+	for _, locale := range Locales {
+		data, err := bundleFiles.ReadFile("locales/" + locale + ".json")
+		if err != nil {
+			return nil, err
+		}
+		bundle := make(map[string]string)
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return nil, fmt.Errorf("invalid bundle of %s: %w", locale, err)
+		}
+		c.bundles[locale] = bundle
+	}
+
+	for key, msg := range c.bundles[Locales[0]] {
+		c.keys[msg] = key
+	}
+	for _, locale := range Locales[1:] {
+		for key := range c.bundles[locale] {
+			if _, ok := c.bundles[Locales[0]][key]; !ok {
+				return nil, fmt.Errorf("key %s of %s is not in the default bundle", key, locale)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// mustLoadCatalog loads the catalog, the bundles are embedded so a broken one is a bug of the build
+func mustLoadCatalog() *messageCatalog {
+	c, err := loadCatalog()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
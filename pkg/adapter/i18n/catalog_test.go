@@ -0,0 +1,146 @@
+package i18n_test
+
+import (
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessage(test *testing.T) {
+	successfulCases := []struct {
+		name     string
+		locale   string
+		key      string
+		params   []string
+		expected string
+	}{
+		{
+			name:     "it should give the message of a key in english",
+			locale:   i18n.English,
+			key:      "user.not_found",
+			expected: "user not found",
+		},
+		{
+			name:     "it should give the message of a key in spanish",
+			locale:   i18n.Spanish,
+			key:      "user.not_found",
+			expected: "usuario no encontrado",
+		},
+		{
+			name:     "it should replace the params of the message",
+			locale:   i18n.Spanish,
+			key:      "patch.invalid_path",
+			params:   []string{`"/email"`},
+			expected: `ruta inválida "/email"`,
+		},
+		{
+			name:     "it should find a business message by its english text",
+			locale:   i18n.Spanish,
+			key:      "a code was sent recently, try again later",
+			expected: "se envió un código recientemente, intenta más tarde",
+		},
+		{
+			name:     "it should fall back to english for an unsupported locale",
+			locale:   "fr",
+			key:      "user.not_found",
+			expected: "user not found",
+		},
+		{
+			name:     "it should keep a text that is in no bundle",
+			locale:   i18n.Spanish,
+			key:      "user 1 was not found",
+			expected: "user 1 was not found",
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tc.expected, i18n.Message(tc.locale, tc.key, tc.params...))
+		})
+	}
+}
+
+func TestBundles(test *testing.T) {
+	defaults := readBundle(test, i18n.English)
+
+	for _, locale := range i18n.Locales[1:] {
+		locale := locale
+		test.Run("it should translate every message to "+locale, func(t *testing.T) {
+			t.Parallel()
+
+			bundle := readBundle(t, locale)
+			for key := range defaults {
+				assert.Contains(t, bundle, key)
+			}
+		})
+	}
+}
+
+func TestLocaleOf(test *testing.T) {
+	translator, err := i18n.NewTranslator(validator.New())
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+
+	successfulCases := []struct {
+		name       string
+		negotiated bool
+		expected   string
+	}{
+		{
+			name:       "it should be the negotiated locale",
+			negotiated: true,
+			expected:   i18n.Spanish,
+		},
+		{
+			name:     "it should default to english when it was not negotiated",
+			expected: i18n.English,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := fiber.New()
+			app.Get("/", func(ctx *fiber.Ctx) error {
+				if tc.negotiated {
+					i18n.SetTranslator(ctx, translator.Negotiate("es"))
+				}
+				return ctx.SendString(i18n.LocaleOf(ctx))
+			})
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, "/", nil))
+			assert.NoError(t, err)
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(body))
+		})
+	}
+}
+
+// readBundle reads the keys and messages of the bundle of a locale
+func readBundle(t testing.TB, locale string) map[string]string {
+	t.Helper()
+
+	data, err := os.ReadFile("locales/" + locale + ".json")
+	if err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+	bundle := make(map[string]string)
+	if err := json.Unmarshal(data, &bundle); err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+	return bundle
+}
@@ -0,0 +1,198 @@
+// Package i18n is a package that translates the messages of the api to the language of the client
+package i18n
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/es"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	entranslations "github.com/go-playground/validator/v10/translations/en"
+	estranslations "github.com/go-playground/validator/v10/translations/es"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// English is the default locale, the one of the clients that accept no supported language
+	English = "en"
+	// Spanish is the spanish locale
+	Spanish = "es"
+
+	// ruleKey is the key of the generic message of the rules
+	ruleKey = "rule"
+	// localsKey is the key of the translator of a request in its locals
+	localsKey = "translator"
+)
+
+// Locales are the supported locales, the first one is the default
+var Locales = []string{English, Spanish}
+
+// genericMessages are the messages of the rules without a translation, {0} is the field and {1} the rule
+var genericMessages = map[string]string{
+	English: "{0} failed on the {1} rule",
+	Spanish: "{0} no cumple la regla {1}",
+}
+
+// ruleMessages are the messages of the rules the validator does not translate, {0} is the field
+// and {1} the parameter of the rule
+var ruleMessages = map[string]map[string]string{
+	English: {
+		"required_without": "{0} is required when {1} is missing",
+		"excluded_with":    "{0} can not be sent with {1}",
+		"base64rawurl":     "{0} must be a base64 url encoded string",
+		"e164":             "{0} must be a phone number in E.164 format",
+	},
+	Spanish: {
+		"required_without": "{0} es un campo requerido cuando falta {1}",
+		"excluded_with":    "{0} no puede enviarse junto con {1}",
+		"base64rawurl":     "{0} debe ser una cadena base64 para urls",
+		"e164":             "{0} debe ser un teléfono en formato E.164",
+		"datetime":         "{0} no cumple el formato {1}",
+	},
+}
+
+// Translator is an interface that extends the translations of the api
+type Translator interface {
+	// Negotiate is the translator of the supported language that fits best an Accept-Language
+	// header, the default one when none does
+	Negotiate(acceptLanguage string) ut.Translator
+}
+
+var _ Translator = (*translator)(nil)
+
+type translator struct {
+	translators map[string]ut.Translator
+}
+
+// NewTranslator is a constructor for the translations, it names the fields of the validation
+// errors of v like the requests do and registers the messages of its rules in every locale,
+// so it is called before v is shared
+func NewTranslator(v *validator.Validate) (Translator, error) {
+	v.RegisterTagNameFunc(fieldName)
+
+	uni := ut.New(en.New(), en.New(), es.New())
+	defaults := map[string]func(*validator.Validate, ut.Translator) error{
+		English: entranslations.RegisterDefaultTranslations,
+		Spanish: estranslations.RegisterDefaultTranslations,
+	}
+
+	translators := make(map[string]ut.Translator, len(Locales))
+	for _, locale := range Locales {
+		trans, _ := uni.GetTranslator(locale)
+		if err := defaults[locale](v, trans); err != nil {
+			return nil, err
+		}
+		if err := trans.Add(ruleKey, genericMessages[locale], true); err != nil {
+			return nil, err
+		}
+		for tag, text := range ruleMessages[locale] {
+			if err := registerRule(v, trans, tag, text); err != nil {
+				return nil, err
+			}
+		}
+		translators[locale] = trans
+	}
+
+	return &translator{translators: translators}, nil
+}
+
+func (t *translator) Negotiate(acceptLanguage string) ut.Translator {
+	type languageRange struct {
+		language string
+		quality  float64
+	}
+	ranges := make([]languageRange, 0)
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		language, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if language == "" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			continue
+		}
+		ranges = append(ranges, languageRange{language: strings.ToLower(language), quality: quality})
+	}
+
+	// the stable sort keeps the order of the header between ranges of the same quality
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	for _, r := range ranges {
+		if r.language == "*" {
+			break
+		}
+		// a regional language like es-MX gets its base language
+		base, _, _ := strings.Cut(r.language, "-")
+		if trans, ok := t.translators[base]; ok {
+			return trans
+		}
+	}
+
+	return t.translators[Locales[0]]
+}
+
+// FieldMessage is the message of a field that failed a rule in the language of the translator,
+// the rules without a translation get a generic one instead of the validator error
+func FieldMessage(trans ut.Translator, fe validator.FieldError) string {
+	msg := fe.Translate(trans)
+	if msg != fe.Error() {
+		return msg
+	}
+
+	generic, err := trans.T(ruleKey, fe.Field(), fe.Tag())
+	if err != nil {
+		return msg
+	}
+	return generic
+}
+
+// SetTranslator keeps the translator of the request in its locals
+func SetTranslator(ctx *fiber.Ctx, trans ut.Translator) {
+	ctx.Locals(localsKey, trans)
+}
+
+// TranslatorOf is the translator of the request, nil when its language was not negotiated
+func TranslatorOf(ctx *fiber.Ctx) ut.Translator {
+	trans, ok := ctx.Locals(localsKey).(ut.Translator)
+	if !ok {
+		return nil
+	}
+	return trans
+}
+
+// fieldName is the name of a field in the requests, its json name or its query name
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "query", "form"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// registerRule registers the message of a rule in the translator
+func registerRule(v *validator.Validate, trans ut.Translator, tag string, text string) error {
+	return v.RegisterTranslation(tag, trans, func(t ut.Translator) error {
+		return t.Add(tag, text, true)
+	}, func(t ut.Translator, fe validator.FieldError) string {
+		msg, err := t.T(tag, fe.Field(), fe.Param())
+		if err != nil {
+			return fe.Error()
+		}
+		return msg
+	})
+}
@@ -0,0 +1,140 @@
+package i18n_test
+
+import (
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"errors"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+)
+
+type signup struct {
+	Email    string `json:"email" validate:"required,email"`
+	Phone    string `json:"phone,omitempty" validate:"omitempty,e164"`
+	Host     string `json:"host" validate:"omitempty,hostname"`
+	Password string `validate:"omitempty,min=8"`
+}
+
+func TestNegotiate(test *testing.T) {
+	v := validator.New()
+	translator, err := i18n.NewTranslator(v)
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+
+	successfulCases := []struct {
+		name           string
+		acceptLanguage string
+		expected       string
+	}{
+		{
+			name:     "it should default to english without a header",
+			expected: i18n.English,
+		},
+		{
+			name:           "it should pick a supported language",
+			acceptLanguage: "es",
+			expected:       i18n.Spanish,
+		},
+		{
+			name:           "it should pick the base of a regional language",
+			acceptLanguage: "es-MX",
+			expected:       i18n.Spanish,
+		},
+		{
+			name:           "it should pick the supported language of the highest quality",
+			acceptLanguage: "fr;q=1, en;q=0.5, es;q=0.8",
+			expected:       i18n.Spanish,
+		},
+		{
+			name:           "it should skip a refused language",
+			acceptLanguage: "es;q=0, en;q=0.1",
+			expected:       i18n.English,
+		},
+		{
+			name:           "it should default to english for unsupported languages",
+			acceptLanguage: "fr-FR, de",
+			expected:       i18n.English,
+		},
+		{
+			name:           "it should default to english for a wildcard",
+			acceptLanguage: "*",
+			expected:       i18n.English,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			trans := translator.Negotiate(tc.acceptLanguage)
+			assert.Equal(t, tc.expected, trans.Locale())
+		})
+	}
+}
+
+func TestFieldMessage(test *testing.T) {
+	v := validator.New()
+	translator, err := i18n.NewTranslator(v)
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+
+	successfulCases := []struct {
+		name           string
+		acceptLanguage string
+		input          *signup
+		expectedField  string
+		expected       string
+	}{
+		{
+			name:          "it should name the field like the json and translate to english",
+			input:         &signup{},
+			expectedField: "email",
+			expected:      "email is a required field",
+		},
+		{
+			name:           "it should translate to spanish",
+			acceptLanguage: "es",
+			input:          &signup{},
+			expectedField:  "email",
+			expected:       "email es un campo requerido",
+		},
+		{
+			name:           "it should translate a rule the validator does not",
+			acceptLanguage: "es",
+			input:          &signup{Email: "test@test.com", Phone: "123"},
+			expectedField:  "phone",
+			expected:       "phone debe ser un teléfono en formato E.164",
+		},
+		{
+			name:          "it should give a generic message to a rule without translation",
+			input:         &signup{Email: "test@test.com", Host: "not a host"},
+			expectedField: "host",
+			expected:      "host failed on the hostname rule",
+		},
+		{
+			name:          "it should keep the name of a field without tags",
+			input:         &signup{Email: "test@test.com", Password: "short"},
+			expectedField: "Password",
+			expected:      "Password must be at least 8 characters in length",
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var ves validator.ValidationErrors
+			if !errors.As(v.Struct(tc.input), &ves) {
+				t.Fatal("expected validation errors")
+			}
+			assert.Len(t, ves, 1)
+			assert.Equal(t, tc.expectedField, ves[0].Field())
+			assert.Equal(t, tc.expected, i18n.FieldMessage(translator.Negotiate(tc.acceptLanguage), ves[0]))
+		})
+	}
+}
@@ -0,0 +1,292 @@
+// Package problem renders errors as RFC 7807 problem details (application/problem+json)
+package problem
+
+import (
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// ContentType is the media type of a problem details response
+	ContentType = "application/problem+json"
+
+	typeBlank = "about:blank"
+
+	detailInternal   = "problem.internal"
+	detailValidation = "problem.validation"
+)
+
+// Stable error codes, clients should rely on them instead of titles or details
+const (
+	CodeBadRequest         = "bad_request"
+	CodeValidation         = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeNotAcceptable      = "not_acceptable"
+	CodeConflict           = "conflict"
+	CodePreconditionFailed = "precondition_failed"
+	CodePayloadTooLarge    = "payload_too_large"
+	CodeUnsupportedMedia   = "unsupported_media_type"
+	CodeUnprocessable      = "unprocessable_entity"
+	CodePreconditionNeeded = "precondition_required"
+	CodeTooManyRequests    = "too_many_requests"
+	CodeInternal           = "internal_error"
+	CodeServiceUnavailable = "service_unavailable"
+)
+
+// codes maps http statuses to the code used when the error carries none
+var codes = map[int]string{
+	fiber.StatusBadRequest:            CodeBadRequest,
+	fiber.StatusUnauthorized:          CodeUnauthorized,
+	fiber.StatusForbidden:             CodeForbidden,
+	fiber.StatusNotFound:              CodeNotFound,
+	fiber.StatusMethodNotAllowed:      CodeMethodNotAllowed,
+	fiber.StatusNotAcceptable:         CodeNotAcceptable,
+	fiber.StatusConflict:              CodeConflict,
+	fiber.StatusPreconditionFailed:    CodePreconditionFailed,
+	fiber.StatusRequestEntityTooLarge: CodePayloadTooLarge,
+	fiber.StatusUnsupportedMediaType:  CodeUnsupportedMedia,
+	fiber.StatusUnprocessableEntity:   CodeUnprocessable,
+	fiber.StatusPreconditionRequired:  CodePreconditionNeeded,
+	fiber.StatusTooManyRequests:       CodeTooManyRequests,
+	fiber.StatusInternalServerError:   CodeInternal,
+	fiber.StatusServiceUnavailable:    CodeServiceUnavailable,
+}
+
+// kinds maps the business error kinds to http statuses, the first match wins
+var kinds = []struct {
+	kind   error
+	status int
+}{
+	{kind: internal.ErrNotFound, status: fiber.StatusNotFound},
+	{kind: internal.ErrConflict, status: fiber.StatusConflict},
+	{kind: internal.ErrUnauthorized, status: fiber.StatusUnauthorized},
+	{kind: internal.ErrForbidden, status: fiber.StatusForbidden},
+	{kind: internal.ErrValidation, status: fiber.StatusUnprocessableEntity},
+	{kind: internal.ErrTooManyRequests, status: fiber.StatusTooManyRequests},
+	{kind: internal.ErrPreconditionFailed, status: fiber.StatusPreconditionFailed},
+}
+
+// FieldError is the detail of a field that failed validation
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Problem is a RFC 7807 problem details object, it implements error
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []FieldError `json:"errors,omitempty"`
+
+	// cause is the internal error, it is logged but never rendered
+	cause error
+	// key and params are the message of the detail in the catalog, it is rendered in the
+	// language of the request
+	key    string
+	params []string
+}
+
+var _ error = (*Problem)(nil)
+
+// New is a constructor for a problem with a stable code and a client safe detail, the detail is a key
+// of the message catalog or a message of its own. Until it is rendered the detail is in english
+func New(status int, code string, detail string) *Problem {
+	if code == "" {
+		code = codeFor(status)
+	}
+	return &Problem{
+		Type:   typeBlank,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: i18n.Message(i18n.English, detail),
+		Code:   code,
+		key:    detail,
+	}
+}
+
+// BadRequest is a problem for malformed requests
+func BadRequest(detail string) *Problem {
+	return New(fiber.StatusBadRequest, CodeBadRequest, detail)
+}
+
+// Unauthorized is a problem for missing, wrong or revoked credentials
+func Unauthorized(detail string) *Problem {
+	return New(fiber.StatusUnauthorized, CodeUnauthorized, detail)
+}
+
+// NotFound is a problem for missing resources
+func NotFound(detail string) *Problem {
+	return New(fiber.StatusNotFound, CodeNotFound, detail)
+}
+
+// Internal is a problem that hides its cause from the client
+func Internal(cause error) *Problem {
+	return New(fiber.StatusInternalServerError, CodeInternal, detailInternal).Wrap(cause)
+}
+
+// Validation is a problem listing every field that failed the validator rules, the messages are
+// translated to the language of the request when it is rendered
+func Validation(err error) *Problem {
+	p := New(fiber.StatusBadRequest, CodeValidation, detailValidation).Wrap(err)
+
+	var ves validator.ValidationErrors
+	if !errors.As(err, &ves) {
+		return p
+	}
+
+	p.Errors = make([]FieldError, 0, len(ves))
+	for _, fe := range ves {
+		p.Errors = append(p.Errors, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: fmt.Sprintf("%s failed on the %s rule", fe.Field(), fe.Tag()),
+		})
+	}
+
+	return p
+}
+
+// translate translates the messages of the fields that failed the validator rules
+func (p *Problem) translate(trans ut.Translator) {
+	var ves validator.ValidationErrors
+	if len(p.Errors) == 0 || !errors.As(p.cause, &ves) {
+		return
+	}
+
+	errs := make([]FieldError, 0, len(ves))
+	for _, fe := range ves {
+		errs = append(errs, FieldError{
+			Field:   fe.Field(),
+			Rule:    fe.Tag(),
+			Message: i18n.FieldMessage(trans, fe),
+		})
+	}
+	p.Errors = errs
+}
+
+// With sets the params of the detail, they replace the {0}, {1}... placeholders of its message
+func (p *Problem) With(params ...string) *Problem {
+	p.params = params
+	p.Detail = i18n.Message(i18n.English, p.key, params...)
+	return p
+}
+
+// localize renders the title and the detail in the language of the locale
+func (p *Problem) localize(locale string) {
+	p.Title = i18n.Message(locale, p.Title)
+	if p.key != "" {
+		p.Detail = i18n.Message(locale, p.key, p.params...)
+	}
+}
+
+// Localize is a copy of the problem in the language of the translator, as the error handler
+// renders it. Without a translator it stays in english
+func (p *Problem) Localize(trans ut.Translator) *Problem {
+	localized := *p
+	if trans != nil {
+		localized.translate(trans)
+		localized.localize(trans.Locale())
+	}
+	return &localized
+}
+
+// Wrap keeps the internal cause of the problem
+func (p *Problem) Wrap(cause error) *Problem {
+	p.cause = cause
+	return p
+}
+
+func (p *Problem) Error() string {
+	if p.cause != nil {
+		return fmt.Sprintf("%s: %v", p.Code, p.cause)
+	}
+	if p.Detail != "" {
+		return fmt.Sprintf("%s: %s", p.Code, p.Detail)
+	}
+	return p.Code
+}
+
+// Unwrap returns the internal cause of the problem
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
+
+// From converts any error into a problem, business and fiber errors keep their status
+func From(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+
+	var de *internal.Error
+	if errors.As(err, &de) {
+		for _, k := range kinds {
+			if errors.Is(err, k.kind) {
+				key := de.Key()
+				if key == "" {
+					key = de.Message()
+				}
+				return New(k.status, "", key).With(de.Params()...).Wrap(err)
+			}
+		}
+	}
+
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		if fe.Code >= fiber.StatusInternalServerError {
+			return New(fe.Code, "", http.StatusText(fe.Code)).Wrap(err)
+		}
+		return New(fe.Code, "", fe.Message)
+	}
+
+	return Internal(err)
+}
+
+// NewErrorHandler is a constructor for the fiber.Config error handler
+func NewErrorHandler(l utils.Logger) fiber.ErrorHandler {
+	return func(ctx *fiber.Ctx, err error) error {
+		p := From(err).Localize(i18n.TranslatorOf(ctx))
+		p.Instance = ctx.OriginalURL()
+		p.RequestID = ctx.GetRespHeader(fiber.HeaderXRequestID)
+
+		if p.Status >= fiber.StatusInternalServerError {
+			l.Error("%s path[%s] request[%s] -> %s", ctx.Method(), ctx.Path(), p.RequestID, p.Error())
+		}
+
+		body, err := json.Marshal(p)
+		if err != nil {
+			return ctx.Status(fiber.StatusInternalServerError).SendString(http.StatusText(fiber.StatusInternalServerError))
+		}
+
+		ctx.Set(fiber.HeaderContentType, ContentType)
+		return ctx.Status(p.Status).Send(body)
+	}
+}
+
+func codeFor(status int) string {
+	if code, ok := codes[status]; ok {
+		return code
+	}
+	if status >= fiber.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
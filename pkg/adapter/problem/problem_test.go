@@ -0,0 +1,373 @@
+// Package problem_test is a test for problem details
+package problem_test
+
+import (
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/utils"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type validated struct {
+	Email string `validate:"required,email"`
+}
+
+func TestFrom(test *testing.T) {
+	successfulCases := []struct {
+		name           string
+		input          error
+		expectedStatus int
+		expectedCode   string
+		expectedDetail string
+	}{
+		{
+			name:           "it should keep a problem",
+			input:          problem.NotFound("user not found"),
+			expectedStatus: fiber.StatusNotFound,
+			expectedCode:   problem.CodeNotFound,
+			expectedDetail: "user not found",
+		},
+		{
+			name:           "it should convert a not found business error",
+			input:          fmt.Errorf("failed to fetch user details: %w", internal.NewError(internal.ErrNotFound, "user not found", nil)),
+			expectedStatus: fiber.StatusNotFound,
+			expectedCode:   problem.CodeNotFound,
+			expectedDetail: "user not found",
+		},
+		{
+			name:           "it should convert a conflict business error",
+			input:          internal.NewError(internal.ErrConflict, "user already exists", errors.New("Duplicate entry")),
+			expectedStatus: fiber.StatusConflict,
+			expectedCode:   problem.CodeConflict,
+			expectedDetail: "user already exists",
+		},
+		{
+			name:           "it should convert a business error with params",
+			input:          internal.NewKeyedError(internal.ErrValidation, "error.invalid_match", "invalid match {0}", "exact"),
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedCode:   problem.CodeUnprocessable,
+			expectedDetail: "invalid match exact",
+		},
+		{
+			name:           "it should convert an unauthorized business error",
+			input:          internal.NewError(internal.ErrUnauthorized, "wrong credentials", nil),
+			expectedStatus: fiber.StatusUnauthorized,
+			expectedCode:   problem.CodeUnauthorized,
+			expectedDetail: "wrong credentials",
+		},
+		{
+			name:           "it should convert a forbidden business error",
+			input:          internal.NewError(internal.ErrForbidden, "not allowed", nil),
+			expectedStatus: fiber.StatusForbidden,
+			expectedCode:   problem.CodeForbidden,
+			expectedDetail: "not allowed",
+		},
+		{
+			name:           "it should convert a validation business error",
+			input:          internal.NewError(internal.ErrValidation, "email is required", nil),
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedCode:   problem.CodeUnprocessable,
+			expectedDetail: "email is required",
+		},
+		{
+			name:           "it should convert a precondition failed business error",
+			input:          internal.NewError(internal.ErrPreconditionFailed, "the user was modified by another request", nil),
+			expectedStatus: fiber.StatusPreconditionFailed,
+			expectedCode:   problem.CodePreconditionFailed,
+			expectedDetail: "the user was modified by another request",
+		},
+		{
+			name:           "it should convert a too many requests business error",
+			input:          internal.NewError(internal.ErrTooManyRequests, "try again later", nil),
+			expectedStatus: fiber.StatusTooManyRequests,
+			expectedCode:   problem.CodeTooManyRequests,
+			expectedDetail: "try again later",
+		},
+		{
+			name:           "it should convert a fiber error",
+			input:          fiber.ErrRequestEntityTooLarge,
+			expectedStatus: fiber.StatusRequestEntityTooLarge,
+			expectedCode:   problem.CodePayloadTooLarge,
+			expectedDetail: fiber.ErrRequestEntityTooLarge.Message,
+		},
+		{
+			name:           "it should keep the code of a missing precondition",
+			input:          fiber.NewError(fiber.StatusPreconditionRequired, "the If-Match header is required"),
+			expectedStatus: fiber.StatusPreconditionRequired,
+			expectedCode:   problem.CodePreconditionNeeded,
+			expectedDetail: "the If-Match header is required",
+		},
+		{
+			name:           "it should hide the message of a fiber server error",
+			input:          fiber.NewError(fiber.StatusServiceUnavailable, "db is down"),
+			expectedStatus: fiber.StatusServiceUnavailable,
+			expectedCode:   problem.CodeServiceUnavailable,
+			expectedDetail: "Service Unavailable",
+		},
+		{
+			name:           "it should hide an unknown error",
+			input:          errors.New("failed to execute SQL statement"),
+			expectedStatus: fiber.StatusInternalServerError,
+			expectedCode:   problem.CodeInternal,
+			expectedDetail: "the request could not be processed",
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := problem.From(tc.input)
+			assert.Equal(t, tc.expectedStatus, p.Status)
+			assert.Equal(t, tc.expectedCode, p.Code)
+			assert.Equal(t, tc.expectedDetail, p.Detail)
+			assert.NotEmpty(t, p.Title, "expected a title, but got empty")
+		})
+	}
+}
+
+func TestValidation(test *testing.T) {
+	v := validator.New()
+
+	successfulCases := []struct {
+		name          string
+		input         *validated
+		expectedRules []string
+	}{
+		{
+			name:          "it should list a required field",
+			input:         &validated{},
+			expectedRules: []string{"required"},
+		},
+		{
+			name:          "it should list an invalid email",
+			input:         &validated{Email: "testtest.com"},
+			expectedRules: []string{"email"},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := v.Struct(tc.input)
+			assert.Error(t, err)
+
+			p := problem.Validation(err)
+			assert.Equal(t, fiber.StatusBadRequest, p.Status)
+			assert.Equal(t, problem.CodeValidation, p.Code)
+			assert.Len(t, p.Errors, len(tc.expectedRules))
+			for i, rule := range tc.expectedRules {
+				assert.Equal(t, "Email", p.Errors[i].Field)
+				assert.Equal(t, rule, p.Errors[i].Rule)
+			}
+			assert.Equal(t, err, errors.Unwrap(p))
+		})
+	}
+}
+
+func TestErrorHandler(test *testing.T) {
+	app := fiber.New(fiber.Config{
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	})
+	app.Get("/problem", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderXRequestID, "im a request id")
+		return problem.Internal(errors.New("failed to execute SQL statement"))
+	})
+
+	req := httptest.NewRequest("GET", "/problem?q=1", nil)
+	resp, err := app.Test(req)
+	assert.NoError(test, err)
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			test.Fatalf("error closing body: %v", err)
+		}
+	}()
+
+	assert.Equal(test, fiber.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(test, problem.ContentType, resp.Header.Get(fiber.HeaderContentType))
+
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(test, err)
+
+	p := &problem.Problem{}
+	err = json.Unmarshal(body, p)
+	assert.NoError(test, err)
+	assert.Equal(test, problem.CodeInternal, p.Code)
+	assert.Equal(test, "/problem?q=1", p.Instance)
+	assert.Equal(test, "im a request id", p.RequestID)
+	assert.NotContains(test, string(body), "SQL")
+}
+
+func TestErrorHandlerTranslation(test *testing.T) {
+	type signup struct {
+		Email string `json:"email" validate:"required,email"`
+	}
+
+	v := validator.New()
+	translator, err := i18n.NewTranslator(v)
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+
+	successfulCases := []struct {
+		name           string
+		acceptLanguage string
+		translated     bool
+		expected       string
+	}{
+		{
+			name:           "it should translate the fields to spanish",
+			acceptLanguage: "es-MX,es;q=0.9",
+			translated:     true,
+			expected:       "email debe ser una dirección de correo electrónico válida",
+		},
+		{
+			name:           "it should translate the fields to english by default",
+			acceptLanguage: "fr",
+			translated:     true,
+			expected:       "email must be a valid email address",
+		},
+		{
+			name:     "it should keep the generic messages without a negotiated language",
+			expected: "email failed on the email rule",
+		},
+	}
+
+	app := fiber.New(fiber.Config{
+		ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+	})
+	app.Use(func(c *fiber.Ctx) error {
+		if c.Query("translated") != "" {
+			i18n.SetTranslator(c, translator.Negotiate(c.Get(fiber.HeaderAcceptLanguage)))
+		}
+		return c.Next()
+	})
+	app.Post("/signup", func(c *fiber.Ctx) error {
+		return problem.Validation(v.Struct(&signup{Email: "testtest.com"}))
+	})
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := "/signup"
+			if tc.translated {
+				path += "?translated=1"
+			}
+			req := httptest.NewRequest(fiber.MethodPost, path, nil)
+			req.Header.Set(fiber.HeaderAcceptLanguage, tc.acceptLanguage)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusBadRequest, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			p := &problem.Problem{}
+			err = json.Unmarshal(body, p)
+			assert.NoError(t, err)
+			assert.Equal(t, []problem.FieldError{{Field: "email", Rule: "email", Message: tc.expected}}, p.Errors)
+		})
+	}
+}
+
+func TestErrorHandlerLocalization(test *testing.T) {
+	translator, err := i18n.NewTranslator(validator.New())
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+
+	successfulCases := []struct {
+		name           string
+		acceptLanguage string
+		err            error
+		expectedStatus int
+		expectedTitle  string
+		expectedDetail string
+	}{
+		{
+			name:           "it should localize a key of the catalog",
+			acceptLanguage: "es",
+			err:            problem.NotFound("user.not_found"),
+			expectedStatus: fiber.StatusNotFound,
+			expectedTitle:  "No encontrado",
+			expectedDetail: "usuario no encontrado",
+		},
+		{
+			name:           "it should localize a key of the catalog with params",
+			acceptLanguage: "es",
+			err:            problem.BadRequest("patch.invalid_path").With(`"/email"`),
+			expectedStatus: fiber.StatusBadRequest,
+			expectedTitle:  "Solicitud incorrecta",
+			expectedDetail: `ruta inválida "/email"`,
+		},
+		{
+			name:           "it should localize a business error by its message",
+			acceptLanguage: "es",
+			err:            internal.NewError(internal.ErrTooManyRequests, "a code was sent recently, try again later", nil),
+			expectedStatus: fiber.StatusTooManyRequests,
+			expectedTitle:  "Demasiadas solicitudes",
+			expectedDetail: "se envió un código recientemente, intenta más tarde",
+		},
+		{
+			name:           "it should localize a business error by its key with params",
+			acceptLanguage: "es",
+			err:            internal.NewKeyedError(internal.ErrValidation, "error.code_digits", "the code must have {0} digits", "6"),
+			expectedStatus: fiber.StatusUnprocessableEntity,
+			expectedTitle:  "Entidad no procesable",
+			expectedDetail: "el código debe tener 6 dígitos",
+		},
+		{
+			name:           "it should keep english for an unsupported language",
+			acceptLanguage: "fr",
+			err:            problem.NotFound("user.not_found"),
+			expectedStatus: fiber.StatusNotFound,
+			expectedTitle:  "Not Found",
+			expectedDetail: "user not found",
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := fiber.New(fiber.Config{
+				ErrorHandler: problem.NewErrorHandler(utils.NewLoggerMock()),
+			})
+			app.Get("/", func(c *fiber.Ctx) error {
+				i18n.SetTranslator(c, translator.Negotiate(c.Get(fiber.HeaderAcceptLanguage)))
+				return tc.err
+			})
+
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			req.Header.Set(fiber.HeaderAcceptLanguage, tc.acceptLanguage)
+			resp, err := app.Test(req)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+
+			p := &problem.Problem{}
+			err = json.Unmarshal(body, p)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedTitle, p.Title)
+			assert.Equal(t, tc.expectedDetail, p.Detail)
+		})
+	}
+}
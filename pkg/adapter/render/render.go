@@ -0,0 +1,339 @@
+// Package render writes the responses and reads the bodies of the api in the format
+// negotiated with the client: json, messagepack or xml
+package render
+
+import (
+	"bytes"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/mitchellh/mapstructure"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	// FormatJSON is the default format of the api
+	FormatJSON = "json"
+	// FormatMessagePack is a compact binary format for embedded clients
+	FormatMessagePack = "msgpack"
+	// FormatXML is an xml document, its elements are named like the json fields
+	FormatXML = "xml"
+
+	// MIMEMessagePack is the media type of messagepack bodies
+	MIMEMessagePack = "application/msgpack"
+
+	// xmlRoot is the root element of an xml response
+	xmlRoot = "response"
+	// xmlItem is the element of every item of a list in xml
+	xmlItem = "item"
+
+	// wildcard is a media range that accepts every format
+	wildcard = "*"
+)
+
+// formats are the formats the api renders, in the order a wildcard picks them
+var formats = []string{FormatJSON, FormatMessagePack, FormatXML}
+
+// mediaTypes maps the media types of every format to it
+var mediaTypes = map[string]string{
+	fiber.MIMEApplicationJSON: FormatJSON,
+	MIMEMessagePack:           FormatMessagePack,
+	"application/x-msgpack":   FormatMessagePack,
+	"application/vnd.msgpack": FormatMessagePack,
+	fiber.MIMEApplicationXML:  FormatXML,
+	fiber.MIMETextXML:         FormatXML,
+	"*/*":                     wildcard,
+	"application/*":           wildcard,
+}
+
+// Negotiate picks the format of a response from an Accept header, the one of the highest quality
+// the api renders. An empty header gets json, and a header that accepts no format is a 406 problem
+func Negotiate(accept string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return FormatJSON, nil
+	}
+
+	type mediaRange struct {
+		format  string
+		quality float64
+	}
+	ranges := make([]mediaRange, 0)
+	refused := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		format := formatOf(mediaType)
+		if format == "" {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := params["q"]; ok {
+			quality, err = strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+		}
+		if quality <= 0 {
+			refused[format] = true
+			continue
+		}
+		ranges = append(ranges, mediaRange{format: format, quality: quality})
+	}
+
+	// the stable sort keeps the order of the header between ranges of the same quality
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].quality > ranges[j].quality
+	})
+	for _, r := range ranges {
+		if r.format != wildcard {
+			if !refused[r.format] {
+				return r.format, nil
+			}
+			continue
+		}
+		for _, format := range formats {
+			if !refused[format] {
+				return format, nil
+			}
+		}
+	}
+
+	return "", problem.New(fiber.StatusNotAcceptable, "", "request.not_acceptable")
+}
+
+// formatOf is the format of a media type, structured suffixes like +json included
+func formatOf(mediaType string) string {
+	mediaType = strings.ToLower(mediaType)
+	if format, ok := mediaTypes[mediaType]; ok {
+		return format
+	}
+
+	_, suffix, found := strings.Cut(mediaType, "+")
+	if !found {
+		return ""
+	}
+	switch suffix {
+	case FormatJSON:
+		return FormatJSON
+	case FormatMessagePack:
+		return FormatMessagePack
+	case FormatXML:
+		return FormatXML
+	default:
+		return ""
+	}
+}
+
+// Respond writes v with the status in the format negotiated with the Accept header of the request
+func Respond(ctx *fiber.Ctx, status int, v interface{}) error {
+	format, err := Negotiate(ctx.Get(fiber.HeaderAccept))
+	if err != nil {
+		return err
+	}
+	ctx.Vary(fiber.HeaderAccept)
+
+	switch format {
+	case FormatMessagePack:
+		body, err := MarshalMessagePack(v)
+		if err != nil {
+			return problem.Internal(err)
+		}
+		ctx.Set(fiber.HeaderContentType, MIMEMessagePack)
+		return ctx.Status(status).Send(body)
+	case FormatXML:
+		body, err := MarshalXML(v)
+		if err != nil {
+			return problem.Internal(err)
+		}
+		ctx.Set(fiber.HeaderContentType, fiber.MIMEApplicationXMLCharsetUTF8)
+		return ctx.Status(status).Send(body)
+	default:
+		return ctx.Status(status).JSON(v)
+	}
+}
+
+// Bind reads the body of the request into v in the format of its content type,
+// messagepack and xml are read here and every other type by the fiber body parser
+func Bind(ctx *fiber.Ctx, v interface{}) error {
+	mediaType, _, _ := mime.ParseMediaType(string(ctx.Request().Header.ContentType()))
+
+	switch formatOf(mediaType) {
+	case FormatMessagePack:
+		return UnmarshalMessagePack(ctx.Body(), v)
+	case FormatXML:
+		return UnmarshalXML(ctx.Body(), v)
+	default:
+		return ctx.BodyParser(v)
+	}
+}
+
+// MarshalMessagePack encodes v as messagepack, maps are keyed like the json fields
+func MarshalMessagePack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	if err := enc.Encode(v); err != nil {
+		return nil, fmt.Errorf("failed to encode messagepack: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalMessagePack decodes a messagepack body into v, reading the keys of the json fields
+func UnmarshalMessagePack(data []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	if err := dec.Decode(v); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "request.invalid_messagepack")
+	}
+	return nil
+}
+
+// MarshalXML encodes v as an xml document. v goes through json first, so the elements
+// are named like the json fields and the lists repeat an item element
+func MarshalXML(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode xml: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var generic interface{}
+	if err := dec.Decode(&generic); err != nil {
+		return nil, fmt.Errorf("failed to encode xml: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	enc := xml.NewEncoder(&buf)
+	if err := encodeXML(enc, xmlRoot, generic); err != nil {
+		return nil, fmt.Errorf("failed to encode xml: %w", err)
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to encode xml: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeXML(enc *xml.Encoder, name string, v interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			if err := encodeXML(enc, key, v[key]); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if err := encodeXML(enc, xmlItem, item); err != nil {
+				return err
+			}
+		}
+	case nil:
+	default:
+		if err := enc.EncodeToken(xml.CharData(fmt.Sprint(v))); err != nil {
+			return err
+		}
+	}
+
+	return enc.EncodeToken(start.End())
+}
+
+// UnmarshalXML decodes an xml body into v, the children of the root element are read
+// as the json fields of v and their text is converted to the type of each field
+func UnmarshalXML(data []byte, v interface{}) error {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+
+	var generic interface{}
+	for {
+		token, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			return fiber.NewError(fiber.StatusBadRequest, "request.empty_xml")
+		}
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "request.invalid_xml")
+		}
+		if _, ok := token.(xml.StartElement); ok {
+			generic, err = decodeXML(dec)
+			if err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "request.invalid_xml")
+			}
+			break
+		}
+	}
+	if _, ok := generic.(string); ok {
+		generic = map[string]interface{}{}
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		TagName:          "json",
+		WeaklyTypedInput: true,
+		Result:           v,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create xml decoder: %w", err)
+	}
+	if err := decoder.Decode(generic); err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "request.invalid_xml")
+	}
+	return nil
+}
+
+// decodeXML reads the element that just started, elements with children become maps,
+// repeated children become lists and the rest is their text
+func decodeXML(dec *xml.Decoder) (interface{}, error) {
+	children := make(map[string]interface{})
+	var text strings.Builder
+
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			child, err := decodeXML(dec)
+			if err != nil {
+				return nil, err
+			}
+			name := t.Name.Local
+			switch existing := children[name].(type) {
+			case nil:
+				children[name] = child
+			case []interface{}:
+				children[name] = append(existing, child)
+			default:
+				children[name] = []interface{}{existing, child}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			if len(children) > 0 {
+				return children, nil
+			}
+			return strings.TrimSpace(text.String()), nil
+		}
+	}
+}
@@ -0,0 +1,235 @@
+// Package render_test is a test for response rendering
+package render_test
+
+import (
+	"bytes"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/adapter/render"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type user struct {
+	ID    string `json:"uid"`
+	Email string `json:"email"`
+	Age   int    `json:"age"`
+}
+
+func TestNegotiate(test *testing.T) {
+	successfulCases := []struct {
+		name     string
+		accept   string
+		expected string
+	}{
+		{name: "it should default to json", accept: "", expected: render.FormatJSON},
+		{name: "it should pick json", accept: "application/json", expected: render.FormatJSON},
+		{name: "it should pick json for a wildcard", accept: "*/*", expected: render.FormatJSON},
+		{name: "it should pick messagepack", accept: "application/msgpack", expected: render.FormatMessagePack},
+		{name: "it should pick messagepack by its other names", accept: "application/x-msgpack", expected: render.FormatMessagePack},
+		{name: "it should pick xml", accept: "text/xml", expected: render.FormatXML},
+		{name: "it should pick a structured suffix", accept: "application/vnd.go-cleanapi.v2+msgpack", expected: render.FormatMessagePack},
+		{name: "it should pick the highest quality", accept: "application/json;q=0.5, application/xml", expected: render.FormatXML},
+		{name: "it should keep the order of equal qualities", accept: "application/msgpack, application/json", expected: render.FormatMessagePack},
+		{name: "it should skip unknown types", accept: "text/html, application/xml;q=0.1", expected: render.FormatXML},
+		{name: "it should skip refused formats for a wildcard", accept: "application/json;q=0, */*", expected: render.FormatMessagePack},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			format, err := render.Negotiate(tc.accept)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, format)
+		})
+	}
+
+	failedCases := []struct {
+		name   string
+		accept string
+	}{
+		{name: "it should not negotiate, unsupported type", accept: "text/html"},
+		{name: "it should not negotiate, every format refused", accept: "application/json;q=0, application/msgpack;q=0, application/xml;q=0, text/csv"},
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := render.Negotiate(tc.accept)
+			var p *problem.Problem
+			assert.True(t, errors.As(err, &p))
+			assert.Equal(t, fiber.StatusNotAcceptable, p.Status)
+			assert.Equal(t, problem.CodeNotAcceptable, p.Code)
+		})
+	}
+}
+
+func TestRespond(test *testing.T) {
+	body := fiber.Map{"data": []user{{ID: "im an id", Email: "test@test.com", Age: 30}}}
+
+	app := fiber.New(fiber.Config{ErrorHandler: problem.NewErrorHandler(nil)})
+	app.Get("/", func(ctx *fiber.Ctx) error {
+		return render.Respond(ctx, fiber.StatusOK, body)
+	})
+
+	successfulCases := []struct {
+		name        string
+		accept      string
+		contentType string
+		check       func(t *testing.T, data []byte)
+	}{
+		{
+			name:        "it should render json",
+			accept:      "application/json",
+			contentType: fiber.MIMEApplicationJSON,
+			check: func(t *testing.T, data []byte) {
+				assert.JSONEq(t, `{"data":[{"uid":"im an id","email":"test@test.com","age":30}]}`, string(data))
+			},
+		},
+		{
+			name:        "it should render messagepack",
+			accept:      "application/msgpack",
+			contentType: render.MIMEMessagePack,
+			check: func(t *testing.T, data []byte) {
+				var decoded struct {
+					Data []map[string]interface{} `msgpack:"data"`
+				}
+				assert.NoError(t, msgpack.Unmarshal(data, &decoded))
+				assert.Equal(t, "im an id", decoded.Data[0]["uid"])
+				assert.Equal(t, "test@test.com", decoded.Data[0]["email"])
+			},
+		},
+		{
+			name:        "it should render xml",
+			accept:      "application/xml",
+			contentType: fiber.MIMEApplicationXMLCharsetUTF8,
+			check: func(t *testing.T, data []byte) {
+				assert.Contains(t, string(data), "<response><data><item><age>30</age>"+
+					"<email>test@test.com</email><uid>im an id</uid></item></data></response>")
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+			req.Header.Set(fiber.HeaderAccept, tc.accept)
+			res, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, res.StatusCode)
+			assert.Equal(t, tc.contentType, res.Header.Get(fiber.HeaderContentType))
+			assert.Equal(t, fiber.HeaderAccept, res.Header.Get(fiber.HeaderVary))
+
+			data, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			tc.check(t, data)
+		})
+	}
+
+	test.Run("it should not render, unsupported type", func(t *testing.T) {
+		t.Parallel()
+
+		req := httptest.NewRequest(fiber.MethodGet, "/", nil)
+		req.Header.Set(fiber.HeaderAccept, "text/html")
+		res, err := app.Test(req, -1)
+		assert.NoError(t, err)
+		assert.Equal(t, fiber.StatusNotAcceptable, res.StatusCode)
+	})
+}
+
+func TestBind(test *testing.T) {
+	packed, err := msgpack.Marshal(map[string]interface{}{"uid": "im an id", "email": "test@test.com", "age": 30})
+	if err != nil {
+		test.Fatalf("an error '%s' was not expected when encoding messagepack", err)
+	}
+
+	successfulCases := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{
+			name:        "it should bind json",
+			contentType: fiber.MIMEApplicationJSON,
+			body:        []byte(`{"uid":"im an id","email":"test@test.com","age":30}`),
+		},
+		{
+			name:        "it should bind messagepack",
+			contentType: render.MIMEMessagePack,
+			body:        packed,
+		},
+		{
+			name:        "it should bind xml",
+			contentType: fiber.MIMEApplicationXMLCharsetUTF8,
+			body:        []byte(`<?xml version="1.0"?><user><uid>im an id</uid><email>test@test.com</email><age>30</age></user>`),
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var bound user
+			app := fiber.New()
+			app.Post("/", func(ctx *fiber.Ctx) error {
+				if err := render.Bind(ctx, &bound); err != nil {
+					return err
+				}
+				return ctx.SendStatus(fiber.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(fiber.MethodPost, "/", bytes.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, tc.contentType)
+			res, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusNoContent, res.StatusCode)
+			assert.Equal(t, user{ID: "im an id", Email: "test@test.com", Age: 30}, bound)
+		})
+	}
+
+	failedCases := []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{name: "it should not bind, invalid messagepack", contentType: render.MIMEMessagePack, body: []byte{0xc1}},
+		{name: "it should not bind, invalid xml", contentType: fiber.MIMEApplicationXML, body: []byte("<user><uid>")},
+		{name: "it should not bind, empty xml", contentType: fiber.MIMEApplicationXML, body: []byte{}},
+		{name: "it should not bind, xml of the wrong type", contentType: fiber.MIMEApplicationXML, body: []byte("<user><age>old</age></user>")},
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			app := fiber.New()
+			app.Post("/", func(ctx *fiber.Ctx) error {
+				var bound user
+				if err := render.Bind(ctx, &bound); err != nil {
+					return err
+				}
+				return ctx.SendStatus(fiber.StatusNoContent)
+			})
+
+			req := httptest.NewRequest(fiber.MethodPost, "/", bytes.NewReader(tc.body))
+			req.Header.Set(fiber.HeaderContentType, tc.contentType)
+			res, err := app.Test(req, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusBadRequest, res.StatusCode)
+		})
+	}
+}
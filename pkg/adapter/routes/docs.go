@@ -0,0 +1,94 @@
+//go:build !coverage
+// +build !coverage
+
+package routes
+
+import (
+	"dall06/go-cleanapi/config"
+	"encoding/json"
+	"fmt"
+
+	// docs are generated by Swag CLI, you have to import them.
+	"dall06/go-cleanapi/docs"
+
+	"github.com/swaggo/swag"
+)
+
+// legacyPaths are the v1 paths of the users routes that v2 moved, by their v2 path and method
+var legacyPaths = map[string]map[string]string{
+	"/users": {
+		"get":  "/users/all",
+		"post": "/users/signup",
+	},
+	"/users/{id}": {
+		"put":    "/users/modify/{id}",
+		"patch":  "/users/modify/{id}",
+		"delete": "/users/delete/{id}",
+	},
+}
+
+// versionDocs is the swagger document of an api version
+type versionDocs string
+
+func (d versionDocs) ReadDoc() string {
+	return string(d)
+}
+
+// registerDocs registers the swagger document of the version and returns its instance name.
+// The generated document describes the latest version, v1 gets its legacy paths back and
+// the operations of a deprecated version are marked as deprecated
+func (routes *routes) registerDocs(version string, basePath string) string {
+	spec := make(map[string]interface{})
+	if err := json.Unmarshal([]byte(docs.SwaggerInfo.ReadDoc()), &spec); err != nil {
+		panic(fmt.Errorf("invalid swagger document: %w", err))
+	}
+	spec["basePath"] = basePath
+	if info, ok := spec["info"].(map[string]interface{}); ok {
+		info["version"] = version
+	}
+
+	paths, _ := spec["paths"].(map[string]interface{})
+	if version == config.APIv1 {
+		for path, methods := range legacyPaths {
+			operations, ok := paths[path].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for method, legacyPath := range methods {
+				operation, ok := operations[method]
+				if !ok {
+					continue
+				}
+				legacy, ok := paths[legacyPath].(map[string]interface{})
+				if !ok {
+					legacy = make(map[string]interface{})
+					paths[legacyPath] = legacy
+				}
+				legacy[method] = operation
+				delete(operations, method)
+			}
+			if len(operations) == 0 {
+				delete(paths, path)
+			}
+		}
+	}
+
+	if _, deprecated := routes.config.APIDeprecations[version]; deprecated {
+		for _, operations := range paths {
+			for _, operation := range operations.(map[string]interface{}) {
+				if op, ok := operation.(map[string]interface{}); ok {
+					op["deprecated"] = true
+				}
+			}
+		}
+	}
+
+	doc, err := json.Marshal(spec)
+	if err != nil {
+		panic(fmt.Errorf("invalid swagger document: %w", err))
+	}
+
+	name := fmt.Sprintf("%s_%s", docs.SwaggerInfo.InstanceName(), version)
+	swag.Register(name, versionDocs(doc))
+	return name
+}
@@ -9,13 +9,13 @@ package routes
 import (
 	"dall06/go-cleanapi/config"
 	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/adapter/graph"
+	"dall06/go-cleanapi/pkg/infrastructure/middleware"
 	"fmt"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/swagger" // swagger handler
-
-	// docs are generated by Swag CLI, you have to import them.
-	_ "dall06/go-cleanapi/docs"
 )
 
 // Routes is an interface that extends routes
@@ -29,32 +29,113 @@ type routes struct {
 	app        *fiber.App
 	config     config.Vars
 	controller controller.Controller
+	graphql    graph.GraphQL
+	middleware middleware.Middleware
 }
 
 // NewRoutes is a constructor for routes generator
-func NewRoutes(app *fiber.App, vars config.Vars, ctrl controller.Controller) Routes {
+func NewRoutes(app *fiber.App, vars config.Vars, ctrl controller.Controller, gql graph.GraphQL, mw middleware.Middleware) Routes {
 	return &routes{
 		app:        app,
 		config:     vars,
 		controller: ctrl,
+		graphql:    gql,
+		middleware: mw,
 	}
 }
 
 func (routes *routes) Set() {
-	basePath := routes.config.APIBasePath
+	for _, version := range config.APIVersions {
+		routes.setVersion(version)
+	}
+
+	// graphql is not versioned, its schema evolves by adding fields
+	graphqlPath := fmt.Sprintf("%s/graphql", routes.config.APIBasePath)
+	routes.app.Get(graphqlPath, routes.limits("graphql", routes.graphql.Handle)...).Name("graphql")
+	routes.app.Post(graphqlPath, routes.limits("graphql", routes.graphql.Handle)...).Name("graphql")
+}
+
+// setVersion sets the routes of an api version, both share their handlers
+// but v2 serves the users crud at resource paths
+func (routes *routes) setVersion(version string) {
+	basePath := fmt.Sprintf("%s/%s", routes.config.APIRootPath, version)
 
 	swaggerPath := fmt.Sprintf("%s/swagger/*", basePath)
-	routes.app.Get(swaggerPath, swagger.HandlerDefault)
+	routes.app.Get(swaggerPath, swagger.New(swagger.Config{InstanceName: routes.registerDocs(version, basePath)}))
 
 	usersPath := fmt.Sprintf("%s/users", basePath)
 	usersGroup := routes.app.Group(usersPath)
 	usersGroup.Get("/hello", func(c *fiber.Ctx) error {
 		return c.SendString("welcome to go-cleanapi user path ...")
 	})
-	usersGroup.Post("/auth", routes.controller.Auth)
-	usersGroup.Post("/signup", routes.controller.Post)
-	usersGroup.Get("/:id", routes.controller.Get)
-	usersGroup.Get("/all", routes.controller.GetAll)
-	usersGroup.Put("/modify/:id", routes.controller.Put)
-	usersGroup.Delete("/delete/:id", routes.controller.Delete)
+	usersGroup.Post("/auth", routes.limits("auth", routes.controller.Auth)...).Name("auth")
+	usersGroup.Post("/auth/mfa", routes.limits("auth_mfa", routes.controller.AuthMFA)...).Name("auth_mfa")
+	usersGroup.Post("/refresh", routes.limits("refresh", routes.controller.Refresh)...).Name("refresh")
+	usersGroup.Post("/logout", routes.limits("logout", routes.controller.Logout)...).Name("logout")
+	usersGroup.Post("/logout/all", routes.limits("logout_all", routes.controller.LogoutAll)...).Name("logout_all")
+	// the export is an admin route, it is set before /:id so it is not read as a user id
+	export := append([]fiber.Handler{routes.middleware.AdminKey()}, routes.limits("export", routes.controller.Export)...)
+	usersGroup.Get("/export", export...).Name("export")
+	events := append([]fiber.Handler{routes.middleware.AdminKey()}, routes.limits("events", routes.controller.Events)...)
+	usersGroup.Get("/events", events...).Name("events")
+	usersGroup.Put("/password", routes.limits("password", routes.controller.ChangePassword)...).Name("password")
+	usersGroup.Post("/password/forgot", routes.limits("password_forgot", routes.controller.ForgotPassword)...).Name("password_forgot")
+	usersGroup.Post("/password/reset", routes.limits("password_reset", routes.controller.ResetPassword)...).Name("password_reset")
+	usersGroup.Get("/email/verify", routes.limits("email_verify", routes.controller.VerifyEmail)...).Name("email_verify")
+	usersGroup.Post("/email/verify/resend", routes.limits("email_resend", routes.controller.ResendVerification)...).Name("email_resend")
+	usersGroup.Post("/phone/verify", routes.limits("phone_verify", routes.controller.RequestPhoneCode)...).Name("phone_verify")
+	usersGroup.Post("/phone/verify/confirm", routes.limits("phone_confirm", routes.controller.ConfirmPhoneCode)...).Name("phone_confirm")
+	usersGroup.Post("/mfa/totp", routes.limits("totp_enroll", routes.controller.EnrollTOTP)...).Name("totp_enroll")
+	usersGroup.Post("/mfa/totp/confirm", routes.limits("totp_confirm", routes.controller.ConfirmTOTP)...).Name("totp_confirm")
+	usersGroup.Delete("/mfa/totp", routes.limits("totp_disable", routes.controller.DisableTOTP)...).Name("totp_disable")
+	// admin routes check the admin key before anything else
+	unlock := append([]fiber.Handler{routes.middleware.AdminKey()}, routes.limits("unlock", routes.controller.Unlock)...)
+	usersGroup.Post("/lockouts/unlock", unlock...).Name("unlock")
+	// the import streams its body, it has no body limit and runs for the import timeout
+	bulkImport := []fiber.Handler{routes.middleware.AdminKey(), routes.middleware.Timeout(routes.timeout("import", routes.config.ImportTimeout)), routes.controller.Import}
+	usersGroup.Post("/import", bulkImport...).Name("import")
+	search := append([]fiber.Handler{routes.middleware.AdminKey()}, routes.limits("search", routes.controller.Search)...)
+	usersGroup.Get("/search", search...).Name("search")
+
+	// the users crud goes last, so its /:id routes do not shadow the static ones,
+	// and its writes need the ETag of the user in every version
+	ifMatch := routes.middleware.RequireIfMatch()
+	switch version {
+	case config.APIv1:
+		usersGroup.Post("/signup", routes.limits("signup", routes.controller.Post)...).Name("signup")
+		usersGroup.Get("/all", routes.limits("all", routes.controller.GetAll)...).Name("all")
+		usersGroup.Get("/:id", routes.limits("get", routes.controller.Get)...).Name("get")
+		usersGroup.Put("/modify/:id", append([]fiber.Handler{ifMatch}, routes.limits("modify", routes.controller.Put)...)...).Name("modify")
+		usersGroup.Patch("/modify/:id", append([]fiber.Handler{ifMatch}, routes.limits("patch", routes.controller.Patch)...)...).Name("patch")
+		usersGroup.Delete("/delete/:id", append([]fiber.Handler{ifMatch}, routes.limits("delete", routes.controller.Delete)...)...).Name("delete")
+	default:
+		usersGroup.Post("", routes.limits("signup", routes.controller.Post)...).Name("signup")
+		usersGroup.Get("", routes.limits("all", routes.controller.GetAll)...).Name("all")
+		usersGroup.Get("/:id", routes.limits("get", routes.controller.Get)...).Name("get")
+		usersGroup.Put("/:id", append([]fiber.Handler{ifMatch}, routes.limits("modify", routes.controller.Put)...)...).Name("modify")
+		usersGroup.Patch("/:id", append([]fiber.Handler{ifMatch}, routes.limits("patch", routes.controller.Patch)...)...).Name("patch")
+		usersGroup.Delete("/:id", append([]fiber.Handler{ifMatch}, routes.limits("delete", routes.controller.Delete)...)...).Name("delete")
+	}
+}
+
+// limits prepends the body limit and timeout handlers of the route name,
+// falling back to the global ones when the route has no override
+func (routes *routes) limits(name string, handler fiber.Handler) []fiber.Handler {
+	timeout := routes.timeout(name, routes.config.HandlerTimeout)
+
+	handlers := make([]fiber.Handler, 0)
+	if limit, ok := routes.config.RouteBodyLimits[name]; ok {
+		handlers = append(handlers, routes.middleware.BodyLimit(limit))
+	}
+	handlers = append(handlers, routes.middleware.Timeout(timeout), handler)
+
+	return handlers
+}
+
+// timeout is the timeout of the route name, fallback when the route has no override
+func (routes *routes) timeout(name string, fallback time.Duration) time.Duration {
+	if timeout, ok := routes.config.RouteTimeouts[name]; ok {
+		return timeout
+	}
+	return fallback
 }
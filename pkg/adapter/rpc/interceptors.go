@@ -0,0 +1,110 @@
+package rpc
+
+import (
+	"context"
+	"dall06/go-cleanapi/utils"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// tokenHeader is the metadata of the api token, the header of the rest api
+	tokenHeader  = "x-access-token"
+	missingToken = "missing api token"
+	invalidToken = "invalid api token"
+	panicked     = "the request could not be processed"
+)
+
+// publicServices are called without the api token, probes can not send it
+var publicServices = []string{grpc_health_v1.Health_ServiceDesc.ServiceName}
+
+// interceptors check the api token of every call and log the processed ones
+type interceptors struct {
+	jwt    utils.JWT
+	logger utils.Logger
+}
+
+func (i *interceptors) unary(
+	ctx context.Context,
+	req interface{},
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler,
+) (res interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			i.logger.Error("grpc method[%s] -> %s: %v", info.FullMethod, internalError, r)
+			err = status.Error(codes.Internal, panicked)
+		}
+	}()
+
+	if err := i.authenticate(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	res, err = handler(ctx, req)
+	if err == nil {
+		i.logger.Info("grpc method[%s] -> %s: %s", info.FullMethod, processed, caller(ctx))
+	}
+	return res, err
+}
+
+func (i *interceptors) stream(
+	srv interface{},
+	ss grpc.ServerStream,
+	info *grpc.StreamServerInfo,
+	handler grpc.StreamHandler,
+) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			i.logger.Error("grpc method[%s] -> %s: %v", info.FullMethod, internalError, r)
+			err = status.Error(codes.Internal, panicked)
+		}
+	}()
+
+	if err := i.authenticate(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	err = handler(srv, ss)
+	if err == nil {
+		i.logger.Info("grpc method[%s] -> %s: %s", info.FullMethod, processed, caller(ss.Context()))
+	}
+	return err
+}
+
+// authenticate checks the api token of the call, like the key auth of the rest api
+func (i *interceptors) authenticate(ctx context.Context, method string) error {
+	for _, service := range publicServices {
+		if strings.HasPrefix(method, "/"+service+"/") {
+			return nil
+		}
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(tokenHeader)
+	if len(tokens) == 0 || tokens[0] == "" {
+		i.logger.Error("grpc method[%s] -> %s: %s", method, requestError, missingToken)
+		return status.Error(codes.Unauthenticated, missingToken)
+	}
+
+	ok, err := i.jwt.CheckAPIJWT(tokens[0])
+	if err != nil || !ok {
+		i.logger.Error("grpc method[%s] -> %s: %s %v", method, requestError, invalidToken, err)
+		return status.Error(codes.Unauthenticated, invalidToken)
+	}
+	return nil
+}
+
+// caller is the address of the peer of the call
+func caller(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return p.Addr.String()
+	}
+	return ""
+}
@@ -0,0 +1,253 @@
+// Package rpc serves the user usecases over grpc to internal services. The calls are
+// authenticated with the api token instead of the session cookie and csrf token of the
+// rest api, and every call resolves through the same usecases and validations
+//
+//go:generate protoc -I proto --go_out=userspb --go_opt=paths=source_relative --go-grpc_out=userspb --go-grpc_opt=paths=source_relative users.proto
+package rpc
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/adapter/rpc/userspb"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"errors"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	processed     = "request processed"
+	requestError  = "request error"
+	internalError = "internal error"
+	invalidUser   = "invalid user format"
+	missingID     = "missing id parameter"
+	// noVersion is the key of the message of the writes without the version of the user
+	noVersion = "request.version_required"
+)
+
+var _ userspb.UserServiceServer = (*userService)(nil)
+
+type userService struct {
+	userspb.UnimplementedUserServiceServer
+
+	usecases    usecases.UseCases
+	lockouts    usecases.Lockouts
+	validate    validator.Validate
+	validations utils.Validations
+	logger      utils.Logger
+}
+
+// NewUserService is a constructor for the grpc user service
+func NewUserService(
+	uc usecases.UseCases,
+	lo usecases.Lockouts,
+	v validator.Validate,
+	vs utils.Validations,
+	l utils.Logger,
+) userspb.UserServiceServer {
+	return &userService{
+		usecases:    uc,
+		lockouts:    lo,
+		validate:    v,
+		validations: vs,
+		logger:      l,
+	}
+}
+
+func (s *userService) Register(ctx context.Context, in *userspb.RegisterRequest) (*emptypb.Empty, error) {
+	req := &controller.PostRequest{
+		Email:    in.GetEmail(),
+		Phone:    in.GetPhone(),
+		Password: in.GetPassword(),
+	}
+	if err := s.validate.Struct(req); err != nil {
+		return nil, s.fail("Register", problem.Validation(err))
+	}
+
+	err := s.usecases.RegisterUser(ctx, &controller.User{
+		Email:    req.Email,
+		Phone:    req.Phone,
+		Password: req.Password,
+	})
+	if err != nil {
+		return nil, s.fail("Register", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// Authenticate checks the credentials like the login of the rest api, without a session.
+// The caller is a service and not the user, so only the failures of the account are counted
+func (s *userService) Authenticate(ctx context.Context, in *userspb.AuthenticateRequest) (*userspb.AuthenticateResponse, error) {
+	req := &controller.AuthRequest{
+		UserName: in.GetUser(),
+		Password: in.GetPassword(),
+	}
+	if err := s.validate.Struct(req); err != nil {
+		return nil, s.fail("Authenticate", problem.Validation(err))
+	}
+
+	userInput := &controller.User{Password: req.Password}
+	switch {
+	case s.validations.IsEmail(req.UserName):
+		userInput.Email = req.UserName
+	case s.validations.IsPhone(req.UserName):
+		userInput.Phone = req.UserName
+	default:
+		return nil, s.fail("Authenticate", problem.BadRequest(invalidUser))
+	}
+
+	if err := s.lockouts.Check(ctx, req.UserName, ""); err != nil {
+		return nil, s.fail("Authenticate", err)
+	}
+
+	res, err := s.usecases.AuthUser(ctx, userInput)
+	if errors.Is(err, internal.ErrUnauthorized) {
+		if err := s.lockouts.Fail(ctx, req.UserName, ""); err != nil {
+			s.logger.Error("grpc method[Authenticate] -> %s: %s", internalError, err)
+		}
+	}
+	if err != nil {
+		return nil, s.fail("Authenticate", err)
+	}
+	if err := s.lockouts.Succeed(ctx, req.UserName); err != nil {
+		s.logger.Error("grpc method[Authenticate] -> %s: %s", internalError, err)
+	}
+
+	return &userspb.AuthenticateResponse{
+		User:     toUser(res),
+		MfaToken: res.MFAToken,
+	}, nil
+}
+
+func (s *userService) Get(ctx context.Context, in *userspb.GetRequest) (*userspb.User, error) {
+	if in.GetUid() == "" {
+		return nil, s.fail("Get", problem.BadRequest(missingID))
+	}
+
+	res, err := s.usecases.IndexUserByID(ctx, &controller.User{ID: in.GetUid()})
+	if err != nil {
+		return nil, s.fail("Get", err)
+	}
+	return toUser(res), nil
+}
+
+func (s *userService) List(ctx context.Context, in *userspb.ListRequest) (*userspb.ListResponse, error) {
+	req := &controller.ListRequest{
+		Limit:       int(in.GetLimit()),
+		Offset:      int(in.GetOffset()),
+		Cursor:      in.GetCursor(),
+		Sort:        in.GetSort(),
+		Email:       in.GetEmail(),
+		Phone:       in.GetPhone(),
+		CreatedFrom: in.GetCreatedFrom(),
+		CreatedTo:   in.GetCreatedTo(),
+	}
+	if err := s.validate.Struct(req); err != nil {
+		return nil, s.fail("List", problem.Validation(err))
+	}
+
+	page, err := s.usecases.IndexUsers(ctx, req)
+	if err != nil {
+		return nil, s.fail("List", err)
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = usecases.DefaultPageSize
+	}
+	res := &userspb.ListResponse{
+		Users:      make([]*userspb.User, 0, len(page.Users)),
+		Total:      int64(page.Total),
+		Limit:      int32(limit),
+		Offset:     int32(req.Offset),
+		NextCursor: page.NextCursor,
+	}
+	for _, user := range page.Users {
+		res.Users = append(res.Users, toUser(user))
+	}
+	return res, nil
+}
+
+func (s *userService) Update(ctx context.Context, in *userspb.UpdateRequest) (*userspb.User, error) {
+	if in.GetUid() == "" {
+		return nil, s.fail("Update", problem.BadRequest(missingID))
+	}
+	if in.GetVersion() <= 0 {
+		return nil, s.fail("Update", problem.New(fiber.StatusPreconditionRequired, "", noVersion))
+	}
+	req := &controller.PatchRequest{
+		Email: in.Email,
+		Phone: in.Phone,
+	}
+	if err := s.validate.Struct(req); err != nil {
+		return nil, s.fail("Update", problem.Validation(err))
+	}
+
+	err := s.usecases.ModifyUser(ctx, &controller.UserPatch{
+		ID:      in.GetUid(),
+		Email:   req.Email,
+		Phone:   req.Phone,
+		Version: int(in.GetVersion()),
+	})
+	if err != nil {
+		return nil, s.fail("Update", err)
+	}
+
+	res, err := s.usecases.IndexUserByID(ctx, &controller.User{ID: in.GetUid()})
+	if err != nil {
+		return nil, s.fail("Update", err)
+	}
+	return toUser(res), nil
+}
+
+func (s *userService) Delete(ctx context.Context, in *userspb.DeleteRequest) (*emptypb.Empty, error) {
+	if in.GetUid() == "" {
+		return nil, s.fail("Delete", problem.BadRequest(missingID))
+	}
+	if in.GetVersion() <= 0 {
+		return nil, s.fail("Delete", problem.New(fiber.StatusPreconditionRequired, "", noVersion))
+	}
+	req := &controller.DeleteRequest{Password: in.GetPassword()}
+	if err := s.validate.Struct(req); err != nil {
+		return nil, s.fail("Delete", problem.Validation(err))
+	}
+
+	err := s.usecases.DestroyUser(ctx, &controller.User{
+		ID:       in.GetUid(),
+		Password: req.Password,
+		Version:  int(in.GetVersion()),
+	})
+	if err != nil {
+		return nil, s.fail("Delete", err)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+// fail logs the error of a method and converts it to the status of the problem the rest api would answer
+func (s *userService) fail(method string, err error) error {
+	p := problem.From(err)
+	s.logger.Error("grpc method[%s] -> %s: %s", method, requestError, p.Error())
+	return toStatus(p).Err()
+}
+
+// toUser is the message of a user, without its password
+func toUser(user *internal.User) *userspb.User {
+	res := &userspb.User{
+		Uid:           user.ID,
+		Email:         user.Email,
+		Phone:         user.Phone,
+		EmailVerified: user.EmailVerified,
+		PhoneVerified: user.PhoneVerified,
+		Version:       int64(user.Version),
+	}
+	if user.CreatedAt != nil {
+		res.CreatedAt = timestamppb.New(*user.CreatedAt)
+	}
+	return res
+}
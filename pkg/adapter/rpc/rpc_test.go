@@ -0,0 +1,354 @@
+// Package rpc_test is a test for the grpc user service
+package rpc_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/adapter/rpc"
+	"dall06/go-cleanapi/pkg/adapter/rpc/userspb"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"database/sql"
+	"errors"
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	spCreate = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
+	spRead   = "CALL `go_cleanapi`.`sp_read_user`(?);"
+	spUpdate = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
+	spDelete = "CALL `go_cleanapi`.`sp_delete_user`(?, ?, ?);"
+	spLogin  = "CALL `go_cleanapi`.`sp_login_user`(?, ?, ?);"
+	qCount   = "SELECT COUNT(*) FROM `go_cleanapi`.`users`"
+	qList    = "SELECT `id_user`, `user_email`, `user_phone`, `created_at`"
+)
+
+// dial serves the user service on a buffer and connects to it
+func dial(t *testing.T, db *sql.DB) *grpc.ClientConn {
+	t.Helper()
+
+	uc := usecases.NewUseCases(repository.NewRepository(db), utils.NewUUIDMock(), false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+	lockouts := usecases.NewLockouts(repository.NewMemoryLoginFailures(), 5, 20, time.Minute, time.Hour, time.Hour)
+	service := rpc.NewUserService(uc, lockouts, *validator.New(), utils.NewValidations(), utils.NewLoggerMock())
+	server := rpc.NewServer(service, utils.NewJWTMock(), utils.NewLoggerMock())
+
+	listener := bufconn.Listen(1024 * 1024)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when dialing the server", err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	return conn
+}
+
+// withToken is a context with the api token in the metadata
+func withToken() context.Context {
+	return metadata.AppendToOutgoingContext(context.Background(), "x-access-token", "im_a_token")
+}
+
+func userRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id_user", "user_email", "user_phone", "email_verified", "phone_verified", "version"}).
+		AddRow("im_an_id", "test@test.com", "+991234567890", true, false, 3)
+}
+
+func TestUserService(test *testing.T) {
+	createdAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	email := "new@test.com"
+
+	successfulCases := []struct {
+		name     string
+		call     func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error)
+		mock     func(m sqlmock.Sqlmock)
+		expected proto.Message
+	}{
+		{
+			name: "it should register a user (mocked)",
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Register(ctx, &userspb.RegisterRequest{Email: "test@test.com", Password: "12345pAsSWORd*"})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).
+					WithArgs(sqlmock.AnyArg(), "test@test.com", sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expected: nil,
+		},
+		{
+			name: "it should authenticate a user (mocked)",
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Authenticate(ctx, &userspb.AuthenticateRequest{User: "test@test.com", Password: "12345pAsSWORd*"})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spLogin)).
+					WithArgs("test@test.com", "", "12345pAsSWORd*").
+					WillReturnRows(sqlmock.NewRows([]string{"id_user", "email_verified", "totp_enabled"}).AddRow("im_an_id", true, false))
+			},
+			expected: &userspb.AuthenticateResponse{User: &userspb.User{Uid: "im_an_id", EmailVerified: true}},
+		},
+		{
+			name: "it should authenticate a user with two-factor auth (mocked)",
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Authenticate(ctx, &userspb.AuthenticateRequest{User: "+991234567890", Password: "12345pAsSWORd*"})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spLogin)).
+					WithArgs("", "+991234567890", "12345pAsSWORd*").
+					WillReturnRows(sqlmock.NewRows([]string{"id_user", "email_verified", "totp_enabled"}).AddRow("im_an_id", false, true))
+			},
+			expected: &userspb.AuthenticateResponse{User: &userspb.User{Uid: "im_an_id"}, MfaToken: "im_an_id"},
+		},
+		{
+			name: "it should get a user (mocked)",
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Get(ctx, &userspb.GetRequest{Uid: "im_an_id"})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spRead)).WithArgs("im_an_id").WillReturnRows(userRows())
+			},
+			expected: &userspb.User{Uid: "im_an_id", Email: "test@test.com", Phone: "+991234567890", EmailVerified: true, Version: 3},
+		},
+		{
+			name: "it should list users (mocked)",
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.List(ctx, &userspb.ListRequest{Limit: 1, Email: "test"})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(qCount)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+				m.ExpectQuery(regexp.QuoteMeta(qList)).WithArgs("test%", 2).WillReturnRows(
+					sqlmock.NewRows([]string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}).
+						AddRow("im_an_id", "test@test.com", "+991234567890", createdAt, true, false))
+			},
+			expected: &userspb.ListResponse{
+				Users: []*userspb.User{{Uid: "im_an_id", Email: "test@test.com", Phone: "+991234567890", EmailVerified: true,
+					CreatedAt: timestamppb.New(createdAt)}},
+				Total: 1,
+				Limit: 1,
+			},
+		},
+		{
+			name: "it should update a user (mocked), leaving out the fields not sent",
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Update(ctx, &userspb.UpdateRequest{Uid: "im_an_id", Email: &email, Version: 2})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdate)).
+					WithArgs("im_an_id", 2, "new@test.com", nil).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectQuery(regexp.QuoteMeta(spRead)).WithArgs("im_an_id").WillReturnRows(userRows())
+			},
+			expected: &userspb.User{Uid: "im_an_id", Email: "test@test.com", Phone: "+991234567890", EmailVerified: true, Version: 3},
+		},
+		{
+			name: "it should delete a user (mocked)",
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Delete(ctx, &userspb.DeleteRequest{Uid: "im_an_id", Password: "12345pAsSWORd*", Version: 3})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spDelete)).
+					WithArgs("im_an_id", "12345pAsSWORd*", 3).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			expected: nil,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.mock(m)
+
+			res, err := tc.call(withToken(), userspb.NewUserServiceClient(dial(t, db)))
+			assert.NoError(t, err)
+			if tc.expected != nil {
+				assert.True(t, proto.Equal(tc.expected, res), "expected %v, but got %v", tc.expected, res)
+			}
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	failedCases := []struct {
+		name     string
+		ctx      context.Context
+		call     func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error)
+		mock     func(m sqlmock.Sqlmock)
+		code     codes.Code
+		reason   string
+		violated string
+	}{
+		{
+			name: "it should not get a user, no api token",
+			ctx:  context.Background(),
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Get(ctx, &userspb.GetRequest{Uid: "im_an_id"})
+			},
+			mock: func(m sqlmock.Sqlmock) {},
+			code: codes.Unauthenticated,
+		},
+		{
+			name: "it should not register a user, invalid email",
+			ctx:  withToken(),
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Register(ctx, &userspb.RegisterRequest{Email: "not an email", Password: "12345pAsSWORd*"})
+			},
+			mock:     func(m sqlmock.Sqlmock) {},
+			code:     codes.InvalidArgument,
+			reason:   "validation_failed",
+			violated: "Email",
+		},
+		{
+			name: "it should not authenticate a user, wrong credentials (mocked)",
+			ctx:  withToken(),
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Authenticate(ctx, &userspb.AuthenticateRequest{User: "test@test.com", Password: "wrong"})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spLogin)).WillReturnError(sql.ErrNoRows)
+			},
+			code:   codes.Unauthenticated,
+			reason: "unauthorized",
+		},
+		{
+			name: "it should not authenticate a user, invalid user format",
+			ctx:  withToken(),
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Authenticate(ctx, &userspb.AuthenticateRequest{User: "someone", Password: "12345pAsSWORd*"})
+			},
+			mock:   func(m sqlmock.Sqlmock) {},
+			code:   codes.InvalidArgument,
+			reason: "bad_request",
+		},
+		{
+			name: "it should not get a user, unknown id (mocked)",
+			ctx:  withToken(),
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Get(ctx, &userspb.GetRequest{Uid: "im_not_an_id"})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spRead)).WithArgs("im_not_an_id").WillReturnError(sql.ErrNoRows)
+			},
+			code:   codes.NotFound,
+			reason: "not_found",
+		},
+		{
+			name: "it should not list users, invalid sort",
+			ctx:  withToken(),
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.List(ctx, &userspb.ListRequest{Sort: "password"})
+			},
+			mock:     func(m sqlmock.Sqlmock) {},
+			code:     codes.InvalidArgument,
+			reason:   "validation_failed",
+			violated: "Sort",
+		},
+		{
+			name: "it should not update a user, no id",
+			ctx:  withToken(),
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Update(ctx, &userspb.UpdateRequest{Email: &email})
+			},
+			mock:   func(m sqlmock.Sqlmock) {},
+			code:   codes.InvalidArgument,
+			reason: "bad_request",
+		},
+		{
+			name: "it should not update a user, no version",
+			ctx:  withToken(),
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Update(ctx, &userspb.UpdateRequest{Uid: "im_an_id", Email: &email})
+			},
+			mock:   func(m sqlmock.Sqlmock) {},
+			code:   codes.FailedPrecondition,
+			reason: "precondition_required",
+		},
+		{
+			name: "it should not delete a user, database failure (mocked)",
+			ctx:  withToken(),
+			call: func(ctx context.Context, c userspb.UserServiceClient) (proto.Message, error) {
+				return c.Delete(ctx, &userspb.DeleteRequest{Uid: "im_an_id", Password: "12345pAsSWORd*", Version: 3})
+			},
+			mock: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spDelete)).WillReturnError(errors.New("connection refused"))
+			},
+			code:   codes.Internal,
+			reason: "internal_error",
+		},
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.mock(m)
+
+			_, err = tc.call(tc.ctx, userspb.NewUserServiceClient(dial(t, db)))
+			st := status.Convert(err)
+			assert.Equal(t, tc.code, st.Code())
+
+			reason, violated := "", ""
+			for _, detail := range st.Details() {
+				switch d := detail.(type) {
+				case *errdetails.ErrorInfo:
+					reason = d.Reason
+				case *errdetails.BadRequest:
+					violated = d.FieldViolations[0].Field
+				}
+			}
+			assert.Equal(t, tc.reason, reason)
+			assert.Equal(t, tc.violated, violated)
+		})
+	}
+}
+
+func TestHealth(test *testing.T) {
+	test.Run("it should report the user service as serving, without an api token", func(t *testing.T) {
+		db, _, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		res, err := grpc_health_v1.NewHealthClient(dial(t, db)).Check(context.Background(),
+			&grpc_health_v1.HealthCheckRequest{Service: userspb.UserService_ServiceDesc.ServiceName})
+		assert.NoError(t, err)
+		assert.Equal(t, grpc_health_v1.HealthCheckResponse_SERVING, res.GetStatus())
+	})
+}
@@ -0,0 +1,59 @@
+package rpc
+
+import (
+	"dall06/go-cleanapi/pkg/adapter/rpc/userspb"
+	"dall06/go-cleanapi/utils"
+	"net"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// Server is the grpc server of the user service, along with the health and reflection services
+type Server interface {
+	// Serve accepts calls on the listener until the server is stopped
+	Serve(l net.Listener) error
+	// Stop reports the services as not serving and waits for the running calls to finish
+	Stop()
+}
+
+var _ Server = (*server)(nil)
+
+type server struct {
+	grpc   *grpc.Server
+	health *health.Server
+}
+
+// NewServer is a constructor for the grpc server, every call but the health checks needs the api token
+func NewServer(us userspb.UserServiceServer, j utils.JWT, l utils.Logger) Server {
+	i := &interceptors{
+		jwt:    j,
+		logger: l,
+	}
+	s := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(i.unary),
+		grpc.ChainStreamInterceptor(i.stream),
+	)
+
+	userspb.RegisterUserServiceServer(s, us)
+	h := health.NewServer()
+	h.SetServingStatus(userspb.UserService_ServiceDesc.ServiceName, grpc_health_v1.HealthCheckResponse_SERVING)
+	grpc_health_v1.RegisterHealthServer(s, h)
+	reflection.Register(s)
+
+	return &server{
+		grpc:   s,
+		health: h,
+	}
+}
+
+func (s *server) Serve(l net.Listener) error {
+	return s.grpc.Serve(l)
+}
+
+func (s *server) Stop() {
+	s.health.Shutdown()
+	s.grpc.GracefulStop()
+}
@@ -0,0 +1,58 @@
+package rpc
+
+import (
+	"dall06/go-cleanapi/pkg/adapter/problem"
+
+	"github.com/gofiber/fiber/v2"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// errorDomain is the domain of the error info of every status
+const errorDomain = "go-cleanapi"
+
+// statusCodes maps the http statuses of the problems to grpc codes, the rest are internal
+var statusCodes = map[int]codes.Code{
+	fiber.StatusBadRequest:           codes.InvalidArgument,
+	fiber.StatusUnauthorized:         codes.Unauthenticated,
+	fiber.StatusForbidden:            codes.PermissionDenied,
+	fiber.StatusNotFound:             codes.NotFound,
+	fiber.StatusConflict:             codes.AlreadyExists,
+	fiber.StatusPreconditionFailed:   codes.FailedPrecondition,
+	fiber.StatusPreconditionRequired: codes.FailedPrecondition,
+	fiber.StatusUnprocessableEntity:  codes.InvalidArgument,
+	fiber.StatusTooManyRequests:      codes.ResourceExhausted,
+	fiber.StatusServiceUnavailable:   codes.Unavailable,
+}
+
+// toStatus converts a problem to a grpc status. Its stable code goes in the reason of the error
+// info and the fields that failed validation in a bad request, so clients read them like the
+// ones of a problem
+func toStatus(p *problem.Problem) *status.Status {
+	code, ok := statusCodes[p.Status]
+	if !ok {
+		code = codes.Internal
+	}
+
+	details := []protoadapt.MessageV1{&errdetails.ErrorInfo{Reason: p.Code, Domain: errorDomain}}
+	if len(p.Errors) > 0 {
+		violations := make([]*errdetails.BadRequest_FieldViolation, 0, len(p.Errors))
+		for _, fe := range p.Errors {
+			violations = append(violations, &errdetails.BadRequest_FieldViolation{
+				Field:       fe.Field,
+				Description: fe.Message,
+			})
+		}
+		details = append(details, &errdetails.BadRequest{FieldViolations: violations})
+	}
+
+	st := status.New(code, p.Detail)
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		// the details are well known messages, they always marshal
+		return st
+	}
+	return withDetails
+}
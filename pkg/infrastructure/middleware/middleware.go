@@ -6,9 +6,20 @@
 package middleware
 
 import (
+	"context"
+	"crypto/subtle"
 	"dall06/go-cleanapi/config"
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"dall06/go-cleanapi/pkg/adapter/problem"
+	"dall06/go-cleanapi/pkg/adapter/render"
+	"dall06/go-cleanapi/pkg/internal/usecases"
 	"dall06/go-cleanapi/utils"
+	"errors"
 	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
@@ -20,11 +31,19 @@ import (
 	"github.com/gofiber/fiber/v2/middleware/etag"
 	"github.com/gofiber/fiber/v2/middleware/idempotency"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	fiberutils "github.com/gofiber/fiber/v2/utils"
 	"github.com/gofiber/helmet/v2"
 	jwtware "github.com/gofiber/jwt/v3"
 	"github.com/gofiber/keyauth/v2"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
+const tracerName = "dall06/go-cleanapi/pkg/infrastructure/middleware"
+
 // Middleware is an interface that extends middleware
 type Middleware interface {
 	CORS() fiber.Handler
@@ -37,29 +56,43 @@ type Middleware interface {
 	KeyAuth() fiber.Handler
 	CRSF() fiber.Handler
 	Idempotency() fiber.Handler
+	Tracing() fiber.Handler
+	RequestID() fiber.Handler
+	BodyLimit(limit int) fiber.Handler
+	StreamBody() fiber.Handler
+	Timeout(timeout time.Duration) fiber.Handler
+	AdminKey() fiber.Handler
+	Version() fiber.Handler
+	ContentNegotiation() fiber.Handler
+	RequireIfMatch() fiber.Handler
+	Language() fiber.Handler
 }
 
 var _ Middleware = (*middleware)(nil)
 
 type middleware struct {
-	jwt    utils.JWT
-	config config.Vars
+	jwt        utils.JWT
+	sessions   usecases.Sessions
+	translator i18n.Translator
+	config     config.Vars
 }
 
 // NewMiddleware is a constructor for middleware
-func NewMiddleware(vars config.Vars, jr utils.JWT) Middleware {
+func NewMiddleware(vars config.Vars, jr utils.JWT, s usecases.Sessions, t i18n.Translator) Middleware {
 	return &middleware{
-		jwt:    jr,
-		config: vars,
+		jwt:        jr,
+		sessions:   s,
+		translator: t,
+		config:     vars,
 	}
 }
 
 func (*middleware) CORS() fiber.Handler {
 	cfg := &cors.Config{
 		AllowOrigins:  "*",
-		AllowHeaders:  "Origin,Content-Type,Accept,X-Session-Token,X-Application-Key",
-		AllowMethods:  "GET,POST,PUT,DELETE",
-		ExposeHeaders: "Content-Length,Authorization",
+		AllowHeaders:  "Origin,Content-Type,Accept,If-Match,If-None-Match,X-Session-Token,X-Application-Key,Traceparent,Tracestate",
+		AllowMethods:  "GET,POST,PUT,PATCH,DELETE",
+		ExposeHeaders: "Content-Length,Authorization,ETag,Traceparent,X-Request-ID",
 		MaxAge:        5600,
 	}
 	return cors.New(*cfg)
@@ -73,10 +106,11 @@ func (*middleware) Helmet() fiber.Handler {
 	return helmet.New(cfg)
 }
 
-func (*middleware) Compress() fiber.Handler {
+func (m *middleware) Compress() fiber.Handler {
 	cfg := compress.Config{
 		Next: func(c *fiber.Ctx) bool {
-			return c.Method() != fiber.MethodGet
+			// a compressed event stream would hold the events back until a block is full
+			return c.Method() != fiber.MethodGet || c.Path() == m.basePath(c)+"/users/events"
 		},
 		Level: compress.LevelBestSpeed, // 1
 	}
@@ -90,10 +124,12 @@ func (m *middleware) EncryptCookie() fiber.Handler {
 	return encryptcookie.New(cfg)
 }
 
-func (*middleware) ETag() fiber.Handler {
+func (m *middleware) ETag() fiber.Handler {
 	cfg := etag.Config{
 		Next: func(c *fiber.Ctx) bool {
-			return c.Method() != fiber.MethodGet
+			// hashing a streamed export or event stream would read it whole into memory
+			return c.Method() != fiber.MethodGet ||
+				c.Path() == m.basePath(c)+"/users/export" || c.Path() == m.basePath(c)+"/users/events"
 		},
 		Weak: true,
 	}
@@ -109,21 +145,47 @@ func (m *middleware) JwtWare() fiber.Handler {
 		SigningKey:  m.config.JWTSecret,
 		TokenLookup: "cookie:session_id",
 		Filter: func(c *fiber.Ctx) bool {
-			basePath := m.config.APIBasePath
+			basePath := m.basePath(c)
 
 			swaggerPath := fmt.Sprintf("%s/swagger/", basePath)
 
 			usersPath := fmt.Sprintf("%s/users", basePath)
 			authPath := fmt.Sprintf("%s/auth", usersPath)
+			mfaPath := fmt.Sprintf("%s/auth/mfa", usersPath)
 			signupPath := fmt.Sprintf("%s/signup", usersPath)
+			refreshPath := fmt.Sprintf("%s/refresh", usersPath)
+			forgotPath := fmt.Sprintf("%s/password/forgot", usersPath)
+			resetPath := fmt.Sprintf("%s/password/reset", usersPath)
+			verifyPath := fmt.Sprintf("%s/email/verify", usersPath)
+			resendPath := fmt.Sprintf("%s/email/verify/resend", usersPath)
+			unlockPath := fmt.Sprintf("%s/lockouts/unlock", usersPath)
+			importPath := fmt.Sprintf("%s/import", usersPath)
+			exportPath := fmt.Sprintf("%s/export", usersPath)
+			eventsPath := fmt.Sprintf("%s/events", usersPath)
+			searchPath := fmt.Sprintf("%s/search", usersPath)
 
 			if c.Path() == swaggerPath {
 				return true
 			}
-			if c.Path() == authPath {
+			// admin routes are guarded by the admin key, not by a user session
+			if c.Path() == unlockPath || c.Path() == importPath || c.Path() == exportPath || c.Path() == searchPath ||
+				c.Path() == eventsPath {
+				return true
+			}
+			if c.Path() == authPath || c.Path() == mfaPath {
+				return true
+			}
+			if c.Path() == refreshPath {
+				return true
+			}
+			if c.Path() == forgotPath || c.Path() == resetPath {
 				return true
 			}
-			if c.Path() == signupPath {
+			if c.Path() == verifyPath || c.Path() == resendPath {
+				return true
+			}
+			// v2 signs up with a post to the users collection
+			if c.Path() == signupPath || (c.Method() == fiber.MethodPost && c.Path() == usersPath) {
 				return true
 			}
 			// Exclude all subroutes of /swagger
@@ -133,6 +195,30 @@ func (m *middleware) JwtWare() fiber.Handler {
 
 			return false
 		},
+		// the errors are problems in the language of the request, like every other error
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			if err.Error() == "Missing or malformed JWT" {
+				return problem.BadRequest("session.missing_jwt")
+			}
+			return problem.Unauthorized("session.invalid_jwt")
+		},
+		// a valid signature is not enough, the session must not be revoked
+		SuccessHandler: func(c *fiber.Ctx) error {
+			token, err := m.jwt.ParseUserJWT(c.Cookies("session_id"))
+			if err != nil {
+				return fiber.NewError(fiber.StatusUnauthorized, "session.invalid_jwt")
+			}
+
+			revoked, err := m.sessions.IsSessionRevoked(c.UserContext(), token)
+			if err != nil {
+				return err
+			}
+			if revoked {
+				return fiber.NewError(fiber.StatusUnauthorized, "session.revoked")
+			}
+
+			return c.Next()
+		},
 	}
 
 	return jwtware.New(cfg)
@@ -144,22 +230,41 @@ func (m *middleware) KeyAuth() fiber.Handler {
 		Validator: func(c *fiber.Ctx, jwts string) (bool, error) {
 			return m.jwt.CheckAPIJWT(jwts)
 		},
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return problem.Unauthorized("session.invalid_api_key")
+		},
 		Filter: func(c *fiber.Ctx) bool {
-			basePath := m.config.APIBasePath
+			basePath := m.basePath(c)
 
 			swaggerPath := fmt.Sprintf("%s/swagger/", basePath)
 
 			usersPath := fmt.Sprintf("%s/users", basePath)
 			authPath := fmt.Sprintf("%s/auth", usersPath)
+			mfaPath := fmt.Sprintf("%s/auth/mfa", usersPath)
 			signupPath := fmt.Sprintf("%s/signup", usersPath)
+			refreshPath := fmt.Sprintf("%s/refresh", usersPath)
+			forgotPath := fmt.Sprintf("%s/password/forgot", usersPath)
+			resetPath := fmt.Sprintf("%s/password/reset", usersPath)
+			verifyPath := fmt.Sprintf("%s/email/verify", usersPath)
+			resendPath := fmt.Sprintf("%s/email/verify/resend", usersPath)
 
 			if c.Path() == swaggerPath {
 				return true
 			}
-			if c.Path() == authPath {
+			if c.Path() == authPath || c.Path() == mfaPath {
+				return true
+			}
+			if c.Path() == refreshPath {
+				return true
+			}
+			if c.Path() == forgotPath || c.Path() == resetPath {
+				return true
+			}
+			if c.Path() == verifyPath || c.Path() == resendPath {
 				return true
 			}
-			if c.Path() == signupPath {
+			// v2 signs up with a post to the users collection
+			if c.Path() == signupPath || (c.Method() == fiber.MethodPost && c.Path() == usersPath) {
 				return true
 			}
 			// Exclude all subroutes of /swagger
@@ -184,3 +289,335 @@ func (*middleware) CRSF() fiber.Handler {
 func (*middleware) Idempotency() fiber.Handler {
 	return idempotency.New()
 }
+
+func (*middleware) Tracing() fiber.Handler {
+	tracer := otel.Tracer(tracerName)
+
+	return func(c *fiber.Ctx) error {
+		propagator := otel.GetTextMapPropagator()
+		ctx := propagator.Extract(c.UserContext(), headerCarrier{ctx: c})
+
+		spanName := fmt.Sprintf("%s %s", c.Method(), c.Path())
+		ctx, span := tracer.Start(ctx, spanName,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethod(c.Method()),
+				semconv.HTTPTarget(string(c.Request().RequestURI())),
+				semconv.HTTPScheme(c.Protocol()),
+				semconv.NetHostName(c.Hostname()),
+				semconv.HTTPUserAgent(c.Get(fiber.HeaderUserAgent)),
+			))
+		defer span.End()
+
+		c.SetUserContext(ctx)
+		propagator.Inject(ctx, headerCarrier{ctx: c})
+
+		err := c.Next()
+
+		// the route is only known once the router matched the request
+		span.SetName(fmt.Sprintf("%s %s", c.Method(), c.Route().Path))
+		span.SetAttributes(semconv.HTTPRoute(c.Route().Path))
+
+		status := c.Response().StatusCode()
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+		span.SetAttributes(semconv.HTTPStatusCode(status))
+		if err != nil {
+			span.RecordError(err)
+		}
+		if status >= fiber.StatusInternalServerError {
+			span.SetStatus(codes.Error, fmt.Sprintf("status code %d", status))
+		}
+
+		return err
+	}
+}
+
+// RequestID reuses the X-Request-ID of the client or generates one, it is echoed in the response
+func (*middleware) RequestID() fiber.Handler {
+	return requestid.New()
+}
+
+// BodyLimit rejects bodies bigger than limit. The server streams request bodies, so a streamed
+// body is read here up to the limit and the handlers get it whole, a rejected one closes the
+// connection because the rest of the body is never read
+func (*middleware) BodyLimit(limit int) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if limit <= 0 {
+			return c.Next()
+		}
+
+		if stream := c.Context().RequestBodyStream(); stream != nil {
+			if c.Request().Header.ContentLength() > limit {
+				c.Context().SetConnectionClose()
+				return fiber.ErrRequestEntityTooLarge
+			}
+			body, err := io.ReadAll(io.LimitReader(stream, int64(limit)+1))
+			if err != nil {
+				c.Context().SetConnectionClose()
+				return fiber.ErrBadRequest
+			}
+			if len(body) > limit {
+				c.Context().SetConnectionClose()
+				return fiber.ErrRequestEntityTooLarge
+			}
+			c.Request().SetBodyRaw(body)
+		}
+
+		if len(c.Body()) > limit {
+			return fiber.ErrRequestEntityTooLarge
+		}
+		return c.Next()
+	}
+}
+
+// StreamBody reads the body of every request up to the global body limit, before any handler
+// needs it. Imports are the exception, they read their streamed body themselves without limit,
+// and their connection is closed after the answer since the body they leave unread can not be
+// followed by another request
+func (m *middleware) StreamBody() fiber.Handler {
+	bodyLimit := m.BodyLimit(m.config.BodyLimit)
+	root := m.config.APIRootPath + "/"
+
+	return func(c *fiber.Ctx) error {
+		if c.Method() == fiber.MethodPost && strings.HasPrefix(c.Path(), root) && strings.HasSuffix(c.Path(), "/users/import") {
+			c.Context().SetConnectionClose()
+			return c.Next()
+		}
+		return bodyLimit(c)
+	}
+}
+
+// Timeout sets a deadline on the user context, so db calls done by the handler are cancelled
+func (*middleware) Timeout(timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if timeout <= 0 {
+			return c.Next()
+		}
+
+		ctx, cancel := context.WithTimeout(c.UserContext(), timeout)
+		defer cancel()
+		c.SetUserContext(ctx)
+
+		// a handler that answered the timeout itself keeps its answer
+		err := c.Next()
+		if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fiber.NewError(fiber.StatusServiceUnavailable, "request.timeout")
+		}
+
+		return err
+	}
+}
+
+// AdminKey lets through the requests whose x-admin-key header is the admin key,
+// admin routes do not exist while the key is not set
+func (m *middleware) AdminKey() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if m.config.AdminKey == "" {
+			return fiber.ErrNotFound
+		}
+		if subtle.ConstantTimeCompare([]byte(c.Get("x-admin-key")), []byte(m.config.AdminKey)) != 1 {
+			return fiber.ErrForbidden
+		}
+		return c.Next()
+	}
+}
+
+// versionKey is the local of the api version of the request
+const versionKey = "api_version"
+
+// Version picks the api version of the request, from its path or else from its Accept header,
+// and routes the request to that version. Deprecated versions answer with the Deprecation
+// and Sunset headers, and once their sunset passed they are gone
+func (m *middleware) Version() fiber.Handler {
+	root := m.config.APIRootPath + "/"
+	latest := config.APIVersions[len(config.APIVersions)-1]
+
+	return func(c *fiber.Ctx) error {
+		if !strings.HasPrefix(c.Path(), root) {
+			return c.Next()
+		}
+
+		// the path is rewritten below, so it is copied out of the request buffer
+		rest := strings.TrimPrefix(fiberutils.CopyString(c.Path()), root)
+		version, tail, _ := strings.Cut(rest, "/")
+		if major, ok := legacyVersion(version); ok {
+			// the first clients name the release, like v1.0.0, they are served by its major version
+			if !config.IsAPIVersion(major) {
+				return fiber.ErrNotFound
+			}
+			version = major
+			c.Path(root + version + "/" + tail)
+		}
+		if !config.IsAPIVersion(version) {
+			if isVersionSegment(version) {
+				return fiber.ErrNotFound
+			}
+
+			// the path names no version, the Accept header may
+			negotiated, err := m.negotiateVersion(c.Get(fiber.HeaderAccept))
+			if err != nil {
+				return err
+			}
+			version = negotiated
+			c.Path(root + version + "/" + rest)
+			c.Vary(fiber.HeaderAccept)
+		}
+
+		c.Locals(versionKey, version)
+		c.Set("API-Version", version)
+
+		if sunset, ok := m.config.APISunsets[version]; ok {
+			c.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			if !time.Now().Before(sunset) {
+				return problem.New(fiber.StatusGone, "", "request.api_gone").With(version, latest)
+			}
+		}
+		if deprecation, ok := m.config.APIDeprecations[version]; ok {
+			c.Set("Deprecation", fmt.Sprintf("@%d", deprecation.Unix()))
+			c.Append(fiber.HeaderLink, fmt.Sprintf(`<%s%s>; rel="successor-version"`, root, latest))
+		}
+
+		return c.Next()
+	}
+}
+
+// negotiateVersion reads the version asked by an Accept header, as a vendor media type like
+// application/vnd.<project>.v2+json or as a version parameter like application/json; version=2.
+// An Accept header without a version gets the default one
+func (m *middleware) negotiateVersion(accept string) (string, error) {
+	vendor := fmt.Sprintf("application/vnd.%s.", m.config.ProyectName)
+
+	for _, mediaRange := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(mediaRange))
+		if err != nil {
+			continue
+		}
+
+		version := params["version"]
+		if strings.HasPrefix(mediaType, vendor) {
+			// the structured suffix is the format of the response, like +json or +msgpack
+			version, _, _ = strings.Cut(strings.TrimPrefix(mediaType, vendor), "+")
+		}
+		if version == "" {
+			continue
+		}
+
+		version = "v" + strings.TrimPrefix(strings.ToLower(version), "v")
+		if !config.IsAPIVersion(version) {
+			return "", problem.New(fiber.StatusNotAcceptable, "", "request.api_unknown").
+				With(version, strings.Join(config.APIVersions, ", "))
+		}
+		return version, nil
+	}
+
+	return m.config.APIDefaultVersion, nil
+}
+
+// ContentNegotiation answers 406 to the api requests whose Accept header takes none of the
+// formats the api renders, before their handler runs. The docs, the export and the events are
+// left out, they are html, csv or ndjson and an event stream
+func (m *middleware) ContentNegotiation() fiber.Handler {
+	root := m.config.APIRootPath + "/"
+
+	return func(c *fiber.Ctx) error {
+		if !strings.HasPrefix(c.Path(), root) {
+			return c.Next()
+		}
+
+		basePath := m.basePath(c)
+		switch {
+		case strings.HasPrefix(c.Path(), basePath+"/swagger/"),
+			c.Path() == basePath+"/users/export",
+			c.Path() == basePath+"/users/events",
+			c.Path() == basePath+"/users/hello":
+			return c.Next()
+		}
+
+		if _, err := render.Negotiate(c.Get(fiber.HeaderAccept)); err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
+
+// RequireIfMatch answers 428 to the writes without an If-Match header, so a client cannot
+// overwrite a user it did not read. The handler compares the ETag with the stored version
+func (*middleware) RequireIfMatch() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if strings.TrimSpace(c.Get(fiber.HeaderIfMatch)) == "" {
+			return fiber.NewError(fiber.StatusPreconditionRequired, "request.if_match_required")
+		}
+		return c.Next()
+	}
+}
+
+// isVersionSegment tells whether a path segment names a version, like v3
+func isVersionSegment(segment string) bool {
+	if len(segment) < 2 || segment[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(segment[1:])
+	return err == nil
+}
+
+// legacyVersion is the major version of a path segment naming a release, like v1.0.0 or v1.2
+func legacyVersion(segment string) (string, bool) {
+	if len(segment) < 2 || segment[0] != 'v' {
+		return "", false
+	}
+	parts := strings.Split(segment[1:], ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return "", false
+	}
+	for _, part := range parts {
+		if _, err := strconv.Atoi(part); err != nil {
+			return "", false
+		}
+	}
+	return "v" + parts[0], true
+}
+
+// basePath is the base path of the api version of the request
+func (m *middleware) basePath(c *fiber.Ctx) string {
+	version, ok := c.Locals(versionKey).(string)
+	if !ok {
+		version = m.config.APIDefaultVersion
+	}
+	return fmt.Sprintf("%s/%s", m.config.APIRootPath, version)
+}
+
+// headerCarrier adapts fiber headers to a propagation.TextMapCarrier,
+// it reads from the request and writes into the response
+type headerCarrier struct {
+	ctx *fiber.Ctx
+}
+
+func (h headerCarrier) Get(key string) string {
+	return h.ctx.Get(key)
+}
+
+func (h headerCarrier) Set(key string, value string) {
+	h.ctx.Set(key, value)
+}
+
+func (h headerCarrier) Keys() []string {
+	keys := make([]string, 0)
+	h.ctx.Request().Header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// Language negotiates the language of the response with the Accept-Language header, the messages
+// of the request are translated to it and the response says which one it got
+func (m *middleware) Language() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		trans := m.translator.Negotiate(c.Get(fiber.HeaderAcceptLanguage))
+		i18n.SetTranslator(c, trans)
+		c.Set(fiber.HeaderContentLanguage, trans.Locale())
+		c.Vary(fiber.HeaderAcceptLanguage)
+		return c.Next()
+	}
+}
@@ -0,0 +1,165 @@
+//go:build !coverage
+// +build !coverage
+
+package middleware_test
+
+import (
+	"dall06/go-cleanapi/config"
+	"dall06/go-cleanapi/pkg/infrastructure/middleware"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersion(test *testing.T) {
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+
+	successfulCases := []struct {
+		name            string
+		path            string
+		expectedVersion string
+	}{
+		{
+			name:            "it should serve a version of the path",
+			path:            "/v1/users/hello",
+			expectedVersion: config.APIv1,
+		},
+		{
+			name:            "it should serve a legacy release path with its major version",
+			path:            "/v1.0.0/users/hello",
+			expectedVersion: config.APIv1,
+		},
+		{
+			name:            "it should serve a legacy minor release path with its major version",
+			path:            "/v2.1/users/hello",
+			expectedVersion: config.APIv2,
+		},
+	}
+
+	failedCases := []struct {
+		name           string
+		path           string
+		expectedStatus int
+	}{
+		{
+			name:           "it should not serve a version that does not exist",
+			path:           "/v3/users/hello",
+			expectedStatus: fiber.StatusNotFound,
+		},
+		{
+			name:           "it should not serve a legacy release of a version that does not exist",
+			path:           "/v3.0.0/users/hello",
+			expectedStatus: fiber.StatusNotFound,
+		},
+	}
+
+	app := fiber.New()
+	app.Use(middleware.NewMiddleware(*vars, nil, nil, nil).Version())
+	for _, version := range config.APIVersions {
+		version := version
+		app.Get(vars.APIRootPath+"/"+version+"/users/hello", func(c *fiber.Ctx) error {
+			return c.SendString(version)
+		})
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, vars.APIRootPath+tc.path, nil))
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+			assert.Equal(t, tc.expectedVersion, resp.Header.Get("API-Version"))
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedVersion, string(body))
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodGet, vars.APIRootPath+tc.path, nil))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+}
+
+func TestBodyLimit(test *testing.T) {
+	cfg := config.NewConfig("8080", "1.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatalf("expected no error but got %v", err)
+	}
+
+	successfulCases := []struct {
+		name string
+		body string
+	}{
+		{
+			name: "it should give the handler a streamed body under the limit",
+			body: "12345678",
+		},
+		{
+			name: "it should give the handler an empty body",
+			body: "",
+		},
+	}
+
+	failedCases := []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{
+			name:           "it should not read a streamed body over the limit",
+			body:           "123456789",
+			expectedStatus: fiber.StatusRequestEntityTooLarge,
+		},
+	}
+
+	app := fiber.New(fiber.Config{StreamRequestBody: true})
+	app.Use(middleware.NewMiddleware(*vars, nil, nil, nil).BodyLimit(8))
+	app.Post("/", func(c *fiber.Ctx) error {
+		return c.Send(c.Body())
+	})
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/", strings.NewReader(tc.body)))
+			assert.NoError(t, err)
+			assert.Equal(t, fiber.StatusOK, resp.StatusCode)
+
+			body, err := io.ReadAll(resp.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.body, string(body))
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			resp, err := app.Test(httptest.NewRequest(fiber.MethodPost, "/", strings.NewReader(tc.body)))
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedStatus, resp.StatusCode)
+		})
+	}
+}
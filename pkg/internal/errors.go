@@ -0,0 +1,92 @@
+package internal
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Kinds of business errors, use errors.Is to check them
+var (
+	// ErrNotFound means the requested actor does not exist
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the request collides with the current state, like a duplicated email
+	ErrConflict = errors.New("conflict")
+	// ErrUnauthorized means the credentials are missing or wrong
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden means the credentials are right but not allowed to do the action
+	ErrForbidden = errors.New("forbidden")
+	// ErrValidation means the input breaks a business rule
+	ErrValidation = errors.New("validation failed")
+	// ErrTooManyRequests means the action was repeated too soon and must wait
+	ErrTooManyRequests = errors.New("too many requests")
+	// ErrPreconditionFailed means the actor changed since the client read it
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Error is a business error, its message is safe to be shown to clients
+// while the cause is kept for logs
+type Error struct {
+	kind    error
+	message string
+	cause   error
+	// key and params are the message in the catalog of the adapters, for messages
+	// that are not the same text for every request
+	key    string
+	params []string
+}
+
+var _ error = (*Error)(nil)
+
+// NewError is a constructor for a business error of the given kind
+func NewError(kind error, message string, cause error) error {
+	return &Error{
+		kind:    kind,
+		message: message,
+		cause:   cause,
+	}
+}
+
+// NewKeyedError is a constructor for a business error whose message has params, the key names it
+// in the message catalog and the params replace the {0}, {1}... placeholders of the english message
+func NewKeyedError(kind error, key string, message string, params ...string) error {
+	for i, param := range params {
+		message = strings.ReplaceAll(message, "{"+strconv.Itoa(i)+"}", param)
+	}
+	return &Error{
+		kind:    kind,
+		message: message,
+		key:     key,
+		params:  params,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.cause != nil {
+		return e.message + ": " + e.cause.Error()
+	}
+	return e.message
+}
+
+// Message returns the client safe message of the error
+func (e *Error) Message() string {
+	return e.message
+}
+
+// Key returns the key of the message in the catalog, it is empty when the message is its own key
+func (e *Error) Key() string {
+	return e.key
+}
+
+// Params returns the params of the message in the catalog
+func (e *Error) Params() []string {
+	return e.params
+}
+
+// Unwrap returns the kind and the cause, so both match errors.Is
+func (e *Error) Unwrap() []error {
+	if e.cause == nil {
+		return []error{e.kind}
+	}
+	return []error{e.kind, e.cause}
+}
@@ -0,0 +1,28 @@
+package internal
+
+import "time"
+
+// Types of the changes in the lifecycle of a user
+const (
+	// UserCreated is the event of a signup
+	UserCreated = "user.created"
+	// UserUpdated is the event of a change of the email or the phone of a user
+	UserUpdated = "user.updated"
+	// UserDeleted is the event of a deleted user
+	UserDeleted = "user.deleted"
+)
+
+// UserEventTypes are the types of the user events, in their lifecycle order
+var UserEventTypes = []string{UserCreated, UserUpdated, UserDeleted}
+
+// UserEvent is a change in the lifecycle of a user, it names the user but carries
+// none of its data. Every event has a greater ID than the ones before it
+type UserEvent struct {
+	ID         int64
+	Type       string
+	UID        string
+	OccurredAt time.Time
+}
+
+// UserEvents is an array type of UserEvent
+type UserEvents []*UserEvent
@@ -0,0 +1,36 @@
+package internal
+
+import "unicode"
+
+const (
+	// PasswordMinLength is the min number of characters of a password
+	PasswordMinLength = 8
+	// PasswordMaxLength is the max number of characters of a password, the stored procedures take up to 64
+	PasswordMaxLength = 64
+)
+
+// CheckPasswordPolicy returns a validation error when the password is too weak,
+// it must have a lower case letter, an upper case letter and a digit
+func CheckPasswordPolicy(password string) error {
+	length := len([]rune(password))
+	if length < PasswordMinLength || length > PasswordMaxLength {
+		return NewError(ErrValidation, "password must have between 8 and 64 characters", nil)
+	}
+
+	var lower, upper, digit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		}
+	}
+	if !lower || !upper || !digit {
+		return NewError(ErrValidation, "password must have a lower case letter, an upper case letter and a digit", nil)
+	}
+
+	return nil
+}
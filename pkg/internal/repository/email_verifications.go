@@ -0,0 +1,72 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+const (
+	spReadEmailVerification = "CALL `go_cleanapi`.`sp_read_email_verification`(?);"
+	spVerifyUserEmail       = "CALL `go_cleanapi`.`sp_verify_user_email`(?);"
+)
+
+// EmailVerifications is an interface that extends the store of the email verification state
+type EmailVerifications interface {
+	// IsPending reports whether a user has the email and has not verified it yet
+	IsPending(ctx context.Context, email string) (bool, error)
+	// Verify marks the email as verified, verifying it twice is not an error
+	Verify(ctx context.Context, email string) error
+}
+
+var _ EmailVerifications = (*emailVerifications)(nil)
+
+type emailVerifications struct {
+	dbConn *sql.DB
+}
+
+// NewEmailVerifications is a constructor for the email verification store
+func NewEmailVerifications(db *sql.DB) EmailVerifications {
+	return &emailVerifications{
+		dbConn: db,
+	}
+}
+
+func (r *emailVerifications) IsPending(ctx context.Context, email string) (bool, error) {
+	if email == "" {
+		return false, invalid("email is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_read_email_verification", spReadEmailVerification)
+	defer span.End()
+
+	var pending bool
+	err := r.dbConn.QueryRowContext(ctx, spReadEmailVerification, email).Scan(&pending)
+	if errors.Is(err, sql.ErrNoRows) {
+		// nobody has the email, there is nothing to verify
+		return false, nil
+	}
+	if err != nil {
+		recordError(span, err)
+		return false, mapError(err)
+	}
+
+	return pending, nil
+}
+
+func (r *emailVerifications) Verify(ctx context.Context, email string) error {
+	if email == "" {
+		return invalid("email is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_verify_user_email", spVerifyUserEmail)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spVerifyUserEmail, email)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spReadEmailVerification = "CALL `go_cleanapi`.`sp_read_email_verification`(?);"
+	spVerifyUserEmail       = "CALL `go_cleanapi`.`sp_verify_user_email`(?);"
+)
+
+func TestEmailVerifications(test *testing.T) {
+	userNotFound := &mysql.MySQLError{Number: 1644, Message: "user not found"}
+	copy(userNotFound.SQLState[:], "40400")
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.EmailVerifications) (interface{}, error)
+		expected interface{}
+	}{
+		{
+			name: "it should tell a pending verification (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadEmailVerification)).
+					WithArgs("test@test.com").
+					WillReturnRows(sqlmock.NewRows([]string{"pending"}).AddRow(true))
+			},
+			call: func(r repository.EmailVerifications) (interface{}, error) {
+				return r.IsPending(context.Background(), "test@test.com")
+			},
+			expected: true,
+		},
+		{
+			name: "it should tell a verified email is not pending (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadEmailVerification)).
+					WithArgs("test@test.com").
+					WillReturnRows(sqlmock.NewRows([]string{"pending"}).AddRow(false))
+			},
+			call: func(r repository.EmailVerifications) (interface{}, error) {
+				return r.IsPending(context.Background(), "test@test.com")
+			},
+			expected: false,
+		},
+		{
+			name: "it should tell an unknown email is not pending (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadEmailVerification)).
+					WithArgs("unknown@test.com").
+					WillReturnRows(sqlmock.NewRows([]string{"pending"}))
+			},
+			call: func(r repository.EmailVerifications) (interface{}, error) {
+				return r.IsPending(context.Background(), "unknown@test.com")
+			},
+			expected: false,
+		},
+		{
+			name: "it should verify an email (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spVerifyUserEmail)).
+					WithArgs("test@test.com").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.EmailVerifications) (interface{}, error) {
+				return nil, r.Verify(context.Background(), "test@test.com")
+			},
+			expected: nil,
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.EmailVerifications) error
+		expected error
+	}{
+		{
+			name:   "it should not read a verification, empty email",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.EmailVerifications) error {
+				_, err := r.IsPending(context.Background(), "")
+				return err
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name:   "it should not verify an email, empty email",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.EmailVerifications) error {
+				return r.Verify(context.Background(), "")
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not verify an email, unknown email (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spVerifyUserEmail)).WillReturnError(userNotFound)
+			},
+			call: func(r repository.EmailVerifications) error {
+				return r.Verify(context.Background(), "unknown@test.com")
+			},
+			expected: internal.ErrNotFound,
+		},
+		{
+			name: "it should not read a verification, db error (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadEmailVerification)).WillReturnError(errors.New("connection refused"))
+			},
+			call: func(r repository.EmailVerifications) error {
+				_, err := r.IsPending(context.Background(), "test@test.com")
+				return err
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewEmailVerifications(db)
+			res, err := tc.call(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewEmailVerifications(db)
+			err = tc.call(r)
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
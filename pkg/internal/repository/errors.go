@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// mysql error numbers, https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+	errDuplicateEntry  = 1062
+	errBadNull         = 1048
+	errDataTooLong     = 1406
+	errNoReferencedRow = 1452
+	errSignalException = 1644
+)
+
+// sqlStates maps the SQLSTATEs raised with SIGNAL in the stored procedures
+var sqlStates = map[string]error{
+	"40100": internal.ErrUnauthorized,
+	"40300": internal.ErrForbidden,
+	"40400": internal.ErrNotFound,
+	"40900": internal.ErrConflict,
+	"41200": internal.ErrPreconditionFailed,
+	"42200": internal.ErrValidation,
+	"42900": internal.ErrTooManyRequests,
+}
+
+// invalid is a validation error of the repository input
+func invalid(message string) error {
+	return internal.NewError(internal.ErrValidation, message, nil)
+}
+
+// mapError translates driver errors into business errors, unknown ones are only wrapped
+func mapError(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return internal.NewError(internal.ErrNotFound, "user not found", err)
+	}
+
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) {
+		return fmt.Errorf("failed to execute SQL statement: %w", err)
+	}
+
+	switch me.Number {
+	case errDuplicateEntry:
+		return internal.NewError(internal.ErrConflict, "user already exists", err)
+	case errBadNull, errDataTooLong, errNoReferencedRow:
+		return internal.NewError(internal.ErrValidation, "invalid user data", err)
+	case errSignalException:
+		if kind, ok := sqlStates[string(me.SQLState[:])]; ok {
+			return internal.NewError(kind, me.Message, err)
+		}
+	}
+
+	return fmt.Errorf("failed to execute SQL statement: %w", err)
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Exports is an interface that extends the store of user exports
+type Exports interface {
+	// OpenUsers opens a cursor over every user matching the filter, paging fields are ignored.
+	// Users are read from the database as the cursor moves, so a whole table never sits in memory
+	OpenUsers(ctx context.Context, filter *internal.UsersFilter) (UsersCursor, error)
+}
+
+// UsersCursor walks the users of an export one by one, it must be closed
+type UsersCursor interface {
+	// Next moves to the next user, it is false at the end of the users or on an error
+	Next() bool
+	// User reads the user the cursor is on
+	User() (*internal.User, error)
+	// Err is the error that stopped the cursor, if any
+	Err() error
+	Close() error
+}
+
+var _ Exports = (*exports)(nil)
+
+type exports struct {
+	dbConn *sql.DB
+}
+
+// NewExports is a constructor for the user exports store
+func NewExports(db *sql.DB) Exports {
+	return &exports{
+		dbConn: db,
+	}
+}
+
+func (r *exports) OpenUsers(ctx context.Context, filter *internal.UsersFilter) (UsersCursor, error) {
+	if filter == nil {
+		filter = &internal.UsersFilter{}
+	}
+	query, args := buildExportQuery(filter)
+
+	ctx, span := startSpan(ctx, "select_users_export", query)
+
+	rows, err := r.dbConn.QueryContext(ctx, query, args...)
+	if err != nil {
+		recordError(span, err)
+		span.End()
+		return nil, mapError(err)
+	}
+
+	return &usersCursor{rows: rows, span: span}, nil
+}
+
+var _ UsersCursor = (*usersCursor)(nil)
+
+type usersCursor struct {
+	rows *sql.Rows
+	span trace.Span
+}
+
+func (c *usersCursor) Next() bool {
+	return c.rows.Next()
+}
+
+func (c *usersCursor) User() (*internal.User, error) {
+	user := &internal.User{}
+	createdAt := time.Time{}
+	err := c.rows.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Phone,
+		&createdAt,
+		&user.EmailVerified,
+		&user.PhoneVerified,
+	)
+	if err != nil {
+		recordError(c.span, err)
+		return nil, err
+	}
+	user.CreatedAt = &createdAt
+
+	return user, nil
+}
+
+func (c *usersCursor) Err() error {
+	if err := c.rows.Err(); err != nil {
+		recordError(c.span, err)
+		return mapError(err)
+	}
+	return nil
+}
+
+func (c *usersCursor) Close() error {
+	defer c.span.End()
+	return c.rows.Close()
+}
@@ -0,0 +1,126 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	qExport = "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, " +
+		"`phone_verified_at` IS NOT NULL FROM `go_cleanapi`.`users` ORDER BY `created_at`, `id_user`;"
+	qExportFiltered = "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, " +
+		"`phone_verified_at` IS NOT NULL FROM `go_cleanapi`.`users` WHERE `user_email` LIKE ? AND `created_at` >= ? " +
+		"ORDER BY `created_at`, `id_user`;"
+)
+
+func TestExports(test *testing.T) {
+	createdAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	columns := []string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}
+
+	successfulCases := []struct {
+		name     string
+		filter   *internal.UsersFilter
+		expect   func(m sqlmock.Sqlmock)
+		expected []string
+	}{
+		{
+			name:   "it should walk every user (mocked)",
+			filter: nil,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(qExport)).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow("im an id", "test@test.com", "+521234567890", createdAt, true, false).
+						AddRow("im another id", "other@test.com", "", createdAt, false, false))
+			},
+			expected: []string{"im an id", "im another id"},
+		},
+		{
+			name: "it should walk the users matching the filter, paging aside (mocked)",
+			filter: &internal.UsersFilter{
+				Limit:       10,
+				Sort:        "-email",
+				Email:       "te_st",
+				CreatedFrom: createdAt,
+			},
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(qExportFiltered)).
+					WithArgs(`te\_st%`, createdAt).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow("im an id", "test@test.com", "+521234567890", createdAt, true, true))
+			},
+			expected: []string{"im an id"},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewExports(db)
+			cursor, err := r.OpenUsers(context.Background(), tc.filter)
+			assert.NoError(t, err)
+
+			ids := make([]string, 0)
+			for cursor.Next() {
+				user, err := cursor.User()
+				assert.NoError(t, err)
+				assert.Equal(t, createdAt, *user.CreatedAt)
+				ids = append(ids, user.ID)
+			}
+			assert.NoError(t, cursor.Err())
+			assert.NoError(t, cursor.Close())
+			assert.Equal(t, tc.expected, ids)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	test.Run("it should not open a cursor, query error (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(qExport)).WillReturnError(errors.New("connection refused"))
+
+		r := repository.NewExports(db)
+		cursor, err := r.OpenUsers(context.Background(), nil)
+		assert.Error(t, err)
+		assert.Nil(t, cursor)
+	})
+
+	test.Run("it should stop the cursor, row error (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(qExport)).
+			WillReturnRows(sqlmock.NewRows(columns).
+				AddRow("im an id", "test@test.com", "", createdAt, false, false).
+				RowError(0, errors.New("connection lost")))
+
+		r := repository.NewExports(db)
+		cursor, err := r.OpenUsers(context.Background(), nil)
+		assert.NoError(t, err)
+		assert.False(t, cursor.Next())
+		assert.Error(t, cursor.Err())
+		assert.NoError(t, cursor.Close())
+	})
+}
@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+	"fmt"
+)
+
+// Imports is an interface that extends the store of bulk user imports
+type Imports interface {
+	// CreateUsers creates a batch of users in a single transaction and returns the error of each user,
+	// nil when it was created. A refused user does not stop the others, while the returned error means
+	// nothing was created. A dry run rolls the transaction back, so it reports what a real run would do
+	CreateUsers(ctx context.Context, users []*internal.User, dryRun bool) ([]error, error)
+}
+
+var _ Imports = (*imports)(nil)
+
+type imports struct {
+	dbConn *sql.DB
+}
+
+// NewImports is a constructor for the bulk user imports store
+func NewImports(db *sql.DB) Imports {
+	return &imports{
+		dbConn: db,
+	}
+}
+
+func (r *imports) CreateUsers(ctx context.Context, users []*internal.User, dryRun bool) ([]error, error) {
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	ctx, span := startSpan(ctx, "sp_create_user", spCreate)
+	defer span.End()
+
+	tx, err := r.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	// mysql only undoes the failed statement, so the rest of the batch goes on
+	errs := make([]error, len(users))
+	for i, user := range users {
+		switch {
+		case user == nil:
+			errs[i] = invalid("user is empty")
+		case user.ID == "":
+			errs[i] = invalid("ID is required")
+		case user.Email == "":
+			errs[i] = invalid("email is required")
+		case user.Password == "":
+			errs[i] = invalid("password is required")
+		}
+		if errs[i] != nil {
+			continue
+		}
+
+		_, err := tx.ExecContext(ctx, spCreate, user.ID, user.Email, user.Phone, user.Password)
+		if err != nil {
+			if ctx.Err() != nil {
+				recordError(span, err)
+				return nil, mapError(err)
+			}
+			errs[i] = mapError(err)
+		}
+	}
+
+	if dryRun {
+		return errs, nil
+	}
+
+	if err := tx.Commit(); err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return errs, nil
+}
@@ -0,0 +1,122 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImports(test *testing.T) {
+	users := []*internal.User{
+		{ID: "im an id", Email: "test@test.com", Phone: "+521234567890", Password: "12345pAsSWORd*"},
+		{ID: "im another id", Email: "taken@test.com", Password: "12345pAsSWORd*"},
+		{ID: "im a third id", Password: "12345pAsSWORd*"},
+	}
+	duplicated := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'taken@test.com' for key 'users.user_email'"}
+
+	successfulCases := []struct {
+		name     string
+		dryRun   bool
+		expect   func(m sqlmock.Sqlmock)
+		expected []error
+	}{
+		{
+			name:   "it should create a batch of users, each one with its own result (mocked)",
+			dryRun: false,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).
+					WithArgs("im an id", "test@test.com", "+521234567890", "12345pAsSWORd*").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).
+					WithArgs("im another id", "taken@test.com", "", "12345pAsSWORd*").
+					WillReturnError(duplicated)
+				m.ExpectCommit()
+			},
+			expected: []error{nil, internal.ErrConflict, internal.ErrValidation},
+		},
+		{
+			name:   "it should roll a dry run back (mocked)",
+			dryRun: true,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectRollback()
+			},
+			expected: []error{nil, nil, internal.ErrValidation},
+		},
+	}
+
+	failedCases := []struct {
+		name   string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			name: "it should not create a batch, begin error (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin().WillReturnError(errors.New("connection refused"))
+			},
+		},
+		{
+			name: "it should not create a batch, commit error (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectCommit().WillReturnError(errors.New("connection refused"))
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewImports(db)
+			errs, err := r.CreateUsers(context.Background(), users, tc.dryRun)
+			assert.NoError(t, err)
+			assert.Len(t, errs, len(tc.expected))
+			for n, expected := range tc.expected {
+				if expected == nil {
+					assert.NoError(t, errs[n])
+					continue
+				}
+				assert.ErrorIs(t, errs[n], expected)
+			}
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewImports(db)
+			errs, err := r.CreateUsers(context.Background(), users, false)
+			assert.Error(t, err)
+			assert.Nil(t, errs)
+		})
+	}
+}
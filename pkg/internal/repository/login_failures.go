@@ -0,0 +1,226 @@
+package repository
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	spReadLoginFailures  = "CALL `go_cleanapi`.`sp_read_login_failures`(?);"
+	spFailLogin          = "CALL `go_cleanapi`.`sp_fail_login`(?, ?);"
+	spLockLogin          = "CALL `go_cleanapi`.`sp_lock_login`(?, ?);"
+	spResetLoginFailures = "CALL `go_cleanapi`.`sp_reset_login_failures`(?);"
+)
+
+// LoginFailures is an interface that extends the store of failed logins,
+// a key is an account or an ip and an unknown key has no failures
+type LoginFailures interface {
+	Read(ctx context.Context, key string) (*internal.LoginFailures, error)
+	// Fail counts a failed login of the key and returns its failures,
+	// failures older than the window are forgotten
+	Fail(ctx context.Context, key string, window time.Duration) (int, error)
+	// Lock refuses the logins of the key until the given time
+	Lock(ctx context.Context, key string, until time.Time) error
+	// Reset forgets the failures and the lock of the key
+	Reset(ctx context.Context, key string) error
+}
+
+var _ LoginFailures = (*loginFailures)(nil)
+
+type loginFailures struct {
+	dbConn *sql.DB
+}
+
+// NewLoginFailures is a constructor for a failed logins store backed by the database
+func NewLoginFailures(db *sql.DB) LoginFailures {
+	return &loginFailures{
+		dbConn: db,
+	}
+}
+
+func (r *loginFailures) Read(ctx context.Context, key string) (*internal.LoginFailures, error) {
+	if key == "" {
+		return nil, invalid("key is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_read_login_failures", spReadLoginFailures)
+	defer span.End()
+
+	failures := &internal.LoginFailures{}
+	lockedUntil := sql.NullTime{}
+	err := r.dbConn.QueryRowContext(ctx, spReadLoginFailures, key).Scan(&failures.Failures, &lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return failures, nil
+	}
+	if err != nil {
+		recordError(span, err)
+		return nil, mapError(err)
+	}
+	if lockedUntil.Valid {
+		failures.LockedUntil = lockedUntil.Time
+	}
+
+	return failures, nil
+}
+
+func (r *loginFailures) Fail(ctx context.Context, key string, window time.Duration) (int, error) {
+	if key == "" {
+		return 0, invalid("key is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_fail_login", spFailLogin)
+	defer span.End()
+
+	var failures int
+	err := r.dbConn.QueryRowContext(ctx, spFailLogin, key, int(window.Seconds())).Scan(&failures)
+	if err != nil {
+		recordError(span, err)
+		return 0, mapError(err)
+	}
+
+	return failures, nil
+}
+
+func (r *loginFailures) Lock(ctx context.Context, key string, until time.Time) error {
+	if key == "" {
+		return invalid("key is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_lock_login", spLockLogin)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spLockLogin, key, until)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (r *loginFailures) Reset(ctx context.Context, key string) error {
+	if key == "" {
+		return invalid("key is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_reset_login_failures", spResetLoginFailures)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spResetLoginFailures, key)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+var _ LoginFailures = (*memoryLoginFailures)(nil)
+
+type memoryLoginFailures struct {
+	keys *cache.Cache
+	mu   sync.Mutex
+}
+
+// memoryLoginFailure is the entry of a key, it expires once its window and its lock are over
+type memoryLoginFailure struct {
+	failures     int
+	lastFailedAt time.Time
+	lockedUntil  time.Time
+}
+
+// NewMemoryLoginFailures is a constructor for a failed logins store kept in memory
+func NewMemoryLoginFailures() LoginFailures {
+	return &memoryLoginFailures{
+		keys: cache.New(cache.NoExpiration, 10*time.Minute),
+	}
+}
+
+func (r *memoryLoginFailures) Read(_ context.Context, key string) (*internal.LoginFailures, error) {
+	if key == "" {
+		return nil, invalid("key is required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	failures := &internal.LoginFailures{}
+	if current, found := r.keys.Get(key); found {
+		entry := current.(memoryLoginFailure)
+		failures.Failures = entry.failures
+		failures.LockedUntil = entry.lockedUntil
+	}
+
+	return failures, nil
+}
+
+func (r *memoryLoginFailures) Fail(_ context.Context, key string, window time.Duration) (int, error) {
+	if key == "" {
+		return 0, invalid("key is required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	entry := memoryLoginFailure{}
+	if current, found := r.keys.Get(key); found {
+		entry = current.(memoryLoginFailure)
+	}
+	if entry.lastFailedAt.Before(now.Add(-window)) {
+		entry.failures = 0
+	}
+	entry.failures++
+	entry.lastFailedAt = now
+	r.set(key, entry, window)
+
+	return entry.failures, nil
+}
+
+func (r *memoryLoginFailures) Lock(_ context.Context, key string, until time.Time) error {
+	if key == "" {
+		return invalid("key is required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	current, found := r.keys.Get(key)
+	if !found {
+		// like the database, only a key with failures can be locked
+		return nil
+	}
+	entry := current.(memoryLoginFailure)
+	entry.lockedUntil = until
+	r.set(key, entry, 0)
+
+	return nil
+}
+
+func (r *memoryLoginFailures) Reset(_ context.Context, key string) error {
+	if key == "" {
+		return invalid("key is required")
+	}
+
+	r.keys.Delete(key)
+
+	return nil
+}
+
+// set keeps the entry at least for the window and until its lock is over
+func (r *memoryLoginFailures) set(key string, entry memoryLoginFailure, window time.Duration) {
+	ttl := window
+	if d := time.Until(entry.lockedUntil); d > ttl {
+		ttl = d
+	}
+	if _, expiration, found := r.keys.GetWithExpiration(key); found && time.Until(expiration) > ttl {
+		ttl = time.Until(expiration)
+	}
+	r.keys.Set(key, entry, ttl)
+}
@@ -0,0 +1,239 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spReadLoginFailures  = "CALL `go_cleanapi`.`sp_read_login_failures`(?);"
+	spFailLogin          = "CALL `go_cleanapi`.`sp_fail_login`(?, ?);"
+	spLockLogin          = "CALL `go_cleanapi`.`sp_lock_login`(?, ?);"
+	spResetLoginFailures = "CALL `go_cleanapi`.`sp_reset_login_failures`(?);"
+)
+
+func TestLoginFailures(test *testing.T) {
+	until := time.Now().Add(time.Minute)
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.LoginFailures) (interface{}, error)
+		expected interface{}
+	}{
+		{
+			name: "it should read the failures of a locked key (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadLoginFailures)).
+					WithArgs("account:test@test.com").
+					WillReturnRows(sqlmock.NewRows([]string{"failures", "locked_until"}).AddRow(5, until))
+			},
+			call: func(r repository.LoginFailures) (interface{}, error) {
+				return r.Read(context.Background(), "account:test@test.com")
+			},
+			expected: &internal.LoginFailures{Failures: 5, LockedUntil: until},
+		},
+		{
+			name: "it should read no failures of an unknown key (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadLoginFailures)).
+					WithArgs("ip:127.0.0.1").
+					WillReturnRows(sqlmock.NewRows([]string{"failures", "locked_until"}))
+			},
+			call: func(r repository.LoginFailures) (interface{}, error) {
+				return r.Read(context.Background(), "ip:127.0.0.1")
+			},
+			expected: &internal.LoginFailures{},
+		},
+		{
+			name: "it should count a failure within the window (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spFailLogin)).
+					WithArgs("account:test@test.com", 3600).
+					WillReturnRows(sqlmock.NewRows([]string{"failures"}).AddRow(2))
+			},
+			call: func(r repository.LoginFailures) (interface{}, error) {
+				return r.Fail(context.Background(), "account:test@test.com", time.Hour)
+			},
+			expected: 2,
+		},
+		{
+			name: "it should lock a key (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spLockLogin)).
+					WithArgs("account:test@test.com", until).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.LoginFailures) (interface{}, error) {
+				return nil, r.Lock(context.Background(), "account:test@test.com", until)
+			},
+			expected: nil,
+		},
+		{
+			name: "it should reset a key (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spResetLoginFailures)).
+					WithArgs("account:test@test.com").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.LoginFailures) (interface{}, error) {
+				return nil, r.Reset(context.Background(), "account:test@test.com")
+			},
+			expected: nil,
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.LoginFailures) error
+		expected error
+	}{
+		{
+			name:   "it should not count a failure, empty key",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.LoginFailures) error {
+				_, err := r.Fail(context.Background(), "", time.Hour)
+				return err
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not read the failures, db error (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadLoginFailures)).WillReturnError(errors.New("connection refused"))
+			},
+			call: func(r repository.LoginFailures) error {
+				_, err := r.Read(context.Background(), "account:test@test.com")
+				return err
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewLoginFailures(db)
+			res, err := tc.call(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewLoginFailures(db)
+			err = tc.call(r)
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMemoryLoginFailures(test *testing.T) {
+	until := time.Now().Add(time.Minute)
+
+	successfulCases := []struct {
+		name     string
+		record   func(r repository.LoginFailures) error
+		expected *internal.LoginFailures
+	}{
+		{
+			name: "it should count the failures of a key",
+			record: func(r repository.LoginFailures) error {
+				for i := 0; i < 3; i++ {
+					if _, err := r.Fail(context.Background(), "account:test@test.com", time.Hour); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			expected: &internal.LoginFailures{Failures: 3},
+		},
+		{
+			name: "it should forget the failures out of the window",
+			record: func(r repository.LoginFailures) error {
+				if _, err := r.Fail(context.Background(), "account:test@test.com", time.Hour); err != nil {
+					return err
+				}
+				time.Sleep(2 * time.Millisecond)
+				_, err := r.Fail(context.Background(), "account:test@test.com", time.Millisecond)
+				return err
+			},
+			expected: &internal.LoginFailures{Failures: 1},
+		},
+		{
+			name: "it should lock a key with failures",
+			record: func(r repository.LoginFailures) error {
+				if _, err := r.Fail(context.Background(), "account:test@test.com", time.Hour); err != nil {
+					return err
+				}
+				return r.Lock(context.Background(), "account:test@test.com", until)
+			},
+			expected: &internal.LoginFailures{Failures: 1, LockedUntil: until},
+		},
+		{
+			name: "it should not lock a key without failures",
+			record: func(r repository.LoginFailures) error {
+				return r.Lock(context.Background(), "account:test@test.com", until)
+			},
+			expected: &internal.LoginFailures{},
+		},
+		{
+			name: "it should forget the failures and the lock of a reset key",
+			record: func(r repository.LoginFailures) error {
+				if _, err := r.Fail(context.Background(), "account:test@test.com", time.Hour); err != nil {
+					return err
+				}
+				if err := r.Lock(context.Background(), "account:test@test.com", until); err != nil {
+					return err
+				}
+				return r.Reset(context.Background(), "account:test@test.com")
+			},
+			expected: &internal.LoginFailures{},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := repository.NewMemoryLoginFailures()
+			err := tc.record(r)
+			assert.NoError(t, err)
+
+			failures, err := r.Read(context.Background(), "account:test@test.com")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, failures)
+		})
+	}
+}
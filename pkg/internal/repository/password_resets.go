@@ -0,0 +1,88 @@
+package repository
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	spCreatePasswordReset = "CALL `go_cleanapi`.`sp_create_password_reset`(?, ?, ?);"
+	spResetPassword       = "CALL `go_cleanapi`.`sp_reset_password`(?, ?);"
+)
+
+// PasswordResets is an interface that extends the store of password reset tokens
+type PasswordResets interface {
+	// Create stores the token for the user of the email, replacing its unused ones,
+	// it reports false when no user has the email
+	Create(ctx context.Context, reset *internal.PasswordReset) (bool, error)
+	// Consume sets the new password when the token is unused and unexpired, it returns the id of the user
+	Consume(ctx context.Context, hash string, newPassword string) (string, error)
+}
+
+var _ PasswordResets = (*passwordResets)(nil)
+
+type passwordResets struct {
+	dbConn *sql.DB
+}
+
+// NewPasswordResets is a constructor for the password reset tokens store
+func NewPasswordResets(db *sql.DB) PasswordResets {
+	return &passwordResets{
+		dbConn: db,
+	}
+}
+
+func (r *passwordResets) Create(ctx context.Context, reset *internal.PasswordReset) (bool, error) {
+	if reset == nil {
+		return false, invalid("password reset is required")
+	}
+	if reset.Hash == "" || reset.Email == "" {
+		return false, invalid("password reset data is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_create_password_reset", spCreatePasswordReset)
+	defer span.End()
+
+	res, err := r.dbConn.ExecContext(ctx, spCreatePasswordReset,
+		reset.Hash,
+		reset.Email,
+		reset.ExpiresAt)
+	if err != nil {
+		recordError(span, err)
+		return false, mapError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to obtain rows affected: %w", err)
+	}
+
+	return affected > 0, nil
+}
+
+func (r *passwordResets) Consume(ctx context.Context, hash string, newPassword string) (string, error) {
+	if hash == "" {
+		return "", invalid("password reset token is required")
+	}
+	if newPassword == "" {
+		return "", invalid("password is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_reset_password", spResetPassword)
+	defer span.End()
+
+	var uid string
+	err := r.dbConn.QueryRowContext(ctx, spResetPassword, hash, newPassword).Scan(&uid)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", internal.NewError(internal.ErrUnauthorized, "invalid or expired reset token", err)
+	}
+	if err != nil {
+		recordError(span, err)
+		return "", mapError(err)
+	}
+
+	return uid, nil
+}
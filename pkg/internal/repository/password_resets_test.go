@@ -0,0 +1,173 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreatePasswordReset = "CALL `go_cleanapi`.`sp_create_password_reset`(?, ?, ?);"
+	spResetPassword       = "CALL `go_cleanapi`.`sp_reset_password`(?, ?);"
+)
+
+func TestPasswordResets(test *testing.T) {
+	reset := &internal.PasswordReset{
+		Hash:      "im a hash",
+		Email:     "test@test.com",
+		ExpiresAt: time.Now().Add(time.Hour),
+	}
+
+	invalidToken := &mysql.MySQLError{Number: 1644, Message: "invalid or expired reset token"}
+	copy(invalidToken.SQLState[:], "40100")
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.PasswordResets) (interface{}, error)
+		expected interface{}
+	}{
+		{
+			name: "it should create a password reset (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreatePasswordReset)).
+					WithArgs(reset.Hash, reset.Email, reset.ExpiresAt).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.PasswordResets) (interface{}, error) {
+				return r.Create(context.Background(), reset)
+			},
+			expected: true,
+		},
+		{
+			name: "it should not create a password reset for an unknown email (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreatePasswordReset)).
+					WithArgs(reset.Hash, reset.Email, reset.ExpiresAt).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			call: func(r repository.PasswordResets) (interface{}, error) {
+				return r.Create(context.Background(), reset)
+			},
+			expected: false,
+		},
+		{
+			name: "it should consume a password reset (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spResetPassword)).
+					WithArgs(reset.Hash, "54321pAsSWORd*").
+					WillReturnRows(sqlmock.NewRows([]string{"id_user"}).AddRow("im an id"))
+			},
+			call: func(r repository.PasswordResets) (interface{}, error) {
+				return r.Consume(context.Background(), reset.Hash, "54321pAsSWORd*")
+			},
+			expected: "im an id",
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.PasswordResets) error
+		expected error
+	}{
+		{
+			name:   "it should not create a password reset, missing email",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.PasswordResets) error {
+				_, err := r.Create(context.Background(), &internal.PasswordReset{Hash: reset.Hash})
+				return err
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name:   "it should not consume a password reset, empty password",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.PasswordResets) error {
+				_, err := r.Consume(context.Background(), reset.Hash, "")
+				return err
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not consume a password reset, used or expired token (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spResetPassword)).WillReturnError(invalidToken)
+			},
+			call: func(r repository.PasswordResets) error {
+				_, err := r.Consume(context.Background(), reset.Hash, "54321pAsSWORd*")
+				return err
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not consume a password reset, no rows (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spResetPassword)).WillReturnError(sql.ErrNoRows)
+			},
+			call: func(r repository.PasswordResets) error {
+				_, err := r.Consume(context.Background(), reset.Hash, "54321pAsSWORd*")
+				return err
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not create a password reset, db error (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreatePasswordReset)).WillReturnError(errors.New("connection refused"))
+			},
+			call: func(r repository.PasswordResets) error {
+				_, err := r.Create(context.Background(), reset)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewPasswordResets(db)
+			res, err := tc.call(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewPasswordResets(db)
+			err = tc.call(r)
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+)
+
+const (
+	spCreatePhoneOTP  = "CALL `go_cleanapi`.`sp_create_phone_otp`(?, ?, ?);"
+	spConfirmPhoneOTP = "CALL `go_cleanapi`.`sp_confirm_phone_otp`(?, ?, ?);"
+)
+
+// PhoneOTPs is an interface that extends the store of phone verification codes
+type PhoneOTPs interface {
+	// Create stores the code for the current phone of the user, replacing its previous one,
+	// it returns the phone the code must be sent to
+	Create(ctx context.Context, otp *internal.PhoneOTP) (string, error)
+	// Confirm verifies the phone when the code matches, every wrong code counts as an attempt
+	// and the code is discarded after maxAttempts of them
+	Confirm(ctx context.Context, uid string, hash string, maxAttempts int) error
+}
+
+var _ PhoneOTPs = (*phoneOTPs)(nil)
+
+type phoneOTPs struct {
+	dbConn *sql.DB
+}
+
+// NewPhoneOTPs is a constructor for the phone verification codes store
+func NewPhoneOTPs(db *sql.DB) PhoneOTPs {
+	return &phoneOTPs{
+		dbConn: db,
+	}
+}
+
+func (r *phoneOTPs) Create(ctx context.Context, otp *internal.PhoneOTP) (string, error) {
+	if otp == nil {
+		return "", invalid("phone code is required")
+	}
+	if otp.UID == "" || otp.Hash == "" {
+		return "", invalid("phone code data is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_create_phone_otp", spCreatePhoneOTP)
+	defer span.End()
+
+	var phone string
+	err := r.dbConn.QueryRowContext(ctx, spCreatePhoneOTP,
+		otp.UID,
+		otp.Hash,
+		otp.ExpiresAt).Scan(&phone)
+	if err != nil {
+		recordError(span, err)
+		return "", mapError(err)
+	}
+
+	return phone, nil
+}
+
+func (r *phoneOTPs) Confirm(ctx context.Context, uid string, hash string, maxAttempts int) error {
+	if uid == "" {
+		return invalid("ID is required")
+	}
+	if hash == "" {
+		return invalid("phone code is required")
+	}
+	if maxAttempts <= 0 {
+		return invalid("max attempts must be positive")
+	}
+
+	ctx, span := startSpan(ctx, "sp_confirm_phone_otp", spConfirmPhoneOTP)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spConfirmPhoneOTP, uid, hash, maxAttempts)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
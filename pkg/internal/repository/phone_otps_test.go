@@ -0,0 +1,172 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreatePhoneOTP  = "CALL `go_cleanapi`.`sp_create_phone_otp`(?, ?, ?);"
+	spConfirmPhoneOTP = "CALL `go_cleanapi`.`sp_confirm_phone_otp`(?, ?, ?);"
+)
+
+func TestPhoneOTPs(test *testing.T) {
+	otp := &internal.PhoneOTP{
+		UID:       "im an id",
+		Hash:      "im a hash",
+		ExpiresAt: time.Now().Add(5 * time.Minute),
+	}
+
+	noPhone := &mysql.MySQLError{Number: 1644, Message: "user has no phone"}
+	copy(noPhone.SQLState[:], "42200")
+	wrongCode := &mysql.MySQLError{Number: 1644, Message: "invalid or expired code"}
+	copy(wrongCode.SQLState[:], "40100")
+	tooManyAttempts := &mysql.MySQLError{Number: 1644, Message: "too many wrong codes, request a new one"}
+	copy(tooManyAttempts.SQLState[:], "42900")
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.PhoneOTPs) (interface{}, error)
+		expected interface{}
+	}{
+		{
+			name: "it should create a phone code (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreatePhoneOTP)).
+					WithArgs(otp.UID, otp.Hash, otp.ExpiresAt).
+					WillReturnRows(sqlmock.NewRows([]string{"user_phone"}).AddRow("+7812324524"))
+			},
+			call: func(r repository.PhoneOTPs) (interface{}, error) {
+				return r.Create(context.Background(), otp)
+			},
+			expected: "+7812324524",
+		},
+		{
+			name: "it should confirm a phone code (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spConfirmPhoneOTP)).
+					WithArgs(otp.UID, otp.Hash, 5).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.PhoneOTPs) (interface{}, error) {
+				return nil, r.Confirm(context.Background(), otp.UID, otp.Hash, 5)
+			},
+			expected: nil,
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.PhoneOTPs) error
+		expected error
+	}{
+		{
+			name:   "it should not create a phone code, missing hash",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.PhoneOTPs) error {
+				_, err := r.Create(context.Background(), &internal.PhoneOTP{UID: otp.UID})
+				return err
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not create a phone code, user without phone (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreatePhoneOTP)).WillReturnError(noPhone)
+			},
+			call: func(r repository.PhoneOTPs) error {
+				_, err := r.Create(context.Background(), otp)
+				return err
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name:   "it should not confirm a phone code, no attempts allowed",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.PhoneOTPs) error {
+				return r.Confirm(context.Background(), otp.UID, otp.Hash, 0)
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not confirm a phone code, wrong code (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spConfirmPhoneOTP)).WillReturnError(wrongCode)
+			},
+			call: func(r repository.PhoneOTPs) error {
+				return r.Confirm(context.Background(), otp.UID, otp.Hash, 5)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not confirm a phone code, too many attempts (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spConfirmPhoneOTP)).WillReturnError(tooManyAttempts)
+			},
+			call: func(r repository.PhoneOTPs) error {
+				return r.Confirm(context.Background(), otp.UID, otp.Hash, 5)
+			},
+			expected: internal.ErrTooManyRequests,
+		},
+		{
+			name: "it should not create a phone code, db error (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreatePhoneOTP)).WillReturnError(errors.New("connection refused"))
+			},
+			call: func(r repository.PhoneOTPs) error {
+				_, err := r.Create(context.Background(), otp)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewPhoneOTPs(db)
+			res, err := tc.call(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewPhoneOTPs(db)
+			err = tc.call(r)
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
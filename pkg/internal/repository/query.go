@@ -0,0 +1,225 @@
+package repository
+
+import (
+	"dall06/go-cleanapi/pkg/internal"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	usersTable = "`go_cleanapi`.`users`"
+
+	sortCreatedAt = "created_at"
+	sortEmail     = "email"
+	sortPhone     = "phone"
+
+	// ngramTokenSize is the ngram_token_size of the fulltext indexes, the MySQL default
+	ngramTokenSize = 2
+)
+
+// sortColumns maps the public sort fields to their column
+var sortColumns = map[string]string{
+	sortCreatedAt: "created_at",
+	sortEmail:     "user_email",
+	sortPhone:     "user_phone",
+}
+
+// cursor is the position of the last user of a page, it is sent to clients as an opaque string
+type cursor struct {
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+func encodeCursor(c cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, invalid("invalid cursor")
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(b, c); err != nil || c.ID == "" {
+		return nil, invalid("invalid cursor")
+	}
+	return c, nil
+}
+
+// usersQuery holds the statements to read a page of users
+type usersQuery struct {
+	count     string
+	countArgs []interface{}
+	list      string
+	listArgs  []interface{}
+	sort      string
+}
+
+// buildUsersQuery translates the filter into sql, every value travels as a placeholder argument
+func buildUsersQuery(filter *internal.UsersFilter) (*usersQuery, error) {
+	sort := strings.TrimPrefix(filter.Sort, "-")
+	if sort == "" {
+		sort = sortCreatedAt
+	}
+	column, ok := sortColumns[sort]
+	if !ok {
+		return nil, invalid(fmt.Sprintf("invalid sort field %s", sort))
+	}
+	desc := strings.HasPrefix(filter.Sort, "-")
+	if filter.Limit <= 0 {
+		return nil, invalid("limit is required")
+	}
+	if filter.Cursor != "" && filter.Offset > 0 {
+		return nil, invalid("only one of cursor or offset is allowed")
+	}
+
+	where, args := filterConditions(filter)
+
+	q := &usersQuery{sort: sort}
+	q.count = fmt.Sprintf("SELECT COUNT(*) FROM %s%s;", usersTable, whereClause(where))
+	q.countArgs = append(q.countArgs, args...)
+
+	if filter.Cursor != "" {
+		c, err := decodeCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		value, err := cursorValue(sort, c.Value)
+		if err != nil {
+			return nil, err
+		}
+		cmp := ">"
+		if desc {
+			cmp = "<"
+		}
+		where = append(where, fmt.Sprintf("(`%s`, `id_user`) %s (?, ?)", column, cmp))
+		args = append(args, value, c.ID)
+	}
+
+	dir := "ASC"
+	if desc {
+		dir = "DESC"
+	}
+	// one more row than the limit tells if there is a next page
+	q.list = fmt.Sprintf("SELECT `id_user`, `user_email`, `user_phone`, `created_at`, "+
+		"`email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL FROM %s%s ORDER BY `%s` %s, `id_user` %s LIMIT ?",
+		usersTable, whereClause(where), column, dir, dir)
+	args = append(args, filter.Limit+1)
+	if filter.Offset > 0 {
+		q.list += " OFFSET ?"
+		args = append(args, filter.Offset)
+	}
+	q.list += ";"
+	q.listArgs = args
+
+	return q, nil
+}
+
+// buildExportQuery translates the filter into the sql of a whole export, sorted by creation
+// with no limit, so the rows are read one by one as the export is written
+func buildExportQuery(filter *internal.UsersFilter) (string, []interface{}) {
+	where, args := filterConditions(filter)
+	query := fmt.Sprintf("SELECT `id_user`, `user_email`, `user_phone`, `created_at`, "+
+		"`email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL FROM %s%s ORDER BY `created_at`, `id_user`;",
+		usersTable, whereClause(where))
+	return query, args
+}
+
+// filterConditions are the conditions of the filter that narrow the users, paging aside
+func filterConditions(filter *internal.UsersFilter) ([]string, []interface{}) {
+	where := make([]string, 0)
+	args := make([]interface{}, 0)
+	if filter.Email != "" {
+		where, args = matchCondition(where, args, "user_email", filter.Email, filter.Match)
+	}
+	if filter.Phone != "" {
+		where, args = matchCondition(where, args, "user_phone", filter.Phone, filter.Match)
+	}
+	if filter.EmailVerified != nil {
+		where = append(where, verifiedCondition("email_verified_at", *filter.EmailVerified))
+	}
+	if filter.PhoneVerified != nil {
+		where = append(where, verifiedCondition("phone_verified_at", *filter.PhoneVerified))
+	}
+	if !filter.CreatedFrom.IsZero() {
+		where = append(where, "`created_at` >= ?")
+		args = append(args, filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		where = append(where, "`created_at` < ?")
+		args = append(args, filter.CreatedTo)
+	}
+	return where, args
+}
+
+// nextCursor is the cursor pointing right after the user
+func (q *usersQuery) nextCursor(u *internal.User) string {
+	c := cursor{ID: u.ID}
+	switch q.sort {
+	case sortEmail:
+		c.Value = u.Email
+	case sortPhone:
+		c.Value = u.Phone
+	default:
+		if u.CreatedAt != nil {
+			c.Value = u.CreatedAt.UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return encodeCursor(c)
+}
+
+func cursorValue(sort string, value string) (interface{}, error) {
+	if sort != sortCreatedAt {
+		return value, nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return nil, invalid("invalid cursor")
+	}
+	return t, nil
+}
+
+// matchCondition adds the condition matching a fragment of the column. A prefix is a LIKE that
+// walks the btree index of the column, while a fragment anywhere in it is first looked up in
+// the ngram fulltext index of the column and then checked with a LIKE, which the index cannot serve.
+// A fragment shorter than the tokens of the index is in none of them, it is only checked with the LIKE
+func matchCondition(where []string, args []interface{}, column string, fragment string, match string) ([]string, []interface{}) {
+	if match != internal.MatchContains {
+		where = append(where, fmt.Sprintf("`%s` LIKE ?", column))
+		return where, append(args, escapeLike(fragment)+"%")
+	}
+
+	// the fragment is searched as a phrase, a quote would end it
+	text := strings.ReplaceAll(fragment, `"`, "")
+	if utf8.RuneCountInString(text) >= ngramTokenSize {
+		where = append(where, fmt.Sprintf("MATCH(`%s`) AGAINST (? IN BOOLEAN MODE)", column))
+		args = append(args, `"`+text+`"`)
+	}
+	where = append(where, fmt.Sprintf("`%s` LIKE ?", column))
+	return where, append(args, "%"+escapeLike(fragment)+"%")
+}
+
+// verifiedCondition keeps the users whose verification date is set, or the ones whose is not
+func verifiedCondition(column string, verified bool) string {
+	if verified {
+		return fmt.Sprintf("`%s` IS NOT NULL", column)
+	}
+	return fmt.Sprintf("`%s` IS NULL", column)
+}
+
+func whereClause(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
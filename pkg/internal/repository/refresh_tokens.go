@@ -0,0 +1,154 @@
+package repository
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	spCreateRefreshToken      = "CALL `go_cleanapi`.`sp_create_refresh_token`(?, ?, ?, ?);"
+	spReadRefreshToken        = "CALL `go_cleanapi`.`sp_read_refresh_token`(?);"
+	spRotateRefreshToken      = "CALL `go_cleanapi`.`sp_rotate_refresh_token`(?, ?, ?);"
+	spRevokeRefreshFamily     = "CALL `go_cleanapi`.`sp_revoke_refresh_family`(?);"
+	spRevokeUserRefreshTokens = "CALL `go_cleanapi`.`sp_revoke_user_refresh_tokens`(?);"
+)
+
+// RefreshTokens is an interface that extends the store of refresh tokens
+type RefreshTokens interface {
+	Create(ctx context.Context, token *internal.RefreshToken) error
+	Read(ctx context.Context, hash string) (*internal.RefreshToken, error)
+	// Rotate marks the token as used and creates the next one of its family,
+	// it fails with a conflict when the token was already used
+	Rotate(ctx context.Context, hash string, next *internal.RefreshToken) error
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeUser(ctx context.Context, uid string) error
+}
+
+var _ RefreshTokens = (*refreshTokens)(nil)
+
+type refreshTokens struct {
+	dbConn *sql.DB
+}
+
+// NewRefreshTokens is a constructor for the refresh tokens store
+func NewRefreshTokens(db *sql.DB) RefreshTokens {
+	return &refreshTokens{
+		dbConn: db,
+	}
+}
+
+func (r *refreshTokens) Create(ctx context.Context, token *internal.RefreshToken) error {
+	if token == nil {
+		return invalid("refresh token is required")
+	}
+	if token.Hash == "" || token.FamilyID == "" || token.UID == "" {
+		return invalid("refresh token data is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_create_refresh_token", spCreateRefreshToken)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spCreateRefreshToken,
+		token.Hash,
+		token.FamilyID,
+		token.UID,
+		token.ExpiresAt)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (r *refreshTokens) Read(ctx context.Context, hash string) (*internal.RefreshToken, error) {
+	if hash == "" {
+		return nil, invalid("refresh token is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_read_refresh_token", spReadRefreshToken)
+	defer span.End()
+
+	token := &internal.RefreshToken{Hash: hash}
+	usedAt := sql.NullTime{}
+	revokedAt := sql.NullTime{}
+	err := r.dbConn.QueryRowContext(ctx, spReadRefreshToken, hash).Scan(
+		&token.FamilyID,
+		&token.UID,
+		&token.ExpiresAt,
+		&usedAt,
+		&revokedAt,
+	)
+	if err != nil {
+		recordError(span, err)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, internal.NewError(internal.ErrNotFound, "refresh token not found", err)
+		}
+		return nil, mapError(err)
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	if revokedAt.Valid {
+		token.RevokedAt = &revokedAt.Time
+	}
+
+	return token, nil
+}
+
+func (r *refreshTokens) Rotate(ctx context.Context, hash string, next *internal.RefreshToken) error {
+	if hash == "" {
+		return invalid("refresh token is required")
+	}
+	if next == nil || next.Hash == "" {
+		return invalid("next refresh token is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_rotate_refresh_token", spRotateRefreshToken)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spRotateRefreshToken, hash, next.Hash, next.ExpiresAt)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (r *refreshTokens) RevokeFamily(ctx context.Context, familyID string) error {
+	if familyID == "" {
+		return invalid("family ID is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_revoke_refresh_family", spRevokeRefreshFamily)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spRevokeRefreshFamily, familyID)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to revoke refresh tokens: %w", mapError(err))
+	}
+
+	return nil
+}
+
+func (r *refreshTokens) RevokeUser(ctx context.Context, uid string) error {
+	if uid == "" {
+		return invalid("ID is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_revoke_user_refresh_tokens", spRevokeUserRefreshTokens)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spRevokeUserRefreshTokens, uid)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to revoke refresh tokens: %w", mapError(err))
+	}
+
+	return nil
+}
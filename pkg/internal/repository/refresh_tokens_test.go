@@ -0,0 +1,229 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreateRefreshToken      = "CALL `go_cleanapi`.`sp_create_refresh_token`(?, ?, ?, ?);"
+	spReadRefreshToken        = "CALL `go_cleanapi`.`sp_read_refresh_token`(?);"
+	spRotateRefreshToken      = "CALL `go_cleanapi`.`sp_rotate_refresh_token`(?, ?, ?);"
+	spRevokeRefreshFamily     = "CALL `go_cleanapi`.`sp_revoke_refresh_family`(?);"
+	spRevokeUserRefreshTokens = "CALL `go_cleanapi`.`sp_revoke_user_refresh_tokens`(?);"
+)
+
+func TestRefreshTokens(test *testing.T) {
+	now := time.Now()
+	token := &internal.RefreshToken{
+		Hash:      "im a hash",
+		FamilyID:  "im a family id",
+		UID:       "im an id",
+		ExpiresAt: now.Add(time.Hour),
+	}
+	next := &internal.RefreshToken{
+		Hash:      "im the next hash",
+		FamilyID:  token.FamilyID,
+		UID:       token.UID,
+		ExpiresAt: now.Add(2 * time.Hour),
+	}
+	columns := []string{"id_family", "id_user", "expires_at", "used_at", "revoked_at"}
+
+	alreadyUsed := &mysql.MySQLError{Number: 1644, Message: "refresh token already used"}
+	copy(alreadyUsed.SQLState[:], "40900")
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.RefreshTokens) (*internal.RefreshToken, error)
+		expected *internal.RefreshToken
+	}{
+		{
+			name: "it should create a refresh token (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreateRefreshToken)).
+					WithArgs(token.Hash, token.FamilyID, token.UID, token.ExpiresAt).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return nil, r.Create(context.Background(), token)
+			},
+		},
+		{
+			name: "it should read an unused refresh token (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).
+					WithArgs(token.Hash).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(token.FamilyID, token.UID, token.ExpiresAt, nil, nil))
+			},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return r.Read(context.Background(), token.Hash)
+			},
+			expected: token,
+		},
+		{
+			name: "it should read a used refresh token (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).
+					WithArgs(token.Hash).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(token.FamilyID, token.UID, token.ExpiresAt, now, nil))
+			},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return r.Read(context.Background(), token.Hash)
+			},
+			expected: &internal.RefreshToken{
+				Hash:      token.Hash,
+				FamilyID:  token.FamilyID,
+				UID:       token.UID,
+				ExpiresAt: token.ExpiresAt,
+				UsedAt:    &now,
+			},
+		},
+		{
+			name: "it should rotate a refresh token (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spRotateRefreshToken)).
+					WithArgs(token.Hash, next.Hash, next.ExpiresAt).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return nil, r.Rotate(context.Background(), token.Hash, next)
+			},
+		},
+		{
+			name: "it should revoke a family of refresh tokens (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spRevokeRefreshFamily)).
+					WithArgs(token.FamilyID).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return nil, r.RevokeFamily(context.Background(), token.FamilyID)
+			},
+		},
+		{
+			name: "it should revoke the refresh tokens of a user (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spRevokeUserRefreshTokens)).
+					WithArgs(token.UID).
+					WillReturnResult(sqlmock.NewResult(0, 3))
+			},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return nil, r.RevokeUser(context.Background(), token.UID)
+			},
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.RefreshTokens) (*internal.RefreshToken, error)
+		expected error
+	}{
+		{
+			name:   "it should not create a refresh token, missing data",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return nil, r.Create(context.Background(), &internal.RefreshToken{Hash: token.Hash})
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not read a refresh token, not found",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).WillReturnError(sql.ErrNoRows)
+			},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return r.Read(context.Background(), token.Hash)
+			},
+			expected: internal.ErrNotFound,
+		},
+		{
+			name: "it should not rotate a refresh token, already used",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spRotateRefreshToken)).WillReturnError(alreadyUsed)
+			},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return nil, r.Rotate(context.Background(), token.Hash, next)
+			},
+			expected: internal.ErrConflict,
+		},
+		{
+			name:   "it should not rotate a refresh token, missing next token",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return nil, r.Rotate(context.Background(), token.Hash, nil)
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not revoke a family of refresh tokens, db error",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spRevokeRefreshFamily)).WillReturnError(errors.New("connection refused"))
+			},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return nil, r.RevokeFamily(context.Background(), token.FamilyID)
+			},
+		},
+		{
+			name:   "it should not revoke the refresh tokens of a user, empty uid",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.RefreshTokens) (*internal.RefreshToken, error) {
+				return nil, r.RevokeUser(context.Background(), "")
+			},
+			expected: internal.ErrValidation,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewRefreshTokens(db)
+			res, err := tc.call(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewRefreshTokens(db)
+			res, err := tc.call(r)
+			assert.Error(t, err)
+			assert.Nil(t, res)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
@@ -2,28 +2,40 @@
 package repository
 
 import (
+	"context"
 	"dall06/go-cleanapi/pkg/internal"
 	"database/sql"
 	"fmt"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	spCreate  = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
-	spRead    = "CALL `go_cleanapi`.`sp_read_user`(?);"
-	spReadAll = "CALL `go_cleanapi`.`sp_read_users`();"
-	spUpdate  = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
-	spDelete  = "CALL `go_cleanapi`.`sp_delete_user`(?, ?);"
-	spLogin   = "CALL `go_cleanapi`.`sp_login_user`(?, ?, ?);"
+	spCreate = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
+	spRead   = "CALL `go_cleanapi`.`sp_read_user`(?);"
+	spUpdate = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
+	spDelete = "CALL `go_cleanapi`.`sp_delete_user`(?, ?, ?);"
+	spLogin  = "CALL `go_cleanapi`.`sp_login_user`(?, ?, ?);"
+
+	spUpdatePassword = "CALL `go_cleanapi`.`sp_update_user_password`(?, ?, ?);"
+
+	tracerName = "dall06/go-cleanapi/pkg/internal/repository"
 )
 
 // Repository is an interface that extends the repository
 type Repository interface {
-	Create(user *internal.User) error
-	Read(user *internal.User) (*internal.User, error)
-	ReadAll() (internal.Users, error)
-	Update(user *internal.User) error
-	Delete(user *internal.User) error
-	Login(user *internal.User) (*internal.User, error)
+	Create(ctx context.Context, user *internal.User) error
+	Read(ctx context.Context, user *internal.User) (*internal.User, error)
+	ReadAll(ctx context.Context, filter *internal.UsersFilter) (*internal.UsersPage, error)
+	Update(ctx context.Context, patch *internal.UserPatch) error
+	// UpdatePassword replaces the password when the current one matches
+	UpdatePassword(ctx context.Context, change *internal.PasswordChange) error
+	Delete(ctx context.Context, user *internal.User) error
+	Login(ctx context.Context, user *internal.User) (*internal.User, error)
 }
 
 var _ Repository = (*repository)(nil)
@@ -39,21 +51,41 @@ func NewRepository(db *sql.DB) Repository {
 	}
 }
 
-func (r *repository) Login(user *internal.User) (*internal.User, error) {
+// startSpan opens a child span for a stored procedure call
+func startSpan(ctx context.Context, procedure string, statement string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, procedure,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemMySQL,
+			semconv.DBOperation(procedure),
+			semconv.DBStatement(statement),
+		))
+}
+
+// recordError marks the span as failed
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func (r *repository) Login(ctx context.Context, user *internal.User) (*internal.User, error) {
 	if user == nil {
-		return nil, fmt.Errorf("user is required")
+		return nil, invalid("user is required")
 	}
 	if user.Email == "" && user.Phone == "" {
-		return nil, fmt.Errorf("data is required")
+		return nil, invalid("data is required")
 	}
 	if user.Email != "" && user.Phone != "" {
-		return nil, fmt.Errorf("only one parameter is required")
+		return nil, invalid("only one parameter is required")
 	}
 	if user.Password == "" {
-		return nil, fmt.Errorf("password is required")
+		return nil, invalid("password is required")
 	}
 	// Add more validation checks as needed.
-	row := r.dbConn.QueryRow(spLogin,
+	ctx, span := startSpan(ctx, "sp_login_user", spLogin)
+	defer span.End()
+
+	row := r.dbConn.QueryRowContext(ctx, spLogin,
 		user.Email,
 		user.Phone,
 		user.Password)
@@ -64,55 +96,62 @@ func (r *repository) Login(user *internal.User) (*internal.User, error) {
 
 	u := &internal.User{}
 
-	err := row.Scan(&u.ID)
+	err := row.Scan(&u.ID, &u.EmailVerified, &u.TOTPEnabled)
 	if err == sql.ErrNoRows {
-		return nil, sql.ErrNoRows
+		return nil, mapError(err)
 	}
 	if err != nil {
-		return nil, err
+		recordError(span, err)
+		return nil, mapError(err)
 	}
 
 	return u, nil
 }
 
-func (r *repository) Create(user *internal.User) error {
+func (r *repository) Create(ctx context.Context, user *internal.User) error {
 	if user == nil {
-		return fmt.Errorf("user is empty")
+		return invalid("user is empty")
 	}
 	if user.ID == "" {
-		return fmt.Errorf("ID is required")
+		return invalid("ID is required")
 	}
 	if user.Email == "" {
-		return fmt.Errorf("email is required")
+		return invalid("email is required")
 	}
 	if user.Password == "" {
-		return fmt.Errorf("password is required")
+		return invalid("password is required")
 	}
 	// Add more validation checks as needed.
+	ctx, span := startSpan(ctx, "sp_create_user", spCreate)
+	defer span.End()
 
-	_, err := r.dbConn.Exec(spCreate,
+	_, err := r.dbConn.ExecContext(ctx, spCreate,
 		user.ID,
 		user.Email,
 		user.Phone,
 		user.Password)
 	if err != nil {
-		return fmt.Errorf("failed to execute SQL statement: %v", err)
+		recordError(span, err)
+		return mapError(err)
 	}
 
 	return nil
 }
 
-func (r repository) Read(user *internal.User) (*internal.User, error) {
+func (r repository) Read(ctx context.Context, user *internal.User) (*internal.User, error) {
 	if user == nil {
-		return nil, fmt.Errorf("user is required")
+		return nil, invalid("user is required")
 	}
 	if user.ID == "" {
-		return nil, fmt.Errorf("ID is required")
+		return nil, invalid("ID is required")
 	}
 
 	u := &internal.User{}
 
-	row := r.dbConn.QueryRow(spRead, user.ID)
+	ctx, span := startSpan(ctx, "sp_read_user", spRead)
+	defer span.End()
+
+	row := r.dbConn.QueryRowContext(ctx, spRead, user.ID)
 	if row == nil {
 		empty := &internal.User{}
 		return empty, nil
@@ -121,40 +160,70 @@ func (r repository) Read(user *internal.User) (*internal.User, error) {
 	err := row.Scan(
 		&u.ID,
 		&u.Email,
-		&u.Phone)
+		&u.Phone,
+		&u.EmailVerified,
+		&u.PhoneVerified,
+		&u.Version)
 	if err == sql.ErrNoRows {
-		return nil, sql.ErrNoRows
+		return nil, mapError(err)
 	}
 	if err != nil {
-		return nil, err
+		recordError(span, err)
+		return nil, mapError(err)
 	}
 
 	return u, nil
 }
 
-func (r *repository) ReadAll() (internal.Users, error) {
-	rows, err := r.dbConn.Query(spReadAll)
+func (r *repository) ReadAll(ctx context.Context, filter *internal.UsersFilter) (*internal.UsersPage, error) {
+	if filter == nil {
+		return nil, invalid("filter is required")
+	}
+
+	q, err := buildUsersQuery(filter)
 	if err != nil {
 		return nil, err
 	}
+
+	ctx, span := startSpan(ctx, "select_users", q.list)
+	defer span.End()
+
+	page := &internal.UsersPage{}
+	err = r.dbConn.QueryRowContext(ctx, q.count, q.countArgs...).Scan(&page.Total)
+	if err != nil {
+		recordError(span, err)
+		return nil, mapError(err)
+	}
+
+	rows, err := r.dbConn.QueryContext(ctx, q.list, q.listArgs...)
+	if err != nil {
+		recordError(span, err)
+		return nil, mapError(err)
+	}
 	defer func() {
 		if cerr := rows.Close(); cerr != nil {
 			err = cerr
 		}
 	}()
 
-	users := make(internal.Users, 0) // allocate slice
+	users := make(internal.Users, 0, filter.Limit) // allocate slice
 
 	for rows.Next() {
 		user := &internal.User{}
+		createdAt := time.Time{}
 		err := rows.Scan(
 			&user.ID,
 			&user.Email,
 			&user.Phone,
+			&createdAt,
+			&user.EmailVerified,
+			&user.PhoneVerified,
 		)
 		if err != nil {
+			recordError(span, err)
 			return nil, err
 		}
+		user.CreatedAt = &createdAt
 
 		users = append(users, user)
 	}
@@ -167,67 +236,125 @@ func (r *repository) ReadAll() (internal.Users, error) {
 		return nil, err
 	}
 
-	return users, nil
+	if len(users) > filter.Limit {
+		users = users[:filter.Limit]
+		page.NextCursor = q.nextCursor(users[len(users)-1])
+	}
+	page.Users = users
+
+	return page, nil
 }
 
-func (r repository) Update(user *internal.User) error {
-	if user == nil {
-		return fmt.Errorf("user is required")
+func (r repository) Update(ctx context.Context, patch *internal.UserPatch) error {
+	if patch == nil {
+		return invalid("user is required")
 	}
-	if user.ID == "" {
-		return fmt.Errorf("ID is resquired")
+	if patch.ID == "" {
+		return invalid("ID is required")
 	}
-	if user.Password == "" {
-		return fmt.Errorf("password is required")
+	if patch.Email == nil && patch.Phone == nil {
+		return invalid("user data is required")
 	}
-	if user.Email == "" && user.Phone == "" {
-		return fmt.Errorf("user data is required")
+	if patch.Email != nil && *patch.Email == "" {
+		return invalid("email cannot be removed")
 	}
 
-	res, err := r.dbConn.Exec(spUpdate,
-		user.ID,
-		user.Email,
-		user.Phone,
-		user.Password)
+	ctx, span := startSpan(ctx, "sp_update_user", spUpdate)
+	defer span.End()
+
+	// nil fields travel as NULL and keep the current value
+	res, err := r.dbConn.ExecContext(ctx, spUpdate,
+		patch.ID,
+		patch.Version,
+		patch.Email,
+		patch.Phone)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to obtain rows affected: %w", err)
+	}
+
+	if affected == 0 {
+		// with a version the row changed between the check of the procedure and its write
+		if patch.Version != 0 {
+			return internal.NewError(internal.ErrPreconditionFailed, "the user was modified by another request", nil)
+		}
+		return internal.NewError(internal.ErrNotFound, "user not found", nil)
+	}
+
+	return nil
+}
+
+func (r repository) UpdatePassword(ctx context.Context, change *internal.PasswordChange) error {
+	if change == nil {
+		return invalid("password change is required")
+	}
+	if change.ID == "" {
+		return invalid("ID is required")
+	}
+	if change.CurrentPassword == "" || change.NewPassword == "" {
+		return invalid("password is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_update_user_password", spUpdatePassword)
+	defer span.End()
+
+	res, err := r.dbConn.ExecContext(ctx, spUpdatePassword,
+		change.ID,
+		change.CurrentPassword,
+		change.NewPassword)
 	if err != nil {
-		return err
+		recordError(span, err)
+		return mapError(err)
 	}
 
 	affected, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to obtain rows affected: %v", err)
+		return fmt.Errorf("failed to obtain rows affected: %w", err)
 	}
 
 	if affected == 0 {
-		return fmt.Errorf("user not updated")
+		return internal.NewError(internal.ErrNotFound, "user not found", nil)
 	}
 
 	return nil
 }
 
-func (r repository) Delete(user *internal.User) error {
+func (r repository) Delete(ctx context.Context, user *internal.User) error {
 	if user == nil {
-		return fmt.Errorf("user is required")
+		return invalid("user is required")
 	}
 	if user.ID == "" {
-		return fmt.Errorf("ID is required")
+		return invalid("ID is required")
 	}
 	if user.Password == "" {
-		return fmt.Errorf("password is required")
+		return invalid("password is required")
 	}
 
-	res, err := r.dbConn.Exec(spDelete, user.ID, user.Password)
+	ctx, span := startSpan(ctx, "sp_delete_user", spDelete)
+	defer span.End()
+
+	res, err := r.dbConn.ExecContext(ctx, spDelete, user.ID, user.Password, user.Version)
 	if err != nil {
-		return err
+		recordError(span, err)
+		return mapError(err)
 	}
 
 	affected, err := res.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("failed to obtain rows affected: %v", err)
+		return fmt.Errorf("failed to obtain rows affected: %w", err)
 	}
 
 	if affected == 0 {
-		return fmt.Errorf("user not deleted")
+		// with a version the row changed between the check of the procedure and its write
+		if user.Version != 0 {
+			return internal.NewError(internal.ErrPreconditionFailed, "the user was modified by another request", nil)
+		}
+		return internal.NewError(internal.ErrNotFound, "user not found", nil)
 	}
 
 	return nil
@@ -2,23 +2,35 @@
 package repository_test
 
 import (
+	"context"
 	"dall06/go-cleanapi/pkg/internal"
 	"dall06/go-cleanapi/pkg/internal/repository"
 	"database/sql"
+	"database/sql/driver"
+	"encoding/base64"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
 )
 
 const (
-	spCreate  = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
-	spRead    = "CALL `go_cleanapi`.`sp_read_user`(?);"
-	spReadAll = "CALL `go_cleanapi`.`sp_read_users`();"
-	spUpdate  = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
-	spDelete  = "CALL `go_cleanapi`.`sp_delete_user`(?, ?);"
-	spLogin   = "CALL `go_cleanapi`.`sp_login_user`(?, ?, ?);"
+	spCreate = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
+	spRead   = "CALL `go_cleanapi`.`sp_read_user`(?);"
+	qCount   = "SELECT COUNT(*) FROM `go_cleanapi`.`users`;"
+	qList    = "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL FROM `go_cleanapi`.`users` ORDER BY `created_at` ASC, `id_user` ASC LIMIT ?;"
+	spUpdate = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
+	spDelete = "CALL `go_cleanapi`.`sp_delete_user`(?, ?, ?);"
+	spLogin  = "CALL `go_cleanapi`.`sp_login_user`(?, ?, ?);"
+
+	spUpdatePassword = "CALL `go_cleanapi`.`sp_update_user_password`(?, ?, ?);"
 )
 
 func TestLogin(test *testing.T) {
@@ -42,18 +54,28 @@ func TestLogin(test *testing.T) {
 
 	rowsSetOne := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		&dbUserOne.ID,
+		true,
+		false,
 	)
 
 	rowsSetOneTwo := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		&dbUserOne.ID,
+		true,
+		false,
 	)
 
 	rowsSetTwo := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	})
 
 	inputUserOne := &internal.User{
@@ -86,7 +108,8 @@ func TestLogin(test *testing.T) {
 	}
 
 	expectedOne := &internal.User{
-		ID: "im an id",
+		ID:            "im an id",
+		EmailVerified: true,
 	}
 
 	successfulCases := []struct {
@@ -199,7 +222,7 @@ func TestLogin(test *testing.T) {
 			assert.NoError(t, err)
 
 			r := repository.NewRepository(db)
-			res, err := r.Login(tc.input)
+			res, err := r.Login(context.Background(), tc.input)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expected, res)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
@@ -227,7 +250,7 @@ func TestLogin(test *testing.T) {
 			assert.NoError(t, err)
 
 			r := repository.NewRepository(db)
-			res, err := r.Login(tc.input)
+			res, err := r.Login(context.Background(), tc.input)
 			assert.Error(t, err)
 			assert.NotEqual(t, tc.expected, res)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
@@ -363,7 +386,7 @@ func TestCreate(test *testing.T) {
 			).WillReturnResult(sqlmock.NewResult(0, 0))
 
 			r := repository.NewRepository(db)
-			err = r.Create(tc.input)
+			err = r.Create(context.Background(), tc.input)
 			assert.NoError(t, err)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
@@ -391,7 +414,7 @@ func TestCreate(test *testing.T) {
 			).WillReturnResult(sqlmock.NewResult(0, 0))
 
 			r := repository.NewRepository(db)
-			err = r.Create(tc.input)
+			err = r.Create(context.Background(), tc.input)
 			assert.Error(t, err)
 
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
@@ -412,16 +435,25 @@ func TestRead(test *testing.T) {
 		"id_user",
 		"user_email",
 		"user_phone",
+		"email_verified",
+		"phone_verified",
+		"version",
 	}).AddRow(
 		&dbUserOne.ID,
 		&dbUserOne.Email,
 		&dbUserOne.Phone,
+		true,
+		false,
+		3,
 	)
 
 	rowsSetTwo := sqlmock.NewRows([]string{
 		"id_user",
 		"user_email",
 		"user_phone",
+		"email_verified",
+		"phone_verified",
+		"version",
 	})
 
 	inputUserOne := &internal.User{
@@ -437,9 +469,11 @@ func TestRead(test *testing.T) {
 	}
 
 	expectedOne := &internal.User{
-		ID:    "im an id",
-		Email: "test@test.com",
-		Phone: "+7812324524",
+		ID:            "im an id",
+		Email:         "test@test.com",
+		Phone:         "+7812324524",
+		EmailVerified: true,
+		Version:       3,
 	}
 
 	successfulCases := []struct {
@@ -513,7 +547,7 @@ func TestRead(test *testing.T) {
 			).WillReturnRows(tc.rows)
 
 			r := repository.NewRepository(db)
-			res, err := r.Read(tc.input)
+			res, err := r.Read(context.Background(), tc.input)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expected, res)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
@@ -538,7 +572,7 @@ func TestRead(test *testing.T) {
 			).WillReturnRows(tc.rows)
 
 			r := repository.NewRepository(db)
-			res, err := r.Read(tc.input)
+			res, err := r.Read(context.Background(), tc.input)
 			assert.Error(t, err)
 			assert.NotEqual(t, tc.expected, res, "")
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
@@ -548,75 +582,175 @@ func TestRead(test *testing.T) {
 }
 
 func TestReadAll(test *testing.T) {
+	createdAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
 	dbUserOne := &internal.User{
-		ID:    "im an id",
-		Email: "test@test.com",
-		Phone: "+7812324524",
+		ID:        "im an id",
+		Email:     "test@test.com",
+		Phone:     "+7812324524",
+		CreatedAt: &createdAt,
 	}
 	dbUserTwo := &internal.User{
-		ID:    "im an id 2",
-		Email: "test2@test.com",
-		Phone: "+8812324524",
-	}
-	dbUserThree := &internal.User{
-		ID:    "im an id 3",
-		Email: "test2@test.com",
-		Phone: "+8812324524",
+		ID:            "im an id 2",
+		Email:         "test2@test.com",
+		Phone:         "+8812324524",
+		CreatedAt:     &createdAt,
+		EmailVerified: true,
 	}
 
-	rowsSetOne := sqlmock.NewRows([]string{
-		"id_user",
-		"user_email",
-		"user_phone",
-	}).AddRow(
-		&dbUserOne.ID,
-		&dbUserOne.Email,
-		&dbUserOne.Phone,
-	).AddRow(
-		&dbUserTwo.ID,
-		&dbUserTwo.Email,
-		&dbUserTwo.Phone,
-	)
-
-	rowsSetTwo := sqlmock.NewRows([]string{"id_user", "user_email", "user_phone"})
-
-	expectedOnes := make(internal.Users, 0)
-	expectedOnes = append(expectedOnes, dbUserOne)
-	expectedOnes = append(expectedOnes, dbUserTwo)
-
-	expectedOnesTwo := make(internal.Users, 0)
-
-	expectedOnesThree := make(internal.Users, 0)
-	expectedOnesThree = append(expectedOnesThree, dbUserOne)
-	expectedOnesThree = append(expectedOnesThree, dbUserThree)
+	verified, unverified := true, false
+	columns := []string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}
+	cursorTwo := base64.RawURLEncoding.EncodeToString([]byte(`{"v":"2023-05-01T10:00:00Z","id":"im an id 2"}`))
+	cursorEmail := base64.RawURLEncoding.EncodeToString([]byte(`{"v":"test@test.com","id":"im an id"}`))
 
 	successfulCases := []struct {
 		name     string
+		filter   *internal.UsersFilter
+		count    string
+		list     string
+		listArgs []driver.Value
 		rows     *sqlmock.Rows
-		expected internal.Users
+		expected *internal.UsersPage
 	}{
 		{
-			name:     "it should read many users (mocked)",
-			rows:     rowsSetOne,
-			expected: expectedOnes,
+			name:     "it should read a page of users (mocked)",
+			filter:   &internal.UsersFilter{Limit: 2},
+			count:    qCount,
+			list:     qList,
+			listArgs: []driver.Value{3},
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUserOne.ID, dbUserOne.Email, dbUserOne.Phone, createdAt, dbUserOne.EmailVerified, dbUserOne.PhoneVerified).
+				AddRow(dbUserTwo.ID, dbUserTwo.Email, dbUserTwo.Phone, createdAt, dbUserTwo.EmailVerified, dbUserTwo.PhoneVerified),
+			expected: &internal.UsersPage{
+				Users: internal.Users{dbUserOne, dbUserTwo},
+				Total: 2,
+			},
+		},
+		{
+			name:     "it should read a page of users (mocked) with next cursor",
+			filter:   &internal.UsersFilter{Limit: 2},
+			count:    qCount,
+			list:     qList,
+			listArgs: []driver.Value{3},
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUserOne.ID, dbUserOne.Email, dbUserOne.Phone, createdAt, dbUserOne.EmailVerified, dbUserOne.PhoneVerified).
+				AddRow(dbUserTwo.ID, dbUserTwo.Email, dbUserTwo.Phone, createdAt, dbUserTwo.EmailVerified, dbUserTwo.PhoneVerified).
+				AddRow("im an id 3", "test3@test.com", "+9812324524", createdAt, false, false),
+			expected: &internal.UsersPage{
+				Users:      internal.Users{dbUserOne, dbUserTwo},
+				Total:      2,
+				NextCursor: cursorTwo,
+			},
 		},
 		{
 			name:     "it should read many users (mocked), but empty db",
-			rows:     rowsSetTwo,
-			expected: expectedOnesTwo,
+			filter:   &internal.UsersFilter{Limit: 2},
+			count:    qCount,
+			list:     qList,
+			listArgs: []driver.Value{3},
+			rows:     sqlmock.NewRows(columns),
+			expected: &internal.UsersPage{
+				Users: internal.Users{},
+				Total: 2,
+			},
+		},
+		{
+			name:   "it should read users (mocked), filtered, sorted and after a cursor",
+			filter: &internal.UsersFilter{Limit: 1, Sort: "-email", Email: "te_st", Cursor: cursorEmail},
+			count:  "SELECT COUNT(*) FROM `go_cleanapi`.`users` WHERE `user_email` LIKE ?;",
+			list: "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL FROM `go_cleanapi`.`users` " +
+				"WHERE `user_email` LIKE ? AND (`user_email`, `id_user`) < (?, ?) ORDER BY `user_email` DESC, `id_user` DESC LIMIT ?;",
+			listArgs: []driver.Value{`te\_st%`, "test@test.com", "im an id", 2},
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUserTwo.ID, dbUserTwo.Email, dbUserTwo.Phone, createdAt, dbUserTwo.EmailVerified, dbUserTwo.PhoneVerified),
+			expected: &internal.UsersPage{
+				Users: internal.Users{dbUserTwo},
+				Total: 2,
+			},
+		},
+		{
+			name:   "it should read users (mocked) containing a fragment",
+			filter: &internal.UsersFilter{Limit: 1, Phone: `123"24`, Match: internal.MatchContains},
+			count: "SELECT COUNT(*) FROM `go_cleanapi`.`users` " +
+				"WHERE MATCH(`user_phone`) AGAINST (? IN BOOLEAN MODE) AND `user_phone` LIKE ?;",
+			list: "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL " +
+				"FROM `go_cleanapi`.`users` WHERE MATCH(`user_phone`) AGAINST (? IN BOOLEAN MODE) AND `user_phone` LIKE ? " +
+				"ORDER BY `created_at` ASC, `id_user` ASC LIMIT ?;",
+			listArgs: []driver.Value{`"12324"`, `%123"24%`, 2},
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUserTwo.ID, dbUserTwo.Email, dbUserTwo.Phone, createdAt, dbUserTwo.EmailVerified, dbUserTwo.PhoneVerified),
+			expected: &internal.UsersPage{
+				Users: internal.Users{dbUserTwo},
+				Total: 2,
+			},
+		},
+		{
+			name:   "it should read users (mocked) containing a fragment shorter than the ngram tokens",
+			filter: &internal.UsersFilter{Limit: 1, Email: "2", Match: internal.MatchContains},
+			count:  "SELECT COUNT(*) FROM `go_cleanapi`.`users` WHERE `user_email` LIKE ?;",
+			list: "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL " +
+				"FROM `go_cleanapi`.`users` WHERE `user_email` LIKE ? ORDER BY `created_at` ASC, `id_user` ASC LIMIT ?;",
+			listArgs: []driver.Value{"%2%", 2},
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUserTwo.ID, dbUserTwo.Email, dbUserTwo.Phone, createdAt, dbUserTwo.EmailVerified, dbUserTwo.PhoneVerified),
+			expected: &internal.UsersPage{
+				Users: internal.Users{dbUserTwo},
+				Total: 2,
+			},
+		},
+		{
+			name:   "it should read users (mocked) by verification status",
+			filter: &internal.UsersFilter{Limit: 1, Email: "test2", EmailVerified: &verified, PhoneVerified: &unverified},
+			count: "SELECT COUNT(*) FROM `go_cleanapi`.`users` " +
+				"WHERE `user_email` LIKE ? AND `email_verified_at` IS NOT NULL AND `phone_verified_at` IS NULL;",
+			list: "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL " +
+				"FROM `go_cleanapi`.`users` WHERE `user_email` LIKE ? AND `email_verified_at` IS NOT NULL AND `phone_verified_at` IS NULL " +
+				"ORDER BY `created_at` ASC, `id_user` ASC LIMIT ?;",
+			listArgs: []driver.Value{"test2%", 2},
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUserTwo.ID, dbUserTwo.Email, dbUserTwo.Phone, createdAt, dbUserTwo.EmailVerified, dbUserTwo.PhoneVerified),
+			expected: &internal.UsersPage{
+				Users: internal.Users{dbUserTwo},
+				Total: 2,
+			},
+		},
+		{
+			name:     "it should read users (mocked) with offset",
+			filter:   &internal.UsersFilter{Limit: 1, Offset: 1, Sort: "phone"},
+			count:    qCount,
+			list:     "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL FROM `go_cleanapi`.`users` ORDER BY `user_phone` ASC, `id_user` ASC LIMIT ? OFFSET ?;",
+			listArgs: []driver.Value{2, 1},
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUserTwo.ID, dbUserTwo.Email, dbUserTwo.Phone, createdAt, dbUserTwo.EmailVerified, dbUserTwo.PhoneVerified),
+			expected: &internal.UsersPage{
+				Users: internal.Users{dbUserTwo},
+				Total: 2,
+			},
 		},
 	}
 
 	failedCases := []struct {
-		name     string
-		rows     *sqlmock.Rows
-		dbUser   internal.Users
-		expected internal.Users
+		name   string
+		filter *internal.UsersFilter
 	}{
 		{
-			name:     "it should not read many users (mocked), wrong values returned",
-			rows:     rowsSetOne,
-			expected: expectedOnesThree,
+			name:   "it should not read users, nil filter",
+			filter: nil,
+		},
+		{
+			name:   "it should not read users, no limit",
+			filter: &internal.UsersFilter{},
+		},
+		{
+			name:   "it should not read users, unknown sort",
+			filter: &internal.UsersFilter{Limit: 1, Sort: "password"},
+		},
+		{
+			name:   "it should not read users, cursor and offset",
+			filter: &internal.UsersFilter{Limit: 1, Offset: 1, Cursor: cursorTwo},
+		},
+		{
+			name:   "it should not read users, malformed cursor",
+			filter: &internal.UsersFilter{Limit: 1, Cursor: "not a cursor"},
 		},
 	}
 
@@ -629,16 +763,14 @@ func TestReadAll(test *testing.T) {
 				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 			}
 
-			assert.NoError(t, err)
-
-			m.ExpectQuery(regexp.QuoteMeta(spReadAll)).WillReturnRows(tc.rows)
+			m.ExpectQuery(regexp.QuoteMeta(tc.count)).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			m.ExpectQuery(regexp.QuoteMeta(tc.list)).WithArgs(tc.listArgs...).WillReturnRows(tc.rows)
 
 			r := repository.NewRepository(db)
-			res, err := r.ReadAll()
+			res, err := r.ReadAll(context.Background(), tc.filter)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expected, res)
-			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
-
+			assert.NoError(t, m.ExpectationsWereMet())
 		})
 	}
 
@@ -647,134 +779,207 @@ func TestReadAll(test *testing.T) {
 		test.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			db, m, err := sqlmock.New()
+			db, _, err := sqlmock.New()
 			if err != nil {
 				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 			}
 
-			assert.NoError(t, err)
-			m.ExpectQuery(regexp.QuoteMeta(spReadAll)).WillReturnRows(tc.rows)
-
 			r := repository.NewRepository(db)
-			res, err := r.ReadAll()
-			assert.NoError(t, err)
-			assert.NotEqual(t, tc.expected, res)
-			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
-
+			res, err := r.ReadAll(context.Background(), tc.filter)
+			assert.ErrorIs(t, err, internal.ErrValidation)
+			assert.Nil(t, res)
 		})
 	}
 }
 
 func TestUpdate(test *testing.T) {
-	dbUserOne := &internal.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "+7812324524",
-		Password: "12345pAsSWORd*",
-	}
+	ptr := func(s string) *string { return &s }
 
-	dbUserTwo := &internal.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "",
-		Password: "12345pAsSWORd*",
+	successfulCases := []struct {
+		name  string
+		input *internal.UserPatch
+		args  []driver.Value
+	}{
+		{
+			name: "it should update an user (mocked)",
+			input: &internal.UserPatch{
+				ID:    "im an id",
+				Email: ptr("test@test.com"),
+				Phone: ptr("+7812324524"),
+			},
+			args: []driver.Value{"im an id", 0, "test@test.com", "+7812324524"},
+		},
+		{
+			name: "it should update an user (mocked), only the email",
+			input: &internal.UserPatch{
+				ID:    "im an id",
+				Email: ptr("test@test.com"),
+			},
+			args: []driver.Value{"im an id", 0, "test@test.com", nil},
+		},
+		{
+			name: "it should update an user (mocked), removing the phone",
+			input: &internal.UserPatch{
+				ID:    "im an id",
+				Phone: ptr(""),
+			},
+			args: []driver.Value{"im an id", 0, nil, ""},
+		},
+		{
+			name: "it should update an user (mocked), from its version",
+			input: &internal.UserPatch{
+				ID:      "im an id",
+				Email:   ptr("test@test.com"),
+				Version: 4,
+			},
+			args: []driver.Value{"im an id", 4, "test@test.com", nil},
+		},
 	}
 
-	dbUserThree := &internal.User{
-		ID:       "im an id",
-		Email:    "",
-		Phone:    "+7812324524",
-		Password: "12345pAsSWORd*",
+	failedCases := []struct {
+		name  string
+		input *internal.UserPatch
+	}{
+		{
+			name:  "it should not update an user (mocked), empty id",
+			input: &internal.UserPatch{Email: ptr("test@test.com")},
+		},
+		{
+			name:  "it should not update an user (mocked), nothing to update",
+			input: &internal.UserPatch{ID: "im an id"},
+		},
+		{
+			name:  "it should not update an user (mocked), removing the email",
+			input: &internal.UserPatch{ID: "im an id", Email: ptr("")},
+		},
+		{
+			name:  "it should not update an user (mocked), nil user",
+			input: nil,
+		},
 	}
 
-	inputUserOne := &internal.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "+7812324524",
-		Password: "12345pAsSWORd*",
-	}
+	for _, tc := range successfulCases {
+		tc := tc
 
-	inputUserTwo := &internal.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "",
-		Password: "12345pAsSWORd*",
-	}
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 
-	inputUserThree := &internal.User{
-		ID:       "im an id",
-		Email:    "",
-		Phone:    "+7812324524",
-		Password: "12345pAsSWORd*",
-	}
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
 
-	inputUserFour := &internal.User{
-		ID:       "",
-		Email:    "test@test.com",
-		Phone:    "+7812324524",
-		Password: "12345pAsSWORd*",
+			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WithArgs(tc.args...).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			r := repository.NewRepository(db)
+			err = r.Update(context.Background(), tc.input)
+			assert.NoError(t, err)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
 	}
 
-	inputUserFive := &internal.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "+7812324524",
-		Password: "",
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			r := repository.NewRepository(db)
+			err = r.Update(context.Background(), tc.input)
+			assert.ErrorIs(t, err, internal.ErrValidation)
+		})
 	}
 
-	inputUserSix := &internal.User{
-		ID:       "im an id but wrong",
-		Email:    "test@test.com",
-		Phone:    "+7812324524",
-		Password: "12345pAsSWORd*",
+	test.Run("it should not update an user (mocked), modified by another request", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		// the version changed between the check of the procedure and its update
+		m.ExpectExec(regexp.QuoteMeta(spUpdate)).
+			WithArgs("im an id", 4, "test@test.com", nil).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		r := repository.NewRepository(db)
+		err = r.Update(context.Background(), &internal.UserPatch{ID: "im an id", Email: ptr("test@test.com"), Version: 4})
+		assert.ErrorIs(t, err, internal.ErrPreconditionFailed)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+}
+
+func TestUpdatePassword(test *testing.T) {
+	change := &internal.PasswordChange{
+		ID:              "im an id",
+		CurrentPassword: "12345pAsSWORd*",
+		NewPassword:     "54321pAsSWORd*",
 	}
 
+	wrongCredentials := &mysql.MySQLError{Number: 1644, Message: "not authorized (wrong credentials)"}
+	copy(wrongCredentials.SQLState[:], "40100")
+
 	successfulCases := []struct {
 		name   string
-		input  *internal.User
-		dbUser *internal.User
+		expect func(m sqlmock.Sqlmock)
+		input  *internal.PasswordChange
 	}{
 		{
-			name:   "it should update an user (mocked)",
-			input:  inputUserOne,
-			dbUser: dbUserOne,
-		},
-		{
-			name:   "it should update an user (mocked) besides empty phone",
-			input:  inputUserTwo,
-			dbUser: dbUserTwo,
-		},
-		{
-			name:   "it should update an user (mocked) besides empty email",
-			input:  inputUserThree,
-			dbUser: dbUserThree,
+			name: "it should update the password of an user (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdatePassword)).
+					WithArgs(change.ID, change.CurrentPassword, change.NewPassword).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			input: change,
 		},
 	}
 
 	failedCases := []struct {
-		name   string
-		input  *internal.User
-		dbUser *internal.User
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		input    *internal.PasswordChange
+		expected error
 	}{
 		{
-			name:   "it should not update an user (mocked), empty id",
-			input:  inputUserFour,
-			dbUser: dbUserOne,
+			name:     "it should not update the password (mocked), nil change",
+			expect:   func(m sqlmock.Sqlmock) {},
+			input:    nil,
+			expected: internal.ErrValidation,
 		},
 		{
-			name:   "it should not update an user (mocked), empty password",
-			input:  inputUserFive,
-			dbUser: dbUserOne,
+			name:     "it should not update the password (mocked), empty id",
+			expect:   func(m sqlmock.Sqlmock) {},
+			input:    &internal.PasswordChange{CurrentPassword: change.CurrentPassword, NewPassword: change.NewPassword},
+			expected: internal.ErrValidation,
 		},
 		{
-			name:   "it should not update an user (mocked), nil user",
-			input:  nil,
-			dbUser: dbUserOne,
+			name:     "it should not update the password (mocked), empty new password",
+			expect:   func(m sqlmock.Sqlmock) {},
+			input:    &internal.PasswordChange{ID: change.ID, CurrentPassword: change.CurrentPassword},
+			expected: internal.ErrValidation,
 		},
 		{
-			name:   "it should not update an user (mocked), id not found",
-			input:  inputUserSix,
-			dbUser: dbUserOne,
+			name: "it should not update the password (mocked), wrong current password",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdatePassword)).WillReturnError(wrongCredentials)
+			},
+			input:    change,
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not update the password (mocked), user not found",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdatePassword)).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			input:    change,
+			expected: internal.ErrNotFound,
 		},
 	}
 
@@ -788,21 +993,12 @@ func TestUpdate(test *testing.T) {
 			if err != nil {
 				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 			}
-
-			assert.NoError(t, err)
-
-			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WithArgs(
-				&tc.dbUser.ID,
-				&tc.dbUser.Email,
-				&tc.dbUser.Phone,
-				&tc.dbUser.Password,
-			).WillReturnResult(sqlmock.NewResult(0, 1))
+			tc.expect(m)
 
 			r := repository.NewRepository(db)
-			err = r.Update(tc.input)
+			err = r.UpdatePassword(context.Background(), tc.input)
 			assert.NoError(t, err)
-			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
-
+			assert.NoError(t, m.ExpectationsWereMet())
 		})
 	}
 
@@ -816,21 +1012,11 @@ func TestUpdate(test *testing.T) {
 			if err != nil {
 				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 			}
-
-			assert.NoError(t, err)
-
-			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WithArgs(
-				&tc.dbUser.ID,
-				&tc.dbUser.Email,
-				&tc.dbUser.Phone,
-				&tc.dbUser.Password,
-			).WillReturnResult(sqlmock.NewResult(0, 0))
+			tc.expect(m)
 
 			r := repository.NewRepository(db)
-			err = r.Update(tc.input)
-			assert.Error(t, err)
-			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
-
+			err = r.UpdatePassword(context.Background(), tc.input)
+			assert.ErrorIs(t, err, tc.expected)
 		})
 	}
 }
@@ -926,10 +1112,11 @@ func TestDelete(test *testing.T) {
 			m.ExpectExec(regexp.QuoteMeta(spDelete)).WithArgs(
 				&tc.dbUser.ID,
 				&tc.dbUser.Password,
+				&tc.dbUser.Version,
 			).WillReturnResult(sqlmock.NewResult(0, 1))
 
 			r := repository.NewRepository(db)
-			err = r.Delete(tc.input)
+			err = r.Delete(context.Background(), tc.input)
 			assert.NoError(t, err)
 
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
@@ -955,10 +1142,11 @@ func TestDelete(test *testing.T) {
 			m.ExpectExec(regexp.QuoteMeta(spDelete)).WithArgs(
 				&tc.dbUser.ID,
 				&tc.dbUser.Password,
+				&tc.dbUser.Version,
 			).WillReturnResult(sqlmock.NewResult(0, 1))
 
 			r := repository.NewRepository(db)
-			err = r.Delete(tc.input)
+			err = r.Delete(context.Background(), tc.input)
 			assert.Error(t, err)
 
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
@@ -967,4 +1155,165 @@ func TestDelete(test *testing.T) {
 
 		})
 	}
+
+	test.Run("it should not delete an user (mocked), modified by another request", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		// the version changed between the check of the procedure and its delete
+		m.ExpectExec(regexp.QuoteMeta(spDelete)).
+			WithArgs("im an id", "12345pAsSWORd*", 4).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		r := repository.NewRepository(db)
+		err = r.Delete(context.Background(), &internal.User{ID: "im an id", Password: "12345pAsSWORd*", Version: 4})
+		assert.ErrorIs(t, err, internal.ErrPreconditionFailed)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+}
+
+func TestSpans(test *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+
+	dbUserOne := &internal.User{
+		ID: "im an id",
+	}
+
+	rowsSetOne := sqlmock.NewRows([]string{
+		"id_user",
+		"user_email",
+		"user_phone",
+		"email_verified",
+		"phone_verified",
+		"version",
+	}).AddRow(
+		"im an id",
+		"test@test.com",
+		"+7812324524",
+		false,
+		false,
+		1,
+	)
+
+	db, m, err := sqlmock.New()
+	if err != nil {
+		test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	m.ExpectQuery(regexp.QuoteMeta(spRead)).WithArgs(dbUserOne.ID).WillReturnRows(rowsSetOne)
+
+	r := repository.NewRepository(db)
+	_, err = r.Read(context.Background(), dbUserOne)
+	assert.NoError(test, err)
+
+	spans := recorder.Ended()
+	assert.Len(test, spans, 1)
+	assert.Equal(test, "sp_read_user", spans[0].Name())
+	assert.Contains(test, spans[0].Attributes(), semconv.DBOperation("sp_read_user"))
+	assert.Contains(test, spans[0].Attributes(), semconv.DBStatement(spRead))
+}
+
+func TestErrors(test *testing.T) {
+	user := &internal.User{
+		ID:       "im an id",
+		Email:    "test@test.com",
+		Phone:    "+7812324524",
+		Password: "12345pAsSWORd*",
+	}
+
+	signal := func(state string) *mysql.MySQLError {
+		me := &mysql.MySQLError{Number: 1644, Message: "not authorized (wrong credentials)"}
+		copy(me.SQLState[:], state)
+		return me
+	}
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.Repository) error
+		expected error
+	}{
+		{
+			name: "it should map a duplicated entry to conflict",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnError(&mysql.MySQLError{Number: 1062})
+			},
+			call: func(r repository.Repository) error {
+				return r.Create(context.Background(), user)
+			},
+			expected: internal.ErrConflict,
+		},
+		{
+			name: "it should map a wrong password signal to unauthorized",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spDelete)).WillReturnError(signal("40100"))
+			},
+			call: func(r repository.Repository) error {
+				return r.Delete(context.Background(), user)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should map a stale version signal to precondition failed",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdate)).WillReturnError(signal("41200"))
+			},
+			call: func(r repository.Repository) error {
+				return r.Update(context.Background(), &internal.UserPatch{ID: user.ID, Email: &user.Email, Version: 2})
+			},
+			expected: internal.ErrPreconditionFailed,
+		},
+		{
+			name: "it should map an unknown user on update to not found",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdate)).WillReturnResult(sqlmock.NewResult(0, 0))
+			},
+			call: func(r repository.Repository) error {
+				return r.Update(context.Background(), &internal.UserPatch{ID: user.ID, Email: &user.Email})
+			},
+			expected: internal.ErrNotFound,
+		},
+		{
+			name: "it should map no rows on read to not found",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spRead)).WillReturnRows(sqlmock.NewRows([]string{"id_user", "user_email", "user_phone"}))
+			},
+			call: func(r repository.Repository) error {
+				_, err := r.Read(context.Background(), user)
+				return err
+			},
+			expected: internal.ErrNotFound,
+		},
+		{
+			name: "it should map an empty id to validation",
+			expect: func(m sqlmock.Sqlmock) {
+			},
+			call: func(r repository.Repository) error {
+				return r.Delete(context.Background(), &internal.User{})
+			},
+			expected: internal.ErrValidation,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewRepository(db)
+			err = tc.call(r)
+			assert.ErrorIs(t, err, tc.expected)
+		})
+	}
 }
@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	spRevokeToken      = "CALL `go_cleanapi`.`sp_revoke_token`(?, ?);"
+	spRevokeUserTokens = "CALL `go_cleanapi`.`sp_revoke_user_tokens`(?, ?);"
+	spIsTokenRevoked   = "CALL `go_cleanapi`.`sp_is_token_revoked`(?, ?, ?);"
+)
+
+// Revocations is an interface that extends the store of revoked sessions
+type Revocations interface {
+	// Revoke ends a single session until its token expires
+	Revoke(ctx context.Context, session *internal.Session) error
+	// RevokeUser ends every session of the user issued before the given time
+	RevokeUser(ctx context.Context, uid string, before time.Time) error
+	IsRevoked(ctx context.Context, session *internal.Session) (bool, error)
+}
+
+var _ Revocations = (*revocations)(nil)
+
+type revocations struct {
+	dbConn *sql.DB
+}
+
+// NewRevocations is a constructor for a revocations store backed by the database
+func NewRevocations(db *sql.DB) Revocations {
+	return &revocations{
+		dbConn: db,
+	}
+}
+
+func (r *revocations) Revoke(ctx context.Context, session *internal.Session) error {
+	if session == nil || session.ID == "" {
+		return invalid("session ID is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_revoke_token", spRevokeToken)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spRevokeToken, session.ID, session.ExpiresAt)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (r *revocations) RevokeUser(ctx context.Context, uid string, before time.Time) error {
+	if uid == "" {
+		return invalid("ID is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_revoke_user_tokens", spRevokeUserTokens)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spRevokeUserTokens, uid, before)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (r *revocations) IsRevoked(ctx context.Context, session *internal.Session) (bool, error) {
+	if session == nil || session.UID == "" {
+		return false, invalid("session user is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_is_token_revoked", spIsTokenRevoked)
+	defer span.End()
+
+	revoked := false
+	err := r.dbConn.QueryRowContext(ctx, spIsTokenRevoked, session.ID, session.UID, session.IssuedAt).Scan(&revoked)
+	if err != nil {
+		recordError(span, err)
+		return false, fmt.Errorf("failed to check revocation: %w", mapError(err))
+	}
+
+	return revoked, nil
+}
+
+var _ Revocations = (*memoryRevocations)(nil)
+
+type memoryRevocations struct {
+	tokens   *cache.Cache
+	users    *cache.Cache
+	lifetime time.Duration
+	mu       sync.Mutex
+}
+
+// NewMemoryRevocations is a constructor for a revocations store kept in memory,
+// lifetime is the max lifetime of a token, a user revocation is dropped once every token it covers expired
+func NewMemoryRevocations(lifetime time.Duration) Revocations {
+	return &memoryRevocations{
+		tokens:   cache.New(cache.NoExpiration, 10*time.Minute),
+		users:    cache.New(cache.NoExpiration, 10*time.Minute),
+		lifetime: lifetime,
+	}
+}
+
+func (r *memoryRevocations) Revoke(_ context.Context, session *internal.Session) error {
+	if session == nil || session.ID == "" {
+		return invalid("session ID is required")
+	}
+
+	ttl := time.Until(session.ExpiresAt)
+	if session.ExpiresAt.IsZero() {
+		ttl = r.lifetime
+	}
+	if ttl <= 0 {
+		// an expired token is already rejected
+		return nil
+	}
+	r.tokens.Set(session.ID, struct{}{}, ttl)
+
+	return nil
+}
+
+func (r *memoryRevocations) RevokeUser(_ context.Context, uid string, before time.Time) error {
+	if uid == "" {
+		return invalid("ID is required")
+	}
+
+	ttl := time.Until(before.Add(r.lifetime))
+	if ttl <= 0 {
+		// every token issued before is already expired
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if current, found := r.users.Get(uid); found && current.(time.Time).After(before) {
+		return nil
+	}
+	r.users.Set(uid, before, ttl)
+
+	return nil
+}
+
+func (r *memoryRevocations) IsRevoked(_ context.Context, session *internal.Session) (bool, error) {
+	if session == nil || session.UID == "" {
+		return false, invalid("session user is required")
+	}
+
+	if _, found := r.tokens.Get(session.ID); found && session.ID != "" {
+		return true, nil
+	}
+	if before, found := r.users.Get(session.UID); found && session.IssuedAt.Before(before.(time.Time)) {
+		return true, nil
+	}
+
+	return false, nil
+}
@@ -0,0 +1,236 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spRevokeToken      = "CALL `go_cleanapi`.`sp_revoke_token`(?, ?);"
+	spRevokeUserTokens = "CALL `go_cleanapi`.`sp_revoke_user_tokens`(?, ?);"
+	spIsTokenRevoked   = "CALL `go_cleanapi`.`sp_is_token_revoked`(?, ?, ?);"
+)
+
+func TestRevocations(test *testing.T) {
+	now := time.Now()
+	session := &internal.Session{
+		ID:        "im a token id",
+		UID:       "im an id",
+		IssuedAt:  now,
+		ExpiresAt: now.Add(time.Hour),
+	}
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.Revocations) (bool, error)
+		expected bool
+	}{
+		{
+			name: "it should revoke a token (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spRevokeToken)).
+					WithArgs(session.ID, session.ExpiresAt).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.Revocations) (bool, error) {
+				return false, r.Revoke(context.Background(), session)
+			},
+		},
+		{
+			name: "it should revoke the tokens of a user (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spRevokeUserTokens)).
+					WithArgs(session.UID, now).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.Revocations) (bool, error) {
+				return false, r.RevokeUser(context.Background(), session.UID, now)
+			},
+		},
+		{
+			name: "it should find a revoked token (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spIsTokenRevoked)).
+					WithArgs(session.ID, session.UID, session.IssuedAt).
+					WillReturnRows(sqlmock.NewRows([]string{"revoked"}).AddRow(true))
+			},
+			call: func(r repository.Revocations) (bool, error) {
+				return r.IsRevoked(context.Background(), session)
+			},
+			expected: true,
+		},
+		{
+			name: "it should not find an active token (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spIsTokenRevoked)).
+					WithArgs(session.ID, session.UID, session.IssuedAt).
+					WillReturnRows(sqlmock.NewRows([]string{"revoked"}).AddRow(false))
+			},
+			call: func(r repository.Revocations) (bool, error) {
+				return r.IsRevoked(context.Background(), session)
+			},
+			expected: false,
+		},
+	}
+
+	failedCases := []struct {
+		name   string
+		expect func(m sqlmock.Sqlmock)
+		call   func(r repository.Revocations) (bool, error)
+	}{
+		{
+			name:   "it should not revoke a token, empty id",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.Revocations) (bool, error) {
+				return false, r.Revoke(context.Background(), &internal.Session{UID: session.UID})
+			},
+		},
+		{
+			name:   "it should not revoke the tokens of a user, empty uid",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.Revocations) (bool, error) {
+				return false, r.RevokeUser(context.Background(), "", now)
+			},
+		},
+		{
+			name:   "it should not check a token, nil session",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.Revocations) (bool, error) {
+				return r.IsRevoked(context.Background(), nil)
+			},
+		},
+		{
+			name: "it should not check a token, db error",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spIsTokenRevoked)).WillReturnError(errors.New("connection refused"))
+			},
+			call: func(r repository.Revocations) (bool, error) {
+				return r.IsRevoked(context.Background(), session)
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewRevocations(db)
+			revoked, err := tc.call(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, revoked)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewRevocations(db)
+			revoked, err := tc.call(r)
+			assert.Error(t, err)
+			assert.False(t, revoked)
+		})
+	}
+}
+
+func TestMemoryRevocations(test *testing.T) {
+	now := time.Now()
+
+	successfulCases := []struct {
+		name     string
+		revoke   func(r repository.Revocations) error
+		session  *internal.Session
+		expected bool
+	}{
+		{
+			name: "it should find a revoked token",
+			revoke: func(r repository.Revocations) error {
+				return r.Revoke(context.Background(), &internal.Session{ID: "token 1", ExpiresAt: now.Add(time.Hour)})
+			},
+			session:  &internal.Session{ID: "token 1", UID: "im an id", IssuedAt: now},
+			expected: true,
+		},
+		{
+			name: "it should not find another token",
+			revoke: func(r repository.Revocations) error {
+				return r.Revoke(context.Background(), &internal.Session{ID: "token 1", ExpiresAt: now.Add(time.Hour)})
+			},
+			session:  &internal.Session{ID: "token 2", UID: "im an id", IssuedAt: now},
+			expected: false,
+		},
+		{
+			name: "it should find a token issued before the user revocation",
+			revoke: func(r repository.Revocations) error {
+				return r.RevokeUser(context.Background(), "im an id", now)
+			},
+			session:  &internal.Session{ID: "token 1", UID: "im an id", IssuedAt: now.Add(-time.Minute)},
+			expected: true,
+		},
+		{
+			name: "it should not find a token issued after the user revocation",
+			revoke: func(r repository.Revocations) error {
+				return r.RevokeUser(context.Background(), "im an id", now.Add(-time.Minute))
+			},
+			session:  &internal.Session{ID: "token 1", UID: "im an id", IssuedAt: now},
+			expected: false,
+		},
+		{
+			name: "it should keep the latest user revocation",
+			revoke: func(r repository.Revocations) error {
+				if err := r.RevokeUser(context.Background(), "im an id", now); err != nil {
+					return err
+				}
+				return r.RevokeUser(context.Background(), "im an id", now.Add(-time.Hour))
+			},
+			session:  &internal.Session{ID: "token 1", UID: "im an id", IssuedAt: now.Add(-time.Minute)},
+			expected: true,
+		},
+		{
+			name: "it should not keep an expired token",
+			revoke: func(r repository.Revocations) error {
+				return r.Revoke(context.Background(), &internal.Session{ID: "token 1", ExpiresAt: now.Add(-time.Minute)})
+			},
+			session:  &internal.Session{ID: "token 1", UID: "im an id", IssuedAt: now},
+			expected: false,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := repository.NewMemoryRevocations(time.Hour)
+			err := tc.revoke(r)
+			assert.NoError(t, err)
+
+			revoked, err := r.IsRevoked(context.Background(), tc.session)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, revoked)
+		})
+	}
+}
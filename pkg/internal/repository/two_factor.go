@@ -0,0 +1,186 @@
+package repository
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+const (
+	spCreateTOTP         = "CALL `go_cleanapi`.`sp_create_totp`(?, ?);"
+	spReadTOTP           = "CALL `go_cleanapi`.`sp_read_totp`(?);"
+	spEnableTOTP         = "CALL `go_cleanapi`.`sp_enable_totp`(?, ?);"
+	spCreateRecoveryCode = "CALL `go_cleanapi`.`sp_create_totp_recovery_code`(?, ?);"
+	spUseTOTPStep        = "CALL `go_cleanapi`.`sp_use_totp_step`(?, ?);"
+	spUseRecoveryCode    = "CALL `go_cleanapi`.`sp_use_totp_recovery_code`(?, ?);"
+	spDisableTOTP        = "CALL `go_cleanapi`.`sp_disable_totp`(?);"
+)
+
+// TwoFactor is an interface that extends the store of the totp secrets and recovery codes
+type TwoFactor interface {
+	// CreateTOTP stores a new secret that waits for confirmation, replacing a pending one,
+	// it returns the email of the user for the provisioning uri
+	CreateTOTP(ctx context.Context, uid string, secret string) (string, error)
+	// ReadTOTP returns the secret of the user, enabled or pending
+	ReadTOTP(ctx context.Context, uid string) (*internal.TOTP, error)
+	// EnableTOTP turns the pending secret on, step is the period of the code that confirmed it,
+	// the hashes replace every previous recovery code
+	EnableTOTP(ctx context.Context, uid string, step int64, recoveryHashes []string) error
+	// UseTOTPStep consumes the period of a valid code, so the same code can not be used twice
+	UseTOTPStep(ctx context.Context, uid string, step int64) error
+	// UseRecoveryCode consumes one of the recovery codes of the user
+	UseRecoveryCode(ctx context.Context, uid string, hash string) error
+	// DisableTOTP removes the secret and the recovery codes of the user
+	DisableTOTP(ctx context.Context, uid string) error
+}
+
+var _ TwoFactor = (*twoFactor)(nil)
+
+type twoFactor struct {
+	dbConn *sql.DB
+}
+
+// NewTwoFactor is a constructor for the two-factor auth store
+func NewTwoFactor(db *sql.DB) TwoFactor {
+	return &twoFactor{
+		dbConn: db,
+	}
+}
+
+func (r *twoFactor) CreateTOTP(ctx context.Context, uid string, secret string) (string, error) {
+	if uid == "" {
+		return "", invalid("ID is required")
+	}
+	if secret == "" {
+		return "", invalid("secret is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_create_totp", spCreateTOTP)
+	defer span.End()
+
+	var email string
+	err := r.dbConn.QueryRowContext(ctx, spCreateTOTP, uid, secret).Scan(&email)
+	if err != nil {
+		recordError(span, err)
+		return "", mapError(err)
+	}
+
+	return email, nil
+}
+
+func (r *twoFactor) ReadTOTP(ctx context.Context, uid string) (*internal.TOTP, error) {
+	if uid == "" {
+		return nil, invalid("ID is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_read_totp", spReadTOTP)
+	defer span.End()
+
+	t := &internal.TOTP{UID: uid}
+	err := r.dbConn.QueryRowContext(ctx, spReadTOTP, uid).Scan(&t.Secret, &t.Enabled, &t.LastStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, internal.NewError(internal.ErrNotFound, "two-factor auth not enrolled", err)
+	}
+	if err != nil {
+		recordError(span, err)
+		return nil, mapError(err)
+	}
+
+	return t, nil
+}
+
+func (r *twoFactor) EnableTOTP(ctx context.Context, uid string, step int64, recoveryHashes []string) error {
+	if uid == "" {
+		return invalid("ID is required")
+	}
+	if len(recoveryHashes) == 0 {
+		return invalid("recovery codes are required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_enable_totp", spEnableTOTP)
+	defer span.End()
+
+	// the secret is never enabled without its recovery codes
+	tx, err := r.dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
+		_ = tx.Rollback()
+	}()
+
+	if _, err := tx.ExecContext(ctx, spEnableTOTP, uid, step); err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+	for _, hash := range recoveryHashes {
+		if _, err := tx.ExecContext(ctx, spCreateRecoveryCode, uid, hash); err != nil {
+			recordError(span, err)
+			return mapError(err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (r *twoFactor) UseTOTPStep(ctx context.Context, uid string, step int64) error {
+	if uid == "" {
+		return invalid("ID is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_use_totp_step", spUseTOTPStep)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spUseTOTPStep, uid, step)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (r *twoFactor) UseRecoveryCode(ctx context.Context, uid string, hash string) error {
+	if uid == "" {
+		return invalid("ID is required")
+	}
+	if hash == "" {
+		return invalid("recovery code is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_use_totp_recovery_code", spUseRecoveryCode)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spUseRecoveryCode, uid, hash)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (r *twoFactor) DisableTOTP(ctx context.Context, uid string) error {
+	if uid == "" {
+		return invalid("ID is required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_disable_totp", spDisableTOTP)
+	defer span.End()
+
+	_, err := r.dbConn.ExecContext(ctx, spDisableTOTP, uid)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,248 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreateTOTP         = "CALL `go_cleanapi`.`sp_create_totp`(?, ?);"
+	spReadTOTP           = "CALL `go_cleanapi`.`sp_read_totp`(?);"
+	spEnableTOTP         = "CALL `go_cleanapi`.`sp_enable_totp`(?, ?);"
+	spCreateRecoveryCode = "CALL `go_cleanapi`.`sp_create_totp_recovery_code`(?, ?);"
+	spUseTOTPStep        = "CALL `go_cleanapi`.`sp_use_totp_step`(?, ?);"
+	spUseRecoveryCode    = "CALL `go_cleanapi`.`sp_use_totp_recovery_code`(?, ?);"
+	spDisableTOTP        = "CALL `go_cleanapi`.`sp_disable_totp`(?);"
+)
+
+func TestTwoFactor(test *testing.T) {
+	alreadyEnabled := &mysql.MySQLError{Number: 1644, Message: "two-factor auth already enabled"}
+	copy(alreadyEnabled.SQLState[:], "40900")
+	usedCode := &mysql.MySQLError{Number: 1644, Message: "invalid or used code"}
+	copy(usedCode.SQLState[:], "40100")
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.TwoFactor) (interface{}, error)
+		expected interface{}
+	}{
+		{
+			name: "it should create a totp secret (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreateTOTP)).
+					WithArgs("im an id", "im a secret").
+					WillReturnRows(sqlmock.NewRows([]string{"user_email"}).AddRow("test@test.com"))
+			},
+			call: func(r repository.TwoFactor) (interface{}, error) {
+				return r.CreateTOTP(context.Background(), "im an id", "im a secret")
+			},
+			expected: "test@test.com",
+		},
+		{
+			name: "it should read a totp secret (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+					WithArgs("im an id").
+					WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_enabled", "totp_last_step"}).
+						AddRow("im a secret", true, 42))
+			},
+			call: func(r repository.TwoFactor) (interface{}, error) {
+				return r.ReadTOTP(context.Background(), "im an id")
+			},
+			expected: &internal.TOTP{UID: "im an id", Secret: "im a secret", Enabled: true, LastStep: 42},
+		},
+		{
+			name: "it should enable totp with its recovery codes (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spEnableTOTP)).
+					WithArgs("im an id", 42).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreateRecoveryCode)).
+					WithArgs("im an id", "hash one").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreateRecoveryCode)).
+					WithArgs("im an id", "hash two").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectCommit()
+			},
+			call: func(r repository.TwoFactor) (interface{}, error) {
+				return nil, r.EnableTOTP(context.Background(), "im an id", 42, []string{"hash one", "hash two"})
+			},
+			expected: nil,
+		},
+		{
+			name: "it should use a totp step (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUseTOTPStep)).
+					WithArgs("im an id", 43).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.TwoFactor) (interface{}, error) {
+				return nil, r.UseTOTPStep(context.Background(), "im an id", 43)
+			},
+			expected: nil,
+		},
+		{
+			name: "it should use a recovery code (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).
+					WithArgs("im an id", "hash one").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.TwoFactor) (interface{}, error) {
+				return nil, r.UseRecoveryCode(context.Background(), "im an id", "hash one")
+			},
+			expected: nil,
+		},
+		{
+			name: "it should disable totp (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spDisableTOTP)).
+					WithArgs("im an id").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(r repository.TwoFactor) (interface{}, error) {
+				return nil, r.DisableTOTP(context.Background(), "im an id")
+			},
+			expected: nil,
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.TwoFactor) error
+		expected error
+	}{
+		{
+			name:   "it should not create a totp secret, missing secret",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.TwoFactor) error {
+				_, err := r.CreateTOTP(context.Background(), "im an id", "")
+				return err
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not create a totp secret, already enabled (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreateTOTP)).WillReturnError(alreadyEnabled)
+			},
+			call: func(r repository.TwoFactor) error {
+				_, err := r.CreateTOTP(context.Background(), "im an id", "im a secret")
+				return err
+			},
+			expected: internal.ErrConflict,
+		},
+		{
+			name: "it should not read a totp secret, not enrolled (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+					WillReturnRows(sqlmock.NewRows([]string{"totp_secret", "totp_enabled", "totp_last_step"}))
+			},
+			call: func(r repository.TwoFactor) error {
+				_, err := r.ReadTOTP(context.Background(), "im an id")
+				return err
+			},
+			expected: internal.ErrNotFound,
+		},
+		{
+			name:   "it should not enable totp, missing recovery codes",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.TwoFactor) error {
+				return r.EnableTOTP(context.Background(), "im an id", 42, nil)
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not enable totp, recovery code error rolls back (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spEnableTOTP)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreateRecoveryCode)).WillReturnError(errors.New("connection refused"))
+				m.ExpectRollback()
+			},
+			call: func(r repository.TwoFactor) error {
+				return r.EnableTOTP(context.Background(), "im an id", 42, []string{"hash one"})
+			},
+		},
+		{
+			name: "it should not use a totp step, used step (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUseTOTPStep)).WillReturnError(usedCode)
+			},
+			call: func(r repository.TwoFactor) error {
+				return r.UseTOTPStep(context.Background(), "im an id", 42)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not use a recovery code, used code (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).WillReturnError(usedCode)
+			},
+			call: func(r repository.TwoFactor) error {
+				return r.UseRecoveryCode(context.Background(), "im an id", "hash one")
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name:   "it should not disable totp, empty id",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.TwoFactor) error {
+				return r.DisableTOTP(context.Background(), "")
+			},
+			expected: internal.ErrValidation,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewTwoFactor(db)
+			res, err := tc.call(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewTwoFactor(db)
+			err = tc.call(r)
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
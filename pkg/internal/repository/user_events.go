@@ -0,0 +1,190 @@
+package repository
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"database/sql"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	spCreateUserEvent   = "CALL `go_cleanapi`.`sp_create_user_event`(?, ?, ?, ?);"
+	spReadUserEvents    = "CALL `go_cleanapi`.`sp_read_user_events`(?, ?);"
+	spReadLastUserEvent = "CALL `go_cleanapi`.`sp_read_last_user_event`();"
+)
+
+// UserEvents is an interface that extends the buffer of user events, it keeps the last
+// events up to its size so the streams that reconnect can resume where they stopped
+type UserEvents interface {
+	// Append stores the event and sets its id, the oldest events past the size are dropped
+	Append(ctx context.Context, event *internal.UserEvent) error
+	// ReadAfter reads up to limit events with an id greater than the given one, oldest first
+	ReadAfter(ctx context.Context, id int64, limit int) (internal.UserEvents, error)
+	// LastID is the id of the last event, 0 when there is none
+	LastID(ctx context.Context) (int64, error)
+}
+
+var _ UserEvents = (*userEvents)(nil)
+
+type userEvents struct {
+	dbConn *sql.DB
+	size   int
+}
+
+// NewUserEvents is a constructor for a user events buffer backed by the database,
+// shared by every instance
+func NewUserEvents(db *sql.DB, size int) UserEvents {
+	return &userEvents{
+		dbConn: db,
+		size:   size,
+	}
+}
+
+func (r *userEvents) Append(ctx context.Context, event *internal.UserEvent) error {
+	if event == nil {
+		return invalid("event is required")
+	}
+	if event.Type == "" || event.UID == "" {
+		return invalid("type and uid are required")
+	}
+
+	ctx, span := startSpan(ctx, "sp_create_user_event", spCreateUserEvent)
+	defer span.End()
+
+	err := r.dbConn.QueryRowContext(ctx, spCreateUserEvent, event.Type, event.UID, event.OccurredAt, r.size).Scan(&event.ID)
+	if err != nil {
+		recordError(span, err)
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (r *userEvents) ReadAfter(ctx context.Context, id int64, limit int) (events internal.UserEvents, err error) {
+	if limit <= 0 {
+		return nil, invalid("limit must be positive")
+	}
+
+	ctx, span := startSpan(ctx, "sp_read_user_events", spReadUserEvents)
+	defer span.End()
+
+	rows, err := r.dbConn.QueryContext(ctx, spReadUserEvents, id, limit)
+	if err != nil {
+		recordError(span, err)
+		return nil, mapError(err)
+	}
+	defer func() {
+		if cerr := rows.Close(); cerr != nil {
+			err = cerr
+		}
+	}()
+
+	events = make(internal.UserEvents, 0)
+	for rows.Next() {
+		event := &internal.UserEvent{}
+		if err := rows.Scan(&event.ID, &event.Type, &event.UID, &event.OccurredAt); err != nil {
+			recordError(span, err)
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		recordError(span, err)
+		return nil, mapError(err)
+	}
+
+	return events, nil
+}
+
+func (r *userEvents) LastID(ctx context.Context) (int64, error) {
+	ctx, span := startSpan(ctx, "sp_read_last_user_event", spReadLastUserEvent)
+	defer span.End()
+
+	var id int64
+	err := r.dbConn.QueryRowContext(ctx, spReadLastUserEvent).Scan(&id)
+	if err != nil {
+		recordError(span, err)
+		return 0, mapError(err)
+	}
+
+	return id, nil
+}
+
+var _ UserEvents = (*memoryUserEvents)(nil)
+
+type memoryUserEvents struct {
+	events internal.UserEvents
+	lastID int64
+	size   int
+	mu     sync.RWMutex
+}
+
+// NewMemoryUserEvents is a constructor for a user events buffer kept in memory, every instance
+// has its own. The ids start at the time of the start so they keep growing across restarts,
+// and a stream that resumes from an id of a previous run gets every event of the new one
+func NewMemoryUserEvents(size int) UserEvents {
+	return &memoryUserEvents{
+		events: make(internal.UserEvents, 0, size),
+		lastID: time.Now().UnixMicro(),
+		size:   size,
+	}
+}
+
+func (r *memoryUserEvents) Append(_ context.Context, event *internal.UserEvent) error {
+	if event == nil {
+		return invalid("event is required")
+	}
+	if event.Type == "" || event.UID == "" {
+		return invalid("type and uid are required")
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastID++
+	stored := *event
+	stored.ID = r.lastID
+	event.ID = r.lastID
+	if len(r.events) == r.size {
+		copy(r.events, r.events[1:])
+		r.events = r.events[:r.size-1]
+	}
+	r.events = append(r.events, &stored)
+
+	return nil
+}
+
+func (r *memoryUserEvents) ReadAfter(_ context.Context, id int64, limit int) (internal.UserEvents, error) {
+	if limit <= 0 {
+		return nil, invalid("limit must be positive")
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// the ids grow with the events, so the first one after the id is found by a binary search
+	first := sort.Search(len(r.events), func(i int) bool {
+		return r.events[i].ID > id
+	})
+	last := first + limit
+	if last > len(r.events) {
+		last = len(r.events)
+	}
+
+	events := make(internal.UserEvents, 0, last-first)
+	for _, event := range r.events[first:last] {
+		copied := *event
+		events = append(events, &copied)
+	}
+
+	return events, nil
+}
+
+func (r *memoryUserEvents) LastID(_ context.Context) (int64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.lastID, nil
+}
@@ -0,0 +1,235 @@
+package repository_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreateUserEvent   = "CALL `go_cleanapi`.`sp_create_user_event`(?, ?, ?, ?);"
+	spReadUserEvents    = "CALL `go_cleanapi`.`sp_read_user_events`(?, ?);"
+	spReadLastUserEvent = "CALL `go_cleanapi`.`sp_read_last_user_event`();"
+)
+
+func TestUserEvents(test *testing.T) {
+	occurredAt := time.Now().UTC()
+	eventColumns := []string{"id", "type", "uid", "occurred_at"}
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.UserEvents) (interface{}, error)
+		expected interface{}
+	}{
+		{
+			name: "it should append an event and set its id (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreateUserEvent)).
+					WithArgs(internal.UserCreated, "1", occurredAt, 10).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			},
+			call: func(r repository.UserEvents) (interface{}, error) {
+				event := &internal.UserEvent{Type: internal.UserCreated, UID: "1", OccurredAt: occurredAt}
+				err := r.Append(context.Background(), event)
+				return event.ID, err
+			},
+			expected: int64(7),
+		},
+		{
+			name: "it should read the events after an id (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadUserEvents)).
+					WithArgs(int64(5), 2).
+					WillReturnRows(sqlmock.NewRows(eventColumns).
+						AddRow(6, internal.UserCreated, "1", occurredAt).
+						AddRow(7, internal.UserDeleted, "1", occurredAt))
+			},
+			call: func(r repository.UserEvents) (interface{}, error) {
+				return r.ReadAfter(context.Background(), 5, 2)
+			},
+			expected: internal.UserEvents{
+				{ID: 6, Type: internal.UserCreated, UID: "1", OccurredAt: occurredAt},
+				{ID: 7, Type: internal.UserDeleted, UID: "1", OccurredAt: occurredAt},
+			},
+		},
+		{
+			name: "it should read no events after the last one (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadUserEvents)).
+					WithArgs(int64(7), 2).
+					WillReturnRows(sqlmock.NewRows(eventColumns))
+			},
+			call: func(r repository.UserEvents) (interface{}, error) {
+				return r.ReadAfter(context.Background(), 7, 2)
+			},
+			expected: internal.UserEvents{},
+		},
+		{
+			name: "it should read the last id (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadLastUserEvent)).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+			},
+			call: func(r repository.UserEvents) (interface{}, error) {
+				return r.LastID(context.Background())
+			},
+			expected: int64(7),
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(r repository.UserEvents) error
+		expected error
+	}{
+		{
+			name:   "it should not append an event, empty uid",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.UserEvents) error {
+				return r.Append(context.Background(), &internal.UserEvent{Type: internal.UserCreated})
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name:   "it should not read the events, no limit",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(r repository.UserEvents) error {
+				_, err := r.ReadAfter(context.Background(), 0, 0)
+				return err
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not read the events, db error (mocked)",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadUserEvents)).WillReturnError(errors.New("connection refused"))
+			},
+			call: func(r repository.UserEvents) error {
+				_, err := r.ReadAfter(context.Background(), 0, 2)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewUserEvents(db, 10)
+			res, err := tc.call(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewUserEvents(db, 10)
+			err = tc.call(r)
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMemoryUserEvents(test *testing.T) {
+	successfulCases := []struct {
+		name     string
+		appended int
+		after    int
+		limit    int
+		expected []string
+	}{
+		{
+			name:     "it should read every event after the first",
+			appended: 3,
+			after:    0,
+			limit:    10,
+			expected: []string{"2", "3"},
+		},
+		{
+			name:     "it should read up to the limit",
+			appended: 3,
+			after:    -1,
+			limit:    2,
+			expected: []string{"1", "2"},
+		},
+		{
+			name:     "it should drop the oldest events past the size",
+			appended: 5,
+			after:    -1,
+			limit:    10,
+			expected: []string{"3", "4", "5"},
+		},
+		{
+			name:     "it should read no events after the last one",
+			appended: 3,
+			after:    2,
+			limit:    10,
+			expected: []string{},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			r := repository.NewMemoryUserEvents(3)
+			start, err := r.LastID(context.Background())
+			assert.NoError(t, err)
+
+			ids := make([]int64, 0, tc.appended)
+			for i := 1; i <= tc.appended; i++ {
+				event := &internal.UserEvent{Type: internal.UserUpdated, UID: string(rune('0' + i)), OccurredAt: time.Now()}
+				assert.NoError(t, r.Append(context.Background(), event))
+				ids = append(ids, event.ID)
+			}
+			last, err := r.LastID(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, start+int64(tc.appended), last)
+
+			// after is the index of the appended event to read after, -1 reads from the start
+			after := start
+			if tc.after >= 0 {
+				after = ids[tc.after]
+			}
+			events, err := r.ReadAfter(context.Background(), after, tc.limit)
+			assert.NoError(t, err)
+
+			uids := make([]string, 0, len(events))
+			for _, event := range events {
+				uids = append(uids, event.UID)
+			}
+			assert.Equal(t, tc.expected, uids)
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package internal
+
+import "time"
+
+// Session is a signed in user, it is identified by the id of its token
+type Session struct {
+	ID        string
+	UID       string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// RefreshToken extends a session, only the hash of the opaque token is kept.
+// Every rotation creates a new token of the same family, so a reused one revokes all of them
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	UID       string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	RevokedAt *time.Time
+}
+
+// IssuedRefreshToken is a refresh token as it is handed to the client
+type IssuedRefreshToken struct {
+	Token     string
+	UID       string
+	ExpiresAt time.Time
+}
+
+// LoginFailures are the recent failed logins of an account or of an ip,
+// LockedUntil is zero when the logins are not locked
+type LoginFailures struct {
+	Failures    int
+	LockedUntil time.Time
+}
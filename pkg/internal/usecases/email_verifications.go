@@ -0,0 +1,144 @@
+package usecases
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/utils"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	verificationMailSubject = "Verify your email"
+	verificationMailBody    = `Welcome! Please confirm this is your email.
+
+%s
+
+The link expires within %s. If you did not sign up, ignore this email.
+`
+)
+
+// EmailVerifications is an interface that extends the cases of the email verification
+type EmailVerifications interface {
+	// SendVerification mails a signed verification link when the email waits for verification,
+	// unknown or verified emails are ignored without error and every email is throttled the same way
+	SendVerification(ctx context.Context, email string) error
+	// VerifyEmail marks the email of a signed verification link as verified
+	VerifyEmail(ctx context.Context, token string) error
+}
+
+var _ EmailVerifications = (*emailVerifications)(nil)
+
+type emailVerifications struct {
+	verifications repository.EmailVerifications
+	mailer        utils.Mailer
+	jwt           utils.JWT
+	ttl           time.Duration
+	resend        time.Duration
+	verifyURL     string
+	// sent keeps the emails that can not be mailed again until the resend interval ends
+	sent *cache.Cache
+}
+
+// NewEmailVerifications is a constructor for the email verification cases, resend is the min time
+// between two emails to the same address and verifyURL is the page linked in the email,
+// without it the email only carries the token
+func NewEmailVerifications(
+	r repository.EmailVerifications,
+	m utils.Mailer,
+	j utils.JWT,
+	ttl time.Duration,
+	resend time.Duration,
+	verifyURL string,
+) EmailVerifications {
+	return &emailVerifications{
+		verifications: r,
+		mailer:        m,
+		jwt:           j,
+		ttl:           ttl,
+		resend:        resend,
+		verifyURL:     verifyURL,
+		sent:          cache.New(resend, 10*time.Minute),
+	}
+}
+
+func (v *emailVerifications) SendVerification(ctx context.Context, email string) error {
+	ctx, span := startSpan(ctx, "SendVerification")
+	defer span.End()
+
+	if email == "" {
+		return internal.NewError(internal.ErrValidation, "empty email", nil)
+	}
+
+	// the throttle comes first, so unknown emails are answered like the registered ones
+	key := strings.ToLower(email)
+	if v.resend > 0 {
+		if err := v.sent.Add(key, struct{}{}, cache.DefaultExpiration); err != nil {
+			return internal.NewError(internal.ErrTooManyRequests, "a verification email was sent recently, try again later", nil)
+		}
+	}
+
+	pending, err := v.verifications.IsPending(ctx, email)
+	if err != nil {
+		recordError(span, err)
+		v.sent.Delete(key)
+		return fmt.Errorf("failed to read email verification: %w", err)
+	}
+	if !pending {
+		return nil
+	}
+
+	token, err := v.jwt.CreateVerificationJWT(email, v.ttl)
+	if err != nil {
+		recordError(span, err)
+		v.sent.Delete(key)
+		return fmt.Errorf("failed to sign verification token: %w", err)
+	}
+
+	err = v.mailer.Send(ctx, &utils.Mail{
+		To:      email,
+		Subject: verificationMailSubject,
+		Body:    fmt.Sprintf(verificationMailBody, v.verificationLink(token), v.ttl),
+	})
+	if err != nil {
+		recordError(span, err)
+		v.sent.Delete(key)
+		return fmt.Errorf("failed to mail verification token: %w", err)
+	}
+
+	return nil
+}
+
+func (v *emailVerifications) VerifyEmail(ctx context.Context, token string) error {
+	ctx, span := startSpan(ctx, "VerifyEmail")
+	defer span.End()
+
+	if token == "" {
+		return internal.NewError(internal.ErrUnauthorized, "missing verification token", nil)
+	}
+
+	email, err := v.jwt.ParseVerificationJWT(token)
+	if err != nil {
+		return internal.NewError(internal.ErrUnauthorized, "invalid or expired verification link", err)
+	}
+
+	err = v.verifications.Verify(ctx, email)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to verify email: %w", err)
+	}
+
+	return nil
+}
+
+// verificationLink is the line of the email that carries the token
+func (v *emailVerifications) verificationLink(token string) string {
+	if v.verifyURL == "" {
+		return "Use this token to verify your email: " + token
+	}
+	return "Follow this link to verify your email: " + withToken(v.verifyURL, token)
+}
@@ -0,0 +1,203 @@
+package usecases_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spReadEmailVerification = "CALL `go_cleanapi`.`sp_read_email_verification`(?);"
+	spVerifyUserEmail       = "CALL `go_cleanapi`.`sp_verify_user_email`(?);"
+)
+
+func TestSendVerification(test *testing.T) {
+	successfulCases := []struct {
+		name    string
+		email   string
+		pending bool
+		mails   int
+	}{
+		{
+			name:    "it should mail a verification link (mocked)",
+			email:   "test@test.com",
+			pending: true,
+			mails:   1,
+		},
+		{
+			name:    "it should not mail a verified email, without error (mocked)",
+			email:   "verified@test.com",
+			pending: false,
+			mails:   0,
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		email    string
+		expect   func(m sqlmock.Sqlmock)
+		expected error
+	}{
+		{
+			name:     "it should not send a verification, empty email",
+			email:    "",
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name:  "it should not send a verification, db error (mocked)",
+			email: "test@test.com",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadEmailVerification)).WillReturnError(errors.New("connection refused"))
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			m.ExpectQuery(regexp.QuoteMeta(spReadEmailVerification)).
+				WithArgs(tc.email).
+				WillReturnRows(sqlmock.NewRows([]string{"pending"}).AddRow(tc.pending))
+
+			dir := t.TempDir()
+			mailer := utils.NewFileMailer(dir, "no-reply@test.com")
+			uc := usecases.NewEmailVerifications(repository.NewEmailVerifications(db), mailer, utils.NewJWTMock(), 24*time.Hour, time.Minute, "http://localhost/verify")
+			err = uc.SendVerification(context.Background(), tc.email)
+			assert.NoError(t, err)
+			assert.NoError(t, m.ExpectationsWereMet())
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+			assert.NoError(t, err)
+			assert.Len(t, files, tc.mails)
+			for _, f := range files {
+				content, err := os.ReadFile(f)
+				assert.NoError(t, err)
+				assert.Contains(t, string(content), "To: "+tc.email)
+				assert.Contains(t, string(content), "http://localhost/verify?token=")
+			}
+
+			// a second email to the same address waits for the resend interval
+			err = uc.SendVerification(context.Background(), tc.email)
+			assert.ErrorIs(t, err, internal.ErrTooManyRequests)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			mailer := utils.NewFileMailer(t.TempDir(), "no-reply@test.com")
+			uc := usecases.NewEmailVerifications(repository.NewEmailVerifications(db), mailer, utils.NewJWTMock(), 24*time.Hour, time.Minute, "")
+			err = uc.SendVerification(context.Background(), tc.email)
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(test *testing.T) {
+	successfulCases := []struct {
+		name  string
+		token string
+	}{
+		{
+			name:  "it should verify the email of the link (mocked)",
+			token: "test@test.com",
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		token    string
+		expect   func(m sqlmock.Sqlmock)
+		expected error
+	}{
+		{
+			name:     "it should not verify an email, missing token",
+			token:    "",
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name:  "it should not verify an email, db error (mocked)",
+			token: "test@test.com",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spVerifyUserEmail)).WillReturnError(errors.New("connection refused"))
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			m.ExpectExec(regexp.QuoteMeta(spVerifyUserEmail)).
+				WithArgs("test@test.com").
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			mailer := utils.NewFileMailer(t.TempDir(), "no-reply@test.com")
+			uc := usecases.NewEmailVerifications(repository.NewEmailVerifications(db), mailer, utils.NewJWTMock(), 24*time.Hour, time.Minute, "")
+			err = uc.VerifyEmail(context.Background(), tc.token)
+			assert.NoError(t, err)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			mailer := utils.NewFileMailer(t.TempDir(), "no-reply@test.com")
+			uc := usecases.NewEmailVerifications(repository.NewEmailVerifications(db), mailer, utils.NewJWTMock(), 24*time.Hour, time.Minute, "")
+			err = uc.VerifyEmail(context.Background(), tc.token)
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package usecases
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"fmt"
+)
+
+// Exports is an interface that extends the cases of the user exports
+type Exports interface {
+	// ExportUsers hands every user matching the filter of the request to fn, in creation order,
+	// as they are read from the store. An error of fn stops the export and is returned
+	ExportUsers(ctx context.Context, req interface{}, fn func(*internal.User) error) error
+}
+
+var _ Exports = (*exports)(nil)
+
+type exports struct {
+	exports repository.Exports
+}
+
+// NewExports is a constructor for the user exports cases
+func NewExports(r repository.Exports) Exports {
+	return &exports{
+		exports: r,
+	}
+}
+
+func (e *exports) ExportUsers(ctx context.Context, req interface{}, fn func(*internal.User) error) (err error) {
+	ctx, span := startSpan(ctx, "ExportUsers")
+	defer span.End()
+
+	filter, err := decodeFilter(req)
+	if err != nil {
+		return err
+	}
+
+	cursor, err := e.exports.OpenUsers(ctx, filter)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to export users: %w", err)
+	}
+	defer func() {
+		if cerr := cursor.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}()
+
+	for cursor.Next() {
+		user, err := cursor.User()
+		if err != nil {
+			recordError(span, err)
+			return fmt.Errorf("failed to read user: %w", err)
+		}
+		if err := fn(user); err != nil {
+			recordError(span, err)
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to export users: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package usecases_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const qExport = "FROM `go_cleanapi`.`users`"
+
+func TestExportUsers(test *testing.T) {
+	createdAt := time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)
+	columns := []string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}
+	newRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows(columns).
+			AddRow("im an id", "test@test.com", "+521234567890", createdAt, true, false).
+			AddRow("im another id", "other@test.com", "", createdAt, false, false)
+	}
+
+	test.Run("it should hand every user to fn (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(qExport)).WithArgs("test%").WillReturnRows(newRows())
+
+		req := struct {
+			Email       string
+			CreatedFrom string
+		}{Email: "test"}
+
+		emails := make([]string, 0)
+		uc := usecases.NewExports(repository.NewExports(db))
+		err = uc.ExportUsers(context.Background(), req, func(u *internal.User) error {
+			emails = append(emails, u.Email)
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"test@test.com", "other@test.com"}, emails)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+
+	test.Run("it should stop when fn fails (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(qExport)).WillReturnRows(newRows())
+
+		closed := errors.New("client went away")
+		calls := 0
+		uc := usecases.NewExports(repository.NewExports(db))
+		err = uc.ExportUsers(context.Background(), nil, func(u *internal.User) error {
+			calls++
+			return closed
+		})
+		assert.ErrorIs(t, err, closed)
+		assert.Equal(t, 1, calls)
+	})
+
+	test.Run("it should not export, query error (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(qExport)).WillReturnError(errors.New("connection refused"))
+
+		uc := usecases.NewExports(repository.NewExports(db))
+		err = uc.ExportUsers(context.Background(), nil, func(u *internal.User) error {
+			return nil
+		})
+		assert.Error(t, err)
+	})
+
+	test.Run("it should not export, invalid filter", func(t *testing.T) {
+		t.Parallel()
+
+		db, _, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+
+		req := struct{ CreatedFrom string }{CreatedFrom: "yesterday"}
+		uc := usecases.NewExports(repository.NewExports(db))
+		err = uc.ExportUsers(context.Background(), req, func(u *internal.User) error {
+			return nil
+		})
+		assert.ErrorIs(t, err, internal.ErrValidation)
+	})
+}
@@ -0,0 +1,82 @@
+package usecases
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/utils"
+	"errors"
+	"fmt"
+)
+
+// Imports is an interface that extends the cases of the bulk user imports
+type Imports interface {
+	// ImportUsers creates the users of a batch of rows and sets the status of every row,
+	// rows that failed before are kept as failed. When the whole batch fails its rows are
+	// failed too and the error is returned, a dry run creates nothing
+	ImportUsers(ctx context.Context, rows []*internal.ImportRow, dryRun bool) error
+}
+
+var _ Imports = (*imports)(nil)
+
+type imports struct {
+	imports repository.Imports
+	uuid    utils.UUID
+}
+
+// NewImports is a constructor for the bulk user imports cases
+func NewImports(r repository.Imports, uid utils.UUID) Imports {
+	return &imports{
+		imports: r,
+		uuid:    uid,
+	}
+}
+
+func (i *imports) ImportUsers(ctx context.Context, rows []*internal.ImportRow, dryRun bool) error {
+	ctx, span := startSpan(ctx, "ImportUsers")
+	defer span.End()
+
+	pending := make([]*internal.ImportRow, 0, len(rows))
+	users := make([]*internal.User, 0, len(rows))
+	for _, row := range rows {
+		if row.Err != nil || row.User == nil {
+			row.Status = internal.ImportFailed
+			if row.Err == nil {
+				row.Err = internal.NewError(internal.ErrValidation, "empty row", nil)
+			}
+			continue
+		}
+
+		row.User.ID = i.uuid.NewString()
+		pending = append(pending, row)
+		users = append(users, row.User)
+	}
+	if len(users) == 0 {
+		return nil
+	}
+
+	errs, err := i.imports.CreateUsers(ctx, users, dryRun)
+	if err != nil {
+		recordError(span, err)
+		for _, row := range pending {
+			row.Status = internal.ImportFailed
+			row.Err = err
+		}
+		return fmt.Errorf("failed to import users: %w", err)
+	}
+
+	for n, row := range pending {
+		switch {
+		case errs[n] == nil:
+			row.Status = internal.ImportCreated
+		case errors.Is(errs[n], internal.ErrConflict):
+			row.Status = internal.ImportSkipped
+			row.Err = errs[n]
+		default:
+			row.Status = internal.ImportFailed
+			row.Err = errs[n]
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package usecases_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportUsers(test *testing.T) {
+	duplicated := &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'taken@test.com' for key 'users.user_email'"}
+
+	newRows := func() []*internal.ImportRow {
+		return []*internal.ImportRow{
+			{Line: 2, User: &internal.User{Email: "test@test.com", Password: "12345pAsSWORd*"}},
+			{Line: 3, User: &internal.User{Email: "taken@test.com", Password: "12345pAsSWORd*"}},
+			{Line: 4, Err: internal.NewError(internal.ErrValidation, "invalid row", nil)},
+		}
+	}
+
+	successfulCases := []struct {
+		name     string
+		dryRun   bool
+		expect   func(m sqlmock.Sqlmock)
+		expected []string
+	}{
+		{
+			name:   "it should report created, skipped and failed rows (mocked)",
+			dryRun: false,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).
+					WithArgs(sqlmock.AnyArg(), "test@test.com", "", "12345pAsSWORd*").
+					WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnError(duplicated)
+				m.ExpectCommit()
+			},
+			expected: []string{internal.ImportCreated, internal.ImportSkipped, internal.ImportFailed},
+		},
+		{
+			name:   "it should report a dry run without creating anyone (mocked)",
+			dryRun: true,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectBegin()
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectExec(regexp.QuoteMeta(spCreate)).WillReturnResult(sqlmock.NewResult(0, 1))
+				m.ExpectRollback()
+			},
+			expected: []string{internal.ImportCreated, internal.ImportCreated, internal.ImportFailed},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			rows := newRows()
+			uc := usecases.NewImports(repository.NewImports(db), utils.NewUUIDMock())
+			err = uc.ImportUsers(context.Background(), rows, tc.dryRun)
+			assert.NoError(t, err)
+			for n, row := range rows {
+				assert.Equal(t, tc.expected[n], row.Status)
+			}
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	test.Run("it should fail every row of a failed batch (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectBegin().WillReturnError(errors.New("connection refused"))
+
+		rows := newRows()
+		uc := usecases.NewImports(repository.NewImports(db), utils.NewUUIDMock())
+		err = uc.ImportUsers(context.Background(), rows, false)
+		assert.Error(t, err)
+		for _, row := range rows {
+			assert.Equal(t, internal.ImportFailed, row.Status)
+			assert.Error(t, row.Err)
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package usecases
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	accountKeyPrefix = "account:"
+	ipKeyPrefix      = "ip:"
+)
+
+// Lockouts is an interface that extends the cases of the failed logins,
+// the accounts are the user names as typed so unknown accounts are locked the same way
+type Lockouts interface {
+	// Check refuses a login while the account or the ip is locked, a locked account
+	// fails like wrong credentials so the response does not tell it is locked
+	Check(ctx context.Context, account string, ip string) error
+	// Fail counts a failed login of the account and the ip, once they reach their threshold
+	// every new failure locks them for twice the time of the previous one
+	Fail(ctx context.Context, account string, ip string) error
+	// Succeed forgets the failures of the account, the ones of the ip expire with the window
+	Succeed(ctx context.Context, account string) error
+	// Unlock forgets the failures and the lock of an account, an ip or both
+	Unlock(ctx context.Context, account string, ip string) error
+}
+
+var _ Lockouts = (*lockouts)(nil)
+
+type lockouts struct {
+	failures    repository.LoginFailures
+	threshold   int
+	ipThreshold int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+	window      time.Duration
+}
+
+// NewLockouts is a constructor for the failed logins cases, threshold and ipThreshold are the failures
+// of an account and of an ip before they are locked for baseDelay, the delay doubles with every new failure
+// up to maxDelay and failures are forgotten after window without new ones
+func NewLockouts(
+	r repository.LoginFailures,
+	threshold int,
+	ipThreshold int,
+	baseDelay time.Duration,
+	maxDelay time.Duration,
+	window time.Duration,
+) Lockouts {
+	return &lockouts{
+		failures:    r,
+		threshold:   threshold,
+		ipThreshold: ipThreshold,
+		baseDelay:   baseDelay,
+		maxDelay:    maxDelay,
+		window:      window,
+	}
+}
+
+func (l *lockouts) Check(ctx context.Context, account string, ip string) error {
+	ctx, span := startSpan(ctx, "CheckLockout")
+	defer span.End()
+
+	if ip != "" {
+		failures, err := l.failures.Read(ctx, ipKey(ip))
+		if err != nil {
+			recordError(span, err)
+			return fmt.Errorf("failed to read ip failures: %w", err)
+		}
+		// a locked ip is refused like a locked account, so the answer does not tell them apart
+		if time.Now().Before(failures.LockedUntil) {
+			return internal.NewError(internal.ErrUnauthorized, wrongCredentials, nil)
+		}
+	}
+
+	if account != "" {
+		failures, err := l.failures.Read(ctx, accountKey(account))
+		if err != nil {
+			recordError(span, err)
+			return fmt.Errorf("failed to read account failures: %w", err)
+		}
+		if time.Now().Before(failures.LockedUntil) {
+			return internal.NewError(internal.ErrUnauthorized, wrongCredentials, nil)
+		}
+	}
+
+	return nil
+}
+
+func (l *lockouts) Fail(ctx context.Context, account string, ip string) error {
+	ctx, span := startSpan(ctx, "FailLogin")
+	defer span.End()
+
+	if account != "" {
+		if err := l.fail(ctx, accountKey(account), l.threshold); err != nil {
+			recordError(span, err)
+			return fmt.Errorf("failed to count account failure: %w", err)
+		}
+	}
+	if ip != "" {
+		if err := l.fail(ctx, ipKey(ip), l.ipThreshold); err != nil {
+			recordError(span, err)
+			return fmt.Errorf("failed to count ip failure: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (l *lockouts) Succeed(ctx context.Context, account string) error {
+	ctx, span := startSpan(ctx, "SucceedLogin")
+	defer span.End()
+
+	if account == "" {
+		return nil
+	}
+
+	if err := l.failures.Reset(ctx, accountKey(account)); err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to reset account failures: %w", err)
+	}
+
+	return nil
+}
+
+func (l *lockouts) Unlock(ctx context.Context, account string, ip string) error {
+	ctx, span := startSpan(ctx, "Unlock")
+	defer span.End()
+
+	if account == "" && ip == "" {
+		return internal.NewError(internal.ErrValidation, "an account or an ip is required", nil)
+	}
+
+	if account != "" {
+		if err := l.failures.Reset(ctx, accountKey(account)); err != nil {
+			recordError(span, err)
+			return fmt.Errorf("failed to unlock account: %w", err)
+		}
+	}
+	if ip != "" {
+		if err := l.failures.Reset(ctx, ipKey(ip)); err != nil {
+			recordError(span, err)
+			return fmt.Errorf("failed to unlock ip: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// fail counts a failure of the key and locks it when it reached the threshold
+func (l *lockouts) fail(ctx context.Context, key string, threshold int) error {
+	failures, err := l.failures.Fail(ctx, key, l.window)
+	if err != nil {
+		return err
+	}
+	if failures < threshold {
+		return nil
+	}
+
+	return l.failures.Lock(ctx, key, time.Now().Add(l.delay(failures-threshold)))
+}
+
+// delay is the lock after the given failures over the threshold, it doubles each time up to maxDelay
+func (l *lockouts) delay(over int) time.Duration {
+	delay := l.baseDelay
+	for i := 0; i < over && delay < l.maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > l.maxDelay {
+		return l.maxDelay
+	}
+	return delay
+}
+
+// accountKey is the key of the failures of an account, emails are not case sensitive
+func accountKey(account string) string {
+	return accountKeyPrefix + strings.ToLower(strings.TrimSpace(account))
+}
+
+// ipKey is the key of the failures of an ip
+func ipKey(ip string) string {
+	return ipKeyPrefix + ip
+}
@@ -0,0 +1,129 @@
+package usecases_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockouts(test *testing.T) {
+	successfulCases := []struct {
+		name  string
+		fails int
+		delay time.Duration
+	}{
+		{
+			name:  "it should lock the account for the base delay at the threshold",
+			fails: 2,
+			delay: time.Minute,
+		},
+		{
+			name:  "it should double the lock with every new failure",
+			fails: 4,
+			delay: 4 * time.Minute,
+		},
+		{
+			name:  "it should not lock the account over the max delay",
+			fails: 10,
+			delay: 5 * time.Minute,
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		account  string
+		ip       string
+		fails    func(uc usecases.Lockouts) error
+		expected error
+	}{
+		{
+			name:    "it should refuse a locked account like wrong credentials",
+			account: "TEST@test.com",
+			ip:      "10.0.0.2",
+			fails: func(uc usecases.Lockouts) error {
+				for i := 0; i < 2; i++ {
+					if err := uc.Fail(context.Background(), "test@test.com", "10.0.0.1"); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name:    "it should refuse a locked ip, whatever the account",
+			account: "other@test.com",
+			ip:      "10.0.0.1",
+			fails: func(uc usecases.Lockouts) error {
+				for i := 0; i < 3; i++ {
+					if err := uc.Fail(context.Background(), "unknown@test.com", "10.0.0.1"); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			expected: internal.ErrUnauthorized,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			store := repository.NewMemoryLoginFailures()
+			uc := usecases.NewLockouts(store, 2, 100, time.Minute, 5*time.Minute, time.Hour)
+			for i := 0; i < tc.fails; i++ {
+				err := uc.Fail(context.Background(), "test@test.com", "10.0.0.1")
+				assert.NoError(t, err)
+			}
+
+			failures, err := store.Read(context.Background(), "account:test@test.com")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.fails, failures.Failures)
+			assert.WithinDuration(t, time.Now().Add(tc.delay), failures.LockedUntil, time.Second)
+
+			// the admin unlock and a successful login forget the lock
+			err = uc.Unlock(context.Background(), "test@test.com", "")
+			assert.NoError(t, err)
+			err = uc.Check(context.Background(), "test@test.com", "10.0.0.1")
+			assert.NoError(t, err)
+			err = uc.Fail(context.Background(), "test@test.com", "10.0.0.1")
+			assert.NoError(t, err)
+			err = uc.Succeed(context.Background(), "test@test.com")
+			assert.NoError(t, err)
+			failures, err = store.Read(context.Background(), "account:test@test.com")
+			assert.NoError(t, err)
+			assert.Zero(t, failures.Failures)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			uc := usecases.NewLockouts(repository.NewMemoryLoginFailures(), 2, 3, time.Minute, time.Hour, time.Hour)
+			err := tc.fails(uc)
+			assert.NoError(t, err)
+
+			err = uc.Check(context.Background(), tc.account, tc.ip)
+			assert.ErrorIs(t, err, tc.expected)
+		})
+	}
+
+	test.Run("it should not unlock, missing account and ip", func(t *testing.T) {
+		t.Parallel()
+
+		uc := usecases.NewLockouts(repository.NewMemoryLoginFailures(), 2, 3, time.Minute, time.Hour, time.Hour)
+		err := uc.Unlock(context.Background(), "", "")
+		assert.ErrorIs(t, err, internal.ErrValidation)
+	})
+}
@@ -0,0 +1,128 @@
+package usecases
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/utils"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	resetMailSubject = "Reset your password"
+	resetMailBody    = `Someone asked to reset the password of your account.
+
+%s
+
+It can be used once within %s. If it was not you, ignore this email, your password is unchanged.
+`
+)
+
+// PasswordResets is an interface that extends the cases of the forgotten passwords
+type PasswordResets interface {
+	// RequestPasswordReset mails a one-time reset token, unknown emails are ignored without error
+	RequestPasswordReset(ctx context.Context, email string) error
+	// ResetPassword sets the new password with a reset token and returns the id of its user
+	ResetPassword(ctx context.Context, token string, newPassword string) (string, error)
+}
+
+var _ PasswordResets = (*passwordResets)(nil)
+
+type passwordResets struct {
+	resets   repository.PasswordResets
+	mailer   utils.Mailer
+	ttl      time.Duration
+	resetURL string
+}
+
+// NewPasswordResets is a constructor for the password reset cases, resetURL is the page
+// linked in the email, without it the email only carries the token
+func NewPasswordResets(r repository.PasswordResets, m utils.Mailer, ttl time.Duration, resetURL string) PasswordResets {
+	return &passwordResets{
+		resets:   r,
+		mailer:   m,
+		ttl:      ttl,
+		resetURL: resetURL,
+	}
+}
+
+func (p *passwordResets) RequestPasswordReset(ctx context.Context, email string) error {
+	ctx, span := startSpan(ctx, "RequestPasswordReset")
+	defer span.End()
+
+	if email == "" {
+		return internal.NewError(internal.ErrValidation, "empty email", nil)
+	}
+
+	token, err := newOpaqueToken()
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to generate reset token: %w", err)
+	}
+
+	created, err := p.resets.Create(ctx, &internal.PasswordReset{
+		Hash:      hashToken(token),
+		Email:     email,
+		ExpiresAt: time.Now().Add(p.ttl),
+	})
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to create reset token: %w", err)
+	}
+	if !created {
+		// nobody has the email, the caller must not be able to tell
+		return nil
+	}
+
+	err = p.mailer.Send(ctx, &utils.Mail{
+		To:      email,
+		Subject: resetMailSubject,
+		Body:    fmt.Sprintf(resetMailBody, p.resetLink(token), p.ttl),
+	})
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to mail reset token: %w", err)
+	}
+
+	return nil
+}
+
+func (p *passwordResets) ResetPassword(ctx context.Context, token string, newPassword string) (string, error) {
+	ctx, span := startSpan(ctx, "ResetPassword")
+	defer span.End()
+
+	if token == "" {
+		return "", internal.NewError(internal.ErrUnauthorized, "missing reset token", nil)
+	}
+	if err := internal.CheckPasswordPolicy(newPassword); err != nil {
+		return "", err
+	}
+
+	uid, err := p.resets.Consume(ctx, hashToken(token), newPassword)
+	if err != nil {
+		recordError(span, err)
+		return "", fmt.Errorf("failed to reset password: %w", err)
+	}
+
+	return uid, nil
+}
+
+// resetLink is the line of the email that carries the token
+func (p *passwordResets) resetLink(token string) string {
+	if p.resetURL == "" {
+		return "Use this token to set a new password: " + token
+	}
+
+	return "Follow this link to set a new password: " + withToken(p.resetURL, token)
+}
+
+// withToken adds the token to the page as the token query parameter
+func withToken(page string, token string) string {
+	if strings.Contains(page, "?") {
+		return page + "&token=" + url.QueryEscape(token)
+	}
+	return page + "?token=" + url.QueryEscape(token)
+}
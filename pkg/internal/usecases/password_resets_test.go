@@ -0,0 +1,216 @@
+package usecases_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreatePasswordReset = "CALL `go_cleanapi`.`sp_create_password_reset`(?, ?, ?);"
+	spResetPassword       = "CALL `go_cleanapi`.`sp_reset_password`(?, ?);"
+)
+
+func TestRequestPasswordReset(test *testing.T) {
+	successfulCases := []struct {
+		name     string
+		email    string
+		affected int64
+		mails    int
+	}{
+		{
+			name:     "it should mail a reset link (mocked)",
+			email:    "test@test.com",
+			affected: 1,
+			mails:    1,
+		},
+		{
+			name:     "it should not mail an unknown email, without error (mocked)",
+			email:    "unknown@test.com",
+			affected: 0,
+			mails:    0,
+		},
+	}
+
+	failedCases := []struct {
+		name   string
+		email  string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			name:   "it should not request a reset, empty email",
+			email:  "",
+			expect: func(m sqlmock.Sqlmock) {},
+		},
+		{
+			name:  "it should not request a reset, db error (mocked)",
+			email: "test@test.com",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreatePasswordReset)).WillReturnError(errors.New("connection refused"))
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			m.ExpectExec(regexp.QuoteMeta(spCreatePasswordReset)).
+				WithArgs(sqlmock.AnyArg(), tc.email, sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, tc.affected))
+
+			dir := t.TempDir()
+			mailer := utils.NewFileMailer(dir, "no-reply@test.com")
+			uc := usecases.NewPasswordResets(repository.NewPasswordResets(db), mailer, 30*time.Minute, "http://localhost/reset")
+			err = uc.RequestPasswordReset(context.Background(), tc.email)
+			assert.NoError(t, err)
+			assert.NoError(t, m.ExpectationsWereMet())
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+			assert.NoError(t, err)
+			assert.Len(t, files, tc.mails)
+			for _, f := range files {
+				content, err := os.ReadFile(f)
+				assert.NoError(t, err)
+				assert.Contains(t, string(content), "To: "+tc.email)
+				assert.Contains(t, string(content), "http://localhost/reset?token=")
+				assert.Contains(t, string(content), "30m0s")
+			}
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			mailer := utils.NewFileMailer(t.TempDir(), "no-reply@test.com")
+			uc := usecases.NewPasswordResets(repository.NewPasswordResets(db), mailer, 30*time.Minute, "")
+			err = uc.RequestPasswordReset(context.Background(), tc.email)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestResetPassword(test *testing.T) {
+	const token = "im a reset token"
+	sum := sha256.Sum256([]byte(token))
+	hash := hex.EncodeToString(sum[:])
+
+	successfulCases := []struct {
+		name        string
+		token       string
+		newPassword string
+	}{
+		{
+			name:        "it should reset the password (mocked)",
+			token:       token,
+			newPassword: "54321pAsSWORd*",
+		},
+	}
+
+	failedCases := []struct {
+		name        string
+		token       string
+		newPassword string
+		expect      func(m sqlmock.Sqlmock)
+		expected    error
+	}{
+		{
+			name:        "it should not reset the password, missing token",
+			token:       "",
+			newPassword: "54321pAsSWORd*",
+			expect:      func(m sqlmock.Sqlmock) {},
+			expected:    internal.ErrUnauthorized,
+		},
+		{
+			name:        "it should not reset the password, weak password",
+			token:       token,
+			newPassword: "password",
+			expect:      func(m sqlmock.Sqlmock) {},
+			expected:    internal.ErrValidation,
+		},
+		{
+			name:        "it should not reset the password, used or expired token (mocked)",
+			token:       token,
+			newPassword: "54321pAsSWORd*",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spResetPassword)).
+					WithArgs(hash, "54321pAsSWORd*").
+					WillReturnRows(sqlmock.NewRows([]string{"id_user"}))
+			},
+			expected: internal.ErrUnauthorized,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			// only the hash of the token reaches the database
+			m.ExpectQuery(regexp.QuoteMeta(spResetPassword)).
+				WithArgs(hash, tc.newPassword).
+				WillReturnRows(sqlmock.NewRows([]string{"id_user"}).AddRow("im an id"))
+
+			mailer := utils.NewFileMailer(t.TempDir(), "no-reply@test.com")
+			uc := usecases.NewPasswordResets(repository.NewPasswordResets(db), mailer, time.Hour, "")
+			uid, err := uc.ResetPassword(context.Background(), tc.token, tc.newPassword)
+			assert.NoError(t, err)
+			assert.Equal(t, "im an id", uid)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			mailer := utils.NewFileMailer(t.TempDir(), "no-reply@test.com")
+			uc := usecases.NewPasswordResets(repository.NewPasswordResets(db), mailer, time.Hour, "")
+			uid, err := uc.ResetPassword(context.Background(), tc.token, tc.newPassword)
+			assert.ErrorIs(t, err, tc.expected)
+			assert.Empty(t, uid)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
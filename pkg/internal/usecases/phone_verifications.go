@@ -0,0 +1,153 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/utils"
+	"fmt"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	phoneCodeDigits = 6
+	phoneCodeBody   = "Your verification code is %s, it expires in %s."
+)
+
+// PhoneVerifications is an interface that extends the cases of the phone verification
+type PhoneVerifications interface {
+	// RequestPhoneCode texts a one-time code to the phone of the user, every user is throttled
+	RequestPhoneCode(ctx context.Context, uid string) error
+	// ConfirmPhoneCode marks the phone of the user as verified when the code matches
+	ConfirmPhoneCode(ctx context.Context, uid string, code string) error
+}
+
+var _ PhoneVerifications = (*phoneVerifications)(nil)
+
+type phoneVerifications struct {
+	otps        repository.PhoneOTPs
+	sender      utils.SMSSender
+	ttl         time.Duration
+	resend      time.Duration
+	maxAttempts int
+	// sent keeps the users that can not get a new code until the resend interval ends
+	sent *cache.Cache
+}
+
+// NewPhoneVerifications is a constructor for the phone verification cases, resend is the min time
+// between two codes to the same user and maxAttempts the wrong codes allowed before a new one is needed
+func NewPhoneVerifications(
+	r repository.PhoneOTPs,
+	s utils.SMSSender,
+	ttl time.Duration,
+	resend time.Duration,
+	maxAttempts int,
+) PhoneVerifications {
+	return &phoneVerifications{
+		otps:        r,
+		sender:      s,
+		ttl:         ttl,
+		resend:      resend,
+		maxAttempts: maxAttempts,
+		sent:        cache.New(resend, 10*time.Minute),
+	}
+}
+
+func (v *phoneVerifications) RequestPhoneCode(ctx context.Context, uid string) error {
+	ctx, span := startSpan(ctx, "RequestPhoneCode")
+	defer span.End()
+
+	if uid == "" {
+		return internal.NewError(internal.ErrValidation, "empty id", nil)
+	}
+
+	if v.resend > 0 {
+		if err := v.sent.Add(uid, struct{}{}, cache.DefaultExpiration); err != nil {
+			return internal.NewError(internal.ErrTooManyRequests, "a code was sent recently, try again later", nil)
+		}
+	}
+
+	code, err := newPhoneCode()
+	if err != nil {
+		recordError(span, err)
+		v.sent.Delete(uid)
+		return fmt.Errorf("failed to generate phone code: %w", err)
+	}
+
+	phone, err := v.otps.Create(ctx, &internal.PhoneOTP{
+		UID:       uid,
+		Hash:      phoneCodeHash(uid, code),
+		ExpiresAt: time.Now().Add(v.ttl),
+	})
+	if err != nil {
+		recordError(span, err)
+		v.sent.Delete(uid)
+		return fmt.Errorf("failed to create phone code: %w", err)
+	}
+
+	err = v.sender.Send(ctx, phone, fmt.Sprintf(phoneCodeBody, code, v.ttl))
+	if err != nil {
+		recordError(span, err)
+		v.sent.Delete(uid)
+		return fmt.Errorf("failed to text phone code: %w", err)
+	}
+
+	return nil
+}
+
+func (v *phoneVerifications) ConfirmPhoneCode(ctx context.Context, uid string, code string) error {
+	ctx, span := startSpan(ctx, "ConfirmPhoneCode")
+	defer span.End()
+
+	if uid == "" {
+		return internal.NewError(internal.ErrValidation, "empty id", nil)
+	}
+	if !isNumericCode(code, phoneCodeDigits) {
+		return internal.NewKeyedError(internal.ErrValidation, "error.code_digits",
+			"the code must have {0} digits", strconv.Itoa(phoneCodeDigits))
+	}
+
+	err := v.otps.Confirm(ctx, uid, phoneCodeHash(uid, code), v.maxAttempts)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to confirm phone code: %w", err)
+	}
+
+	return nil
+}
+
+// newPhoneCode returns a random code of phoneCodeDigits digits, leading zeros included
+func newPhoneCode() (string, error) {
+	max := big.NewInt(1)
+	for i := 0; i < phoneCodeDigits; i++ {
+		max.Mul(max, big.NewInt(10))
+	}
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", phoneCodeDigits, n), nil
+}
+
+// phoneCodeHash binds the code to its user, so equal codes of two users never share a hash
+func phoneCodeHash(uid string, code string) string {
+	return hashToken(uid + ":" + code)
+}
+
+// isNumericCode tells whether the code has exactly the given number of digits and nothing else
+func isNumericCode(code string, digits int) bool {
+	if len(code) != digits {
+		return false
+	}
+	for _, c := range code {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,229 @@
+package usecases_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreatePhoneOTP  = "CALL `go_cleanapi`.`sp_create_phone_otp`(?, ?, ?);"
+	spConfirmPhoneOTP = "CALL `go_cleanapi`.`sp_confirm_phone_otp`(?, ?, ?);"
+)
+
+func TestRequestPhoneCode(test *testing.T) {
+	noPhone := &mysql.MySQLError{Number: 1644, Message: "user has no phone"}
+	copy(noPhone.SQLState[:], "42200")
+
+	successfulCases := []struct {
+		name  string
+		uid   string
+		phone string
+	}{
+		{
+			name:  "it should text a phone code (mocked)",
+			uid:   "im an id",
+			phone: "+7812324524",
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		uid      string
+		expect   func(m sqlmock.Sqlmock)
+		expected error
+	}{
+		{
+			name:     "it should not text a code, empty id",
+			uid:      "",
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not text a code, user without phone (mocked)",
+			uid:  "im an id",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreatePhoneOTP)).WillReturnError(noPhone)
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not text a code, db error (mocked)",
+			uid:  "im an id",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreatePhoneOTP)).WillReturnError(errors.New("connection refused"))
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			m.ExpectQuery(regexp.QuoteMeta(spCreatePhoneOTP)).
+				WithArgs(tc.uid, sqlmock.AnyArg(), sqlmock.AnyArg()).
+				WillReturnRows(sqlmock.NewRows([]string{"user_phone"}).AddRow(tc.phone))
+
+			dir := t.TempDir()
+			uc := usecases.NewPhoneVerifications(repository.NewPhoneOTPs(db), utils.NewFakeSMSSender(dir), 5*time.Minute, time.Minute, 5)
+			err = uc.RequestPhoneCode(context.Background(), tc.uid)
+			assert.NoError(t, err)
+			assert.NoError(t, m.ExpectationsWereMet())
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.sms"))
+			assert.NoError(t, err)
+			assert.Len(t, files, 1)
+			for _, f := range files {
+				content, err := os.ReadFile(f)
+				assert.NoError(t, err)
+				assert.Contains(t, string(content), "To: "+tc.phone)
+				assert.Regexp(t, `code is \d{6},`, string(content))
+				assert.Contains(t, string(content), "5m0s")
+			}
+
+			// a second code to the same user waits for the resend interval
+			err = uc.RequestPhoneCode(context.Background(), tc.uid)
+			assert.ErrorIs(t, err, internal.ErrTooManyRequests)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			uc := usecases.NewPhoneVerifications(repository.NewPhoneOTPs(db), utils.NewFakeSMSSender(t.TempDir()), 5*time.Minute, time.Minute, 5)
+			err = uc.RequestPhoneCode(context.Background(), tc.uid)
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestConfirmPhoneCode(test *testing.T) {
+	const uid = "im an id"
+	sum := sha256.Sum256([]byte(uid + ":" + "012345"))
+	hash := hex.EncodeToString(sum[:])
+
+	wrongCode := &mysql.MySQLError{Number: 1644, Message: "invalid or expired code"}
+	copy(wrongCode.SQLState[:], "40100")
+	tooManyAttempts := &mysql.MySQLError{Number: 1644, Message: "too many wrong codes, request a new one"}
+	copy(tooManyAttempts.SQLState[:], "42900")
+
+	successfulCases := []struct {
+		name string
+		code string
+	}{
+		{
+			name: "it should verify the phone (mocked)",
+			code: "012345",
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		code     string
+		expect   func(m sqlmock.Sqlmock)
+		expected error
+	}{
+		{
+			name:     "it should not verify the phone, short code",
+			code:     "1234",
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name:     "it should not verify the phone, code with letters",
+			code:     "12a456",
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not verify the phone, wrong code (mocked)",
+			code: "012345",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spConfirmPhoneOTP)).WillReturnError(wrongCode)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not verify the phone, too many attempts (mocked)",
+			code: "012345",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spConfirmPhoneOTP)).WillReturnError(tooManyAttempts)
+			},
+			expected: internal.ErrTooManyRequests,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			// only the hash of the code reaches the database
+			m.ExpectExec(regexp.QuoteMeta(spConfirmPhoneOTP)).
+				WithArgs(uid, hash, 5).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+
+			uc := usecases.NewPhoneVerifications(repository.NewPhoneOTPs(db), utils.NewFakeSMSSender(t.TempDir()), 5*time.Minute, time.Minute, 5)
+			err = uc.ConfirmPhoneCode(context.Background(), uid, tc.code)
+			assert.NoError(t, err)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			uc := usecases.NewPhoneVerifications(repository.NewPhoneOTPs(db), utils.NewFakeSMSSender(t.TempDir()), 5*time.Minute, time.Minute, 5)
+			err = uc.ConfirmPhoneCode(context.Background(), uid, tc.code)
+			assert.ErrorIs(t, err, tc.expected)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
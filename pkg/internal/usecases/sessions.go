@@ -0,0 +1,259 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/utils"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// opaqueTokenSize is the number of random bytes of the tokens handed to clients, like refresh tokens
+const opaqueTokenSize = 32
+
+// Sessions is an interface that extends the cases of the user sessions
+type Sessions interface {
+	EndSession(ctx context.Context, token *utils.UserToken) error
+	EndAllSessions(ctx context.Context, uid string, before time.Time) error
+	IsSessionRevoked(ctx context.Context, token *utils.UserToken) (bool, error)
+	// IssueRefreshToken starts a new family of refresh tokens for the user
+	IssueRefreshToken(ctx context.Context, uid string) (*internal.IssuedRefreshToken, error)
+	// RotateRefreshToken exchanges a refresh token for the next one of its family
+	RotateRefreshToken(ctx context.Context, refreshToken string) (*internal.IssuedRefreshToken, error)
+	// EndRefreshToken revokes the family of the refresh token
+	EndRefreshToken(ctx context.Context, refreshToken string) error
+}
+
+var _ Sessions = (*sessions)(nil)
+
+type sessions struct {
+	revocations   repository.Revocations
+	refreshTokens repository.RefreshTokens
+	uuid          utils.UUID
+	refreshTTL    time.Duration
+}
+
+// NewSessions is a constructor for the sessions cases, refreshTTL is the lifetime of every refresh token
+func NewSessions(r repository.Revocations, rt repository.RefreshTokens, uid utils.UUID, refreshTTL time.Duration) Sessions {
+	return &sessions{
+		revocations:   r,
+		refreshTokens: rt,
+		uuid:          uid,
+		refreshTTL:    refreshTTL,
+	}
+}
+
+func (s *sessions) EndSession(ctx context.Context, token *utils.UserToken) error {
+	ctx, span := startSpan(ctx, "EndSession")
+	defer span.End()
+
+	if token == nil {
+		return internal.NewError(internal.ErrValidation, "empty token", nil)
+	}
+
+	err := s.revocations.Revoke(ctx, toSession(token))
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to end session: %w", err)
+	}
+
+	return nil
+}
+
+func (s *sessions) EndAllSessions(ctx context.Context, uid string, before time.Time) error {
+	ctx, span := startSpan(ctx, "EndAllSessions")
+	defer span.End()
+
+	if uid == "" {
+		return internal.NewError(internal.ErrValidation, "empty uid", nil)
+	}
+
+	// tokens carry the issue time in seconds, so a session started in the same second survives
+	err := s.revocations.RevokeUser(ctx, uid, before.Truncate(time.Second))
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to end all sessions: %w", err)
+	}
+
+	err = s.refreshTokens.RevokeUser(ctx, uid)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to end all sessions: %w", err)
+	}
+
+	return nil
+}
+
+func (s *sessions) IsSessionRevoked(ctx context.Context, token *utils.UserToken) (bool, error) {
+	ctx, span := startSpan(ctx, "IsSessionRevoked")
+	defer span.End()
+
+	if token == nil {
+		return false, internal.NewError(internal.ErrValidation, "empty token", nil)
+	}
+
+	revoked, err := s.revocations.IsRevoked(ctx, toSession(token))
+	if err != nil {
+		recordError(span, err)
+		return false, fmt.Errorf("failed to check session: %w", err)
+	}
+
+	return revoked, nil
+}
+
+func (s *sessions) IssueRefreshToken(ctx context.Context, uid string) (*internal.IssuedRefreshToken, error) {
+	ctx, span := startSpan(ctx, "IssueRefreshToken")
+	defer span.End()
+
+	if uid == "" {
+		return nil, internal.NewError(internal.ErrValidation, "empty uid", nil)
+	}
+
+	issued, err := newRefreshToken(uid, s.refreshTTL)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	err = s.refreshTokens.Create(ctx, &internal.RefreshToken{
+		Hash:      hashToken(issued.Token),
+		FamilyID:  s.uuid.NewString(),
+		UID:       uid,
+		ExpiresAt: issued.ExpiresAt,
+	})
+	if err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to issue refresh token: %w", err)
+	}
+
+	return issued, nil
+}
+
+func (s *sessions) RotateRefreshToken(ctx context.Context, refreshToken string) (*internal.IssuedRefreshToken, error) {
+	ctx, span := startSpan(ctx, "RotateRefreshToken")
+	defer span.End()
+
+	if refreshToken == "" {
+		return nil, internal.NewError(internal.ErrUnauthorized, "missing refresh token", nil)
+	}
+
+	hash := hashToken(refreshToken)
+	current, err := s.refreshTokens.Read(ctx, hash)
+	if errors.Is(err, internal.ErrNotFound) {
+		return nil, internal.NewError(internal.ErrUnauthorized, "invalid refresh token", nil)
+	}
+	if err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to read refresh token: %w", err)
+	}
+
+	if current.RevokedAt != nil || !time.Now().Before(current.ExpiresAt) {
+		return nil, internal.NewError(internal.ErrUnauthorized, "invalid refresh token", nil)
+	}
+	if current.UsedAt != nil {
+		// a used token is only presented again when it was stolen, the whole family is compromised
+		return nil, s.revokeFamily(ctx, current)
+	}
+
+	next, err := newRefreshToken(current.UID, s.refreshTTL)
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	err = s.refreshTokens.Rotate(ctx, hash, &internal.RefreshToken{
+		Hash:      hashToken(next.Token),
+		FamilyID:  current.FamilyID,
+		UID:       current.UID,
+		ExpiresAt: next.ExpiresAt,
+	})
+	if errors.Is(err, internal.ErrConflict) {
+		// it was used by a concurrent request
+		return nil, s.revokeFamily(ctx, current)
+	}
+	if err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to rotate refresh token: %w", err)
+	}
+
+	return next, nil
+}
+
+func (s *sessions) EndRefreshToken(ctx context.Context, refreshToken string) error {
+	ctx, span := startSpan(ctx, "EndRefreshToken")
+	defer span.End()
+
+	if refreshToken == "" {
+		return internal.NewError(internal.ErrValidation, "empty refresh token", nil)
+	}
+
+	current, err := s.refreshTokens.Read(ctx, hashToken(refreshToken))
+	if errors.Is(err, internal.ErrNotFound) {
+		// nothing to end
+		return nil
+	}
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to read refresh token: %w", err)
+	}
+
+	err = s.refreshTokens.RevokeFamily(ctx, current.FamilyID)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to end refresh token: %w", err)
+	}
+
+	return nil
+}
+
+// revokeFamily ends every refresh token of the family after a reuse
+func (s *sessions) revokeFamily(ctx context.Context, token *internal.RefreshToken) error {
+	err := s.refreshTokens.RevokeFamily(ctx, token.FamilyID)
+	if err != nil {
+		return fmt.Errorf("failed to revoke reused refresh token family: %w", err)
+	}
+	return internal.NewError(internal.ErrUnauthorized, "refresh token reused", nil)
+}
+
+// newRefreshToken is an opaque random token, it is only sent to the client
+func newRefreshToken(uid string, ttl time.Duration) (*internal.IssuedRefreshToken, error) {
+	token, err := newOpaqueToken()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+	}
+	return &internal.IssuedRefreshToken{
+		Token:     token,
+		UID:       uid,
+		ExpiresAt: time.Now().Add(ttl),
+	}, nil
+}
+
+// newOpaqueToken is a random url safe token
+func newOpaqueToken() (string, error) {
+	b := make([]byte, opaqueTokenSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashToken is the only form of an opaque token that is stored
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func toSession(token *utils.UserToken) *internal.Session {
+	return &internal.Session{
+		ID:        token.ID,
+		UID:       token.UID,
+		IssuedAt:  token.IssuedAt,
+		ExpiresAt: token.ExpiresAt,
+	}
+}
@@ -0,0 +1,326 @@
+package usecases_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"database/sql"
+	"encoding/hex"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreateRefreshToken      = "CALL `go_cleanapi`.`sp_create_refresh_token`(?, ?, ?, ?);"
+	spReadRefreshToken        = "CALL `go_cleanapi`.`sp_read_refresh_token`(?);"
+	spRotateRefreshToken      = "CALL `go_cleanapi`.`sp_rotate_refresh_token`(?, ?, ?);"
+	spRevokeRefreshFamily     = "CALL `go_cleanapi`.`sp_revoke_refresh_family`(?);"
+	spRevokeUserRefreshTokens = "CALL `go_cleanapi`.`sp_revoke_user_refresh_tokens`(?);"
+)
+
+func TestSessions(test *testing.T) {
+	now := time.Now()
+	token := &utils.UserToken{
+		ID:        "im a token id",
+		UID:       "im an id",
+		IssuedAt:  now.Add(-time.Minute),
+		ExpiresAt: now.Add(time.Hour),
+	}
+	newToken := &utils.UserToken{
+		ID:        "im a new token id",
+		UID:       "im an id",
+		IssuedAt:  now.Add(time.Second),
+		ExpiresAt: now.Add(time.Hour),
+	}
+
+	revokeUser := func(m sqlmock.Sqlmock) {
+		m.ExpectExec(regexp.QuoteMeta(spRevokeUserRefreshTokens)).
+			WithArgs(token.UID).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+
+	successfulCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		end      func(s usecases.Sessions) error
+		token    *utils.UserToken
+		expected bool
+	}{
+		{
+			name:     "it should keep an active session",
+			expect:   func(m sqlmock.Sqlmock) {},
+			end:      func(s usecases.Sessions) error { return nil },
+			token:    token,
+			expected: false,
+		},
+		{
+			name:   "it should end a session",
+			expect: func(m sqlmock.Sqlmock) {},
+			end: func(s usecases.Sessions) error {
+				return s.EndSession(context.Background(), token)
+			},
+			token:    token,
+			expected: true,
+		},
+		{
+			name:   "it should end all the sessions of a user",
+			expect: revokeUser,
+			end: func(s usecases.Sessions) error {
+				return s.EndAllSessions(context.Background(), token.UID, now)
+			},
+			token:    token,
+			expected: true,
+		},
+		{
+			name:   "it should keep the sessions started after ending all of them",
+			expect: revokeUser,
+			end: func(s usecases.Sessions) error {
+				return s.EndAllSessions(context.Background(), token.UID, now)
+			},
+			token:    newToken,
+			expected: false,
+		},
+	}
+
+	failedCases := []struct {
+		name string
+		call func(s usecases.Sessions) error
+	}{
+		{
+			name: "it should not end a session, nil token",
+			call: func(s usecases.Sessions) error {
+				return s.EndSession(context.Background(), nil)
+			},
+		},
+		{
+			name: "it should not end a session, token without id",
+			call: func(s usecases.Sessions) error {
+				return s.EndSession(context.Background(), &utils.UserToken{UID: "im an id"})
+			},
+		},
+		{
+			name: "it should not end all sessions, empty uid",
+			call: func(s usecases.Sessions) error {
+				return s.EndAllSessions(context.Background(), "", now)
+			},
+		},
+		{
+			name: "it should not check a session, nil token",
+			call: func(s usecases.Sessions) error {
+				_, err := s.IsSessionRevoked(context.Background(), nil)
+				return err
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			s := newSessions(db)
+			err = tc.end(s)
+			assert.NoError(t, err)
+
+			revoked, err := s.IsSessionRevoked(context.Background(), tc.token)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, revoked)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			s := newSessions(db)
+			err = tc.call(s)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestRefreshTokens(test *testing.T) {
+	const refreshToken = "im a refresh token"
+	sum := sha256.Sum256([]byte(refreshToken))
+	hash := hex.EncodeToString(sum[:])
+
+	now := time.Now()
+	columns := []string{"id_family", "id_user", "expires_at", "used_at", "revoked_at"}
+	alreadyUsed := &mysql.MySQLError{Number: 1644, Message: "refresh token already used"}
+	copy(alreadyUsed.SQLState[:], "40900")
+
+	successfulCases := []struct {
+		name   string
+		expect func(m sqlmock.Sqlmock)
+		call   func(s usecases.Sessions) (*internal.IssuedRefreshToken, error)
+	}{
+		{
+			name: "it should issue a refresh token",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spCreateRefreshToken)).
+					WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), "im an id", sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+			call: func(s usecases.Sessions) (*internal.IssuedRefreshToken, error) {
+				return s.IssueRefreshToken(context.Background(), "im an id")
+			},
+		},
+		{
+			name: "it should rotate a refresh token",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).
+					WithArgs(hash).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("im a family id", "im an id", now.Add(time.Hour), nil, nil))
+				m.ExpectExec(regexp.QuoteMeta(spRotateRefreshToken)).
+					WithArgs(hash, sqlmock.AnyArg(), sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+			call: func(s usecases.Sessions) (*internal.IssuedRefreshToken, error) {
+				return s.RotateRefreshToken(context.Background(), refreshToken)
+			},
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		expect   func(m sqlmock.Sqlmock)
+		call     func(s usecases.Sessions) (*internal.IssuedRefreshToken, error)
+		expected error
+	}{
+		{
+			name:   "it should not issue a refresh token, empty uid",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(s usecases.Sessions) (*internal.IssuedRefreshToken, error) {
+				return s.IssueRefreshToken(context.Background(), "")
+			},
+			expected: internal.ErrValidation,
+		},
+		{
+			name:   "it should not rotate a refresh token, missing token",
+			expect: func(m sqlmock.Sqlmock) {},
+			call: func(s usecases.Sessions) (*internal.IssuedRefreshToken, error) {
+				return s.RotateRefreshToken(context.Background(), "")
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not rotate a refresh token, unknown token",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).WillReturnError(sql.ErrNoRows)
+			},
+			call: func(s usecases.Sessions) (*internal.IssuedRefreshToken, error) {
+				return s.RotateRefreshToken(context.Background(), refreshToken)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not rotate a refresh token, expired token",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("im a family id", "im an id", now.Add(-time.Hour), nil, nil))
+			},
+			call: func(s usecases.Sessions) (*internal.IssuedRefreshToken, error) {
+				return s.RotateRefreshToken(context.Background(), refreshToken)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not rotate a refresh token, reused token revokes its family",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("im a family id", "im an id", now.Add(time.Hour), now, nil))
+				m.ExpectExec(regexp.QuoteMeta(spRevokeRefreshFamily)).
+					WithArgs("im a family id").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+			call: func(s usecases.Sessions) (*internal.IssuedRefreshToken, error) {
+				return s.RotateRefreshToken(context.Background(), refreshToken)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name: "it should not rotate a refresh token, concurrent use revokes its family",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadRefreshToken)).
+					WillReturnRows(sqlmock.NewRows(columns).AddRow("im a family id", "im an id", now.Add(time.Hour), nil, nil))
+				m.ExpectExec(regexp.QuoteMeta(spRotateRefreshToken)).WillReturnError(alreadyUsed)
+				m.ExpectExec(regexp.QuoteMeta(spRevokeRefreshFamily)).
+					WithArgs("im a family id").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+			},
+			call: func(s usecases.Sessions) (*internal.IssuedRefreshToken, error) {
+				return s.RotateRefreshToken(context.Background(), refreshToken)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			res, err := tc.call(newSessions(db))
+			assert.NoError(t, err)
+			assert.NotEmpty(t, res.Token)
+			assert.NotEqual(t, refreshToken, res.Token)
+			assert.Equal(t, "im an id", res.UID)
+			assert.True(t, res.ExpiresAt.After(now))
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			res, err := tc.call(newSessions(db))
+			assert.ErrorIs(t, err, tc.expected)
+			assert.Nil(t, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func newSessions(db *sql.DB) usecases.Sessions {
+	return usecases.NewSessions(
+		repository.NewMemoryRevocations(time.Hour),
+		repository.NewRefreshTokens(db),
+		utils.NewUUIDMock(),
+		time.Hour,
+	)
+}
@@ -0,0 +1,267 @@
+package usecases
+
+import (
+	"context"
+	"crypto/rand"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/utils"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	totpCodeDigits = 6
+	// recoveryCodes is the number of one-time codes given when two-factor auth is enabled
+	recoveryCodes = 10
+	// recoveryCodeSize is the size in bytes of a recovery code, 8 characters once encoded
+	recoveryCodeSize = 5
+)
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactor is an interface that extends the cases of the totp two-factor auth
+type TwoFactor interface {
+	// EnrollTOTP starts the enrolment with a new secret, it is not asked at login until it is confirmed
+	EnrollTOTP(ctx context.Context, uid string) (*internal.TOTPEnrollment, error)
+	// ConfirmTOTP enables two-factor auth with a code of the enrolled secret and returns the recovery codes,
+	// they are shown only this time
+	ConfirmTOTP(ctx context.Context, uid string, code string) ([]string, error)
+	// DisableTOTP turns two-factor auth off with a totp code or a recovery code
+	DisableTOTP(ctx context.Context, uid string, code string) error
+	// CompleteLogin exchanges the mfa token of a login and a totp code or a recovery code for the id of its user
+	CompleteLogin(ctx context.Context, mfaToken string, code string) (string, error)
+}
+
+var _ TwoFactor = (*twoFactor)(nil)
+
+type twoFactor struct {
+	store       repository.TwoFactor
+	totp        utils.TOTP
+	jwt         utils.JWT
+	maxAttempts int
+	// failures counts the wrong codes of every user until the window ends
+	failures *cache.Cache
+}
+
+// NewTwoFactor is a constructor for the two-factor auth cases, a user gets maxAttempts wrong codes
+// within window before every code is refused until the window ends
+func NewTwoFactor(
+	r repository.TwoFactor,
+	t utils.TOTP,
+	j utils.JWT,
+	maxAttempts int,
+	window time.Duration,
+) TwoFactor {
+	return &twoFactor{
+		store:       r,
+		totp:        t,
+		jwt:         j,
+		maxAttempts: maxAttempts,
+		failures:    cache.New(window, 10*time.Minute),
+	}
+}
+
+func (f *twoFactor) EnrollTOTP(ctx context.Context, uid string) (*internal.TOTPEnrollment, error) {
+	ctx, span := startSpan(ctx, "EnrollTOTP")
+	defer span.End()
+
+	if uid == "" {
+		return nil, internal.NewError(internal.ErrValidation, "empty id", nil)
+	}
+
+	secret, err := f.totp.GenerateSecret()
+	if err != nil {
+		recordError(span, err)
+		return nil, err
+	}
+
+	email, err := f.store.CreateTOTP(ctx, uid, secret)
+	if err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to create totp secret: %w", err)
+	}
+
+	return &internal.TOTPEnrollment{
+		Secret: secret,
+		URI:    f.totp.URI(secret, email),
+	}, nil
+}
+
+func (f *twoFactor) ConfirmTOTP(ctx context.Context, uid string, code string) ([]string, error) {
+	ctx, span := startSpan(ctx, "ConfirmTOTP")
+	defer span.End()
+
+	if uid == "" {
+		return nil, internal.NewError(internal.ErrValidation, "empty id", nil)
+	}
+	if !isNumericCode(code, totpCodeDigits) {
+		return nil, internal.NewKeyedError(internal.ErrValidation, "error.code_digits",
+			"the code must have {0} digits", strconv.Itoa(totpCodeDigits))
+	}
+	if f.blocked(uid) {
+		return nil, internal.NewError(internal.ErrTooManyRequests, "too many wrong codes, try again later", nil)
+	}
+
+	secret, err := f.store.ReadTOTP(ctx, uid)
+	if err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to read totp secret: %w", err)
+	}
+	if secret.Enabled {
+		return nil, internal.NewError(internal.ErrConflict, "two-factor auth already enabled", nil)
+	}
+
+	step, err := f.totp.Validate(secret.Secret, code, time.Now())
+	if err != nil {
+		f.fail(uid)
+		return nil, internal.NewError(internal.ErrUnauthorized, "invalid code", nil)
+	}
+
+	codes := make([]string, 0, recoveryCodes)
+	hashes := make([]string, 0, recoveryCodes)
+	for i := 0; i < recoveryCodes; i++ {
+		c, err := newRecoveryCode()
+		if err != nil {
+			recordError(span, err)
+			return nil, fmt.Errorf("failed to generate recovery code: %w", err)
+		}
+		codes = append(codes, c)
+		hashes = append(hashes, recoveryCodeHash(uid, c))
+	}
+
+	err = f.store.EnableTOTP(ctx, uid, step, hashes)
+	if err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to enable totp: %w", err)
+	}
+
+	return codes, nil
+}
+
+func (f *twoFactor) DisableTOTP(ctx context.Context, uid string, code string) error {
+	ctx, span := startSpan(ctx, "DisableTOTP")
+	defer span.End()
+
+	if uid == "" {
+		return internal.NewError(internal.ErrValidation, "empty id", nil)
+	}
+
+	err := f.checkCode(ctx, uid, code)
+	if err != nil {
+		recordError(span, err)
+		return err
+	}
+
+	err = f.store.DisableTOTP(ctx, uid)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to disable totp: %w", err)
+	}
+
+	return nil
+}
+
+func (f *twoFactor) CompleteLogin(ctx context.Context, mfaToken string, code string) (string, error) {
+	ctx, span := startSpan(ctx, "CompleteLogin")
+	defer span.End()
+
+	if mfaToken == "" {
+		return "", internal.NewError(internal.ErrUnauthorized, "missing mfa token", nil)
+	}
+
+	uid, err := f.jwt.ParseMFAJWT(mfaToken)
+	if err != nil {
+		return "", internal.NewError(internal.ErrUnauthorized, "invalid or expired mfa token, login again", err)
+	}
+
+	err = f.checkCode(ctx, uid, code)
+	if err != nil {
+		recordError(span, err)
+		return "", err
+	}
+
+	return uid, nil
+}
+
+// checkCode consumes a totp code or a recovery code of a user with two-factor auth enabled,
+// wrong codes count towards the attempts of the user
+func (f *twoFactor) checkCode(ctx context.Context, uid string, code string) error {
+	if code == "" {
+		return internal.NewError(internal.ErrValidation, "empty code", nil)
+	}
+	if f.blocked(uid) {
+		return internal.NewError(internal.ErrTooManyRequests, "too many wrong codes, try again later", nil)
+	}
+
+	var err error
+	if isNumericCode(code, totpCodeDigits) {
+		err = f.useTOTPCode(ctx, uid, code)
+	} else {
+		err = f.store.UseRecoveryCode(ctx, uid, recoveryCodeHash(uid, code))
+	}
+	if errors.Is(err, internal.ErrUnauthorized) || errors.Is(err, internal.ErrNotFound) {
+		f.fail(uid)
+		// do not tell apart a wrong code from a user without two-factor auth
+		return internal.NewError(internal.ErrUnauthorized, "invalid code", nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check code: %w", err)
+	}
+
+	return nil
+}
+
+func (f *twoFactor) useTOTPCode(ctx context.Context, uid string, code string) error {
+	secret, err := f.store.ReadTOTP(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if !secret.Enabled {
+		return internal.NewError(internal.ErrUnauthorized, "two-factor auth not enabled", nil)
+	}
+
+	step, err := f.totp.Validate(secret.Secret, code, time.Now())
+	if err != nil {
+		return internal.NewError(internal.ErrUnauthorized, "invalid code", err)
+	}
+	if step <= secret.LastStep {
+		return internal.NewError(internal.ErrUnauthorized, "code already used", nil)
+	}
+
+	// the store refuses the step too if another request used it in the meantime
+	return f.store.UseTOTPStep(ctx, uid, step)
+}
+
+func (f *twoFactor) blocked(uid string) bool {
+	n, found := f.failures.Get(uid)
+	return found && n.(int) >= f.maxAttempts
+}
+
+func (f *twoFactor) fail(uid string) {
+	if err := f.failures.Add(uid, 1, cache.DefaultExpiration); err != nil {
+		_, _ = f.failures.IncrementInt(uid, 1)
+	}
+}
+
+// newRecoveryCode returns a random code formatted as two groups of four characters
+func newRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeSize)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	c := strings.ToLower(recoveryEncoding.EncodeToString(b))
+	return c[:4] + "-" + c[4:], nil
+}
+
+// recoveryCodeHash binds the code to its user, the code is matched without its dash and case
+func recoveryCodeHash(uid string, code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashToken(uid + ":" + code)
+}
@@ -0,0 +1,399 @@
+package usecases_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"dall06/go-cleanapi/utils"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	spCreateTOTP         = "CALL `go_cleanapi`.`sp_create_totp`(?, ?);"
+	spReadTOTP           = "CALL `go_cleanapi`.`sp_read_totp`(?);"
+	spEnableTOTP         = "CALL `go_cleanapi`.`sp_enable_totp`(?, ?);"
+	spCreateRecoveryCode = "CALL `go_cleanapi`.`sp_create_totp_recovery_code`(?, ?);"
+	spUseTOTPStep        = "CALL `go_cleanapi`.`sp_use_totp_step`(?, ?);"
+	spUseRecoveryCode    = "CALL `go_cleanapi`.`sp_use_totp_recovery_code`(?, ?);"
+	spDisableTOTP        = "CALL `go_cleanapi`.`sp_disable_totp`(?);"
+
+	totpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+)
+
+var totpColumns = []string{"totp_secret", "totp_enabled", "totp_last_step"}
+
+func newTwoFactor(db *sql.DB, maxAttempts int) usecases.TwoFactor {
+	return usecases.NewTwoFactor(repository.NewTwoFactor(db), utils.NewTOTP("go-cleanapi"), utils.NewJWTMock(), maxAttempts, 5*time.Minute)
+}
+
+func currentTOTPCode(t *testing.T) string {
+	code, err := utils.NewTOTP("go-cleanapi").Code(totpSecret, time.Now())
+	if err != nil {
+		t.Fatalf("expected no error but got %v", err)
+	}
+	return code
+}
+
+func TestEnrollTOTP(test *testing.T) {
+	alreadyEnabled := &mysql.MySQLError{Number: 1644, Message: "two-factor auth already enabled"}
+	copy(alreadyEnabled.SQLState[:], "40900")
+
+	failedCases := []struct {
+		name     string
+		uid      string
+		expect   func(m sqlmock.Sqlmock)
+		expected error
+	}{
+		{
+			name:     "it should not enroll totp, empty id",
+			uid:      "",
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not enroll totp, already enabled (mocked)",
+			uid:  "im an id",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spCreateTOTP)).WillReturnError(alreadyEnabled)
+			},
+			expected: internal.ErrConflict,
+		},
+	}
+
+	test.Run("it should enroll totp (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(spCreateTOTP)).
+			WithArgs("im an id", sqlmock.AnyArg()).
+			WillReturnRows(sqlmock.NewRows([]string{"user_email"}).AddRow("test@test.com"))
+
+		uc := newTwoFactor(db, 5)
+		res, err := uc.EnrollTOTP(context.Background(), "im an id")
+		assert.NoError(t, err)
+		assert.NotEmpty(t, res.Secret)
+		assert.Contains(t, res.URI, "otpauth://totp/go-cleanapi:test@test.com?")
+		assert.Contains(t, res.URI, "secret="+res.Secret)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			uc := newTwoFactor(db, 5)
+			res, err := uc.EnrollTOTP(context.Background(), tc.uid)
+			assert.ErrorIs(t, err, tc.expected)
+			assert.Nil(t, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestConfirmTOTP(test *testing.T) {
+	failedCases := []struct {
+		name     string
+		code     string
+		expect   func(m sqlmock.Sqlmock)
+		expected error
+	}{
+		{
+			name:     "it should not confirm totp, short code",
+			code:     "1234",
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not confirm totp, not enrolled (mocked)",
+			code: "123456",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).WillReturnRows(sqlmock.NewRows(totpColumns))
+			},
+			expected: internal.ErrNotFound,
+		},
+		{
+			name: "it should not confirm totp, already enabled (mocked)",
+			code: "123456",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+					WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(totpSecret, true, -1))
+			},
+			expected: internal.ErrConflict,
+		},
+		{
+			name: "it should not confirm totp, wrong code (mocked)",
+			code: "000000",
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+					WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(totpSecret, false, -1))
+			},
+			expected: internal.ErrUnauthorized,
+		},
+	}
+
+	test.Run("it should confirm totp and return the recovery codes (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+			WithArgs("im an id").
+			WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(totpSecret, false, -1))
+		m.ExpectBegin()
+		m.ExpectExec(regexp.QuoteMeta(spEnableTOTP)).
+			WithArgs("im an id", sqlmock.AnyArg()).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		for i := 0; i < 10; i++ {
+			m.ExpectExec(regexp.QuoteMeta(spCreateRecoveryCode)).
+				WithArgs("im an id", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+		}
+		m.ExpectCommit()
+
+		uc := newTwoFactor(db, 5)
+		codes, err := uc.ConfirmTOTP(context.Background(), "im an id", currentTOTPCode(t))
+		assert.NoError(t, err)
+		assert.Len(t, codes, 10)
+		for _, c := range codes {
+			assert.Regexp(t, `^[a-z2-7]{4}-[a-z2-7]{4}$`, c)
+		}
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			uc := newTwoFactor(db, 5)
+			codes, err := uc.ConfirmTOTP(context.Background(), "im an id", tc.code)
+			assert.ErrorIs(t, err, tc.expected)
+			assert.Empty(t, codes)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestCompleteLogin(test *testing.T) {
+	const uid = "im an id"
+	sum := sha256.Sum256([]byte(uid + ":" + "abcd2345"))
+	recoveryHash := hex.EncodeToString(sum[:])
+
+	usedCode := &mysql.MySQLError{Number: 1644, Message: "invalid or used code"}
+	copy(usedCode.SQLState[:], "40100")
+
+	successfulCases := []struct {
+		name   string
+		code   func(t *testing.T) string
+		expect func(m sqlmock.Sqlmock)
+	}{
+		{
+			name: "it should complete the login with a totp code (mocked)",
+			code: currentTOTPCode,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+					WithArgs(uid).
+					WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(totpSecret, true, -1))
+				m.ExpectExec(regexp.QuoteMeta(spUseTOTPStep)).
+					WithArgs(uid, sqlmock.AnyArg()).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "it should complete the login with a recovery code (mocked)",
+			code: func(t *testing.T) string { return "ABCD-2345" },
+			expect: func(m sqlmock.Sqlmock) {
+				// only the hash of the code reaches the database
+				m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).
+					WithArgs(uid, recoveryHash).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		mfaToken string
+		code     func(t *testing.T) string
+		expect   func(m sqlmock.Sqlmock)
+		expected error
+	}{
+		{
+			name:     "it should not complete the login, missing mfa token",
+			mfaToken: "",
+			code:     currentTOTPCode,
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name:     "it should not complete the login, wrong totp code (mocked)",
+			mfaToken: uid,
+			code:     func(t *testing.T) string { return "000000" },
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+					WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(totpSecret, true, -1))
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name:     "it should not complete the login, reused totp code (mocked)",
+			mfaToken: uid,
+			code:     currentTOTPCode,
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+					WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(totpSecret, true, time.Now().Unix()/30+1))
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name:     "it should not complete the login, used recovery code (mocked)",
+			mfaToken: uid,
+			code:     func(t *testing.T) string { return "abcd-2345" },
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).WillReturnError(usedCode)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+		{
+			name:     "it should not complete the login, db error (mocked)",
+			mfaToken: uid,
+			code:     func(t *testing.T) string { return "abcd-2345" },
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).WillReturnError(errors.New("connection refused"))
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			uc := newTwoFactor(db, 5)
+			res, err := uc.CompleteLogin(context.Background(), uid, tc.code(t))
+			assert.NoError(t, err)
+			assert.Equal(t, uid, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			uc := newTwoFactor(db, 5)
+			res, err := uc.CompleteLogin(context.Background(), tc.mfaToken, tc.code(t))
+			assert.Error(t, err)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+			}
+			assert.Empty(t, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	test.Run("it should refuse every code after too many wrong ones (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).WillReturnError(usedCode)
+
+		uc := newTwoFactor(db, 1)
+		_, err = uc.CompleteLogin(context.Background(), uid, "abcd-2345")
+		assert.ErrorIs(t, err, internal.ErrUnauthorized)
+
+		// the right code is refused too, without reaching the database
+		_, err = uc.CompleteLogin(context.Background(), uid, currentTOTPCode(t))
+		assert.ErrorIs(t, err, internal.ErrTooManyRequests)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+}
+
+func TestDisableTOTP(test *testing.T) {
+	usedCode := &mysql.MySQLError{Number: 1644, Message: "invalid or used code"}
+	copy(usedCode.SQLState[:], "40100")
+
+	test.Run("it should disable totp (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectQuery(regexp.QuoteMeta(spReadTOTP)).
+			WillReturnRows(sqlmock.NewRows(totpColumns).AddRow(totpSecret, true, -1))
+		m.ExpectExec(regexp.QuoteMeta(spUseTOTPStep)).WillReturnResult(sqlmock.NewResult(0, 1))
+		m.ExpectExec(regexp.QuoteMeta(spDisableTOTP)).
+			WithArgs("im an id").
+			WillReturnResult(sqlmock.NewResult(0, 1))
+
+		uc := newTwoFactor(db, 5)
+		err = uc.DisableTOTP(context.Background(), "im an id", currentTOTPCode(t))
+		assert.NoError(t, err)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+
+	test.Run("it should not disable totp, used recovery code (mocked)", func(t *testing.T) {
+		t.Parallel()
+
+		db, m, err := sqlmock.New()
+		if err != nil {
+			test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+		}
+		m.ExpectExec(regexp.QuoteMeta(spUseRecoveryCode)).WillReturnError(usedCode)
+
+		uc := newTwoFactor(db, 5)
+		err = uc.DisableTOTP(context.Background(), "im an id", "abcd-2345")
+		assert.ErrorIs(t, err, internal.ErrUnauthorized)
+		assert.NoError(t, m.ExpectationsWereMet())
+	})
+}
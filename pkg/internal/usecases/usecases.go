@@ -2,155 +2,392 @@
 package usecases
 
 import (
+	"context"
 	"dall06/go-cleanapi/pkg/internal"
 	"dall06/go-cleanapi/pkg/internal/repository"
 	"dall06/go-cleanapi/utils"
-	"database/sql"
+	"errors"
 	"fmt"
+	"reflect"
+	"strconv"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// DefaultPageSize is the number of users listed when the request sets no limit
+	DefaultPageSize = 20
+	// MaxPageSize is the max number of users listed at once
+	MaxPageSize = 100
+	// MinContainsLength is the min number of characters of a fragment searched anywhere in a field,
+	// shorter ones would match most of the users
+	MinContainsLength = 3
+
+	tracerName = "dall06/go-cleanapi/pkg/internal/usecases"
+
+	// wrongCredentials is the only answer to a refused login, whatever the reason
+	wrongCredentials = "wrong credentials"
 )
 
 // UseCases is an interface that extend the cases
 type UseCases interface {
-	RegisterUser(req interface{}) error
-	AuthUser(req interface{}) (*internal.User, error)
-	IndexUserByID(req interface{}) (*internal.User, error)
-	IndexUsers() (internal.Users, error)
-	ModifyUser(req interface{}) error
-	DestroyUser(req interface{}) error
+	RegisterUser(ctx context.Context, req interface{}) error
+	AuthUser(ctx context.Context, req interface{}) (*internal.User, error)
+	IndexUserByID(ctx context.Context, req interface{}) (*internal.User, error)
+	IndexUsers(ctx context.Context, req interface{}) (*internal.UsersPage, error)
+	// SearchUsers is a page of the users matching fragments of their email or phone,
+	// their verification status or their creation date, at least one of them is required
+	SearchUsers(ctx context.Context, req interface{}) (*internal.UsersPage, error)
+	ModifyUser(ctx context.Context, req interface{}) error
+	ChangePassword(ctx context.Context, req interface{}) error
+	DestroyUser(ctx context.Context, req interface{}) error
 }
 
 var _ UseCases = (*cases)(nil)
 
 type cases struct {
-	repository repository.Repository
-	uuid       utils.UUID
+	repository           repository.Repository
+	uuid                 utils.UUID
+	requireVerifiedEmail bool
+	jwt                  utils.JWT
+	mfaTTL               time.Duration
+	events               UserEvents
 }
 
-// NewUseCases is a construcotr for the cases
-func NewUseCases(r repository.Repository, uid utils.UUID) UseCases {
+// NewUseCases is a construcotr for the cases, requireVerifiedEmail blocks the login
+// of users that did not verify their email yet and mfaTTL is the time a login
+// waits for the second factor of users with two-factor auth. Signups, changes and deletes
+// publish their user events
+func NewUseCases(
+	r repository.Repository,
+	uid utils.UUID,
+	requireVerifiedEmail bool,
+	j utils.JWT,
+	mfaTTL time.Duration,
+	ev UserEvents,
+) UseCases {
 	return &cases{
-		repository: r,
-		uuid:       uid,
+		repository:           r,
+		uuid:                 uid,
+		requireVerifiedEmail: requireVerifiedEmail,
+		jwt:                  j,
+		mfaTTL:               mfaTTL,
+		events:               ev,
 	}
 }
 
-func (s *cases) AuthUser(req interface{}) (*internal.User, error) {
+// startSpan opens a span named after the use case
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, "usecases."+name)
+}
+
+// recordError marks the span as failed
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+func (s *cases) AuthUser(ctx context.Context, req interface{}) (*internal.User, error) {
+	ctx, span := startSpan(ctx, "AuthUser")
+	defer span.End()
+
 	user := &internal.User{}
 
 	if req == nil {
-		return nil, fmt.Errorf("empty request")
+		return nil, internal.NewError(internal.ErrValidation, "empty request", nil)
 	}
 
 	err := mapstructure.Decode(req, &user)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode user details: %v", err)
+		return nil, internal.NewError(internal.ErrValidation, "failed to decode user details", err)
 	}
 
 	// add uuidGenerator to the user
-	res, err := s.repository.Login(user)
-	if err == sql.ErrNoRows {
-		empty := &internal.User{}
-		return empty, fmt.Errorf("failed to fetch user details: %v", "user not found")
+	res, err := s.repository.Login(ctx, user)
+	if errors.Is(err, internal.ErrNotFound) {
+		// do not tell apart an unknown user from a wrong password
+		return nil, internal.NewError(internal.ErrUnauthorized, wrongCredentials, nil)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to auth user details: %v", err)
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to auth user details: %w", err)
+	}
+	if s.requireVerifiedEmail && !res.EmailVerified {
+		// only told once the password matched, so it does not reveal whether an account exists,
+		// and it is not a failed login: the owner just has to verify the email
+		return nil, internal.NewError(internal.ErrForbidden, "email not verified", nil)
+	}
+	if res.TOTPEnabled {
+		// no session yet, the token only proves the password until the second factor is checked
+		token, err := s.jwt.CreateMFAJWT(res.ID, s.mfaTTL)
+		if err != nil {
+			recordError(span, err)
+			return nil, fmt.Errorf("failed to sign mfa token: %w", err)
+		}
+		res.MFAToken = token
 	}
 
 	return res, nil
 }
 
-func (s *cases) RegisterUser(req interface{}) error {
+func (s *cases) RegisterUser(ctx context.Context, req interface{}) error {
+	ctx, span := startSpan(ctx, "RegisterUser")
+	defer span.End()
+
 	user := &internal.User{}
 
 	if req == nil {
-		return fmt.Errorf("empty request")
+		return internal.NewError(internal.ErrValidation, "empty request", nil)
 	}
 
 	err := mapstructure.Decode(req, &user)
 	if err != nil {
-		return fmt.Errorf("failed to decode user details: %v", err)
+		return internal.NewError(internal.ErrValidation, "failed to decode user details", err)
 	}
 
 	// add uuidGenerator to the user
 	user.ID = s.uuid.NewString()
-	err = s.repository.Create(user)
+	err = s.repository.Create(ctx, user)
 	if err != nil {
-		return fmt.Errorf("failed to fetch user details: %v", err)
+		recordError(span, err)
+		return fmt.Errorf("failed to create user: %w", err)
 	}
+	s.publish(ctx, span, internal.UserCreated, user.ID)
 
 	return nil
 }
 
-func (s *cases) IndexUserByID(req interface{}) (*internal.User, error) {
+func (s *cases) IndexUserByID(ctx context.Context, req interface{}) (*internal.User, error) {
+	ctx, span := startSpan(ctx, "IndexUserByID")
+	defer span.End()
+
 	user := &internal.User{}
 
 	if req == nil {
-		return nil, fmt.Errorf("empty request")
+		return nil, internal.NewError(internal.ErrValidation, "empty request", nil)
 	}
 
 	err := mapstructure.Decode(req, &user)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode user details: %v", err)
+		return nil, internal.NewError(internal.ErrValidation, "failed to decode user details", err)
 	}
 
-	res, err := s.repository.Read(user)
-	if err == sql.ErrNoRows {
-		empty := &internal.User{}
-		return empty, nil
+	res, err := s.repository.Read(ctx, user)
+	if errors.Is(err, internal.ErrNotFound) {
+		return nil, err
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch user details: %v", err)
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to fetch user details: %w", err)
 	}
 
 	return res, nil
 }
 
-func (s *cases) IndexUsers() (internal.Users, error) {
-	users, err := s.repository.ReadAll()
+func (s *cases) IndexUsers(ctx context.Context, req interface{}) (*internal.UsersPage, error) {
+	ctx, span := startSpan(ctx, "IndexUsers")
+	defer span.End()
+
+	filter, err := decodeFilter(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultPageSize
+	}
+	if filter.Limit > MaxPageSize {
+		filter.Limit = MaxPageSize
+	}
+
+	page, err := s.repository.ReadAll(ctx, filter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch user details: %v", err)
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to fetch user details: %w", err)
 	}
-	return users, nil
+	return page, nil
 }
 
-func (s *cases) ModifyUser(req interface{}) error {
-	user := &internal.User{}
+func (s *cases) SearchUsers(ctx context.Context, req interface{}) (*internal.UsersPage, error) {
+	ctx, span := startSpan(ctx, "SearchUsers")
+	defer span.End()
+
+	filter, err := decodeFilter(req)
+	if err != nil {
+		return nil, err
+	}
 
+	if filter.Email == "" && filter.Phone == "" && filter.EmailVerified == nil && filter.PhoneVerified == nil &&
+		filter.CreatedFrom.IsZero() && filter.CreatedTo.IsZero() {
+		return nil, internal.NewError(internal.ErrValidation, "at least one search criterion is required", nil)
+	}
+	switch filter.Match {
+	case "":
+		filter.Match = internal.MatchPrefix
+	case internal.MatchPrefix:
+	case internal.MatchContains:
+		for _, fragment := range []string{filter.Email, filter.Phone} {
+			if fragment != "" && len([]rune(fragment)) < MinContainsLength {
+				return nil, internal.NewKeyedError(internal.ErrValidation, "error.contains_too_short",
+					"a contains search needs at least {0} characters", strconv.Itoa(MinContainsLength))
+			}
+		}
+	default:
+		return nil, internal.NewKeyedError(internal.ErrValidation, "error.invalid_match", "invalid match {0}", filter.Match)
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultPageSize
+	}
+	if filter.Limit > MaxPageSize {
+		filter.Limit = MaxPageSize
+	}
+
+	page, err := s.repository.ReadAll(ctx, filter)
+	if err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to search users: %w", err)
+	}
+	return page, nil
+}
+
+// decodeFilter decodes the request into a users filter, a nil request is an empty filter
+func decodeFilter(req interface{}) (*internal.UsersFilter, error) {
+	filter := &internal.UsersFilter{}
 	if req == nil {
-		return fmt.Errorf("empty request")
+		return filter, nil
 	}
 
-	err := mapstructure.Decode(req, &user)
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: stringToTimeHook,
+		Result:     filter,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create filter decoder: %w", err)
+	}
+	err = decoder.Decode(req)
+	if err != nil {
+		return nil, internal.NewError(internal.ErrValidation, "failed to decode filter", err)
+	}
+	return filter, nil
+}
+
+// stringToTimeHook decodes RFC 3339 strings into time.Time, empty strings stay as the zero time
+func stringToTimeHook(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+	if f.Kind() != reflect.String || t != reflect.TypeOf(time.Time{}) {
+		return data, nil
+	}
+	if data.(string) == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, data.(string))
+}
+
+func (s *cases) ModifyUser(ctx context.Context, req interface{}) error {
+	ctx, span := startSpan(ctx, "ModifyUser")
+	defer span.End()
+
+	patch := &internal.UserPatch{}
+
+	if req == nil {
+		return internal.NewError(internal.ErrValidation, "empty request", nil)
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		DecodeHook: emptyStringToNilHook,
+		Result:     patch,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create patch decoder: %w", err)
+	}
+	err = decoder.Decode(req)
+	if err != nil {
+		return internal.NewError(internal.ErrValidation, "failed to decode user details", err)
+	}
+
+	err = s.repository.Update(ctx, patch)
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	s.publish(ctx, span, internal.UserUpdated, patch.ID)
+
+	return nil
+}
+
+func (s *cases) ChangePassword(ctx context.Context, req interface{}) error {
+	ctx, span := startSpan(ctx, "ChangePassword")
+	defer span.End()
+
+	change := &internal.PasswordChange{}
+
+	if req == nil {
+		return internal.NewError(internal.ErrValidation, "empty request", nil)
+	}
+
+	err := mapstructure.Decode(req, &change)
 	if err != nil {
-		return fmt.Errorf("failed to decode user details: %v", err)
+		return internal.NewError(internal.ErrValidation, "failed to decode password change", err)
 	}
 
-	err = s.repository.Update(user)
+	if change.NewPassword == change.CurrentPassword {
+		return internal.NewError(internal.ErrValidation, "new password must be different from the current one", nil)
+	}
+	if err := internal.CheckPasswordPolicy(change.NewPassword); err != nil {
+		return err
+	}
+
+	err = s.repository.UpdatePassword(ctx, change)
 	if err != nil {
-		return fmt.Errorf("failed to update user: %v", err)
+		recordError(span, err)
+		return fmt.Errorf("failed to change password: %w", err)
 	}
 
 	return nil
 }
 
-func (s *cases) DestroyUser(req interface{}) error {
+// emptyStringToNilHook leaves the patch fields of empty strings as nil,
+// so a full user only changes the fields it carries while pointers are kept as they are
+func emptyStringToNilHook(f reflect.Type, t reflect.Type, data interface{}) (interface{}, error) {
+	if f.Kind() != reflect.String || t.Kind() != reflect.Ptr || data.(string) != "" {
+		return data, nil
+	}
+	return nil, nil
+}
+
+func (s *cases) DestroyUser(ctx context.Context, req interface{}) error {
+	ctx, span := startSpan(ctx, "DestroyUser")
+	defer span.End()
+
 	user := &internal.User{}
 
 	if req == nil {
-		return fmt.Errorf("empty request")
+		return internal.NewError(internal.ErrValidation, "empty request", nil)
 	}
 
 	err := mapstructure.Decode(req, &user)
 	if err != nil {
-		return fmt.Errorf("failed to decode user details: %v", err)
+		return internal.NewError(internal.ErrValidation, "failed to decode user details", err)
 	}
 
-	err = s.repository.Delete(user)
+	err = s.repository.Delete(ctx, user)
 	if err != nil {
-		return fmt.Errorf("failed to fetch user details: %v", err)
+		recordError(span, err)
+		return fmt.Errorf("failed to delete user: %w", err)
 	}
+	s.publish(ctx, span, internal.UserDeleted, user.ID)
 
 	return nil
 }
+
+// publish publishes a user event of a change that is already done, so a failed event
+// is recorded in the span of the change but does not fail it
+func (s *cases) publish(ctx context.Context, span trace.Span, eventType string, uid string) {
+	if err := s.events.Publish(ctx, eventType, uid); err != nil {
+		recordError(span, err)
+	}
+}
@@ -2,27 +2,33 @@
 package usecases_test
 
 import (
+	"context"
 	"dall06/go-cleanapi/pkg/adapter/controller"
 	"dall06/go-cleanapi/pkg/internal"
 	"dall06/go-cleanapi/pkg/internal/repository"
 	"dall06/go-cleanapi/pkg/internal/usecases"
 	"dall06/go-cleanapi/utils"
 	"database/sql"
-	"fmt"
+	"database/sql/driver"
 	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
 )
 
 const (
-	spCreate  = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
-	spRead    = "CALL `go_cleanapi`.`sp_read_user`(?);"
-	spReadAll = "CALL `go_cleanapi`.`sp_read_users`();"
-	spUpdate  = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
-	spDelete  = "CALL `go_cleanapi`.`sp_delete_user`(?, ?);"
-	spLogin   = "CALL `go_cleanapi`.`sp_login_user`(?, ?, ?);"
+	spCreate = "CALL `go_cleanapi`.`sp_create_user`(?, ?, ?, ?);"
+	spRead   = "CALL `go_cleanapi`.`sp_read_user`(?);"
+	qCount   = "SELECT COUNT(*) FROM `go_cleanapi`.`users`;"
+	qList    = "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL FROM `go_cleanapi`.`users` ORDER BY `created_at` ASC, `id_user` ASC LIMIT ?;"
+	spUpdate = "CALL `go_cleanapi`.`sp_update_user`(?, ?, ?, ?);"
+	spDelete = "CALL `go_cleanapi`.`sp_delete_user`(?, ?, ?);"
+	spLogin  = "CALL `go_cleanapi`.`sp_login_user`(?, ?, ?);"
+
+	spUpdatePassword = "CALL `go_cleanapi`.`sp_update_user_password`(?, ?, ?);"
 )
 
 func TestAuthUser(test *testing.T) {
@@ -46,18 +52,28 @@ func TestAuthUser(test *testing.T) {
 
 	rowsSetOne := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		&dbUserOne.ID,
+		true,
+		false,
 	)
 
 	rowsSetOneTwo := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	}).AddRow(
 		&dbUserOne.ID,
+		true,
+		false,
 	)
 
 	rowsSetTwo := sqlmock.NewRows([]string{
 		"id_user",
+		"email_verified",
+		"totp_enabled",
 	})
 
 	inputUserOne := &controller.User{
@@ -90,7 +106,8 @@ func TestAuthUser(test *testing.T) {
 	}
 
 	expectedOne := &internal.User{
-		ID: "im an id",
+		ID:            "im an id",
+		EmailVerified: true,
 	}
 
 	successfulCases := []struct {
@@ -216,8 +233,8 @@ func TestAuthUser(test *testing.T) {
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			res, err := uc.AuthUser(tc.input)
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.AuthUser(context.Background(), tc.input)
 
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expected, res)
@@ -246,8 +263,8 @@ func TestAuthUser(test *testing.T) {
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			res, err := uc.AuthUser(tc.input)
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.AuthUser(context.Background(), tc.input)
 
 			assert.Error(t, err)
 			assert.NotEqual(t, tc.expected, res)
@@ -257,6 +274,109 @@ func TestAuthUser(test *testing.T) {
 	}
 }
 
+func TestAuthUserVerifiedEmail(test *testing.T) {
+	input := &controller.User{
+		Email:    "test@test.com",
+		Password: "12345pAsSWORd*",
+	}
+
+	cases := []struct {
+		name                 string
+		requireVerifiedEmail bool
+		verified             bool
+		expected             error
+	}{
+		{
+			name:                 "it should login an unverified email (mocked), verification not required",
+			requireVerifiedEmail: false,
+			verified:             false,
+		},
+		{
+			name:                 "it should login a verified email (mocked), verification required",
+			requireVerifiedEmail: true,
+			verified:             true,
+		},
+		{
+			name:                 "it should not login an unverified email (mocked), verification required",
+			requireVerifiedEmail: true,
+			verified:             false,
+			expected:             internal.ErrForbidden,
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			m.ExpectQuery(regexp.QuoteMeta(spLogin)).
+				WithArgs(input.Email, "", input.Password).
+				WillReturnRows(sqlmock.NewRows([]string{"id_user", "email_verified", "totp_enabled"}).AddRow("im an id", tc.verified, false))
+
+			uc := usecases.NewUseCases(repository.NewRepository(db), utils.NewUUIDMock(), tc.requireVerifiedEmail, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.AuthUser(context.Background(), input)
+			if tc.expected != nil {
+				assert.ErrorIs(t, err, tc.expected)
+				assert.Nil(t, res)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "im an id", res.ID)
+		})
+	}
+}
+
+func TestAuthUserTwoFactor(test *testing.T) {
+	input := &controller.User{
+		Email:    "test@test.com",
+		Password: "12345pAsSWORd*",
+	}
+
+	cases := []struct {
+		name             string
+		totpEnabled      bool
+		expectedMFAToken string
+	}{
+		{
+			name:             "it should login without second factor (mocked), totp not enabled",
+			totpEnabled:      false,
+			expectedMFAToken: "",
+		},
+		{
+			name:             "it should wait for the second factor (mocked), totp enabled",
+			totpEnabled:      true,
+			expectedMFAToken: "im an id",
+		},
+	}
+
+	for _, tc := range cases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			m.ExpectQuery(regexp.QuoteMeta(spLogin)).
+				WithArgs(input.Email, "", input.Password).
+				WillReturnRows(sqlmock.NewRows([]string{"id_user", "email_verified", "totp_enabled"}).AddRow("im an id", true, tc.totpEnabled))
+
+			uc := usecases.NewUseCases(repository.NewRepository(db), utils.NewUUIDMock(), false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.AuthUser(context.Background(), input)
+			assert.NoError(t, err)
+			assert.Equal(t, "im an id", res.ID)
+			assert.Equal(t, tc.expectedMFAToken, res.MFAToken)
+		})
+	}
+}
+
 func TestRegisterUser(test *testing.T) {
 	dbUserOne := &internal.User{
 		Email:    "test@test.com",
@@ -365,9 +485,16 @@ func TestRegisterUser(test *testing.T) {
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			err = uc.RegisterUser(tc.input)
+			events := usecases.NewUserEvents(repository.NewMemoryUserEvents(10))
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, events)
+			err = uc.RegisterUser(context.Background(), tc.input)
+			assert.NoError(t, err)
+
+			published, err := events.ReadAfter(context.Background(), 0)
 			assert.NoError(t, err)
+			if assert.Len(t, published, 1) {
+				assert.Equal(t, internal.UserCreated, published[0].Type)
+			}
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
 		})
@@ -394,8 +521,8 @@ func TestRegisterUser(test *testing.T) {
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			err = uc.RegisterUser(tc.input)
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			err = uc.RegisterUser(context.Background(), tc.input)
 			assert.NotEmpty(t, err, "expected error, but got:", err)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
@@ -415,16 +542,25 @@ func TestIndexUserByID(test *testing.T) {
 		"id_user",
 		"user_email",
 		"user_phone",
+		"email_verified",
+		"phone_verified",
+		"version",
 	}).AddRow(
 		&dbUserOne.ID,
 		&dbUserOne.Email,
 		&dbUserOne.Phone,
+		false,
+		false,
+		2,
 	)
 
 	rowsSetTwo := sqlmock.NewRows([]string{
 		"id_user",
 		"user_email",
 		"user_phone",
+		"email_verified",
+		"phone_verified",
+		"version",
 	})
 
 	inputUserOne := &controller.User{
@@ -440,9 +576,10 @@ func TestIndexUserByID(test *testing.T) {
 	}
 
 	expectedOne := &internal.User{
-		ID:    "im an id",
-		Email: "test@test.com",
-		Phone: "+991234567890",
+		ID:      "im an id",
+		Email:   "test@test.com",
+		Phone:   "+991234567890",
+		Version: 2,
 	}
 
 	successfulCases := []struct {
@@ -516,8 +653,8 @@ func TestIndexUserByID(test *testing.T) {
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			res, err := uc.IndexUserByID(tc.input)
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.IndexUserByID(context.Background(), tc.input)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expected, res)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
@@ -542,8 +679,8 @@ func TestIndexUserByID(test *testing.T) {
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			res, err := uc.IndexUserByID(tc.input)
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.IndexUserByID(context.Background(), tc.input)
 			assert.Error(t, err)
 			assert.NotEqual(t, tc.expected, res)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
@@ -553,75 +690,88 @@ func TestIndexUserByID(test *testing.T) {
 }
 
 func TestIndexUsers(test *testing.T) {
+	createdAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
 	dbUserOne := &internal.User{
-		ID:    "im an id",
-		Email: "test@test.com",
-		Phone: "+991234567890",
+		ID:        "im an id",
+		Email:     "test@test.com",
+		Phone:     "+991234567890",
+		CreatedAt: &createdAt,
 	}
 	dbUserTwo := &internal.User{
-		ID:    "im an id 2",
-		Email: "test2@test.com",
-		Phone: "+891234567891",
-	}
-	dbUserThree := &internal.User{
-		ID:    "im an id 3",
-		Email: "test2@test.com",
-		Phone: "+891234567891",
+		ID:        "im an id 2",
+		Email:     "test2@test.com",
+		Phone:     "+891234567891",
+		CreatedAt: &createdAt,
 	}
 
-	rowsSetOne := sqlmock.NewRows([]string{
-		"id_user",
-		"user_email",
-		"user_phone",
-	}).AddRow(
-		dbUserOne.ID,
-		dbUserOne.Email,
-		dbUserOne.Phone,
-	).AddRow(
-		dbUserTwo.ID,
-		dbUserTwo.Email,
-		dbUserTwo.Phone,
-	)
-
-	rowsSetTwo := sqlmock.NewRows([]string{"id_user", "user_email", "user_phone"})
-
-	expectedOnes := make(internal.Users, 0)
-	expectedOnes = append(expectedOnes, dbUserOne)
-	expectedOnes = append(expectedOnes, dbUserTwo)
-
-	expectedOnesTwo := make(internal.Users, 0)
-
-	expectedOnesThree := make(internal.Users, 0)
-	expectedOnesThree = append(expectedOnesThree, dbUserOne)
-	expectedOnesThree = append(expectedOnesThree, dbUserThree)
+	columns := []string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}
 
 	successfulCases := []struct {
 		name     string
+		req      interface{}
+		list     string
+		listArgs []driver.Value
 		rows     *sqlmock.Rows
-		expected internal.Users
+		expected *internal.UsersPage
 	}{
 		{
-			name:     "it should read many users (mocked)",
-			rows:     rowsSetOne,
-			expected: expectedOnes,
+			name:     "it should index users (mocked) with the default page size",
+			req:      nil,
+			list:     qList,
+			listArgs: []driver.Value{usecases.DefaultPageSize + 1},
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUserOne.ID, dbUserOne.Email, dbUserOne.Phone, createdAt, dbUserOne.EmailVerified, dbUserOne.PhoneVerified).
+				AddRow(dbUserTwo.ID, dbUserTwo.Email, dbUserTwo.Phone, createdAt, dbUserTwo.EmailVerified, dbUserTwo.PhoneVerified),
+			expected: &internal.UsersPage{
+				Users: internal.Users{dbUserOne, dbUserTwo},
+				Total: 2,
+			},
 		},
 		{
-			name:     "it should read many users (mocked), but empty db",
-			rows:     rowsSetTwo,
-			expected: expectedOnesTwo,
+			name:     "it should index users (mocked), clamping the page size",
+			req:      &controller.ListRequest{Limit: 1000},
+			list:     qList,
+			listArgs: []driver.Value{usecases.MaxPageSize + 1},
+			rows:     sqlmock.NewRows(columns),
+			expected: &internal.UsersPage{
+				Users: internal.Users{},
+				Total: 2,
+			},
+		},
+		{
+			name: "it should index users (mocked) created in a range",
+			req: &controller.ListRequest{
+				Limit:       1,
+				CreatedFrom: "2023-05-01T00:00:00Z",
+				CreatedTo:   "2023-05-02T00:00:00Z",
+			},
+			list: "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL FROM `go_cleanapi`.`users` " +
+				"WHERE `created_at` >= ? AND `created_at` < ? ORDER BY `created_at` ASC, `id_user` ASC LIMIT ?;",
+			listArgs: []driver.Value{
+				time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2023, 5, 2, 0, 0, 0, 0, time.UTC),
+				2,
+			},
+			rows: sqlmock.NewRows(columns).
+				AddRow(dbUserOne.ID, dbUserOne.Email, dbUserOne.Phone, createdAt, dbUserOne.EmailVerified, dbUserOne.PhoneVerified),
+			expected: &internal.UsersPage{
+				Users: internal.Users{dbUserOne},
+				Total: 2,
+			},
 		},
 	}
 
 	failedCases := []struct {
-		name     string
-		rows     *sqlmock.Rows
-		dbUser   internal.Users
-		expected internal.Users
+		name string
+		req  interface{}
 	}{
 		{
-			name:     "it should not read many users (mocked), wrong values returned",
-			rows:     rowsSetOne,
-			expected: expectedOnesThree,
+			name: "it should not index users, invalid date",
+			req:  &controller.ListRequest{CreatedFrom: "yesterday"},
+		},
+		{
+			name: "it should not index users, unknown sort",
+			req:  &controller.ListRequest{Sort: "password"},
 		},
 	}
 
@@ -634,22 +784,17 @@ func TestIndexUsers(test *testing.T) {
 			if err != nil {
 				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 			}
-			assert.NoError(t, err)
 
-			m.ExpectQuery(regexp.QuoteMeta(spReadAll)).WillReturnRows(tc.rows)
+			m.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*)")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+			m.ExpectQuery(regexp.QuoteMeta(tc.list)).WithArgs(tc.listArgs...).WillReturnRows(tc.rows)
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			res, err := uc.IndexUsers()
-
-			fmt.Println("expected: ", tc.expected)
-			fmt.Println("actual: ", res)
-
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.IndexUsers(context.Background(), tc.req)
 			assert.NoError(t, err)
 			assert.Equal(t, tc.expected, res)
-			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
-
+			assert.NoError(t, m.ExpectationsWereMet())
 		})
 	}
 
@@ -658,135 +803,218 @@ func TestIndexUsers(test *testing.T) {
 		test.Run(tc.name, func(t *testing.T) {
 			t.Parallel()
 
-			db, m, err := sqlmock.New()
+			db, _, err := sqlmock.New()
 			if err != nil {
 				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 			}
-			assert.NoError(t, err)
-			m.ExpectQuery(regexp.QuoteMeta(spReadAll)).WillReturnRows(tc.rows)
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			res, err := uc.IndexUsers()
-			assert.NoError(t, err)
-			assert.NotEqual(t, tc.expected, res)
-			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
-
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.IndexUsers(context.Background(), tc.req)
+			assert.ErrorIs(t, err, internal.ErrValidation)
+			assert.Nil(t, res)
 		})
 	}
 }
 
-func TestModifyUser(test *testing.T) {
-	dbUserOne := &internal.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
+func TestSearchUsers(test *testing.T) {
+	createdAt := time.Date(2023, 5, 1, 10, 0, 0, 0, time.UTC)
+	verified := true
+	dbUser := &internal.User{
+		ID:            "im an id",
+		Email:         "test@test.com",
+		Phone:         "+991234567890",
+		CreatedAt:     &createdAt,
+		EmailVerified: true,
 	}
 
-	dbUserTwo := &internal.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "",
-		Password: "12345pAsSWORd*",
-	}
+	columns := []string{"id_user", "user_email", "user_phone", "created_at", "email_verified", "phone_verified"}
+	selected := "SELECT `id_user`, `user_email`, `user_phone`, `created_at`, `email_verified_at` IS NOT NULL, `phone_verified_at` IS NOT NULL " +
+		"FROM `go_cleanapi`.`users` "
 
-	dbUserThree := &internal.User{
-		ID:       "im an id",
-		Email:    "",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
+	successfulCases := []struct {
+		name     string
+		req      interface{}
+		list     string
+		listArgs []driver.Value
+	}{
+		{
+			name:     "it should search users (mocked) by prefix by default",
+			req:      &controller.SearchRequest{ListRequest: controller.ListRequest{Email: "test"}},
+			list:     selected + "WHERE `user_email` LIKE ? ORDER BY `created_at` ASC, `id_user` ASC LIMIT ?;",
+			listArgs: []driver.Value{"test%", usecases.DefaultPageSize + 1},
+		},
+		{
+			name: "it should search users (mocked) containing a fragment",
+			req: &controller.SearchRequest{
+				ListRequest: controller.ListRequest{Limit: 1000, Phone: "12345"},
+				Match:       internal.MatchContains,
+			},
+			list: selected + "WHERE MATCH(`user_phone`) AGAINST (? IN BOOLEAN MODE) AND `user_phone` LIKE ? " +
+				"ORDER BY `created_at` ASC, `id_user` ASC LIMIT ?;",
+			listArgs: []driver.Value{`"12345"`, "%12345%", usecases.MaxPageSize + 1},
+		},
+		{
+			name:     "it should search users (mocked) by verification status only",
+			req:      &controller.SearchRequest{EmailVerified: &verified},
+			list:     selected + "WHERE `email_verified_at` IS NOT NULL ORDER BY `created_at` ASC, `id_user` ASC LIMIT ?;",
+			listArgs: []driver.Value{usecases.DefaultPageSize + 1},
+		},
 	}
 
-	inputUserOne := &controller.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
+	failedCases := []struct {
+		name string
+		req  interface{}
+	}{
+		{
+			name: "it should not search users, no criteria",
+			req:  &controller.SearchRequest{ListRequest: controller.ListRequest{Limit: 10, Sort: "email"}},
+		},
+		{
+			name: "it should not search users, unknown match",
+			req:  &controller.SearchRequest{ListRequest: controller.ListRequest{Email: "test"}, Match: "suffix"},
+		},
+		{
+			name: "it should not search users, fragment too short to contain",
+			req:  &controller.SearchRequest{ListRequest: controller.ListRequest{Email: "te"}, Match: internal.MatchContains},
+		},
+		{
+			name: "it should not search users, invalid date",
+			req:  &controller.SearchRequest{ListRequest: controller.ListRequest{CreatedFrom: "yesterday"}},
+		},
 	}
 
-	inputUserTwo := &controller.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "",
-		Password: "12345pAsSWORd*",
-	}
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 
-	inputUserThree := &controller.User{
-		ID:       "im an id",
-		Email:    "",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
-	}
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
 
-	inputUserFour := &controller.User{
-		ID:       "",
-		Email:    "test@test.com",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
-	}
+			m.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*)")).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+			m.ExpectQuery(regexp.QuoteMeta(tc.list)).WithArgs(tc.listArgs...).WillReturnRows(sqlmock.NewRows(columns).
+				AddRow(dbUser.ID, dbUser.Email, dbUser.Phone, createdAt, dbUser.EmailVerified, dbUser.PhoneVerified))
 
-	inputUserFive := &controller.User{
-		ID:       "im an id",
-		Email:    "test@test.com",
-		Phone:    "+991234567890",
-		Password: "",
+			r := repository.NewRepository(db)
+			uuid := utils.NewUUIDMock()
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.SearchUsers(context.Background(), tc.req)
+			assert.NoError(t, err)
+			assert.Equal(t, &internal.UsersPage{Users: internal.Users{dbUser}, Total: 1}, res)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
 	}
 
-	inputUserSix := &controller.User{
-		ID:       "im an id but wrong",
-		Email:    "test@test.com",
-		Phone:    "+991234567890",
-		Password: "12345pAsSWORd*",
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, _, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			r := repository.NewRepository(db)
+			uuid := utils.NewUUIDMock()
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			res, err := uc.SearchUsers(context.Background(), tc.req)
+			assert.ErrorIs(t, err, internal.ErrValidation)
+			assert.Nil(t, res)
+		})
 	}
+}
+
+func TestModifyUser(test *testing.T) {
+	ptr := func(s string) *string { return &s }
 
 	successfulCases := []struct {
-		name   string
-		input  *controller.User
-		dbUser *internal.User
+		name  string
+		input interface{}
+		args  []driver.Value
 	}{
 		{
-			name:   "it should update an user (mocked)",
-			input:  inputUserOne,
-			dbUser: dbUserOne,
+			name: "it should update an user (mocked), ignoring the password",
+			input: &controller.User{
+				ID:       "im an id",
+				Email:    "test@test.com",
+				Phone:    "+991234567890",
+				Password: "12345pAsSWORd*",
+			},
+			args: []driver.Value{"im an id", 0, "test@test.com", "+991234567890"},
 		},
 		{
-			name:   "it should update an user (mocked) besides empty phone",
-			input:  inputUserTwo,
-			dbUser: dbUserTwo,
+			name: "it should update an user (mocked), keeping the empty phone",
+			input: &controller.User{
+				ID:       "im an id",
+				Email:    "test@test.com",
+				Password: "12345pAsSWORd*",
+			},
+			args: []driver.Value{"im an id", 0, "test@test.com", nil},
 		},
 		{
-			name:   "it should update an user (mocked) besides empty email",
-			input:  inputUserThree,
-			dbUser: dbUserThree,
+			name: "it should update an user (mocked), keeping the empty email",
+			input: &controller.User{
+				ID:       "im an id",
+				Phone:    "+991234567890",
+				Password: "12345pAsSWORd*",
+			},
+			args: []driver.Value{"im an id", 0, nil, "+991234567890"},
+		},
+		{
+			name: "it should patch an user (mocked), removing the phone",
+			input: &controller.UserPatch{
+				ID:    "im an id",
+				Email: ptr("test@test.com"),
+				Phone: ptr(""),
+			},
+			args: []driver.Value{"im an id", 0, "test@test.com", ""},
+		},
+		{
+			name: "it should patch an user (mocked), from its version",
+			input: &controller.UserPatch{
+				ID:      "im an id",
+				Phone:   ptr("+991234567890"),
+				Version: 3,
+			},
+			args: []driver.Value{"im an id", 3, nil, "+991234567890"},
 		},
 	}
 
 	failedCases := []struct {
-		name   string
-		input  *controller.User
-		dbUser *internal.User
+		name  string
+		input interface{}
 	}{
 		{
-			name:   "it should not update an user (mocked), empty id",
-			input:  inputUserFour,
-			dbUser: dbUserOne,
+			name: "it should not update an user (mocked), empty id",
+			input: &controller.User{
+				Email:    "test@test.com",
+				Phone:    "+991234567890",
+				Password: "12345pAsSWORd*",
+			},
 		},
 		{
-			name:   "it should not update an user (mocked), empty password",
-			input:  inputUserFive,
-			dbUser: dbUserOne,
+			name:  "it should not update an user (mocked), nothing to update",
+			input: &controller.User{ID: "im an id"},
 		},
 		{
-			name:   "it should not update an user (mocked), nil user",
-			input:  nil,
-			dbUser: dbUserOne,
+			name:  "it should not patch an user (mocked), removing the email",
+			input: &controller.UserPatch{ID: "im an id", Email: ptr("")},
 		},
 		{
-			name:   "it should not update an user (mocked), id not found",
-			input:  inputUserSix,
-			dbUser: dbUserOne,
+			name:  "it should not update an user (mocked), nil user",
+			input: nil,
+		},
+		{
+			name: "it should not update an user (mocked), id not found",
+			input: &controller.UserPatch{
+				ID:    "im an id but wrong",
+				Email: ptr("test@test.com"),
+			},
 		},
 	}
 
@@ -800,22 +1028,15 @@ func TestModifyUser(test *testing.T) {
 			if err != nil {
 				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 			}
-			assert.NoError(t, err)
 
-			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WithArgs(
-				&tc.dbUser.ID,
-				&tc.dbUser.Email,
-				&tc.dbUser.Phone,
-				&tc.dbUser.Password,
-			).WillReturnResult(sqlmock.NewResult(0, 1))
+			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WithArgs(tc.args...).WillReturnResult(sqlmock.NewResult(0, 1))
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			err = uc.ModifyUser(tc.input)
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			err = uc.ModifyUser(context.Background(), tc.input)
 			assert.NoError(t, err)
-			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
-
+			assert.NoError(t, m.ExpectationsWereMet())
 		})
 	}
 
@@ -829,22 +1050,134 @@ func TestModifyUser(test *testing.T) {
 			if err != nil {
 				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 			}
-			assert.NoError(t, err)
 
-			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WithArgs(
-				&tc.dbUser.ID,
-				&tc.dbUser.Email,
-				&tc.dbUser.Phone,
-				&tc.dbUser.Password,
-			).WillReturnResult(sqlmock.NewResult(0, 0))
+			m.ExpectExec(regexp.QuoteMeta(spUpdate)).WillReturnResult(sqlmock.NewResult(0, 0))
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			err = uc.ModifyUser(tc.input)
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			err = uc.ModifyUser(context.Background(), tc.input)
 			assert.Error(t, err)
-			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
+		})
+	}
+}
+
+func TestChangePassword(test *testing.T) {
+	wrongCredentials := &mysql.MySQLError{Number: 1644, Message: "not authorized (wrong credentials)"}
+	copy(wrongCredentials.SQLState[:], "40100")
+
+	successfulCases := []struct {
+		name  string
+		input interface{}
+		args  []driver.Value
+	}{
+		{
+			name: "it should change the password (mocked)",
+			input: &controller.PasswordChange{
+				ID:              "im an id",
+				CurrentPassword: "12345pAsSWORd*",
+				NewPassword:     "54321pAsSWORd*",
+			},
+			args: []driver.Value{"im an id", "12345pAsSWORd*", "54321pAsSWORd*"},
+		},
+	}
+
+	failedCases := []struct {
+		name     string
+		input    interface{}
+		expect   func(m sqlmock.Sqlmock)
+		expected error
+	}{
+		{
+			name:     "it should not change the password (mocked), nil request",
+			input:    nil,
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not change the password (mocked), same password",
+			input: &controller.PasswordChange{
+				ID:              "im an id",
+				CurrentPassword: "12345pAsSWORd*",
+				NewPassword:     "12345pAsSWORd*",
+			},
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not change the password (mocked), too short",
+			input: &controller.PasswordChange{
+				ID:              "im an id",
+				CurrentPassword: "12345pAsSWORd*",
+				NewPassword:     "1aA",
+			},
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not change the password (mocked), without upper case letters",
+			input: &controller.PasswordChange{
+				ID:              "im an id",
+				CurrentPassword: "12345pAsSWORd*",
+				NewPassword:     "54321password*",
+			},
+			expect:   func(m sqlmock.Sqlmock) {},
+			expected: internal.ErrValidation,
+		},
+		{
+			name: "it should not change the password (mocked), wrong current password",
+			input: &controller.PasswordChange{
+				ID:              "im an id",
+				CurrentPassword: "wrong pAsSWORd*",
+				NewPassword:     "54321pAsSWORd*",
+			},
+			expect: func(m sqlmock.Sqlmock) {
+				m.ExpectExec(regexp.QuoteMeta(spUpdatePassword)).WillReturnError(wrongCredentials)
+			},
+			expected: internal.ErrUnauthorized,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+
+			m.ExpectExec(regexp.QuoteMeta(spUpdatePassword)).WithArgs(tc.args...).WillReturnResult(sqlmock.NewResult(0, 1))
+
+			r := repository.NewRepository(db)
+			uuid := utils.NewUUIDMock()
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			err = uc.ChangePassword(context.Background(), tc.input)
+			assert.NoError(t, err)
+			assert.NoError(t, m.ExpectationsWereMet())
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			db, m, err := sqlmock.New()
+			if err != nil {
+				test.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+			}
+			tc.expect(m)
+
+			r := repository.NewRepository(db)
+			uuid := utils.NewUUIDMock()
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			err = uc.ChangePassword(context.Background(), tc.input)
+			assert.ErrorIs(t, err, tc.expected)
+			assert.NoError(t, m.ExpectationsWereMet())
 		})
 	}
 }
@@ -939,12 +1272,13 @@ func TestDelete(test *testing.T) {
 			m.ExpectExec(regexp.QuoteMeta(spDelete)).WithArgs(
 				&tc.dbUser.ID,
 				&tc.dbUser.Password,
+				&tc.dbUser.Version,
 			).WillReturnResult(sqlmock.NewResult(0, 1))
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			err = uc.DestroyUser(tc.input)
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			err = uc.DestroyUser(context.Background(), tc.input)
 			assert.NoError(t, err)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
@@ -966,12 +1300,13 @@ func TestDelete(test *testing.T) {
 			m.ExpectExec(regexp.QuoteMeta(spDelete)).WithArgs(
 				&tc.dbUser.ID,
 				&tc.dbUser.Password,
+				&tc.dbUser.Version,
 			).WillReturnResult(sqlmock.NewResult(0, 1))
 
 			r := repository.NewRepository(db)
 			uuid := utils.NewUUIDMock()
-			uc := usecases.NewUseCases(r, uuid)
-			err = uc.DestroyUser(tc.input)
+			uc := usecases.NewUseCases(r, uuid, false, utils.NewJWTMock(), 5*time.Minute, usecases.NewUserEvents(repository.NewMemoryUserEvents(10)))
+			err = uc.DestroyUser(context.Background(), tc.input)
 			assert.Error(t, err)
 			m.ExpectClose().WillReturnError(sql.ErrConnDone) // expect a call to Close() but return an error to indicate that it was not expected
 
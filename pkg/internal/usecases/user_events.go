@@ -0,0 +1,114 @@
+package usecases
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// UserEventsBatch is the max number of events read at once
+const UserEventsBatch = 100
+
+// UserEvents is an interface that extends the user lifecycle events. The user cases publish
+// them and the streams read them, each one resuming after the last event it got
+type UserEvents interface {
+	// Publish stores an event of the user and wakes the streams waiting for one
+	Publish(ctx context.Context, eventType string, uid string) error
+	// ReadAfter reads the events after the id, oldest first and at most UserEventsBatch
+	ReadAfter(ctx context.Context, id int64) (internal.UserEvents, error)
+	// LastID is the id of the last event, a stream that resumes from nothing starts after it
+	LastID(ctx context.Context) (int64, error)
+	// Published is closed by the next event published by this instance, the events
+	// published by other instances sharing the buffer are only found by reading it
+	Published() <-chan struct{}
+	// Closed is closed once the events are closed
+	Closed() <-chan struct{}
+	// Close ends the streams, it is called before the server shuts down
+	Close()
+}
+
+var _ UserEvents = (*userEvents)(nil)
+
+type userEvents struct {
+	buffer    repository.UserEvents
+	published chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+}
+
+// NewUserEvents is a constructor for the user events cases
+func NewUserEvents(r repository.UserEvents) UserEvents {
+	return &userEvents{
+		buffer:    r,
+		published: make(chan struct{}),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (e *userEvents) Publish(ctx context.Context, eventType string, uid string) error {
+	ctx, span := startSpan(ctx, "PublishUserEvent")
+	defer span.End()
+
+	err := e.buffer.Append(ctx, &internal.UserEvent{
+		Type:       eventType,
+		UID:        uid,
+		OccurredAt: time.Now().UTC(),
+	})
+	if err != nil {
+		recordError(span, err)
+		return fmt.Errorf("failed to publish user event: %w", err)
+	}
+
+	// waking every waiting stream at once, each one reads from its own last event
+	e.mu.Lock()
+	close(e.published)
+	e.published = make(chan struct{})
+	e.mu.Unlock()
+
+	return nil
+}
+
+func (e *userEvents) ReadAfter(ctx context.Context, id int64) (internal.UserEvents, error) {
+	ctx, span := startSpan(ctx, "ReadUserEvents")
+	defer span.End()
+
+	events, err := e.buffer.ReadAfter(ctx, id, UserEventsBatch)
+	if err != nil {
+		recordError(span, err)
+		return nil, fmt.Errorf("failed to read user events: %w", err)
+	}
+	return events, nil
+}
+
+func (e *userEvents) LastID(ctx context.Context) (int64, error) {
+	ctx, span := startSpan(ctx, "ReadLastUserEvent")
+	defer span.End()
+
+	id, err := e.buffer.LastID(ctx)
+	if err != nil {
+		recordError(span, err)
+		return 0, fmt.Errorf("failed to read last user event: %w", err)
+	}
+	return id, nil
+}
+
+func (e *userEvents) Published() <-chan struct{} {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.published
+}
+
+func (e *userEvents) Closed() <-chan struct{} {
+	return e.closed
+}
+
+func (e *userEvents) Close() {
+	e.closeOnce.Do(func() {
+		close(e.closed)
+	})
+}
@@ -0,0 +1,97 @@
+package usecases_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/internal"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserEvents(test *testing.T) {
+	successfulCases := []struct {
+		name      string
+		published []string
+		expected  []string
+	}{
+		{
+			name:      "it should read the published events in order",
+			published: []string{internal.UserCreated, internal.UserUpdated, internal.UserDeleted},
+			expected:  []string{internal.UserCreated, internal.UserUpdated, internal.UserDeleted},
+		},
+		{
+			name:     "it should read no events when none was published",
+			expected: []string{},
+		},
+	}
+
+	failedCases := []struct {
+		name      string
+		eventType string
+		uid       string
+		expected  error
+	}{
+		{
+			name:      "it should not publish an event, empty uid",
+			eventType: internal.UserCreated,
+			expected:  internal.ErrValidation,
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ue := usecases.NewUserEvents(repository.NewMemoryUserEvents(10))
+			start, err := ue.LastID(context.Background())
+			assert.NoError(t, err)
+
+			for _, eventType := range tc.published {
+				waiting := ue.Published()
+				assert.NoError(t, ue.Publish(context.Background(), eventType, "1"))
+				// every publish wakes the streams waiting for it
+				select {
+				case <-waiting:
+				default:
+					t.Fatal("the published channel was not closed")
+				}
+			}
+
+			events, err := ue.ReadAfter(context.Background(), start)
+			assert.NoError(t, err)
+			types := make([]string, 0, len(events))
+			for _, event := range events {
+				types = append(types, event.Type)
+			}
+			assert.Equal(t, tc.expected, types)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ue := usecases.NewUserEvents(repository.NewMemoryUserEvents(10))
+			err := ue.Publish(context.Background(), tc.eventType, tc.uid)
+			assert.Error(t, err)
+			assert.ErrorIs(t, err, tc.expected)
+		})
+	}
+
+	test.Run("it should close the events once", func(t *testing.T) {
+		t.Parallel()
+
+		ue := usecases.NewUserEvents(repository.NewMemoryUserEvents(10))
+		ue.Close()
+		ue.Close()
+		select {
+		case <-ue.Closed():
+		default:
+			t.Fatal("the closed channel was not closed")
+		}
+	})
+}
@@ -1,13 +1,128 @@
 // Package internal contains bussines rules
 package internal
 
+import "time"
+
 // User struct is the model of the actor called user
 type User struct {
-	ID       string
-	Email    string
-	Phone    string
-	Password string
+	ID        string
+	Email     string
+	Phone     string
+	Password  string
+	CreatedAt *time.Time
+	// EmailVerified tells whether the user followed the verification link sent to its email
+	EmailVerified bool
+	// PhoneVerified tells whether the user confirmed the code sent to its phone
+	PhoneVerified bool
+	// TOTPEnabled tells whether the login of the user asks for a totp code
+	TOTPEnabled bool
+	// MFAToken is only set by a login that waits for the second factor
+	MFAToken string
+	// Version grows with every change of the profile. A delete with a version only
+	// goes through while the user is still at that version, 0 deletes any version
+	Version int
+}
+
+// UserPatch is a partial update of a user profile, nil fields are left unchanged
+type UserPatch struct {
+	ID    string
+	Email *string
+	Phone *string
+	// Version is the version the patch was made from, 0 patches any version
+	Version int
+}
+
+// PasswordChange is the request of a user to replace its password
+type PasswordChange struct {
+	ID              string
+	CurrentPassword string
+	NewPassword     string
 }
 
 // Users is an array type of User
 type Users []*User
+
+// How the Email and Phone of a UsersFilter are matched
+const (
+	// MatchPrefix matches the users whose field starts with the fragment
+	MatchPrefix = "prefix"
+	// MatchContains matches the users whose field contains the fragment anywhere
+	MatchContains = "contains"
+)
+
+// UsersFilter is the criteria to list users,
+// a page starts right after Cursor when it is set, otherwise it skips Offset users
+type UsersFilter struct {
+	Limit  int
+	Offset int
+	Cursor string
+	// Sort is the field to sort by, prefixed with "-" for descending order
+	Sort string
+	// Email is a fragment of the user email, matched as said by Match
+	Email string
+	// Phone is a fragment of the user phone, matched as said by Match
+	Phone string
+	// Match is MatchPrefix or MatchContains, an empty Match is MatchPrefix
+	Match string
+	// EmailVerified and PhoneVerified keep the users with that verification status, nil keeps all
+	EmailVerified *bool
+	PhoneVerified *bool
+	CreatedFrom   time.Time
+	CreatedTo     time.Time
+}
+
+// UsersPage is a page of users with the total of users matching the filter
+type UsersPage struct {
+	Users      Users
+	Total      int
+	NextCursor string
+}
+
+// PasswordReset is a one-time token to set a new password without the current one,
+// only its hash is stored
+type PasswordReset struct {
+	Hash      string
+	Email     string
+	ExpiresAt time.Time
+}
+
+// PhoneOTP is a one-time code to verify the phone of a user, only its hash is stored
+type PhoneOTP struct {
+	UID       string
+	Hash      string
+	ExpiresAt time.Time
+}
+
+// TOTP is the authenticator app secret of a user, it is asked at login once it is enabled
+type TOTP struct {
+	UID     string
+	Secret  string
+	Enabled bool
+	// LastStep is the last period whose code was used, -1 when none was
+	LastStep int64
+}
+
+// TOTPEnrollment is a new totp secret and the uri authenticator apps read from a qr code
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// Statuses of a row of a bulk import
+const (
+	// ImportCreated means the user of the row was created, or would be on a dry run
+	ImportCreated = "created"
+	// ImportSkipped means the user of the row already exists
+	ImportSkipped = "skipped"
+	// ImportFailed means the row is invalid or the database refused it
+	ImportFailed = "failed"
+)
+
+// ImportRow is a row of a bulk import of users, Err is set when the row failed
+// before it reached the database and Status is set once the row is imported
+type ImportRow struct {
+	Line   int
+	User   *User
+	Status string
+	Err    error
+}
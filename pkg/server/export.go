@@ -0,0 +1,33 @@
+//go:build !coverage
+// +build !coverage
+
+package server
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/infrastructure/database"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"io"
+)
+
+func (s server) Export(ctx context.Context, w io.Writer, req *controller.ExportRequest) error {
+	dbConn := database.NewDBConn(s.logger, s.config)
+	conn, err := dbConn.Open()
+	if err != nil {
+		s.logger.Error("Failed to open database connection", err)
+		return err
+	}
+	defer func() {
+		if err := dbConn.Close(conn); err != nil {
+			s.logger.Error("Failed to close db connection")
+		}
+		if err := s.tracer.Shutdown(context.Background()); err != nil {
+			s.logger.Error("Failed to flush traces", err)
+		}
+	}()
+
+	exporter := controller.NewExporter(usecases.NewExports(repository.NewExports(conn)), s.validation)
+	return exporter.Export(ctx, w, req)
+}
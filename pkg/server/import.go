@@ -0,0 +1,33 @@
+//go:build !coverage
+// +build !coverage
+
+package server
+
+import (
+	"context"
+	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/infrastructure/database"
+	"dall06/go-cleanapi/pkg/internal/repository"
+	"dall06/go-cleanapi/pkg/internal/usecases"
+	"io"
+)
+
+func (s server) Import(ctx context.Context, r io.Reader, format string, dryRun bool, batchSize int) (*controller.ImportReport, error) {
+	dbConn := database.NewDBConn(s.logger, s.config)
+	conn, err := dbConn.Open()
+	if err != nil {
+		s.logger.Error("Failed to open database connection", err)
+		return nil, err
+	}
+	defer func() {
+		if err := dbConn.Close(conn); err != nil {
+			s.logger.Error("Failed to close db connection")
+		}
+		if err := s.tracer.Shutdown(context.Background()); err != nil {
+			s.logger.Error("Failed to flush traces", err)
+		}
+	}()
+
+	importer := controller.NewImporter(usecases.NewImports(repository.NewImports(conn), s.uids), s.validation, batchSize)
+	return importer.Import(ctx, r, format, dryRun)
+}
@@ -7,32 +7,46 @@
 package server
 
 import (
+	"context"
 	"dall06/go-cleanapi/config"
 	"dall06/go-cleanapi/pkg/adapter/controller"
+	"dall06/go-cleanapi/pkg/adapter/graph"
+	"dall06/go-cleanapi/pkg/adapter/i18n"
+	"dall06/go-cleanapi/pkg/adapter/problem"
 	"dall06/go-cleanapi/pkg/adapter/routes"
+	"dall06/go-cleanapi/pkg/adapter/rpc"
 	"dall06/go-cleanapi/pkg/infrastructure/database"
 	"dall06/go-cleanapi/pkg/infrastructure/middleware"
 	"dall06/go-cleanapi/pkg/internal/repository"
 	"dall06/go-cleanapi/pkg/internal/usecases"
 	"dall06/go-cleanapi/utils"
 	"fmt"
+	"io"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
 	"github.com/go-playground/validator/v10"
 	"github.com/gofiber/fiber/v2"
 	"github.com/patrickmn/go-cache"
+	"github.com/valyala/fasthttp"
 )
 
 // Server is an interface for server
 type Server interface {
 	Start() error
+	// Import streams a bulk user import into the database, without starting the http server
+	Import(ctx context.Context, r io.Reader, format string, dryRun bool, batchSize int) (*controller.ImportReport, error)
+	// Export streams the users matching the request to w, without starting the http server
+	Export(ctx context.Context, w io.Writer, req *controller.ExportRequest) error
 }
 
 type server struct {
 	config      config.Vars
 	logger      utils.Logger
+	tracer      utils.Tracer
 	jwt         utils.JWT
 	uids        utils.UUID
 	validations utils.Validations
@@ -45,6 +59,7 @@ var _ Server = (*server)(nil)
 func NewServer(
 	vars config.Vars,
 	l utils.Logger,
+	t utils.Tracer,
 	j utils.JWT,
 	u utils.UUID,
 	vs utils.Validations,
@@ -52,6 +67,7 @@ func NewServer(
 	return server{
 		config:      vars,
 		logger:      l,
+		tracer:      t,
 		jwt:         j,
 		uids:        u,
 		validations: vs,
@@ -68,14 +84,73 @@ func (s server) Start() error {
 		return err
 	}
 
+	// translations, they register the messages of the validator before it is handed out
+	translator, err := i18n.NewTranslator(&s.validation)
+	if err != nil {
+		s.logger.Error("Failed to load the translations", err)
+		return err
+	}
+
 	// generate caches, depending on the needs of each dependency
 	ctrlCache := cache.New(5*time.Minute, 10*time.Minute)
 
 	// generate internal controllers
+	// sessions
+	revocations := repository.NewMemoryRevocations(s.config.AccessTokenTTL)
+	if s.config.RevocationStore == config.RevocationStoreSQL {
+		revocations = repository.NewRevocations(conn)
+	}
+	sessions := usecases.NewSessions(revocations, repository.NewRefreshTokens(conn), s.uids, s.config.RefreshTokenTTL)
+	// password resets
+	var mailer utils.Mailer = utils.NewFileMailer(s.config.MailDir, s.config.MailFrom)
+	if s.config.MailDriver == config.MailDriverSMTP {
+		mailer = utils.NewSMTPMailer(s.config)
+	}
+	resets := usecases.NewPasswordResets(repository.NewPasswordResets(conn), mailer, s.config.PasswordResetTTL, s.config.PasswordResetURL)
+	// email verifications
+	verifications := usecases.NewEmailVerifications(repository.NewEmailVerifications(conn), mailer, s.jwt,
+		s.config.EmailVerificationTTL, s.config.EmailVerificationResend, s.config.EmailVerificationURL)
+	// phone verifications
+	phones := usecases.NewPhoneVerifications(repository.NewPhoneOTPs(conn), utils.NewFakeSMSSender(s.config.SMSDir),
+		s.config.PhoneOTPTTL, s.config.PhoneOTPResend, s.config.PhoneOTPAttempts)
+	// two-factor auth
+	twoFactor := usecases.NewTwoFactor(repository.NewTwoFactor(conn), utils.NewTOTP(s.config.TOTPIssuer), s.jwt,
+		s.config.MFAAttempts, s.config.MFATokenTTL)
+	// failed logins
+	loginFailures := repository.NewMemoryLoginFailures()
+	if s.config.LockoutStore == config.LockoutStoreSQL {
+		loginFailures = repository.NewLoginFailures(conn)
+	}
+	lockouts := usecases.NewLockouts(loginFailures, s.config.LockoutThreshold, s.config.LockoutIPThreshold,
+		s.config.LockoutBaseDelay, s.config.LockoutMaxDelay, s.config.LockoutWindow)
+	// bulk imports
+	importer := controller.NewImporter(usecases.NewImports(repository.NewImports(conn), s.uids), s.validation, s.config.ImportBatchSize)
+	// exports
+	exporter := controller.NewExporter(usecases.NewExports(repository.NewExports(conn)), s.validation)
+	// user events, instances sharing the database share the sql buffer
+	eventBuffer := repository.NewMemoryUserEvents(s.config.EventsBufferSize)
+	if s.config.EventsStore == config.EventsStoreSQL {
+		eventBuffer = repository.NewUserEvents(conn, s.config.EventsBufferSize)
+	}
+	events := usecases.NewUserEvents(eventBuffer)
+	streamer := controller.NewEventStreamer(events, s.config.EventsHeartbeat, s.config.EventsStreamTimeout)
 	// user
 	repo := repository.NewRepository(conn)
-	usecases := usecases.NewUseCases(repo, s.uids)
-	ctrl := controller.NewController(usecases, s.validation, s.logger, s.jwt, s.validations, *ctrlCache)
+	usecases := usecases.NewUseCases(repo, s.uids, s.config.RequireVerifiedEmail, s.jwt, s.config.MFATokenTTL, events)
+	ctrl := controller.NewController(usecases, sessions, resets, verifications, phones, twoFactor, lockouts, importer, exporter, streamer, s.validation, s.logger, s.jwt, s.validations, *ctrlCache)
+	gql, err := graph.NewGraphQL(usecases, verifications, s.validation, s.jwt, s.logger, s.config.GraphQLMaxDepth, s.config.GraphQLMaxComplexity)
+	if err != nil {
+		s.logger.Error("Failed to build the graphql endpoint", err)
+		return err
+	}
+
+	// grpc user service, for internal services
+	grpcServer := rpc.NewServer(rpc.NewUserService(usecases, lockouts, s.validation, s.validations, s.logger), s.jwt, s.logger)
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", s.config.GRPCPort))
+	if err != nil {
+		s.logger.Error("Failed to listen on grpc port", err)
+		return err
+	}
 
 	// init server
 	cfg := fiber.Config{
@@ -83,11 +158,45 @@ func (s server) Start() error {
 		CaseSensitive: true,
 		ServerHeader:  "go-cleanapi",
 		AppName:       s.config.AppName,
+		BodyLimit:     s.config.BodyLimit,
+		ReadTimeout:   s.config.ReadTimeout,
+		WriteTimeout:  s.config.WriteTimeout,
+		IdleTimeout:   s.config.IdleTimeout,
+		ErrorHandler:  problem.NewErrorHandler(s.logger),
+		// imports read their rows as they arrive, the body limit of the other requests
+		// is enforced by the StreamBody middleware
+		StreamRequestBody: true,
 	}
 
 	app := fiber.New(cfg)
+	// imports are read, and exports and event streams are written, for as long as they take,
+	// not for the timeouts of the other requests
+	app.Server().HeaderReceived = func(header *fasthttp.RequestHeader) fasthttp.RequestConfig {
+		// the version is not known yet, it may come in the path or in the Accept header
+		path, _, _ := strings.Cut(string(header.RequestURI()), "?")
+		if !strings.HasPrefix(path, s.config.APIRootPath+"/") {
+			return fasthttp.RequestConfig{}
+		}
+		switch {
+		case strings.HasSuffix(path, "/users/import"):
+			return fasthttp.RequestConfig{ReadTimeout: s.config.ImportTimeout, WriteTimeout: s.config.ImportTimeout}
+		case strings.HasSuffix(path, "/users/export"):
+			return fasthttp.RequestConfig{WriteTimeout: s.config.ExportWriteTimeout}
+		case strings.HasSuffix(path, "/users/events"):
+			// a stream ends by itself after its timeout, the heartbeat covers its last write
+			return fasthttp.RequestConfig{WriteTimeout: s.config.EventsStreamTimeout + s.config.EventsHeartbeat}
+		}
+		return fasthttp.RequestConfig{}
+	}
 	// init middleware
-	mw := middleware.NewMiddleware(s.config, s.jwt)
+	mw := middleware.NewMiddleware(s.config, s.jwt, sessions, translator)
+	// the request id and the span come first, so the requests the others reject have them too
+	app.Use(mw.RequestID())
+	app.Use(mw.Tracing())
+	app.Use(mw.Language())
+	app.Use(mw.Version())
+	app.Use(mw.ContentNegotiation())
+	app.Use(mw.StreamBody())
 	app.Use(mw.CORS())
 	app.Use(mw.Compress())
 	app.Use(mw.Helmet())
@@ -100,7 +209,7 @@ func (s server) Start() error {
 	app.Use(mw.Idempotency())
 
 	// generate routing
-	rts := routes.NewRoutes(app, s.config, ctrl)
+	rts := routes.NewRoutes(app, s.config, ctrl, gql, mw)
 	rts.Set()
 
 	// run gracefully
@@ -109,9 +218,15 @@ func (s server) Start() error {
 			s.logger.Error("Failed to listen on port", err)
 		}
 	}()
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			s.logger.Error("Failed to serve grpc", err)
+		}
+	}()
 
-	s.logger.Info("Running api server version %s in port %s, with base path %s",
-		s.config.APIVersion, s.config.APIPort, s.config.APIBasePath)
+	s.logger.Info("Running api server version %s in port %s, with base path %s and api versions %s",
+		s.config.APIVersion, s.config.APIPort, s.config.APIBasePath, strings.Join(config.APIVersions, ", "))
+	s.logger.Info("Running grpc user service in port %s", s.config.GRPCPort)
 
 	// Gracefully shutdown
 	c := make(chan os.Signal, 1)
@@ -120,16 +235,24 @@ func (s server) Start() error {
 
 	// Clean up tasks
 	s.logger.Info("Shutting down server...")
+	// the event streams never end by themselves before their timeout, the shutdown would wait for them
+	events.Close()
 	err = app.Shutdown()
 	if err != nil {
 		s.logger.Error("Failed to shutdown", err)
 		return err
 	}
+	grpcServer.Stop()
 	err = dbConn.Close(conn)
 	if err != nil {
 		s.logger.Error("Failed to close db connection")
 		return err
 	}
+	err = s.tracer.Shutdown(context.Background())
+	if err != nil {
+		s.logger.Error("Failed to flush traces", err)
+		return err
+	}
 
 	// Before close
 	s.logger.Info("Successfully shutdow nof the server")
@@ -3,11 +3,99 @@ CREATE DATABASE db_go_cleanapi;
 
 USE db_go_cleanapi;
 
+-- the ngram parser leaves out the tokens holding a stopword, the fulltext indexes of the users
+-- are created without stopwords so contains searches find every fragment
+SET SESSION innodb_ft_enable_stopword = OFF;
+
 CREATE TABLE users (
 	id_user VARCHAR(64) NOT NULL UNIQUE,
-    user_email VARCHAR(128) NOT NULL,
+    user_email VARCHAR(128) NOT NULL UNIQUE,
     user_phone VARCHAR(16),
-    user_password VARCHAR(64) NOT NULL
+    user_password CHAR(128) NOT NULL,
+    email_verified_at DATETIME NULL,
+    phone_verified_at DATETIME NULL,
+    totp_secret VARCHAR(64) NULL,
+    totp_enabled_at DATETIME NULL,
+    totp_last_step BIGINT NULL,
+    created_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    -- version grows with every change of the profile, it is the ETag of the user
+    version INT UNSIGNED NOT NULL DEFAULT 1,
+    INDEX idx_users_created_at (created_at, id_user),
+    INDEX idx_users_email (user_email, id_user),
+    INDEX idx_users_phone (user_phone, id_user),
+    INDEX idx_users_email_verified (email_verified_at, created_at),
+    INDEX idx_users_phone_verified (phone_verified_at, created_at),
+    -- the ngram parser indexes every 2 characters, so fragments found anywhere in a value use the index
+    FULLTEXT INDEX ft_users_email (user_email) WITH PARSER ngram,
+    FULLTEXT INDEX ft_users_phone (user_phone) WITH PARSER ngram
+);
+
+CREATE TABLE revoked_tokens (
+	id_token VARCHAR(64) NOT NULL PRIMARY KEY,
+    expires_at DATETIME NOT NULL,
+    INDEX idx_revoked_tokens_expires_at (expires_at)
+);
+
+CREATE TABLE revoked_users (
+	id_user VARCHAR(64) NOT NULL PRIMARY KEY,
+    revoked_before DATETIME NOT NULL
+);
+
+CREATE TABLE refresh_tokens (
+	id_token_hash CHAR(64) NOT NULL PRIMARY KEY,
+    id_family VARCHAR(64) NOT NULL,
+    id_user VARCHAR(64) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME NULL,
+    revoked_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_refresh_tokens_family (id_family),
+    INDEX idx_refresh_tokens_user (id_user),
+    INDEX idx_refresh_tokens_expires_at (expires_at)
+);
+
+CREATE TABLE phone_otps (
+	id_user VARCHAR(64) NOT NULL PRIMARY KEY,
+    user_phone VARCHAR(16) NOT NULL,
+    code_hash CHAR(64) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    expires_at DATETIME NOT NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_phone_otps_expires_at (expires_at)
+);
+
+CREATE TABLE totp_recovery_codes (
+	code_hash CHAR(64) NOT NULL PRIMARY KEY,
+    id_user VARCHAR(64) NOT NULL,
+    used_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_totp_recovery_codes_user (id_user)
+);
+
+CREATE TABLE login_failures (
+	failure_key VARCHAR(160) NOT NULL PRIMARY KEY,
+    failures INT NOT NULL DEFAULT 0,
+    last_failed_at DATETIME NOT NULL,
+    locked_until DATETIME NULL,
+    INDEX idx_login_failures_last_failed_at (last_failed_at)
+);
+
+CREATE TABLE password_resets (
+	id_token_hash CHAR(64) NOT NULL PRIMARY KEY,
+    id_user VARCHAR(64) NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME NULL,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    INDEX idx_password_resets_user (id_user),
+    INDEX idx_password_resets_expires_at (expires_at)
+);
+
+-- user_events keeps the last events of the users, the older ones are dropped as new ones come
+CREATE TABLE user_events (
+	id_event BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    event_type VARCHAR(32) NOT NULL,
+    id_user VARCHAR(64) NOT NULL,
+    occurred_at DATETIME(6) NOT NULL
 );
 
 DELIMITER $$
@@ -19,7 +107,7 @@ CREATE DEFINER=`root`@`localhost` FUNCTION `fn_validate_user`(
     DETERMINISTIC
 BEGIN
 	RETURN IF( EXISTS(
-		SELECT * FROM `users` WHERE `user_id` = in_u_id AND `user_pass` = SHA2(in_u_pass, 512)), 1, 0);
+		SELECT * FROM `users` WHERE `id_user` = in_u_id AND `user_password` = SHA2(in_u_pass, 512)), 1, 0);
 END$$
 DELIMITER ;
 
@@ -31,9 +119,17 @@ CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_login_user`(
 )
 BEGIN
 	IF p_user_phone = '' THEN
-		SELECT * FROM `users` WHERE `p_user_email` = user_email AND `user_pass` = SHA2(in_u_pass, 512);
+		SELECT `id_user`,
+		`email_verified_at` IS NOT NULL AS email_verified,
+		`totp_enabled_at` IS NOT NULL AS totp_enabled
+		FROM `users`
+		WHERE `user_email` = p_user_email AND `user_password` = SHA2(p_user_password, 512);
 	ELSEIF p_user_email = '' THEN
-		SELECT * FROM `users` WHERE `p_user_email` = user_email AND `user_pass` = SHA2(in_u_pass, 512);
+		SELECT `id_user`,
+		`email_verified_at` IS NOT NULL AS email_verified,
+		`totp_enabled_at` IS NOT NULL AS totp_enabled
+		FROM `users`
+		WHERE `user_phone` = p_user_phone AND `user_password` = SHA2(p_user_password, 512);
 	END IF;
 END$$
 DELIMITER ;
@@ -43,20 +139,13 @@ CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_read_user`(
 	p_id_user VARCHAR(64)
 )
 BEGIN
-	SELECT  (`id_user`,
+	SELECT `id_user`,
 	`user_email`,
-	`user_phone`) FROM users WHERE id_user = p_id_user;
-END$$
-DELIMITER ;
-
-DELIMITER $$
-CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_read_users`()
-BEGIN
-	SELECT 
-    (`id_user`,
-	`user_email`,
-	`user_phone`)
-    FROM users;
+	`user_phone`,
+	`email_verified_at` IS NOT NULL AS email_verified,
+	`phone_verified_at` IS NOT NULL AS phone_verified,
+	`version`
+	FROM users WHERE id_user = p_id_user;
 END$$
 DELIMITER ;
 
@@ -84,16 +173,44 @@ DELIMITER ;
 DELIMITER $$
 CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_update_user`(
 	p_id_user VARCHAR(64),
+    p_version INT UNSIGNED,
     p_user_email VARCHAR(128),
-    p_user_phone VARCHAR(16),
-    p_user_password VARCHAR(64)
+    p_user_phone VARCHAR(16)
 )
 BEGIN
+	-- a version of 0 updates whatever version the user has
+	IF p_version <> 0 AND EXISTS(SELECT 1 FROM `db_go_cleanapi`.`users` WHERE `id_user` = p_id_user AND `version` <> p_version) THEN
+		SIGNAL SQLSTATE '41200' SET MESSAGE_TEXT = 'the user was modified by another request';
+	END IF;
+
     UPDATE `db_go_cleanapi`.`users`
 	SET
-		`user_email` = p_user_email,
-		`user_phone` = p_user_phone,
-		`user_password` = p_user_password
+		-- a new email has to be verified again
+		`email_verified_at` = IF(p_user_email IS NULL OR p_user_email = `user_email`, `email_verified_at`, NULL),
+		`phone_verified_at` = IF(p_user_phone IS NULL OR p_user_phone = `user_phone`, `phone_verified_at`, NULL),
+		`user_email` = COALESCE(p_user_email, `user_email`),
+		`user_phone` = COALESCE(p_user_phone, `user_phone`),
+		`version` = `version` + 1
+	WHERE `id_user` = p_id_user AND (p_version = 0 OR `version` = p_version);
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_update_user_password`(
+	p_id_user VARCHAR(64),
+    p_current_password VARCHAR(64),
+    p_new_password VARCHAR(64)
+)
+BEGIN
+	DECLARE is_auth TINYINT;
+
+	SELECT `db_go_cleanapi`.`fn_validate_user`(p_id_user, p_current_password) INTO is_auth;
+    IF is_auth = FALSE THEN
+		SIGNAL SQLSTATE '40100' SET MESSAGE_TEXT = 'not authorized (wrong credentials)';
+    END IF;
+
+    UPDATE `db_go_cleanapi`.`users`
+	SET `user_password` = SHA2(p_new_password, 512)
 	WHERE `id_user` = p_id_user;
 END$$
 DELIMITER ;
@@ -101,16 +218,559 @@ DELIMITER ;
 DELIMITER $$
 CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_delete_user`(
 	p_id_user VARCHAR(64),
-    p_user_password VARCHAR(64)
+    p_user_password VARCHAR(64),
+    p_version INT UNSIGNED
 )
 BEGIN
 	DECLARE is_auth TINYINT;
     
 	SELECT `db_go_cleanapi`.`fn_validate_user`(p_id_user, p_user_password) INTO is_auth;
     IF is_auth = FALSE THEN 
-		SIGNAL SQLSTATE '40400' SET MESSAGE_TEXT = 'not authorized (worng credentials)';
+		SIGNAL SQLSTATE '40100' SET MESSAGE_TEXT = 'not authorized (wrong credentials)';
     END IF;
     
-    DELETE FROM `db_go_cleanapi`.users WHERE id_user = p_id_user AND user_password = SHA2(p_user_password, 512);
+    -- a version of 0 deletes whatever version the user has
+	IF p_version <> 0 AND EXISTS(SELECT 1 FROM `db_go_cleanapi`.`users` WHERE `id_user` = p_id_user AND `version` <> p_version) THEN
+		SIGNAL SQLSTATE '41200' SET MESSAGE_TEXT = 'the user was modified by another request';
+	END IF;
+
+    DELETE FROM `db_go_cleanapi`.users WHERE id_user = p_id_user AND user_password = SHA2(p_user_password, 512)
+		AND (p_version = 0 OR `version` = p_version);
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_revoke_token`(
+	p_id_token VARCHAR(64),
+    p_expires_at DATETIME
+)
+BEGIN
+	-- expired tokens are rejected by their signature, they do not need to be kept
+	DELETE FROM `db_go_cleanapi`.`revoked_tokens` WHERE expires_at < UTC_TIMESTAMP();
+
+	INSERT IGNORE INTO `db_go_cleanapi`.`revoked_tokens`
+	(`id_token`,
+	`expires_at`)
+	VALUES
+	(p_id_token,
+	p_expires_at);
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_revoke_user_tokens`(
+	p_id_user VARCHAR(64),
+    p_revoked_before DATETIME
+)
+BEGIN
+	INSERT INTO `db_go_cleanapi`.`revoked_users`
+	(`id_user`,
+	`revoked_before`)
+	VALUES
+	(p_id_user,
+	p_revoked_before)
+	ON DUPLICATE KEY UPDATE `revoked_before` = GREATEST(`revoked_before`, p_revoked_before);
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_is_token_revoked`(
+	p_id_token VARCHAR(64),
+    p_id_user VARCHAR(64),
+    p_issued_at DATETIME
+)
+BEGIN
+	SELECT
+		EXISTS(SELECT 1 FROM `db_go_cleanapi`.`revoked_tokens` WHERE id_token = p_id_token)
+		OR EXISTS(SELECT 1 FROM `db_go_cleanapi`.`revoked_users` WHERE id_user = p_id_user AND revoked_before > p_issued_at)
+		AS revoked;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_create_refresh_token`(
+	p_id_token_hash CHAR(64),
+    p_id_family VARCHAR(64),
+    p_id_user VARCHAR(64),
+    p_expires_at DATETIME
+)
+BEGIN
+	-- expired tokens can not be exchanged anymore, they do not need to be kept
+	DELETE FROM `db_go_cleanapi`.`refresh_tokens` WHERE expires_at < UTC_TIMESTAMP();
+
+	INSERT INTO `db_go_cleanapi`.`refresh_tokens`
+	(`id_token_hash`,
+	`id_family`,
+	`id_user`,
+	`expires_at`)
+	VALUES
+	(p_id_token_hash,
+	p_id_family,
+	p_id_user,
+	p_expires_at);
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_read_refresh_token`(
+	p_id_token_hash CHAR(64)
+)
+BEGIN
+	SELECT id_family, id_user, expires_at, used_at, revoked_at
+	FROM `db_go_cleanapi`.`refresh_tokens`
+	WHERE id_token_hash = p_id_token_hash;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_rotate_refresh_token`(
+	p_id_token_hash CHAR(64),
+    p_next_token_hash CHAR(64),
+    p_expires_at DATETIME
+)
+BEGIN
+	DECLARE v_id_family VARCHAR(64);
+    DECLARE v_id_user VARCHAR(64);
+
+	START TRANSACTION;
+
+	SELECT id_family, id_user INTO v_id_family, v_id_user
+	FROM `db_go_cleanapi`.`refresh_tokens`
+	WHERE id_token_hash = p_id_token_hash AND used_at IS NULL AND revoked_at IS NULL
+	FOR UPDATE;
+
+	IF v_id_family IS NULL THEN
+		ROLLBACK;
+		SIGNAL SQLSTATE '40900' SET MESSAGE_TEXT = 'refresh token already used';
+	END IF;
+
+	UPDATE `db_go_cleanapi`.`refresh_tokens` SET used_at = UTC_TIMESTAMP()
+	WHERE id_token_hash = p_id_token_hash;
+
+	INSERT INTO `db_go_cleanapi`.`refresh_tokens`
+	(`id_token_hash`,
+	`id_family`,
+	`id_user`,
+	`expires_at`)
+	VALUES
+	(p_next_token_hash,
+	v_id_family,
+	v_id_user,
+	p_expires_at);
+
+	COMMIT;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_revoke_refresh_family`(
+	p_id_family VARCHAR(64)
+)
+BEGIN
+	UPDATE `db_go_cleanapi`.`refresh_tokens` SET revoked_at = UTC_TIMESTAMP()
+	WHERE id_family = p_id_family AND revoked_at IS NULL;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_revoke_user_refresh_tokens`(
+	p_id_user VARCHAR(64)
+)
+BEGIN
+	UPDATE `db_go_cleanapi`.`refresh_tokens` SET revoked_at = UTC_TIMESTAMP()
+	WHERE id_user = p_id_user AND revoked_at IS NULL;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_create_password_reset`(
+	p_id_token_hash CHAR(64),
+    p_user_email VARCHAR(128),
+    p_expires_at DATETIME
+)
+BEGIN
+	DECLARE v_id_user VARCHAR(64);
+
+	SELECT id_user INTO v_id_user FROM `db_go_cleanapi`.`users` WHERE user_email = p_user_email;
+
+	-- unknown emails insert nothing, the caller answers the same way
+	IF v_id_user IS NOT NULL THEN
+		DELETE FROM `db_go_cleanapi`.`password_resets`
+		WHERE expires_at < UTC_TIMESTAMP() OR (id_user = v_id_user AND used_at IS NULL);
+
+		INSERT INTO `db_go_cleanapi`.`password_resets`
+		(`id_token_hash`,
+		`id_user`,
+		`expires_at`)
+		VALUES
+		(p_id_token_hash,
+		v_id_user,
+		p_expires_at);
+	END IF;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_reset_password`(
+	p_id_token_hash CHAR(64),
+    p_user_password VARCHAR(64)
+)
+BEGIN
+	DECLARE v_id_user VARCHAR(64);
+
+	START TRANSACTION;
+
+	SELECT id_user INTO v_id_user
+	FROM `db_go_cleanapi`.`password_resets`
+	WHERE id_token_hash = p_id_token_hash AND used_at IS NULL AND expires_at > UTC_TIMESTAMP()
+	FOR UPDATE;
+
+	IF v_id_user IS NULL THEN
+		ROLLBACK;
+		SIGNAL SQLSTATE '40100' SET MESSAGE_TEXT = 'invalid or expired reset token';
+	END IF;
+
+	UPDATE `db_go_cleanapi`.`password_resets` SET used_at = UTC_TIMESTAMP()
+	WHERE id_token_hash = p_id_token_hash;
+
+	UPDATE `db_go_cleanapi`.`users` SET `user_password` = SHA2(p_user_password, 512)
+	WHERE `id_user` = v_id_user;
+
+	COMMIT;
+
+	SELECT v_id_user AS id_user;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_read_email_verification`(
+    p_user_email VARCHAR(128)
+)
+BEGIN
+	SELECT `email_verified_at` IS NULL AS pending
+	FROM `db_go_cleanapi`.`users`
+	WHERE `user_email` = p_user_email;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_verify_user_email`(
+    p_user_email VARCHAR(128)
+)
+BEGIN
+	IF NOT EXISTS(SELECT 1 FROM `db_go_cleanapi`.`users` WHERE `user_email` = p_user_email) THEN
+		SIGNAL SQLSTATE '40400' SET MESSAGE_TEXT = 'user not found';
+	END IF;
+
+	UPDATE `db_go_cleanapi`.`users` SET `email_verified_at` = UTC_TIMESTAMP(), `version` = `version` + 1
+	WHERE `user_email` = p_user_email AND `email_verified_at` IS NULL;
 END$$
-DELIMITER ;
\ No newline at end of file
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_create_phone_otp`(
+	p_id_user VARCHAR(64),
+    p_code_hash CHAR(64),
+    p_expires_at DATETIME
+)
+BEGIN
+	DECLARE v_user_phone VARCHAR(16);
+    DECLARE v_verified TINYINT;
+
+	SELECT `user_phone`, `phone_verified_at` IS NOT NULL INTO v_user_phone, v_verified
+	FROM `db_go_cleanapi`.`users` WHERE `id_user` = p_id_user;
+
+	IF v_verified IS NULL THEN
+		SIGNAL SQLSTATE '40400' SET MESSAGE_TEXT = 'user not found';
+	END IF;
+	IF v_user_phone IS NULL OR v_user_phone = '' THEN
+		SIGNAL SQLSTATE '42200' SET MESSAGE_TEXT = 'user has no phone';
+	END IF;
+	IF v_verified THEN
+		SIGNAL SQLSTATE '40900' SET MESSAGE_TEXT = 'phone already verified';
+	END IF;
+
+	-- expired codes can not be confirmed anymore, they do not need to be kept
+	DELETE FROM `db_go_cleanapi`.`phone_otps` WHERE expires_at < UTC_TIMESTAMP() OR id_user = p_id_user;
+
+	INSERT INTO `db_go_cleanapi`.`phone_otps`
+	(`id_user`,
+	`user_phone`,
+	`code_hash`,
+	`expires_at`)
+	VALUES
+	(p_id_user,
+	v_user_phone,
+	p_code_hash,
+	p_expires_at);
+
+	SELECT v_user_phone AS user_phone;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_confirm_phone_otp`(
+	p_id_user VARCHAR(64),
+    p_code_hash CHAR(64),
+    p_max_attempts INT
+)
+BEGIN
+	DECLARE v_user_phone VARCHAR(16);
+    DECLARE v_code_hash CHAR(64);
+    DECLARE v_attempts INT;
+
+	START TRANSACTION;
+
+	SELECT user_phone, code_hash, attempts INTO v_user_phone, v_code_hash, v_attempts
+	FROM `db_go_cleanapi`.`phone_otps`
+	WHERE id_user = p_id_user AND expires_at > UTC_TIMESTAMP()
+	FOR UPDATE;
+
+	IF v_code_hash IS NULL THEN
+		ROLLBACK;
+		SIGNAL SQLSTATE '40100' SET MESSAGE_TEXT = 'invalid or expired code';
+	END IF;
+
+	IF v_code_hash <> p_code_hash THEN
+		IF v_attempts + 1 >= p_max_attempts THEN
+			DELETE FROM `db_go_cleanapi`.`phone_otps` WHERE id_user = p_id_user;
+			COMMIT;
+			SIGNAL SQLSTATE '42900' SET MESSAGE_TEXT = 'too many wrong codes, request a new one';
+		END IF;
+
+		-- the attempt is kept even though the call fails
+		UPDATE `db_go_cleanapi`.`phone_otps` SET attempts = attempts + 1 WHERE id_user = p_id_user;
+		COMMIT;
+		SIGNAL SQLSTATE '40100' SET MESSAGE_TEXT = 'invalid or expired code';
+	END IF;
+
+	DELETE FROM `db_go_cleanapi`.`phone_otps` WHERE id_user = p_id_user;
+
+	-- the phone may have changed since the code was sent
+	UPDATE `db_go_cleanapi`.`users` SET `phone_verified_at` = UTC_TIMESTAMP(), `version` = `version` + 1
+	WHERE `id_user` = p_id_user AND `user_phone` = v_user_phone;
+
+	COMMIT;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_create_totp`(
+	p_id_user VARCHAR(64),
+    p_totp_secret VARCHAR(64)
+)
+BEGIN
+	DECLARE v_user_email VARCHAR(128);
+    DECLARE v_enabled BOOL;
+
+	SELECT user_email, totp_enabled_at IS NOT NULL INTO v_user_email, v_enabled
+	FROM `db_go_cleanapi`.`users` WHERE id_user = p_id_user;
+
+	IF v_user_email IS NULL THEN
+		SIGNAL SQLSTATE '40400' SET MESSAGE_TEXT = 'user not found';
+	END IF;
+	IF v_enabled THEN
+		SIGNAL SQLSTATE '40900' SET MESSAGE_TEXT = 'two-factor auth already enabled';
+	END IF;
+
+	-- a pending secret is replaced, it was never asked at login
+	UPDATE `db_go_cleanapi`.`users` SET totp_secret = p_totp_secret, totp_last_step = NULL
+	WHERE id_user = p_id_user;
+
+	SELECT v_user_email AS user_email;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_read_totp`(
+	p_id_user VARCHAR(64)
+)
+BEGIN
+	SELECT totp_secret,
+	totp_enabled_at IS NOT NULL AS totp_enabled,
+	COALESCE(totp_last_step, -1) AS totp_last_step
+	FROM `db_go_cleanapi`.`users`
+	WHERE id_user = p_id_user AND totp_secret IS NOT NULL;
+END$$
+DELIMITER ;
+
+-- sp_enable_totp runs in the transaction that stores the new recovery codes
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_enable_totp`(
+	p_id_user VARCHAR(64),
+    p_step BIGINT
+)
+BEGIN
+	UPDATE `db_go_cleanapi`.`users` SET totp_enabled_at = UTC_TIMESTAMP(), totp_last_step = p_step
+	WHERE id_user = p_id_user AND totp_secret IS NOT NULL AND totp_enabled_at IS NULL;
+
+	IF ROW_COUNT() = 0 THEN
+		SIGNAL SQLSTATE '40900' SET MESSAGE_TEXT = 'two-factor auth already enabled or not enrolled';
+	END IF;
+
+	DELETE FROM `db_go_cleanapi`.`totp_recovery_codes` WHERE id_user = p_id_user;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_create_totp_recovery_code`(
+	p_id_user VARCHAR(64),
+    p_code_hash CHAR(64)
+)
+BEGIN
+	INSERT INTO `db_go_cleanapi`.`totp_recovery_codes` (code_hash, id_user)
+	VALUES (p_code_hash, p_id_user);
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_use_totp_step`(
+	p_id_user VARCHAR(64),
+    p_step BIGINT
+)
+BEGIN
+	-- a code is accepted once, the step only moves forward
+	UPDATE `db_go_cleanapi`.`users` SET totp_last_step = p_step
+	WHERE id_user = p_id_user AND totp_enabled_at IS NOT NULL
+	AND (totp_last_step IS NULL OR totp_last_step < p_step);
+
+	IF ROW_COUNT() = 0 THEN
+		SIGNAL SQLSTATE '40100' SET MESSAGE_TEXT = 'invalid or used code';
+	END IF;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_use_totp_recovery_code`(
+	p_id_user VARCHAR(64),
+    p_code_hash CHAR(64)
+)
+BEGIN
+	UPDATE `db_go_cleanapi`.`totp_recovery_codes` c
+	JOIN `db_go_cleanapi`.`users` u ON u.id_user = c.id_user
+	SET c.used_at = UTC_TIMESTAMP()
+	WHERE c.code_hash = p_code_hash AND c.id_user = p_id_user AND c.used_at IS NULL
+	AND u.totp_enabled_at IS NOT NULL;
+
+	IF ROW_COUNT() = 0 THEN
+		SIGNAL SQLSTATE '40100' SET MESSAGE_TEXT = 'invalid or used code';
+	END IF;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_disable_totp`(
+	p_id_user VARCHAR(64)
+)
+BEGIN
+	UPDATE `db_go_cleanapi`.`users` SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_step = NULL
+	WHERE id_user = p_id_user;
+
+	DELETE FROM `db_go_cleanapi`.`totp_recovery_codes` WHERE id_user = p_id_user;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_read_login_failures`(
+	p_failure_key VARCHAR(160)
+)
+BEGIN
+	SELECT failures, locked_until
+	FROM `db_go_cleanapi`.`login_failures`
+	WHERE failure_key = p_failure_key;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_fail_login`(
+	p_failure_key VARCHAR(160),
+    p_window_seconds INT
+)
+BEGIN
+	-- failures older than the window are forgotten, a running lock is kept
+	INSERT INTO `db_go_cleanapi`.`login_failures`
+	(`failure_key`,
+	`failures`,
+	`last_failed_at`)
+	VALUES
+	(p_failure_key,
+	1,
+	UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE
+	`failures` = IF(`last_failed_at` < UTC_TIMESTAMP() - INTERVAL p_window_seconds SECOND, 1, `failures` + 1),
+	`last_failed_at` = UTC_TIMESTAMP();
+
+	SELECT failures FROM `db_go_cleanapi`.`login_failures` WHERE failure_key = p_failure_key;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_lock_login`(
+	p_failure_key VARCHAR(160),
+    p_locked_until DATETIME
+)
+BEGIN
+	UPDATE `db_go_cleanapi`.`login_failures` SET locked_until = p_locked_until
+	WHERE failure_key = p_failure_key;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_reset_login_failures`(
+	p_failure_key VARCHAR(160)
+)
+BEGIN
+	DELETE FROM `db_go_cleanapi`.`login_failures` WHERE failure_key = p_failure_key;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_create_user_event`(
+	p_event_type VARCHAR(32),
+    p_id_user VARCHAR(64),
+    p_occurred_at DATETIME(6),
+    p_buffer_size INT
+)
+BEGIN
+	DECLARE v_id_event BIGINT UNSIGNED;
+
+	INSERT INTO `db_go_cleanapi`.`user_events`
+	(`event_type`,
+	`id_user`,
+	`occurred_at`)
+	VALUES
+	(p_event_type,
+	p_id_user,
+	p_occurred_at);
+	SET v_id_event = LAST_INSERT_ID();
+
+	-- the buffer keeps the last p_buffer_size events, the id is unsigned so
+	-- nothing is trimmed before the buffer is full
+	IF v_id_event > p_buffer_size THEN
+		DELETE FROM `db_go_cleanapi`.`user_events` WHERE id_event <= v_id_event - p_buffer_size;
+	END IF;
+
+	SELECT v_id_event;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_read_user_events`(
+	p_after_id BIGINT UNSIGNED,
+    p_limit INT
+)
+BEGIN
+	SELECT id_event, event_type, id_user, occurred_at
+	FROM `db_go_cleanapi`.`user_events`
+	WHERE id_event > p_after_id
+	ORDER BY id_event
+	LIMIT p_limit;
+END$$
+DELIMITER ;
+
+DELIMITER $$
+CREATE DEFINER=`root`@`localhost` PROCEDURE `sp_read_last_user_event`()
+BEGIN
+	SELECT COALESCE(MAX(id_event), 0) FROM `db_go_cleanapi`.`user_events`;
+END$$
+DELIMITER ;
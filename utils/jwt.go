@@ -10,24 +10,55 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-const jwtExpirationTime = 72 * time.Hour
+const (
+	jwtExpirationTime = 72 * time.Hour
+
+	// verificationAudience keeps verification links apart from session tokens signed with the same secret
+	verificationAudience = "email_verification"
+	// mfaAudience marks the tokens of a login that waits for its second factor
+	mfaAudience = "mfa_pending"
+)
 
 type userClaims struct {
 	UID string `json:"uid"`
 	jwt.RegisteredClaims
 }
 
+type verificationClaims struct {
+	Email string `json:"email"`
+	jwt.RegisteredClaims
+}
+
 type apiClaims struct {
 	Hash string `json:"hash"`
 	jwt.RegisteredClaims
 }
 
+// UserToken is the session data carried by a user jwt
+type UserToken struct {
+	// ID is the unique id of the token (jti)
+	ID        string
+	UID       string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
 // JWT is an interface for jwt util
 type JWT interface {
 	CreateUserJWT(uid string) (string, error)
 	CheckUserJwt(requestToken string) (bool, error)
+	ParseUserJWT(requestToken string) (*UserToken, error)
+	// CreateVerificationJWT signs the email for a verification link that expires after ttl
+	CreateVerificationJWT(email string, ttl time.Duration) (string, error)
+	// ParseVerificationJWT returns the email of a valid verification link token
+	ParseVerificationJWT(requestToken string) (string, error)
+	// CreateMFAJWT signs the id of a user that passed the password but not the second factor yet
+	CreateMFAJWT(uid string, ttl time.Duration) (string, error)
+	// ParseMFAJWT returns the user id of a valid mfa pending token
+	ParseMFAJWT(requestToken string) (string, error)
 	CreateAPIJWT() (string, error)
 	CheckAPIJWT(requestToken string) (bool, error)
 }
@@ -50,10 +81,18 @@ func (ju *myJwt) CreateUserJWT(id string) (string, error) {
 		return "", errors.New("id cannot be empty")
 	}
 
-	expiresAt := time.Now().Add(jwtExpirationTime)
+	// user jwts are short lived, sessions are extended with refresh tokens
+	lifetime := ju.config.AccessTokenTTL
+	if lifetime <= 0 {
+		lifetime = jwtExpirationTime
+	}
+	issuedAt := time.Now()
+	expiresAt := issuedAt.Add(lifetime)
 	userClaims := userClaims{
 		UID: id,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
 			ExpiresAt: jwt.NewNumericDate(expiresAt),
 		},
 	}
@@ -89,9 +128,163 @@ func (ju *myJwt) CheckUserJwt(requestToken string) (bool, error) {
 		return false, errors.New("invalid token")
 	}
 
+	// tokens with an audience are signed for other purposes, they are not sessions
+	if len(claims.Audience) > 0 {
+		return false, errors.New("token is not a session token")
+	}
+
 	return true, nil
 }
 
+func (ju *myJwt) ParseUserJWT(requestToken string) (*UserToken, error) {
+	if requestToken == "" {
+		return nil, errors.New("token cannot be empty")
+	}
+
+	claims := &userClaims{}
+	token, err := jwt.ParseWithClaims(requestToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return ju.config.JWTSecret, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	if !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if len(claims.Audience) > 0 {
+		return nil, errors.New("token is not a session token")
+	}
+	if claims.UID == "" {
+		return nil, errors.New("token without uid")
+	}
+
+	userToken := &UserToken{
+		ID:  claims.ID,
+		UID: claims.UID,
+	}
+	if claims.IssuedAt != nil {
+		userToken.IssuedAt = claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		userToken.ExpiresAt = claims.ExpiresAt.Time
+	}
+
+	return userToken, nil
+}
+
+func (ju *myJwt) CreateVerificationJWT(email string, ttl time.Duration) (string, error) {
+	if email == "" {
+		return "", errors.New("email cannot be empty")
+	}
+	if ttl <= 0 {
+		return "", errors.New("ttl must be positive")
+	}
+
+	issuedAt := time.Now()
+	claims := verificationClaims{
+		Email: email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Audience:  jwt.ClaimStrings{verificationAudience},
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(ttl)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+	signedToken, err := token.SignedString(ju.config.JWTSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return signedToken, nil
+}
+
+func (ju *myJwt) ParseVerificationJWT(requestToken string) (string, error) {
+	if requestToken == "" {
+		return "", errors.New("token cannot be empty")
+	}
+
+	claims := &verificationClaims{}
+	token, err := jwt.ParseWithClaims(requestToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return ju.config.JWTSecret, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	if !token.Valid {
+		return "", errors.New("invalid token")
+	}
+
+	// session tokens are signed with the same secret, they must not verify emails
+	if !claims.VerifyAudience(verificationAudience, true) {
+		return "", errors.New("token is not a verification token")
+	}
+	if claims.Email == "" {
+		return "", errors.New("token without email")
+	}
+
+	return claims.Email, nil
+}
+
+func (ju *myJwt) CreateMFAJWT(uid string, ttl time.Duration) (string, error) {
+	if uid == "" {
+		return "", errors.New("id cannot be empty")
+	}
+	if ttl <= 0 {
+		return "", errors.New("ttl must be positive")
+	}
+
+	issuedAt := time.Now()
+	claims := userClaims{
+		UID: uid,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Audience:  jwt.ClaimStrings{mfaAudience},
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(ttl)),
+		},
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+	signedToken, err := token.SignedString(ju.config.JWTSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to sign token: %w", err)
+	}
+
+	return signedToken, nil
+}
+
+func (ju *myJwt) ParseMFAJWT(requestToken string) (string, error) {
+	if requestToken == "" {
+		return "", errors.New("token cannot be empty")
+	}
+
+	claims := &userClaims{}
+	token, err := jwt.ParseWithClaims(requestToken, claims, func(token *jwt.Token) (interface{}, error) {
+		return ju.config.JWTSecret, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to parse token: %w", err)
+	}
+
+	if !token.Valid {
+		return "", errors.New("invalid token")
+	}
+
+	// a session token must not skip the second factor of another login
+	if !claims.VerifyAudience(mfaAudience, true) {
+		return "", errors.New("token is not an mfa token")
+	}
+	if claims.UID == "" {
+		return "", errors.New("token without uid")
+	}
+
+	return claims.UID, nil
+}
+
 func (ju *myJwt) CreateAPIJWT() (string, error) {
 	apiKey := ju.config.APIKey
 
@@ -4,6 +4,13 @@
 // Package utils is a package that provides general method for the api usage
 package utils
 
+import (
+	"errors"
+	"time"
+)
+
+const jwtMockLifetime = 15 * time.Minute
+
 type jwtMock struct{}
 
 // NewJWTMock is a mock for jwt
@@ -19,6 +26,46 @@ func (j *jwtMock) CheckUserJwt(_ string) (bool, error) {
 	return true, nil
 }
 
+func (j *jwtMock) ParseUserJWT(requestToken string) (*UserToken, error) {
+	if requestToken == "" {
+		return nil, errors.New("token cannot be empty")
+	}
+	return &UserToken{
+		ID:        requestToken,
+		UID:       requestToken,
+		IssuedAt:  time.Now(),
+		ExpiresAt: time.Now().Add(jwtMockLifetime),
+	}, nil
+}
+
+func (j *jwtMock) CreateVerificationJWT(email string, _ time.Duration) (string, error) {
+	if email == "" {
+		return "", errors.New("email cannot be empty")
+	}
+	return email, nil
+}
+
+func (j *jwtMock) ParseVerificationJWT(requestToken string) (string, error) {
+	if requestToken == "" {
+		return "", errors.New("token cannot be empty")
+	}
+	return requestToken, nil
+}
+
+func (j *jwtMock) CreateMFAJWT(uid string, _ time.Duration) (string, error) {
+	if uid == "" {
+		return "", errors.New("id cannot be empty")
+	}
+	return uid, nil
+}
+
+func (j *jwtMock) ParseMFAJWT(requestToken string) (string, error) {
+	if requestToken == "" {
+		return "", errors.New("token cannot be empty")
+	}
+	return requestToken, nil
+}
+
 func (j *jwtMock) CreateAPIJWT() (string, error) {
 	return "", nil
 }
@@ -5,6 +5,7 @@ import (
 	"dall06/go-cleanapi/config"
 	"dall06/go-cleanapi/utils"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -140,6 +141,254 @@ func TestCheckUserJWT(test *testing.T) {
 	}
 }
 
+func TestParseUserJWT(test *testing.T) {
+	cfg := config.NewConfig("8080", "0.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatal("expected no error, but got:", err)
+	}
+
+	otherVars := *vars
+	otherVars.JWTSecret = []byte("another secret")
+
+	successfulCases := []struct {
+		name   string
+		input  string
+		config *config.Vars
+	}{
+		{
+			config: vars,
+			name:   "it should parse a jwt user based",
+			input:  "im an id",
+		},
+	}
+
+	failedCases := []struct {
+		name   string
+		token  string
+		config *config.Vars
+	}{
+		{
+			config: vars,
+			name:   "it should fail parse a jwt user based, empty string",
+			token:  "",
+		},
+		{
+			config: vars,
+			name:   "it should fail parse a jwt user based, malformed token",
+			token:  "not a token",
+		},
+		{
+			config: &otherVars,
+			name:   "it should fail parse a jwt user based, signed with another secret",
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			jwt := utils.NewJWT(*tc.config)
+
+			r, err := jwt.CreateUserJWT(tc.input)
+			assert.NoError(t, err)
+
+			token, err := jwt.ParseUserJWT(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.input, token.UID)
+			assert.NotEmpty(t, token.ID, "expected a token id, but got empty")
+			assert.Equal(t, tc.config.AccessTokenTTL, token.ExpiresAt.Sub(token.IssuedAt))
+
+			other, err := jwt.CreateUserJWT(tc.input)
+			assert.NoError(t, err)
+			otherToken, err := jwt.ParseUserJWT(other)
+			assert.NoError(t, err)
+			assert.NotEqual(t, token.ID, otherToken.ID, "expected unique token ids")
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			token := tc.token
+			if tc.config != vars {
+				r, err := utils.NewJWT(*vars).CreateUserJWT("im an id")
+				assert.NoError(t, err)
+				token = r
+			}
+
+			jwt := utils.NewJWT(*tc.config)
+			r, err := jwt.ParseUserJWT(token)
+			assert.Error(t, err)
+			assert.Nil(t, r)
+		})
+	}
+}
+
+func TestVerificationJWT(test *testing.T) {
+	cfg := config.NewConfig("8080", "0.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatal("expected no error, but got:", err)
+	}
+
+	successfulCases := []struct {
+		name  string
+		email string
+	}{
+		{
+			name:  "it should create and parse a verification jwt",
+			email: "test@test.com",
+		},
+	}
+
+	failedCases := []struct {
+		name  string
+		token func(j utils.JWT) string
+	}{
+		{
+			name:  "it should fail parse a verification jwt, empty string",
+			token: func(j utils.JWT) string { return "" },
+		},
+		{
+			name: "it should fail parse a verification jwt, expired token",
+			token: func(j utils.JWT) string {
+				r, _ := j.CreateVerificationJWT("test@test.com", time.Nanosecond)
+				time.Sleep(time.Second)
+				return r
+			},
+		},
+		{
+			name: "it should fail parse a verification jwt, session token",
+			token: func(j utils.JWT) string {
+				r, _ := j.CreateUserJWT("im an id")
+				return r
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			jwt := utils.NewJWT(*vars)
+
+			r, err := jwt.CreateVerificationJWT(tc.email, time.Hour)
+			assert.NoError(t, err)
+
+			email, err := jwt.ParseVerificationJWT(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.email, email)
+
+			// a verification token does not open a session
+			_, err = jwt.ParseUserJWT(r)
+			assert.Error(t, err)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			jwt := utils.NewJWT(*vars)
+
+			email, err := jwt.ParseVerificationJWT(tc.token(jwt))
+			assert.Error(t, err)
+			assert.Empty(t, email)
+		})
+	}
+}
+
+func TestMFAJWT(test *testing.T) {
+	cfg := config.NewConfig("8080", "0.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatal("expected no error, but got:", err)
+	}
+
+	successfulCases := []struct {
+		name string
+		uid  string
+	}{
+		{
+			name: "it should create and parse an mfa jwt",
+			uid:  "im an id",
+		},
+	}
+
+	failedCases := []struct {
+		name  string
+		token func(j utils.JWT) string
+	}{
+		{
+			name:  "it should fail parse an mfa jwt, empty string",
+			token: func(j utils.JWT) string { return "" },
+		},
+		{
+			name: "it should fail parse an mfa jwt, expired token",
+			token: func(j utils.JWT) string {
+				r, _ := j.CreateMFAJWT("im an id", time.Nanosecond)
+				time.Sleep(time.Second)
+				return r
+			},
+		},
+		{
+			name: "it should fail parse an mfa jwt, session token",
+			token: func(j utils.JWT) string {
+				r, _ := j.CreateUserJWT("im an id")
+				return r
+			},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			jwt := utils.NewJWT(*vars)
+
+			r, err := jwt.CreateMFAJWT(tc.uid, time.Minute)
+			assert.NoError(t, err)
+
+			uid, err := jwt.ParseMFAJWT(r)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.uid, uid)
+
+			// a pending login does not open a session
+			_, err = jwt.ParseUserJWT(r)
+			assert.Error(t, err)
+			valid, err := jwt.CheckUserJwt(r)
+			assert.Error(t, err)
+			assert.False(t, valid)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			jwt := utils.NewJWT(*vars)
+
+			uid, err := jwt.ParseMFAJWT(tc.token(jwt))
+			assert.Error(t, err)
+			assert.Empty(t, uid)
+		})
+	}
+}
+
 func TestCreateAPIJWT(test *testing.T) {
 	cfg := config.NewConfig("8080", "0.0.0")
 	vars, err := cfg.SetConfig()
@@ -0,0 +1,123 @@
+// Package utils is a package that provides general method for the api usage
+package utils
+
+import (
+	"bytes"
+	"context"
+	"dall06/go-cleanapi/config"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Mail is a plain text email
+type Mail struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer is an interface that extends the delivery of emails
+type Mailer interface {
+	Send(ctx context.Context, mail *Mail) error
+}
+
+var (
+	_ Mailer = (*smtpMailer)(nil)
+	_ Mailer = (*fileMailer)(nil)
+)
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer is a constructor for a mailer that sends the emails through the configured smtp server
+func NewSMTPMailer(v config.Vars) Mailer {
+	m := &smtpMailer{
+		addr: net.JoinHostPort(v.SMTPHost, v.SMTPPort),
+		from: v.MailFrom,
+	}
+	if v.SMTPUser != "" {
+		m.auth = smtp.PlainAuth("", v.SMTPUser, v.SMTPPassword, v.SMTPHost)
+	}
+	return m
+}
+
+func (m *smtpMailer) Send(ctx context.Context, mail *Mail) error {
+	if err := checkMail(mail); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	err := smtp.SendMail(m.addr, m.auth, m.from, []string{mail.To}, formatMail(m.from, mail))
+	if err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+	return nil
+}
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// NewFileMailer is a constructor for a mailer that writes every email to a file in dir,
+// it is meant for development and tests so nothing leaves the host
+func NewFileMailer(dir string, from string) Mailer {
+	return &fileMailer{
+		dir:  dir,
+		from: from,
+	}
+}
+
+func (m *fileMailer) Send(ctx context.Context, mail *Mail) error {
+	if err := checkMail(mail); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create mail dir: %w", err)
+	}
+
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(mail.To))
+	err := os.WriteFile(filepath.Join(m.dir, name), formatMail(m.from, mail), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+	return nil
+}
+
+func checkMail(mail *Mail) error {
+	if mail == nil || mail.To == "" {
+		return fmt.Errorf("mail without recipient")
+	}
+	if strings.ContainsAny(mail.To+mail.Subject, "\r\n") {
+		return fmt.Errorf("invalid mail header")
+	}
+	return nil
+}
+
+// formatMail renders the email as a RFC 5322 message
+func formatMail(from string, mail *Mail) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", mail.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mail.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(mail.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
@@ -0,0 +1,117 @@
+// Package utils_test is a test package for utils
+package utils_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/config"
+	"dall06/go-cleanapi/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileMailer(test *testing.T) {
+	successfulCases := []struct {
+		name string
+		mail *utils.Mail
+	}{
+		{
+			name: "it should write the mail to a file",
+			mail: &utils.Mail{
+				To:      "test@test.com",
+				Subject: "Reset your password",
+				Body:    "im a body\nwith two lines",
+			},
+		},
+	}
+
+	failedCases := []struct {
+		name string
+		mail *utils.Mail
+	}{
+		{
+			name: "it should not write the mail, nil mail",
+			mail: nil,
+		},
+		{
+			name: "it should not write the mail, empty recipient",
+			mail: &utils.Mail{Subject: "im a subject"},
+		},
+		{
+			name: "it should not write the mail, header injection",
+			mail: &utils.Mail{To: "test@test.com\r\nBcc: evil@test.com", Subject: "im a subject"},
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			mailer := utils.NewFileMailer(dir, "no-reply@test.com")
+			err := mailer.Send(context.Background(), tc.mail)
+			assert.NoError(t, err)
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+			assert.NoError(t, err)
+			assert.Len(t, files, 1)
+
+			content, err := os.ReadFile(files[0])
+			assert.NoError(t, err)
+			assert.Contains(t, string(content), "From: no-reply@test.com\r\n")
+			assert.Contains(t, string(content), "To: test@test.com\r\n")
+			assert.Contains(t, string(content), "Subject: Reset your password\r\n")
+			assert.Contains(t, string(content), "im a body\r\nwith two lines")
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			mailer := utils.NewFileMailer(dir, "no-reply@test.com")
+			err := mailer.Send(context.Background(), tc.mail)
+			assert.Error(t, err)
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+			assert.NoError(t, err)
+			assert.Empty(t, files)
+		})
+	}
+}
+
+func TestSMTPMailer(test *testing.T) {
+	failedCases := []struct {
+		name string
+		mail *utils.Mail
+	}{
+		{
+			name: "it should not send the mail, empty recipient",
+			mail: &utils.Mail{Subject: "im a subject"},
+		},
+		{
+			name: "it should not send the mail, unreachable server",
+			mail: &utils.Mail{To: "test@test.com", Subject: "im a subject"},
+		},
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			mailer := utils.NewSMTPMailer(config.Vars{
+				SMTPHost: "127.0.0.1",
+				SMTPPort: "1",
+				MailFrom: "no-reply@test.com",
+			})
+			err := mailer.Send(context.Background(), tc.mail)
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,55 @@
+// Package utils is a package that provides general method for the api usage
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SMSSender is an interface that extends the delivery of text messages,
+// a provider backed implementation can be plugged in the server
+type SMSSender interface {
+	Send(ctx context.Context, to string, body string) error
+}
+
+var _ SMSSender = (*fakeSMSSender)(nil)
+
+type fakeSMSSender struct {
+	dir string
+}
+
+// NewFakeSMSSender is a constructor for a sender that writes every message to a file in dir,
+// it is meant for development and tests so nothing leaves the host
+func NewFakeSMSSender(dir string) SMSSender {
+	return &fakeSMSSender{
+		dir: dir,
+	}
+}
+
+func (s *fakeSMSSender) Send(ctx context.Context, to string, body string) error {
+	if to == "" {
+		return fmt.Errorf("sms without recipient")
+	}
+	if strings.ContainsAny(to, "\r\n/") {
+		return fmt.Errorf("invalid sms recipient")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(s.dir, 0o750); err != nil {
+		return fmt.Errorf("failed to create sms dir: %w", err)
+	}
+
+	name := fmt.Sprintf("%d-%s.sms", time.Now().UnixNano(), strings.TrimPrefix(to, "+"))
+	content := fmt.Sprintf("To: %s\nDate: %s\n\n%s\n", to, time.Now().Format(time.RFC1123Z), body)
+	err := os.WriteFile(filepath.Join(s.dir, name), []byte(content), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write sms: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+// Package utils_test is a test package for utils
+package utils_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFakeSMSSender(test *testing.T) {
+	successfulCases := []struct {
+		name string
+		to   string
+		body string
+	}{
+		{
+			name: "it should write the sms to a file",
+			to:   "+7812324524",
+			body: "im a body",
+		},
+	}
+
+	failedCases := []struct {
+		name string
+		to   string
+	}{
+		{
+			name: "it should not write the sms, empty recipient",
+			to:   "",
+		},
+		{
+			name: "it should not write the sms, invalid recipient",
+			to:   "+78123\n../24524",
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			sender := utils.NewFakeSMSSender(dir)
+			err := sender.Send(context.Background(), tc.to, tc.body)
+			assert.NoError(t, err)
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.sms"))
+			assert.NoError(t, err)
+			assert.Len(t, files, 1)
+
+			content, err := os.ReadFile(files[0])
+			assert.NoError(t, err)
+			assert.Contains(t, string(content), "To: "+tc.to+"\n")
+			assert.Contains(t, string(content), tc.body)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			sender := utils.NewFakeSMSSender(dir)
+			err := sender.Send(context.Background(), tc.to, "im a body")
+			assert.Error(t, err)
+
+			files, err := filepath.Glob(filepath.Join(dir, "*.sms"))
+			assert.NoError(t, err)
+			assert.Empty(t, files)
+		})
+	}
+}
@@ -0,0 +1,124 @@
+// Package utils is a package that provides general method for the api usage
+package utils
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" // #nosec G505 -- RFC 6238 authenticator apps expect HMAC-SHA1
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	totpPeriod = 30
+	totpDigits = 6
+	// totpSkew is the number of periods accepted before and after the current one,
+	// so a code typed right when it changes still works
+	totpSkew = 1
+	// totpSecretSize is the size in bytes of a new secret, the RFC 4226 recommendation
+	totpSecretSize = 20
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTP is an interface for the time-based one-time passwords of RFC 6238
+type TOTP interface {
+	// GenerateSecret returns a new random secret encoded in base32
+	GenerateSecret() (string, error)
+	// URI returns the otpauth provisioning uri of the secret, authenticator apps read it from a qr code
+	URI(secret string, account string) string
+	// Code returns the code of the secret at the given time
+	Code(secret string, at time.Time) (string, error)
+	// Validate checks the code against the periods around the given time and returns the period that matched
+	Validate(secret string, code string, at time.Time) (int64, error)
+}
+
+var _ TOTP = (*totp)(nil)
+
+type totp struct {
+	issuer string
+}
+
+// NewTOTP is a constructor for totp, issuer is the name shown next to the account in authenticator apps
+func NewTOTP(issuer string) TOTP {
+	return &totp{
+		issuer: issuer,
+	}
+}
+
+func (t *totp) GenerateSecret() (string, error) {
+	secret := make([]byte, totpSecretSize)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("failed to generate totp secret: %w", err)
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+func (t *totp) URI(secret string, account string) string {
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", t.issuer)
+	values.Set("algorithm", "SHA1")
+	values.Set("digits", fmt.Sprint(totpDigits))
+	values.Set("period", fmt.Sprint(totpPeriod))
+
+	label := url.PathEscape(t.issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+func (t *totp) Code(secret string, at time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("invalid totp secret: %w", err)
+	}
+	return totpCode(key, totpStep(at)), nil
+}
+
+func (t *totp) Validate(secret string, code string, at time.Time) (int64, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0, fmt.Errorf("invalid totp secret: %w", err)
+	}
+	if len(code) != totpDigits {
+		return 0, errors.New("invalid totp code")
+	}
+
+	step := totpStep(at)
+	for i := -totpSkew; i <= totpSkew; i++ {
+		candidate := totpCode(key, step+int64(i))
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(code)) == 1 {
+			return step + int64(i), nil
+		}
+	}
+
+	return 0, errors.New("invalid totp code")
+}
+
+func totpStep(at time.Time) int64 {
+	return at.Unix() / totpPeriod
+}
+
+// totpCode is the HOTP value of RFC 4226 for the step used as counter
+func totpCode(key []byte, step int64) string {
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod)
+}
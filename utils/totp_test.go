@@ -0,0 +1,110 @@
+// Package utils_test is a test package for utils
+package utils_test
+
+import (
+	"dall06/go-cleanapi/utils"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// rfcSecret is the base32 of the RFC 6238 test secret "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTP(test *testing.T) {
+	totp := utils.NewTOTP("go-cleanapi")
+
+	successfulCases := []struct {
+		name     string
+		at       time.Time
+		expected string
+	}{
+		{
+			name:     "it should match the rfc code at 59",
+			at:       time.Unix(59, 0),
+			expected: "287082",
+		},
+		{
+			name:     "it should match the rfc code at 1111111109",
+			at:       time.Unix(1111111109, 0),
+			expected: "081804",
+		},
+		{
+			name:     "it should match the rfc code at 1234567890",
+			at:       time.Unix(1234567890, 0),
+			expected: "005924",
+		},
+	}
+
+	failedCases := []struct {
+		name   string
+		secret string
+		code   string
+		at     time.Time
+	}{
+		{
+			name:   "it should not validate a code of another period",
+			secret: rfcSecret,
+			code:   "287082",
+			at:     time.Unix(1234567890, 0),
+		},
+		{
+			name:   "it should not validate a short code",
+			secret: rfcSecret,
+			code:   "28708",
+			at:     time.Unix(59, 0),
+		},
+		{
+			name:   "it should not validate with an invalid secret",
+			secret: "im not base32!",
+			code:   "287082",
+			at:     time.Unix(59, 0),
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			code, err := totp.Code(rfcSecret, tc.at)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, code)
+
+			// the previous and the next period are accepted too
+			for _, at := range []time.Time{tc.at, tc.at.Add(-30 * time.Second), tc.at.Add(30 * time.Second)} {
+				step, err := totp.Validate(rfcSecret, tc.expected, at)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.at.Unix()/30, step)
+			}
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+		test.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := totp.Validate(tc.secret, tc.code, tc.at)
+			assert.Error(t, err)
+		})
+	}
+
+	test.Run("it should provision a new secret", func(t *testing.T) {
+		secret, err := totp.GenerateSecret()
+		assert.NoError(t, err)
+		assert.Len(t, secret, 32)
+
+		uri := totp.URI(secret, "test@test.com")
+		assert.True(t, strings.HasPrefix(uri, "otpauth://totp/go-cleanapi:test@test.com?"))
+		assert.Contains(t, uri, "secret="+secret)
+		assert.Contains(t, uri, "issuer=go-cleanapi")
+
+		code, err := totp.Code(secret, time.Now())
+		assert.NoError(t, err)
+		_, err = totp.Validate(secret, code, time.Now())
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,94 @@
+// Package utils is a package that provides general method for the api usage
+package utils
+
+import (
+	"context"
+	"dall06/go-cleanapi/config"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.17.0"
+)
+
+const (
+	traceExporterNone   = "none"
+	traceExporterStdout = "stdout"
+	traceExporterOTLP   = "otlp"
+)
+
+// Tracer refers to the repository as interface of the tracer provider
+type Tracer interface {
+	Initialize() error
+	Shutdown(ctx context.Context) error
+}
+
+var _ Tracer = (*tracer)(nil)
+
+type tracer struct {
+	provider *sdktrace.TracerProvider
+	config   config.Vars
+}
+
+// NewTracer is a function constructor for Tracer
+func NewTracer(v config.Vars) Tracer {
+	return &tracer{
+		config: v,
+	}
+}
+
+func (t *tracer) Initialize() error {
+	// W3C traceparent and baggage are always propagated, even without exporter
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	switch t.config.TraceExporter {
+	case traceExporterNone, "":
+		return nil
+	case traceExporterStdout:
+		exp, err := stdouttrace.New(stdouttrace.WithPrettyPrint())
+		if err != nil {
+			return fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		exporter = exp
+	case traceExporterOTLP:
+		exp, err := otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpoint(t.config.TraceEndpoint),
+			otlptracehttp.WithInsecure())
+		if err != nil {
+			return fmt.Errorf("failed to create otlp exporter: %w", err)
+		}
+		exporter = exp
+	default:
+		return fmt.Errorf("unknown trace exporter: %s", t.config.TraceExporter)
+	}
+
+	res := resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(t.config.ProyectName),
+		semconv.ServiceVersion(t.config.APIVersion),
+		semconv.DeploymentEnvironment(t.config.Stage),
+	)
+
+	t.provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(t.provider)
+
+	return nil
+}
+
+func (t *tracer) Shutdown(ctx context.Context) error {
+	if t.provider == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
@@ -0,0 +1,79 @@
+// Package utils_test is a test package for utils
+package utils_test
+
+import (
+	"context"
+	"dall06/go-cleanapi/config"
+	"dall06/go-cleanapi/utils"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInitializeTracer(test *testing.T) {
+	cfg := config.NewConfig("8080", "0.0.0")
+	vars, err := cfg.SetConfig()
+	if err != nil {
+		test.Fatal("expected no error, but got:", err)
+	}
+
+	varsNone := *vars
+	varsNone.TraceExporter = "none"
+	varsStdout := *vars
+	varsStdout.TraceExporter = "stdout"
+	varsOTLP := *vars
+	varsOTLP.TraceExporter = "otlp"
+	varsUnknown := *vars
+	varsUnknown.TraceExporter = "unknown"
+
+	successfulCases := []struct {
+		name   string
+		config *config.Vars
+	}{
+		{
+			config: &varsNone,
+			name:   "it should initialize tracer without exporter",
+		},
+		{
+			config: &varsStdout,
+			name:   "it should initialize tracer with stdout exporter",
+		},
+		{
+			config: &varsOTLP,
+			name:   "it should initialize tracer with otlp exporter",
+		},
+	}
+
+	failedCases := []struct {
+		name   string
+		config *config.Vars
+	}{
+		{
+			config: &varsUnknown,
+			name:   "it should not initialize tracer, unknown exporter",
+		},
+	}
+
+	for _, tc := range successfulCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			tracer := utils.NewTracer(*tc.config)
+			err := tracer.Initialize()
+			assert.NoError(t, err)
+
+			err = tracer.Shutdown(context.Background())
+			assert.NoError(t, err)
+		})
+	}
+
+	for _, tc := range failedCases {
+		tc := tc
+
+		test.Run(tc.name, func(t *testing.T) {
+			tracer := utils.NewTracer(*tc.config)
+			err := tracer.Initialize()
+			assert.Error(t, err)
+		})
+	}
+}